@@ -27,3 +27,11 @@ var AppMap = map[string]AppMaker{
 		return evm.NewEVMApp(c)
 	},
 }
+
+// GenesisCheckers validate the app_state of a genesis doc before a runtime is initialized with it
+var GenesisCheckers = map[string]func(*types.GenesisDoc) error{
+	"evm": func(genDoc *types.GenesisDoc) error {
+		_, err := evm.GenesisFromDoc(genDoc)
+		return err
+	},
+}
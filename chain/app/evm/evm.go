@@ -20,7 +20,7 @@ import (
 	"fmt"
 	"math/big"
 	"path/filepath"
-	"strings"
+	"runtime"
 	"sync"
 
 	"go.uber.org/zap"
@@ -50,6 +50,7 @@ const (
 	DatabaseHandles = 1024
 	MaxKey          = 256
 	MaxValue        = 4096
+	MaxKVBatchOps   = 100
 
 	// With 2.2 GHz Intel Core i7, 16 GB 2400 MHz DDR4, 256GB SSD, we tested following contract, it takes about 24157 gas and 171.193µs.
 	// function setVal(uint256 _val) public {
@@ -82,6 +83,7 @@ func (bc *BlockChainEvm) GetHeader(hash common.Hash, number uint64) *etypes.Head
 var (
 	ReceiptsPrefix = []byte("receipts-")
 	KvPrefix       = []byte("kvstore-")
+	FailurePrefix  = []byte("txfailure-")
 
 	EmptyTrieRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
 
@@ -103,15 +105,26 @@ type EVMApp struct {
 
 	currentHeader *etypes.Header
 	chainConfig   *params.ChainConfig
+	gasLimit      uint64 // block gas limit of the genesis
+	txGasLimit    uint64
+	fee           *FeeConfig // nil for the chains made before fees were configured
+	kvStateBlock  int64      // first height folding the kv store into the state root, -1 if never
+	punishment    string     // punishment of double signing, of the genesis as every node applies it
+
+	parallelWorkers int // workers executing txs ahead of their turn, txs are executed serially when 0
 
 	stateDb                ethdb.Database
 	keyValueHistoryManager *KeyValueHistoryManager
+	logIndexManager        *LogIndexManager
 	stateMtx               sync.Mutex
 	state                  *estate.StateDB
 	currentState           *estate.StateDB
 
 	receipts          etypes.Receipts
-	kvs               rtypes.KVs
+	txFailures        map[common.Hash]*rtypes.TxFailure // failed txs of the executing block
+	txStatus          *txStatusTracker
+	kvs               []*rtypes.KVOp
+	kvRoot            common.Hash // root of the kv trie updated by the executing block
 	keyValueHistories gtypes.KeyValueHistories
 	Signer            etypes.Signer
 }
@@ -123,10 +136,13 @@ type LastBlockInfo struct {
 
 func NewEVMApp(config *viper.Viper) (*EVMApp, error) {
 	app := &EVMApp{
-		datadir:     config.GetString("db_dir"),
-		Config:      config,
-		chainConfig: params.MainnetChainConfig,
-		Signer:      new(etypes.HomesteadSigner),
+		datadir:      config.GetString("db_dir"),
+		Config:       config,
+		chainConfig:  params.MainnetChainConfig,
+		gasLimit:     math.MaxUint64,
+		txGasLimit:   math.MaxUint64,
+		kvStateBlock: -1,
+		punishment:   PunishRemove,
 	}
 
 	app.AngineHooks = gtypes.Hooks{
@@ -152,8 +168,22 @@ func NewEVMApp(config *viper.Viper) (*EVMApp, error) {
 	} else {
 		app.keyValueHistoryManager = NewKeyValueHistoryManager(kvdb)
 	}
+	if logdb, err := ethdb.NewLDBDatabase(filepath.Join(app.datadir, "log_index"), DatabaseCache, DatabaseHandles); err != nil {
+		log.Error("OpenDatabase error", zap.Error(err))
+		return nil, errors.Wrap(err, "app error")
+	} else {
+		app.logIndexManager = NewLogIndexManager(logdb)
+	}
 
-	app.pool = NewEthTxPool(app, config)
+	app.txStatus = newTxStatusTracker(config.GetInt("evm_txstatus_limit"))
+	if app.pool, err = NewEthTxPool(app, config); err != nil {
+		return nil, errors.Wrap(err, "app error")
+	}
+	if config.GetBool("evm_parallel_exec") {
+		if app.parallelWorkers = config.GetInt("evm_parallel_workers"); app.parallelWorkers <= 0 {
+			app.parallelWorkers = runtime.NumCPU()
+		}
+	}
 
 	return app, nil
 }
@@ -162,14 +192,57 @@ func OpenDatabase(datadir string, name string, cache int, handles int) (ethdb.Da
 	return ethdb.NewLDBDatabase(filepath.Join(datadir, name), cache, handles)
 }
 
+// writeGenesis makes the genesis state from the app_state of the genesis doc,
+// the genesis of an initialized app is the one it was made from
 func (app *EVMApp) writeGenesis() error {
-	if app.getLastAppHash() != EmptyTrieRoot {
-		return nil
+	var (
+		g   *Genesis
+		err error
+	)
+	var genDoc *gtypes.GenesisDoc
+	if app.core != nil {
+		genDoc = app.core.Genesis()
 	}
-
-	g := core.DefaultGenesis()
-	b := g.ToBlock(app.stateDb)
-	app.SaveLastBlock(LastBlockInfo{Height: 0, AppHash: b.Root().Bytes()})
+	if app.getLastAppHash() != EmptyTrieRoot {
+		if g, err = loadGenesis(app.stateDb); err != nil {
+			return err
+		}
+		// a chain already running takes the kv state fork scheduled by the genesis doc
+		if genDoc != nil && len(genDoc.AppState) > 0 {
+			doc, err := GenesisFromDoc(genDoc)
+			if err != nil {
+				return err
+			}
+			scheduled, err := g.scheduleKVState(doc, app.lastHeight())
+			if err != nil {
+				return err
+			}
+			if scheduled {
+				if err = saveGenesis(app.stateDb, g); err != nil {
+					return err
+				}
+			}
+		}
+	} else {
+		if g, err = GenesisFromDoc(genDoc); err != nil {
+			return err
+		}
+		b := (&core.Genesis{Alloc: g.ToAlloc()}).ToBlock(app.stateDb)
+		if genDoc != nil && len(genDoc.AppHash) > 0 && !bytes.Equal(genDoc.AppHash, b.Root().Bytes()) {
+			return fmt.Errorf("genesis app hash %X mismatches app_state root %X", genDoc.AppHash, b.Root().Bytes())
+		}
+		if err = saveGenesis(app.stateDb, g); err != nil {
+			return err
+		}
+		app.SaveLastBlock(LastBlockInfo{Height: 0, AppHash: b.Root().Bytes()})
+	}
+	app.chainConfig = g.Config
+	app.gasLimit = g.BlockGasLimit()
+	app.txGasLimit = g.MaxTxGas()
+	app.fee = g.Fee
+	app.kvStateBlock = g.KVStateHeight()
+	app.punishment = g.Punishment()
+	app.Signer = NewReplaySigner(g.Config.ChainID, g.AllowLegacyTx)
 	return nil
 }
 
@@ -197,12 +270,13 @@ func (app *EVMApp) Start() (err error) {
 	if len(lastBlock.AppHash) > 0 {
 		trieRoot = common.BytesToHash(lastBlock.AppHash)
 	}
-	app.pool.Start(lastBlock.Height)
 	if app.state, err = estate.New(trieRoot, estate.NewDatabase(app.stateDb)); err != nil {
 		app.Stop()
 		log.Error("fail to new state", zap.Error(err))
 		return
 	}
+	// the journaled txs are checked against the state
+	app.pool.Start(lastBlock.Height)
 
 	return nil
 }
@@ -227,8 +301,10 @@ func (app *EVMApp) GetTxPool() gtypes.TxPool {
 
 func (app *EVMApp) Stop() {
 	app.BaseApplication.Stop()
+	app.pool.Stop()
 	app.stateDb.Close()
 	app.keyValueHistoryManager.Close()
+	app.logIndexManager.Close()
 }
 
 func (app *EVMApp) GetAngineHooks() gtypes.Hooks {
@@ -248,7 +324,12 @@ func (app *EVMApp) OnPrevote(height, round int64, block *gtypes.Block) (interfac
 }
 
 func (app *EVMApp) executeOriginTx(blockHash common.Hash, state *estate.StateDB, txIndex int, raw []byte, tx *etypes.Transaction) (*etypes.Receipt, error) {
-	gp := new(core.GasPool).AddGas(math.MaxUint64)
+	return app.applyTx(app.currentHeader, blockHash, state, txIndex, tx)
+}
+
+// applyTx applies an evm tx of the block of header
+func (app *EVMApp) applyTx(header *etypes.Header, blockHash common.Hash, state *estate.StateDB, txIndex int, tx *etypes.Transaction) (*etypes.Receipt, error) {
+	gp := new(core.GasPool).AddGas(app.gasLimit)
 
 	txBytes, err := rlp.EncodeToBytes(tx)
 	if err != nil {
@@ -264,7 +345,7 @@ func (app *EVMApp) executeOriginTx(blockHash common.Hash, state *estate.StateDB,
 		nil, // coinbase ,maybe use local account
 		gp,
 		state,
-		app.currentHeader,
+		header,
 		tx,
 		new(uint64),
 		evmConfig)
@@ -275,54 +356,124 @@ func (app *EVMApp) executeOriginTx(blockHash common.Hash, state *estate.StateDB,
 	return receipt, nil
 }
 
-func (app *EVMApp) executeKVTx(state *estate.StateDB, tx *etypes.Transaction) (*rtypes.KV, error) {
-	txData := tx.Data()[len(rtypes.KVTxType):]
-	kvData := &rtypes.KV{}
-	if err := rlp.DecodeBytes(txData, kvData); err != nil {
+func (app *EVMApp) executeKVTx(state *estate.StateDB, tx *etypes.Transaction) ([]*rtypes.KVOp, error) {
+	ops, err := decodeKVOps(tx.Data())
+	if err != nil {
 		return nil, err
 	}
 	from, _ := etypes.Sender(app.Signer, tx)
 	state.SetNonce(from, state.GetNonce(from)+1)
-	return kvData, nil
+	return ops, nil
+}
+
+func isKVTx(data []byte) bool {
+	return bytes.HasPrefix(data, rtypes.KVTxType) || bytes.HasPrefix(data, rtypes.KVBatchTxType)
+}
+
+// decodeKVOps decodes the payload of a single kv tx or of a kv batch tx and validates its ops
+func decodeKVOps(data []byte) ([]*rtypes.KVOp, error) {
+	if bytes.HasPrefix(data, rtypes.KVTxType) {
+		kv := &rtypes.KV{}
+		if err := rlp.DecodeBytes(data[len(rtypes.KVTxType):], kv); err != nil {
+			return nil, fmt.Errorf("rlp decode to kv error %s", err.Error())
+		}
+		if len(kv.Key) > MaxKey || len(kv.Value) > MaxValue {
+			return nil, fmt.Errorf("key or value too big,MaxKey:%v,MaxValue:%v", MaxKey, MaxValue)
+		}
+		return []*rtypes.KVOp{{Op: rtypes.KVOpPut, Key: kv.Key, Value: kv.Value}}, nil
+	}
+
+	batch := &rtypes.KVBatch{}
+	if err := rlp.DecodeBytes(data[len(rtypes.KVBatchTxType):], batch); err != nil {
+		return nil, fmt.Errorf("rlp decode to kv batch error %s", err.Error())
+	}
+	if len(batch.Ops) == 0 || len(batch.Ops) > MaxKVBatchOps {
+		return nil, fmt.Errorf("kv batch should have 1 to %v ops", MaxKVBatchOps)
+	}
+	for _, op := range batch.Ops {
+		if len(op.Key) == 0 || len(op.Key) > MaxKey || len(op.Value) > MaxValue {
+			return nil, fmt.Errorf("empty or too big key or value,MaxKey:%v,MaxValue:%v", MaxKey, MaxValue)
+		}
+		switch op.Op {
+		case rtypes.KVOpPut:
+			// an empty value reads as deleted, so it can't be put
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("empty value for key %s", op.Key)
+			}
+		case rtypes.KVOpDelete:
+			if len(op.Value) != 0 {
+				return nil, fmt.Errorf("delete of key %s carries a value", op.Key)
+			}
+		default:
+			return nil, fmt.Errorf("unknown kv op %d", op.Op)
+		}
+	}
+	return batch.Ops, nil
 }
 
-func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult) BeginExecFunc {
+// genExecFun makes the execution of the txs of block, spec is nil when they aren't executed ahead
+func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult, spec *speculation) BeginExecFunc {
 	blockHash := common.BytesToHash(block.Hash())
-	app.currentHeader = makeCurrentHeader(block, block.Header)
+
+	// the gas left in the block, the unused gas of the txs is given back
+	blockGas := new(core.GasPool).AddGas(app.gasLimit)
 
 	return func() (ExecFunc, EndExecFunc) {
 		state := app.currentState
 		stateSnapshot := state.Snapshot()
 		temReceipt := make([]*etypes.Receipt, 0)
-		temKv := make([]*rtypes.KV, 0)
+		temFailures := make([]*etypes.Receipt, 0)
+		temKv := make([]*rtypes.KVOp, 0)
 		tempKeyValueUpdateHistories := make([]*gtypes.KeyValueHistory, 0)
 
 		execFunc := func(txIndex int, raw []byte, tx *etypes.Transaction) error {
-			txType := common.Bytes2Hex(tx.Data())
-			if strings.HasPrefix(txType, common.Bytes2Hex(rtypes.KVTxType)) {
-				kv, err := app.executeKVTx(state, tx)
+			if isKVTx(tx.Data()) {
+				ops, err := app.executeKVTx(state, tx)
 				if err != nil {
 					return err
 				}
-				temKv = append(temKv, kv)
+				temKv = append(temKv, ops...)
 				txBytes, _ := rlp.EncodeToBytes(tx)
-				history := &gtypes.KeyValueHistory{
-					Key: kv.Key,
-					ValueUpdateHistory: &gtypes.ValueUpdateHistory{
-						Value:       kv.Value,
-						TxHash:      gtypes.Tx(txBytes).Hash(),
-						BlockHeight: uint64(block.Height),
-						TimeStamp:   uint64(block.Time.Unix()),
-						TxIndex:uint32(txIndex),
-					},
+				for _, op := range ops {
+					// a delete is recorded as an update to the empty value
+					history := &gtypes.KeyValueHistory{
+						Key: op.Key,
+						ValueUpdateHistory: &gtypes.ValueUpdateHistory{
+							Value:       op.Value,
+							TxHash:      gtypes.Tx(txBytes).Hash(),
+							BlockHeight: uint64(block.Height),
+							TimeStamp:   uint64(block.Time.Unix()),
+							TxIndex:     uint32(txIndex),
+						},
+					}
+					tempKeyValueUpdateHistories = append(tempKeyValueUpdateHistories, history)
 				}
-				tempKeyValueUpdateHistories = append(tempKeyValueUpdateHistories, history)
 			} else {
-				receipt, err := app.executeOriginTx(blockHash, state, txIndex, raw, tx)
+				if err := app.checkTxGas(tx); err != nil {
+					return err
+				}
+				if tx.Gas() > blockGas.Gas() {
+					return core.ErrGasLimitReached
+				}
+				var (
+					receipt *etypes.Receipt
+					err     error
+					merged  bool
+				)
+				if spec != nil {
+					receipt, err, merged = spec.merge(state, txIndex)
+				}
+				if !merged {
+					receipt, err = app.executeOriginTx(blockHash, state, txIndex, raw, tx)
+				}
 				if err != nil {
 					return err
 				}
+				blockGas.SubGas(receipt.GasUsed)
 				temReceipt = append(temReceipt, receipt)
+				if receipt.Status != etypes.ReceiptStatusSuccessful {
+					temFailures = append(temFailures, receipt)
+				}
 			}
 
 			return nil
@@ -333,10 +484,16 @@ func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult) Be
 				log.Warn("[evm execute],apply transaction", zap.Error(err))
 				state.RevertToSnapshot(stateSnapshot)
 				temReceipt = nil
+				temFailures = nil
 				temKv = nil
+				tempKeyValueUpdateHistories = nil
 				res.InvalidTxs = append(res.InvalidTxs, gtypes.ExecuteInvalidTx{Bytes: raw, Error: err})
+				app.addTxFailure(common.BytesToHash(gtypes.Tx(raw).Hash()), &rtypes.TxFailure{Error: err.Error(), Invalid: true})
 				return true
 			}
+			for _, r := range temFailures {
+				app.addTxFailure(r.TxHash, revertFailure(r))
+			}
 			app.receipts = append(app.receipts, temReceipt...)
 			app.kvs = append(app.kvs, temKv...)
 			app.keyValueHistories = append(app.keyValueHistories, tempKeyValueUpdateHistories...)
@@ -347,11 +504,12 @@ func (app *EVMApp) genExecFun(block *gtypes.Block, res *gtypes.ExecuteResult) Be
 	}
 }
 
-func makeCurrentHeader(block *gtypes.Block, header *gtypes.Header) *etypes.Header {
+func makeCurrentHeader(block *gtypes.Block, header *gtypes.Header, gasLimit uint64, coinbase common.Address) *etypes.Header {
 	return &etypes.Header{
 		ParentHash: common.BytesToHash(block.Header.LastBlockID.Hash),
+		Coinbase:   coinbase,
 		Difficulty: big.NewInt(0),
-		GasLimit:   math.MaxUint64,
+		GasLimit:   gasLimit,
 		Time:       big.NewInt(block.Header.Time.Unix()),
 		Number:     big.NewInt(header.Height),
 	}
@@ -366,7 +524,33 @@ func (app *EVMApp) OnExecute(height, round int64, block *gtypes.Block) (interfac
 	if app.currentState, err = estate.New(app.getLastAppHash(), estate.NewDatabase(app.stateDb)); err != nil {
 		return nil, errors.Wrap(err, "create StateDB failed")
 	}
-	exeWithCPUParallelVeirfy(app.Signer, block.Data.Txs, nil, app.genExecFun(block, &res))
+	app.currentHeader = makeCurrentHeader(block, block.Header, app.gasLimit, app.fee.Beneficiary(block.ProposerAddress))
+
+	var spec *speculation
+	if app.parallelWorkers > 0 {
+		spec = app.speculate(common.BytesToHash(block.Hash()), app.getLastAppHash(), block.Data.Txs)
+	}
+	exeWithCPUParallelVeirfy(app.Signer, block.Data.Txs, nil, app.genExecFun(block, &res, spec))
+	if spec != nil {
+		// the txs dropped before their turn may still be executing
+		spec.wait()
+	}
+	if app.kvStateAt(height) {
+		ops := app.kvs
+		if height == app.kvStateBlock {
+			// the kvs stored before the fork are folded in with the ones of the block
+			stored, err := kvStoreOps(app.stateDb)
+			if err != nil {
+				return nil, errors.Wrap(err, "read kv store failed")
+			}
+			ops = append(stored, ops...)
+		}
+		if len(ops) > 0 {
+			if app.kvRoot, err = applyKVOps(app.currentState, ops); err != nil {
+				return nil, errors.Wrap(err, "apply kv ops failed")
+			}
+		}
+	}
 
 	m := make(map[string]int)
 	for _, tx := range block.Data.Txs {
@@ -391,22 +575,35 @@ func (app *EVMApp) OnCommit(height, round int64, block *gtypes.Block) (interface
 	if err := app.currentState.Database().TrieDB().Commit(appHash, false); err != nil {
 		return nil, err
 	}
+	if err := commitKVTrie(app.currentState, app.kvRoot); err != nil {
+		return nil, err
+	}
+	app.kvRoot = common.Hash{}
+
+	// the receipts and logs are saved before the height, readers never find a committed block without them
+	rHash, err := app.SaveReceipts()
+	if err != nil {
+		log.Error("application save receipts", zap.Error(err), zap.Int64("height", block.Height))
+	}
+	if err := app.logIndexManager.SaveBlockLogs(uint64(height), app.receipts); err != nil {
+		log.Error("application index logs", zap.Error(err), zap.Int64("height", block.Height))
+	}
 
 	app.stateMtx.Lock()
 	if app.state, err = estate.New(appHash, estate.NewDatabase(app.stateDb)); err != nil {
 		app.stateMtx.Unlock()
 		return nil, errors.Wrap(err, "create StateDB failed")
 	}
-	app.stateMtx.Unlock()
-
+	// the height is saved with the state, readers get both of the same block
 	app.SaveLastBlock(LastBlockInfo{Height: height, AppHash: appHash.Bytes()})
+	app.stateMtx.Unlock()
 
-	rHash, err := app.SaveReceipts()
-	if err != nil {
-		log.Error("application save receipts", zap.Error(err), zap.Int64("height", block.Height))
+	if err := app.saveTxFailures(block); err != nil {
+		log.Error("application save failed txs", zap.Error(err), zap.Int64("height", block.Height))
 	}
-
+	app.updateTxStatuses(block)
 	app.receipts = nil
+	app.txFailures = nil
 	app.pool.updateToState()
 	log.Info("application save to db", zap.String("appHash", fmt.Sprintf("%X", appHash.Bytes())), zap.String("receiptHash", fmt.Sprintf("%X", rHash)))
 
@@ -427,7 +624,17 @@ func (app *EVMApp) CheckTx(bs []byte) (from common.Address, nonce uint64, err er
 	if err != nil {
 		return
 	}
-	from, _ = etypes.Sender(app.Signer, tx)
+	if from, err = etypes.Sender(app.Signer, tx); err != nil {
+		return
+	}
+	if !isKVTx(tx.Data()) {
+		if err = app.checkTxGas(tx); err != nil {
+			return
+		}
+		if err = app.checkIntrinsicGas(tx); err != nil {
+			return
+		}
+	}
 	app.stateMtx.Lock()
 	defer app.stateMtx.Unlock()
 	// Last but not least check for nonce errors
@@ -446,19 +653,8 @@ func (app *EVMApp) CheckTx(bs []byte) (from common.Address, nonce uint64, err er
 		return
 	}
 
-	txType := common.Bytes2Hex(tx.Data())
-
-	if strings.HasPrefix(txType, common.Bytes2Hex(rtypes.KVTxType)) {
-		txData := tx.Data()[len(rtypes.KVTxType):]
-		kvData := &rtypes.KV{}
-		if err = rlp.DecodeBytes(txData, kvData); err != nil {
-			err = fmt.Errorf("rlp decode to kv error %s", err.Error())
-			return
-		}
-		if len(kvData.Key) > MaxKey || len(kvData.Value) > MaxValue {
-			err = fmt.Errorf("key or value too big,MaxKey:%v,MaxValue:%v", MaxKey, MaxValue)
-			return
-		}
+	if isKVTx(tx.Data()) {
+		_, err = decodeKVOps(tx.Data())
 	}
 	return
 }
@@ -481,14 +677,26 @@ func (app *EVMApp) SaveReceipts() ([]byte, error) {
 		savedReceipts = append(savedReceipts, storageReceiptBytes)
 	}
 
-	for _, kv := range app.kvs {
-		kvBytes, err := rlp.EncodeToBytes(kv)
-		if err != nil {
-			return nil, fmt.Errorf("wrong rlp encode:%v", err.Error())
+	for _, op := range app.kvs {
+		var (
+			kvBytes []byte
+			err     error
+		)
+		key := append(KvPrefix, op.Key...)
+		if op.Op == rtypes.KVOpDelete {
+			kvBytes, err = rlp.EncodeToBytes(op)
+			if err == nil {
+				err = receiptBatch.Delete(key)
+			}
+		} else {
+			// puts keep the encoding of single kv txs
+			kvBytes, err = rlp.EncodeToBytes(&rtypes.KV{Key: op.Key, Value: op.Value})
+			if err == nil {
+				err = receiptBatch.Put(key, op.Value)
+			}
 		}
-		key := append(KvPrefix, kv.Key...)
-		if err := receiptBatch.Put(key, kv.Value); err != nil {
-			return nil, fmt.Errorf("batch receipt failed:%v", err.Error())
+		if err != nil {
+			return nil, fmt.Errorf("batch kv failed:%v", err.Error())
 		}
 		savedReceipts = append(savedReceipts, kvBytes)
 	}
@@ -501,6 +709,7 @@ func (app *EVMApp) SaveReceipts() ([]byte, error) {
 		log.Warnf("save key value history error", zap.Error(err))
 	}
 
+	app.kvs = nil
 	app.keyValueHistories = nil
 	rHash := merkle.SimpleHashFromHashes(savedReceipts)
 	return rHash, nil
@@ -536,6 +745,22 @@ func (app *EVMApp) Query(query []byte) (res gtypes.Result) {
 		res = app.queryContract(load[:len(load)-8], h)
 	case rtypes.QueryType_Nonce:
 		res = app.queryNonce(load)
+	case rtypes.QueryType_Balance:
+		res = app.queryBalance(load)
+	case rtypes.QueryType_Code:
+		res = app.queryCode(load)
+	case rtypes.QueryType_Storage:
+		res = app.queryStorage(load)
+	case rtypes.QueryType_Call:
+		res = app.queryCall(load)
+	case rtypes.QueryType_EstimateGas:
+		res = app.queryEstimateGas(load)
+	case rtypes.QueryType_Logs:
+		res = app.queryLogs(load)
+	case rtypes.QueryType_LogsBloom:
+		res = app.queryLogsBloom(load)
+	case rtypes.QueryType_BlockTxs:
+		res = app.queryBlockTxs(load)
 	case rtypes.QueryType_Receipt:
 		res = app.queryReceipt(load)
 	case rtypes.QueryType_Existence:
@@ -546,6 +771,20 @@ func (app *EVMApp) Query(query []byte) (res gtypes.Result) {
 		res = app.queryTransaction(load)
 	case rtypes.QueryType_Key:
 		res = app.queryKey(load)
+	case rtypes.QueryType_Key_At_Height:
+		res = app.queryKeyAtHeight(load)
+	case rtypes.QueryType_Proof:
+		res = app.queryProof(load)
+	case rtypes.QueryType_Simulate:
+		res = app.querySimulate(load)
+	case rtypes.QueryType_Trace:
+		res = app.queryTrace(load)
+	case rtypes.QueryType_TxPool:
+		res = app.queryTxPool(load)
+	case rtypes.QueryType_TxStatus:
+		res = app.queryTxStatus(load)
+	case rtypes.QueryType_Key_Proof:
+		res = app.queryKeyProof(load)
 	case rtypes.QueryType_Key_Prefix:
 		res = app.queryKeyWithPrefix(load)
 	case rtypes.QueryType_Pending_Nonce:
@@ -596,46 +835,233 @@ func (app *EVMApp) queryContract(load []byte, height uint64) gtypes.Result {
 	}
 	txMsg := etypes.NewMessage(from, tx.To(), 0, tx.Value(), tx.Gas(), tx.GasPrice(), tx.Data(), false)
 
-	bc := NewBlockChain(app.stateDb)
-
-	var vmEnv *vm.EVM
+	state, header, err := app.stateAt(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	envCxt := core.NewEVMContext(txMsg, header, NewBlockChain(app.stateDb), nil)
+	vmEnv := vm.NewEVM(envCxt, state, app.chainConfig, evmConfig)
 
-	if height == 0 {
+	gpl := new(core.GasPool).AddGas(math.MaxUint64)
+	res, _, _, err := core.ApplyMessage(vmEnv, txMsg, gpl) // we don't care about gasUsed
+	if err != nil {
+		log.Warn("query apply msg err", zap.Error(err))
+	}
 
-		envCxt := core.NewEVMContext(txMsg, app.currentHeader, bc, nil)
+	return gtypes.NewResultOK(res, "")
+}
 
+// stateAt returns a copy of the evm state after the block at height was committed,
+// together with the header used as execution context. Height 0 means the latest state.
+func (app *EVMApp) stateAt(height uint64) (*estate.StateDB, *etypes.Header, error) {
+	if height == 0 || height >= uint64(app.lastHeight()) {
 		app.stateMtx.Lock()
-		vmEnv = vm.NewEVM(envCxt, app.state.Copy(), app.chainConfig, evmConfig)
+		defer app.stateMtx.Unlock()
+		return app.state.Copy(), app.latestHeader(), nil
+	}
+
+	//appHash save in next block AppHash
+	blockMeta, err := app.core.GetBlockMeta(int64(height + 1))
+	if err != nil {
+		return nil, nil, err
+	}
+	trieRoot := EmptyTrieRoot
+	if len(blockMeta.Header.AppHash) > 0 {
+		trieRoot = common.BytesToHash(blockMeta.Header.AppHash)
+	}
+	state, err := estate.New(trieRoot, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, makeETHHeader(blockMeta.Header), nil
+}
+
+// stateWithHeight returns a copy of the evm state committed at height and the height of the copy,
+// the latest state and its height are read together. Height 0 means the latest state.
+func (app *EVMApp) stateWithHeight(height uint64) (*estate.StateDB, uint64, error) {
+	app.stateMtx.Lock()
+	last := uint64(app.lastHeight())
+	if height == 0 || height >= last {
+		state := app.state.Copy()
 		app.stateMtx.Unlock()
-	} else {
-		//appHash save in next block AppHash
-		height++
-		blockMeta, err := app.core.GetBlockMeta(int64(height))
-		if err != nil {
-			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
-		}
-		ethHeader := makeETHHeader(blockMeta.Header)
-		envCxt := core.NewEVMContext(txMsg, ethHeader, bc, nil)
+		return state, last, nil
+	}
+	app.stateMtx.Unlock()
+	state, _, err := app.stateAt(height)
+	return state, height, err
+}
 
-		trieRoot := EmptyTrieRoot
-		if len(blockMeta.Header.AppHash) > 0 {
-			trieRoot = common.BytesToHash(blockMeta.Header.AppHash)
-		}
+// latestHeader returns the header of the block being (or last) executed,
+// or a zero header if no block has been executed since start.
+func (app *EVMApp) latestHeader() *etypes.Header {
+	if app.currentHeader != nil {
+		return app.currentHeader
+	}
+	return &etypes.Header{
+		Difficulty: big.NewInt(0),
+		GasLimit:   app.gasLimit,
+		Time:       big.NewInt(0),
+		Number:     big.NewInt(app.lastHeight()),
+	}
+}
 
-		state, err := estate.New(trieRoot, estate.NewDatabase(app.stateDb))
-		if err != nil {
-			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
-		}
-		vmEnv = vm.NewEVM(envCxt, state, app.chainConfig, evmConfig)
+func (app *EVMApp) lastHeight() int64 {
+	lastBlock := &LastBlockInfo{
+		Height:  0,
+		AppHash: make([]byte, 0),
+	}
+	if res, err := app.LoadLastBlock(lastBlock); err == nil && res != nil {
+		lastBlock = res.(*LastBlockInfo)
+	}
+	return lastBlock.Height
+}
+
+// splitQueryHeight splits a state query load of n bytes followed by an optional height.
+func splitQueryHeight(load []byte, n int) ([]byte, uint64, error) {
+	switch len(load) {
+	case n:
+		return load, 0, nil
+	case n + rtypes.QueryHeightLen:
+		return load[:n], binary.BigEndian.Uint64(load[n:]), nil
+	}
+	return nil, 0, fmt.Errorf("wrong query length %d", len(load))
+}
+
+func (app *EVMApp) queryBalance(load []byte) gtypes.Result {
+	addrBytes, height, err := splitQueryHeight(load, common.AddressLength)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	state, _, err := app.stateAt(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(state.GetBalance(common.BytesToAddress(addrBytes)))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp encode error:"+err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+func (app *EVMApp) queryCode(load []byte) gtypes.Result {
+	addrBytes, height, err := splitQueryHeight(load, common.AddressLength)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	state, _, err := app.stateAt(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	return gtypes.NewResultOK(state.GetCode(common.BytesToAddress(addrBytes)), "")
+}
+
+func (app *EVMApp) queryStorage(load []byte) gtypes.Result {
+	body, height, err := splitQueryHeight(load, common.AddressLength+common.HashLength)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	state, _, err := app.stateAt(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	addr := common.BytesToAddress(body[:common.AddressLength])
+	key := common.BytesToHash(body[common.AddressLength:])
+	return gtypes.NewResultOK(state.GetState(addr, key).Bytes(), "")
+}
+
+// applyCallMsg executes an unsigned message with the given gas on a copy of the state,
+// nothing is persisted.
+func (app *EVMApp) applyCallMsg(msg *rtypes.CallMsg, gas uint64) ([]byte, uint64, bool, error) {
+	state, header, err := app.stateAt(msg.Height)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	var to *common.Address
+	if len(msg.To) > 0 {
+		addr := common.BytesToAddress(msg.To)
+		to = &addr
+	}
+	value, gasPrice := new(big.Int), new(big.Int)
+	if msg.Value != nil {
+		value.Set(msg.Value)
 	}
+	if msg.GasPrice != nil {
+		gasPrice.Set(msg.GasPrice)
+	}
+	txMsg := etypes.NewMessage(msg.From, to, 0, value, gas, gasPrice, msg.Data, false)
 
+	envCxt := core.NewEVMContext(txMsg, header, NewBlockChain(app.stateDb), nil)
+	vmEnv := vm.NewEVM(envCxt, state, app.chainConfig, evmConfig)
 	gpl := new(core.GasPool).AddGas(math.MaxUint64)
-	res, _, _, err := core.ApplyMessage(vmEnv, txMsg, gpl) // we don't care about gasUsed
+	return core.ApplyMessage(vmEnv, txMsg, gpl)
+}
+
+func decodeCallMsg(load []byte) (*rtypes.CallMsg, error) {
+	msg := &rtypes.CallMsg{}
+	if err := rlp.DecodeBytes(load, msg); err != nil {
+		return nil, err
+	}
+	if msg.Gas == 0 || msg.Gas > EVMGasLimit {
+		msg.Gas = EVMGasLimit
+	}
+	return msg, nil
+}
+
+func (app *EVMApp) queryCall(load []byte) gtypes.Result {
+	msg, err := decodeCallMsg(load)
 	if err != nil {
-		log.Warn("query apply msg err", zap.Error(err))
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp decode error:"+err.Error())
+	}
+	ret, _, failed, err := app.applyCallMsg(msg, msg.Gas)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	if failed {
+		return gtypes.Result{Code: gtypes.CodeType_InternalError, Data: ret, Log: "execution reverted"}
 	}
+	return gtypes.NewResultOK(ret, "")
+}
 
-	return gtypes.NewResultOK(res, "")
+// queryEstimateGas binary searches the lowest gas limit the message succeeds with,
+// the same way go-ethereum does, since refunds make gas used lower than gas needed.
+func (app *EVMApp) queryEstimateGas(load []byte) gtypes.Result {
+	msg, err := decodeCallMsg(load)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp decode error:"+err.Error())
+	}
+
+	executable := func(gas uint64) (bool, error) {
+		_, _, failed, err := app.applyCallMsg(msg, gas)
+		if err != nil {
+			if err == vm.ErrInsufficientBalance {
+				return false, err
+			}
+			return false, nil
+		}
+		return !failed, nil
+	}
+
+	lo, hi := params.TxGas-1, msg.Gas
+	if ok, err := executable(hi); err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	} else if !ok {
+		return gtypes.NewError(gtypes.CodeType_InternalError, fmt.Sprintf("gas required exceeds allowance (%d) or always failing transaction", hi))
+	}
+	for lo+1 < hi {
+		mid := (lo + hi) / 2
+		if ok, _ := executable(mid); ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+
+	data, err := rlp.EncodeToBytes(hi)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp encode error:"+err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
 }
 
 func makeETHHeader(header *gtypes.Header) *etypes.Header {
@@ -663,15 +1089,25 @@ func (app *EVMApp) queryPendingNonce(addrBytes []byte) gtypes.Result {
 	return gtypes.NewResultOK(data, "")
 }
 
-func (app *EVMApp) queryNonce(addrBytes []byte) gtypes.Result {
-	if len(addrBytes) != 20 {
+func (app *EVMApp) queryNonce(load []byte) gtypes.Result {
+	addrBytes, height, err := splitQueryHeight(load, common.AddressLength)
+	if err != nil {
 		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Invalid address")
 	}
 	addr := common.BytesToAddress(addrBytes)
 
-	app.stateMtx.Lock()
-	nonce := app.state.GetNonce(addr)
-	app.stateMtx.Unlock()
+	var nonce uint64
+	if height == 0 {
+		app.stateMtx.Lock()
+		nonce = app.state.GetNonce(addr)
+		app.stateMtx.Unlock()
+	} else {
+		state, _, err := app.stateAt(height)
+		if err != nil {
+			return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+		}
+		nonce = state.GetNonce(addr)
+	}
 
 	data, err := rlp.EncodeToBytes(nonce)
 	if err != nil {
@@ -681,14 +1117,120 @@ func (app *EVMApp) queryNonce(addrBytes []byte) gtypes.Result {
 }
 
 func (app *EVMApp) queryReceipt(txHashBytes []byte) gtypes.Result {
+	withFailure := len(txHashBytes) == common.HashLength+1 && txHashBytes[common.HashLength] == rtypes.QueryReceiptFailure
+	if withFailure {
+		txHashBytes = txHashBytes[:common.HashLength]
+	}
 	key := append(ReceiptsPrefix, txHashBytes...)
 	data, err := app.stateDb.Get(key)
 	if err != nil {
 		return gtypes.NewError(gtypes.CodeType_InternalError, "fail to get receipt for tx:"+string(key))
 	}
+	if !withFailure {
+		return gtypes.NewResultOK(data, "")
+	}
+	res, err := app.receiptResult(txHashBytes, data)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, err.Error())
+	}
+	return gtypes.NewResultOK(res, "")
+}
+
+// queryBlockTxs lists the transactions of the blocks from load[:8] to load[8:16] with their receipts,
+// archived blocks included
+func (app *EVMApp) queryBlockTxs(load []byte) gtypes.Result {
+	if len(load) != 2*rtypes.QueryHeightLen {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height range")
+	}
+	from := int64(binary.BigEndian.Uint64(load[:rtypes.QueryHeightLen]))
+	to := int64(binary.BigEndian.Uint64(load[rtypes.QueryHeightLen:]))
+	if from < 1 || from > to {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("invalid height range [%d, %d]", from, to))
+	}
+	if to-from >= rtypes.MaxBlockTxsRange {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("at most %d blocks per query", rtypes.MaxBlockTxsRange))
+	}
+
+	result := make([]*rtypes.BlockTxs, 0, to-from+1)
+	for height := from; height <= to; height++ {
+		block, _, err := app.core.GetBlock(height)
+		if err != nil {
+			return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+		}
+		if block == nil {
+			return gtypes.NewError(gtypes.CodeType_InternalError, fmt.Sprintf("block %d not found", height))
+		}
+		blockTxs := &rtypes.BlockTxs{
+			Height: uint64(height),
+			Hash:   block.Hash(),
+			Time:   uint64(block.Time.Unix()),
+			Txs:    make([]*rtypes.BlockTx, 0, len(block.Data.Txs)),
+		}
+		for i, raw := range block.Data.Txs {
+			tx := new(etypes.Transaction)
+			if err := rlp.DecodeBytes(raw, tx); err != nil {
+				// not an ethereum transaction, eg. adminOp
+				continue
+			}
+			btx := &rtypes.BlockTx{
+				Hash:  common.BytesToHash(raw.Hash()),
+				Index: uint64(i),
+				Tx:    tx,
+			}
+			if btx.From, err = etypes.Sender(app.Signer, tx); err != nil {
+				return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+			}
+			if isKVTx(tx.Data()) {
+				btx.KVOps, _ = decodeKVOps(tx.Data())
+			} else if receipt, err := app.stateDb.Get(append(ReceiptsPrefix, btx.Hash.Bytes()...)); err == nil {
+				btx.Receipt = receipt
+			}
+			blockTxs.Txs = append(blockTxs.Txs, btx)
+		}
+		result = append(result, blockTxs)
+	}
+
+	data, err := rlp.EncodeToBytes(result)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
 	return gtypes.NewResultOK(data, "")
 }
 
+func (app *EVMApp) queryLogs(load []byte) gtypes.Result {
+	filter := &rtypes.LogFilter{}
+	if err := rlp.DecodeBytes(load, filter); err != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, err.Error())
+	}
+	if last := uint64(app.lastHeight()); filter.ToBlock == 0 || filter.ToBlock > last {
+		filter.ToBlock = last
+	}
+	logs, err := app.logIndexManager.FilterLogs(filter)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	stored := make([]*etypes.LogForStorage, len(logs))
+	for i, l := range logs {
+		stored[i] = (*etypes.LogForStorage)(l)
+	}
+	data, err := rlp.EncodeToBytes(stored)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
+
+func (app *EVMApp) queryLogsBloom(load []byte) gtypes.Result {
+	if len(load) != rtypes.QueryHeightLen {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height")
+	}
+	bloom, err := app.logIndexManager.GetBloom(binary.BigEndian.Uint64(load))
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	return gtypes.NewResultOK(bloom.Bytes(), "")
+}
+
 func (app *EVMApp) queryTransaction(txHashBytes []byte) gtypes.Result {
 	if len(txHashBytes) == 0 {
 		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "Empty query")
@@ -736,6 +1278,23 @@ func (app *EVMApp) queryKey(load []byte) gtypes.Result {
 	return gtypes.NewResultOK(value, "")
 }
 
+// queryKeyAtHeight reads the value of load[8:] as of the height load[:8] from the key history
+func (app *EVMApp) queryKeyAtHeight(load []byte) gtypes.Result {
+	if len(load) <= rtypes.QueryHeightLen {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height or key")
+	}
+	height := binary.BigEndian.Uint64(load[:rtypes.QueryHeightLen])
+	key := load[rtypes.QueryHeightLen:]
+	history, err := app.keyValueHistoryManager.GetAsOf(key, height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, fmt.Sprintf("fail to get value history %s %v", string(key), err))
+	}
+	if history == nil || len(history.Value) == 0 {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("key %s not found at height %d", string(key), height))
+	}
+	return gtypes.NewResultOK(history.Value, "")
+}
+
 func (app *EVMApp) queryKeyWithPrefix(load []byte) gtypes.Result {
 	st := &struct {
 		Prefix  []byte
@@ -786,3 +1345,7 @@ func (app *EVMApp) queryKeyUpdateHistory(key []byte, pageNo uint32, pageSize uin
 func (app *EVMApp) SetCore(core gtypes.Core) {
 	app.core = core
 }
+
+func (app *EVMApp) ChainConfig() *params.ChainConfig {
+	return app.chainConfig
+}
@@ -0,0 +1,133 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	"github.com/dappledger/AnnChain/eth/common/math"
+	"github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+const (
+	FeeModeFree     = "free"     // gas is metered but costs nothing, gas prices must be 0
+	FeeModeTreasury = "treasury" // fees go to the treasury account
+	FeeModeProposer = "proposer" // fees go to the payout account of the proposer of the block
+)
+
+// FeeConfig is how the gas of the txs is paid. Without it, any gas price is
+// accepted and the fees go to the zero address, as on the chains made before it.
+type FeeConfig struct {
+	Mode        string                `json:"mode"`
+	MinGasPrice *math.HexOrDecimal256 `json:"minGasPrice,omitempty"` // lowest gas price of the paying modes
+	Treasury    *common.Address       `json:"treasury,omitempty"`
+	// Payouts maps the 0x prefixed hex addresses of the validators to the accounts their fees go to in the proposer mode,
+	// a validator address is no account. The fees of the proposers without payout are burned
+	Payouts map[string]common.Address `json:"payouts,omitempty"`
+}
+
+func (f *FeeConfig) Validate() error {
+	switch f.Mode {
+	case FeeModeFree:
+		if f.MinGasPrice != nil && (*big.Int)(f.MinGasPrice).Sign() != 0 {
+			return fmt.Errorf("fee.minGasPrice is set in the free mode")
+		}
+	case FeeModeTreasury:
+		if f.Treasury == nil {
+			return fmt.Errorf("fee.treasury is missing")
+		}
+	case FeeModeProposer:
+		if len(f.Payouts) == 0 {
+			return fmt.Errorf("fee.payouts is missing")
+		}
+	default:
+		return fmt.Errorf("unknown fee.mode %q", f.Mode)
+	}
+	if f.Mode != FeeModeTreasury && f.Treasury != nil {
+		return fmt.Errorf("fee.treasury is set in the %s mode", f.Mode)
+	}
+	if f.Mode != FeeModeProposer && len(f.Payouts) > 0 {
+		return fmt.Errorf("fee.payouts is set in the %s mode", f.Mode)
+	}
+	for validator := range f.Payouts {
+		if _, err := hexutil.Decode(validator); err != nil {
+			return fmt.Errorf("fee.payouts has an invalid validator address %q: %v", validator, err)
+		}
+	}
+	if f.MinGasPrice != nil && (*big.Int)(f.MinGasPrice).Sign() < 0 {
+		return fmt.Errorf("fee.minGasPrice is negative")
+	}
+	return nil
+}
+
+// CheckGasPrice checks the gas price of a tx
+func (f *FeeConfig) CheckGasPrice(price *big.Int) error {
+	switch {
+	case f == nil:
+		return nil
+	case f.Mode == FeeModeFree:
+		if price.Sign() != 0 {
+			return fmt.Errorf("gas price %v isn't 0, gas is free", price)
+		}
+	case f.MinGasPrice != nil:
+		if price.Cmp((*big.Int)(f.MinGasPrice)) < 0 {
+			return fmt.Errorf("gas price %v is below the minimum %v", price, (*big.Int)(f.MinGasPrice))
+		}
+	}
+	return nil
+}
+
+// Beneficiary is the account the fees of a block proposed by proposer go to
+func (f *FeeConfig) Beneficiary(proposer []byte) common.Address {
+	switch {
+	case f == nil:
+		return common.Address{}
+	case f.Mode == FeeModeTreasury:
+		return *f.Treasury
+	case f.Mode == FeeModeProposer:
+		for validator, payout := range f.Payouts {
+			if addr, _ := hexutil.Decode(validator); bytes.Equal(addr, proposer) {
+				return payout
+			}
+		}
+	}
+	return common.Address{}
+}
+
+// checkTxGas checks the gas limit and the gas price of an evm tx, a block made of
+// txs failing it is invalid
+func (app *EVMApp) checkTxGas(tx *etypes.Transaction) error {
+	if tx.Gas() > app.txGasLimit {
+		return fmt.Errorf("tx gas %d exceeds the limit %d", tx.Gas(), app.txGasLimit)
+	}
+	return app.fee.CheckGasPrice(tx.GasPrice())
+}
+
+// checkIntrinsicGas refuses the txs which can't pay for their data
+func (app *EVMApp) checkIntrinsicGas(tx *etypes.Transaction) error {
+	homestead := app.chainConfig.IsHomestead(big.NewInt(app.lastHeight() + 1))
+	gas, err := core.IntrinsicGas(tx.Data(), tx.To() == nil, homestead)
+	if err != nil {
+		return err
+	}
+	if tx.Gas() < gas {
+		return core.ErrIntrinsicGas
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	"github.com/dappledger/AnnChain/eth/common/math"
+	"github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestGasLimitsAndFees(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	treasury := common.HexToAddress("0x0e")
+	payout := common.HexToAddress("0x0f")
+	// the address of a validator, a hash of its node pubkey, isn't the account of its fees
+	proposer := []byte("validator-address-01")
+	signTx := func(nonce, gas, price uint64) []byte {
+		tx := etypes.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(1), gas, new(big.Int).SetUint64(price), nil)
+		signed, err := etypes.SignTx(tx, etypes.NewEIP155Signer(big.NewInt(1001)), key)
+		assert.NoError(t, err)
+		raw, err := rlp.EncodeToBytes(signed)
+		assert.NoError(t, err)
+		return raw
+	}
+
+	for mode, beneficiary := range map[string]common.Address{FeeModeTreasury: treasury, FeeModeProposer: payout} {
+		fee := map[string]interface{}{"mode": mode, "minGasPrice": "2"}
+		if mode == FeeModeTreasury {
+			fee["treasury"] = treasury.Hex()
+		} else {
+			fee["payouts"] = map[string]string{hexutil.Encode(proposer): payout.Hex()}
+		}
+		app, closeApp := newTestAppWithGenesis(t, []*ecdsa.PrivateKey{key}, false, map[string]interface{}{
+			"gasLimit":   "100000",
+			"txGasLimit": "50000",
+			"fee":        fee,
+		})
+
+		_, _, err = app.CheckTx(signTx(0, 60000, 2))
+		assert.Error(t, err, "over the tx gas limit")
+		_, _, err = app.CheckTx(signTx(0, 21000, 1))
+		assert.Error(t, err, "below the min gas price")
+		_, _, err = app.CheckTx(signTx(0, 20000, 2))
+		assert.Equal(t, core.ErrIntrinsicGas, err)
+
+		// a block holds 4 txs of 21000 gas, the pool promotes the queued ones with the first
+		txs := make(gtypes.Txs, 6)
+		for nonce := len(txs) - 1; nonce >= 0; nonce-- {
+			txs[nonce] = signTx(uint64(nonce), 21000, 2)
+			_, _, err = app.CheckTx(txs[nonce])
+			assert.NoError(t, err)
+			assert.NoError(t, app.pool.ReceiveTx(txs[nonce]))
+		}
+		assert.Len(t, app.pool.Reap(-1), 4, mode)
+
+		block, _ := gtypes.MakeBlock(1, "test", txs, nil, &gtypes.Commit{}, proposer, gtypes.BlockID{}, nil, nil, nil, 65536)
+		block.Time = time.Unix(1500000000, 0)
+		res, err := app.OnExecute(1, 0, block)
+		assert.NoError(t, err)
+		assert.Len(t, res.(gtypes.ExecuteResult).ValidTxs, 4, mode)
+		assert.Len(t, res.(gtypes.ExecuteResult).InvalidTxs, 2, mode)
+		_, err = app.OnCommit(1, 0, block)
+		assert.NoError(t, err)
+		assert.Equal(t, big.NewInt(4*21000*2), app.state.GetBalance(beneficiary), mode)
+		assert.Equal(t, big.NewInt(0), app.state.GetBalance(common.BytesToAddress(proposer)), mode)
+		closeApp()
+	}
+}
+
+func TestFeeConfigValidate(t *testing.T) {
+	treasury := common.HexToAddress("0x0e")
+	price := func(p int64) *big.Int { return big.NewInt(p) }
+	payouts := map[string]common.Address{"0x0a0b": treasury}
+	for name, fee := range map[string]*FeeConfig{
+		"unknown mode":        {Mode: "burn"},
+		"no treasury":         {Mode: FeeModeTreasury},
+		"treasury elsewhere":  {Mode: FeeModeTreasury, Treasury: &treasury, Payouts: payouts},
+		"no payouts":          {Mode: FeeModeProposer},
+		"payout elsewhere":    {Mode: FeeModeProposer, Treasury: &treasury, Payouts: payouts},
+		"payout of no hex":    {Mode: FeeModeProposer, Payouts: map[string]common.Address{"validator": treasury}},
+		"free with min price": {Mode: FeeModeFree, MinGasPrice: (*math.HexOrDecimal256)(price(1))},
+		"negative min price":  {Mode: FeeModeProposer, Payouts: payouts, MinGasPrice: (*math.HexOrDecimal256)(price(-1))},
+	} {
+		assert.Error(t, fee.Validate(), name)
+	}
+
+	free := &FeeConfig{Mode: FeeModeFree}
+	assert.NoError(t, free.Validate())
+	assert.NoError(t, free.CheckGasPrice(price(0)))
+	assert.Error(t, free.CheckGasPrice(price(1)))
+	assert.Equal(t, common.Address{}, free.Beneficiary(treasury.Bytes()))
+
+	proposer := &FeeConfig{Mode: FeeModeProposer, Payouts: payouts}
+	assert.NoError(t, proposer.Validate())
+	assert.Equal(t, treasury, proposer.Beneficiary([]byte{0x0a, 0x0b}))
+	assert.Equal(t, common.Address{}, proposer.Beneficiary([]byte{0x0a}), "no payout")
+
+	// chains made before fees were configured take any price, and burn it
+	var legacy *FeeConfig
+	assert.NoError(t, legacy.CheckGasPrice(price(5)))
+	assert.Equal(t, common.Address{}, legacy.Beneficiary(treasury.Bytes()))
+}
@@ -0,0 +1,240 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	"github.com/dappledger/AnnChain/eth/common/math"
+	"github.com/dappledger/AnnChain/eth/core"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/params"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var genesisKey = []byte("evm-genesis")
+
+// Genesis is the evm section of a genesis doc, its app_state
+type Genesis struct {
+	Config     *params.ChainConfig               `json:"config"`
+	GasLimit   math.HexOrDecimal64               `json:"gasLimit,omitempty"`   // block gas limit, unlimited when 0
+	TxGasLimit math.HexOrDecimal64               `json:"txGasLimit,omitempty"` // tx gas limit, the block one when 0
+	Fee        *FeeConfig                        `json:"fee,omitempty"`
+	Alloc      map[common.Address]GenesisAccount `json:"alloc,omitempty"`
+
+	// AllowLegacyTx accepts the txs without EIP-155 replay protection
+	AllowLegacyTx bool `json:"allowLegacyTx,omitempty"`
+	// DoubleSignPunishment is what the validators signing conflicting votes get, remove (the default), halve or none
+	DoubleSignPunishment string `json:"doubleSignPunishment,omitempty"`
+	// KVStateBlock is the first block folding the kv store into the state root, never when unset.
+	// A chain already running, with or without app_state, schedules it by setting it in the app_state of
+	// the genesis file of every node above the current height, see scheduleKVState
+	KVStateBlock *math.HexOrDecimal64 `json:"kvStateBlock,omitempty"`
+}
+
+// GenesisAccount is an account of the genesis state
+type GenesisAccount struct {
+	Balance *math.HexOrDecimal256       `json:"balance,omitempty"`
+	Nonce   math.HexOrDecimal64         `json:"nonce,omitempty"`
+	Code    hexutil.Bytes               `json:"code,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// DefaultGenesis is the genesis of the chains whose genesis doc has no app_state,
+// they were made before EIP-155 txs and keep accepting the legacy ones
+func DefaultGenesis() *Genesis {
+	return &Genesis{Config: params.MainnetChainConfig, AllowLegacyTx: true}
+}
+
+// GenesisFromDoc reads and checks the evm genesis of genDoc
+func GenesisFromDoc(genDoc *gtypes.GenesisDoc) (*Genesis, error) {
+	if genDoc == nil || len(genDoc.AppState) == 0 {
+		return DefaultGenesis(), nil
+	}
+	g := &Genesis{}
+	decoder := json.NewDecoder(bytes.NewReader(genDoc.AppState))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(g); err != nil {
+		return nil, fmt.Errorf("invalid app_state: %v", err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid app_state: %v", err)
+	}
+	return g, nil
+}
+
+// Validate checks the chain config and the accounts of g
+func (g *Genesis) Validate() error {
+	if g.Config == nil {
+		return fmt.Errorf("config is missing")
+	}
+	if g.Config.ChainID == nil || g.Config.ChainID.Sign() <= 0 {
+		return fmt.Errorf("config.chainId must be positive")
+	}
+	// forks activate in order, a fork can't be scheduled after one that never happens
+	forks := []struct {
+		name  string
+		block *big.Int
+	}{
+		{"homesteadBlock", g.Config.HomesteadBlock},
+		{"eip150Block", g.Config.EIP150Block},
+		{"eip155Block", g.Config.EIP155Block},
+		{"eip158Block", g.Config.EIP158Block},
+		{"byzantiumBlock", g.Config.ByzantiumBlock},
+		{"constantinopleBlock", g.Config.ConstantinopleBlock},
+	}
+	var last *big.Int
+	lastName := ""
+	for i, fork := range forks {
+		if fork.block != nil && fork.block.Sign() < 0 {
+			return fmt.Errorf("config.%s is negative", fork.name)
+		}
+		if i > 0 {
+			if last == nil && fork.block != nil {
+				return fmt.Errorf("config.%s is set while config.%s isn't", fork.name, lastName)
+			}
+			if last != nil && fork.block != nil && fork.block.Cmp(last) < 0 {
+				return fmt.Errorf("config.%s is before config.%s", fork.name, lastName)
+			}
+		}
+		last, lastName = fork.block, fork.name
+	}
+	if uint64(g.TxGasLimit) > EVMGasLimit {
+		return fmt.Errorf("txGasLimit exceeds the evm limit %d", EVMGasLimit)
+	}
+	if g.GasLimit != 0 && g.TxGasLimit > g.GasLimit {
+		return fmt.Errorf("txGasLimit exceeds gasLimit")
+	}
+	if g.Fee != nil {
+		if err := g.Fee.Validate(); err != nil {
+			return err
+		}
+	}
+	switch g.DoubleSignPunishment {
+	case "", PunishRemove, PunishHalve, PunishNone:
+	default:
+		return fmt.Errorf("unknown doubleSignPunishment %q", g.DoubleSignPunishment)
+	}
+	for addr, account := range g.Alloc {
+		if addr == core.AdminTo {
+			return fmt.Errorf("alloc %s is the admin contract", addr.Hex())
+		}
+		if account.Balance != nil && (*big.Int)(account.Balance).Sign() < 0 {
+			return fmt.Errorf("alloc %s has a negative balance", addr.Hex())
+		}
+	}
+	return nil
+}
+
+// ToAlloc returns the accounts of the genesis state, the admin contract included
+func (g *Genesis) ToAlloc() core.GenesisAlloc {
+	alloc := core.DefaultGenesis().Alloc
+	for addr, account := range g.Alloc {
+		balance := new(big.Int)
+		if account.Balance != nil {
+			balance.Set((*big.Int)(account.Balance))
+		}
+		alloc[addr] = core.GenesisAccount{
+			Balance: balance,
+			Nonce:   uint64(account.Nonce),
+			Code:    account.Code,
+			Storage: account.Storage,
+		}
+	}
+	return alloc
+}
+
+// BlockGasLimit is the gas limit of every block
+func (g *Genesis) BlockGasLimit() uint64 {
+	if g.GasLimit == 0 {
+		return math.MaxUint64
+	}
+	return uint64(g.GasLimit)
+}
+
+// MaxTxGas is the gas limit of every tx
+func (g *Genesis) MaxTxGas() uint64 {
+	if g.TxGasLimit == 0 {
+		return g.BlockGasLimit()
+	}
+	return uint64(g.TxGasLimit)
+}
+
+// Punishment is the punishment of double signing
+func (g *Genesis) Punishment() string {
+	if g.DoubleSignPunishment == "" {
+		return PunishRemove
+	}
+	return g.DoubleSignPunishment
+}
+
+// KVStateHeight is the first height whose state root holds the kv store, -1 if none does
+func (g *Genesis) KVStateHeight() int64 {
+	if g.KVStateBlock == nil {
+		return -1
+	}
+	return int64(*g.KVStateBlock)
+}
+
+func saveGenesis(db ethdb.Database, g *Genesis) error {
+	data, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	return db.Put(genesisKey, data)
+}
+
+// scheduleKVState takes the kv state fork of doc, the genesis read from the genesis doc of a chain already
+// at height, the rest of doc doesn't apply to the chain. It returns if g changed
+func (g *Genesis) scheduleKVState(doc *Genesis, height int64) (bool, error) {
+	if doc.KVStateBlock == nil {
+		return false, nil
+	}
+	if g.KVStateBlock != nil {
+		if *g.KVStateBlock != *doc.KVStateBlock {
+			return false, fmt.Errorf("kvStateBlock %d mismatches the scheduled %d", *doc.KVStateBlock, *g.KVStateBlock)
+		}
+		return false, nil
+	}
+	if int64(*doc.KVStateBlock) <= height {
+		return false, fmt.Errorf("kvStateBlock %d must be above the current height %d", *doc.KVStateBlock, height)
+	}
+	g.KVStateBlock = doc.KVStateBlock
+	return true, nil
+}
+
+// loadGenesis returns the genesis the app state was made from, chains made before it was saved use the default one
+func loadGenesis(db ethdb.Database) (*Genesis, error) {
+	has, err := db.Has(genesisKey)
+	if err != nil {
+		return nil, err
+	}
+	if !has {
+		return DefaultGenesis(), nil
+	}
+	data, err := db.Get(genesisKey)
+	if err != nil {
+		return nil, err
+	}
+	g := &Genesis{}
+	if err := json.Unmarshal(data, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
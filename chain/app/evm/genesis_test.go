@@ -0,0 +1,130 @@
+package evm
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/math"
+	"github.com/dappledger/AnnChain/eth/core"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testGenesis = `{
+  "genesis_time": "2019-01-01T00:00:00.000Z",
+  "chain_id": "test",
+  "validators": [],
+  "app_hash": "",
+  "plugins": "",
+  "app_state": {
+    "config": {"chainId": 1001, "homesteadBlock": 0, "eip150Block": 0, "eip155Block": 0, "eip158Block": 0, "byzantiumBlock": 10},
+    "gasLimit": "0x1000000",
+    "alloc": {
+      "0x0000000000000000000000000000000000000001": {"balance": "1000000000000000000"},
+      "0x0000000000000000000000000000000000000002": {"code": "0x60006000", "nonce": "0x1", "storage": {"0x0000000000000000000000000000000000000000000000000000000000000001": "0x0000000000000000000000000000000000000000000000000000000000000002"}}
+    }
+  }
+}`
+
+func TestGenesisFromDoc(t *testing.T) {
+	genDoc, err := gtypes.GenesisDocFromJSONRet([]byte(testGenesis))
+	assert.NoError(t, err)
+	g, err := GenesisFromDoc(genDoc)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1001), g.Config.ChainID.Int64())
+	assert.Equal(t, uint64(0x1000000), g.BlockGasLimit())
+	assert.False(t, g.AllowLegacyTx)
+
+	// app_state survives saving the genesis doc
+	again, err := gtypes.GenesisDocFromJSONRet(genDoc.JSONBytes())
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(genDoc.AppState), string(again.AppState))
+
+	db := ethdb.NewMemDatabase()
+	root := (&core.Genesis{Alloc: g.ToAlloc()}).ToBlock(db).Root()
+	state, err := estate.New(root, estate.NewDatabase(db))
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(1e18), state.GetBalance(common.HexToAddress("0x01")))
+	assert.Equal(t, []byte{0x60, 0x00, 0x60, 0x00}, state.GetCode(common.HexToAddress("0x02")))
+	assert.Equal(t, uint64(1), state.GetNonce(common.HexToAddress("0x02")))
+	assert.Equal(t, common.HexToHash("0x02"), state.GetState(common.HexToAddress("0x02"), common.HexToHash("0x01")))
+	assert.NotEmpty(t, state.GetCode(core.AdminTo))
+
+	// without app_state, the genesis state is the one of the chains made before it
+	g, err = GenesisFromDoc(&gtypes.GenesisDoc{})
+	assert.NoError(t, err)
+	assert.True(t, g.AllowLegacyTx)
+	defaultGenesis := core.DefaultGenesis()
+	assert.Equal(t, defaultGenesis.ToBlock(ethdb.NewMemDatabase()).Root(), (&core.Genesis{Alloc: g.ToAlloc()}).ToBlock(ethdb.NewMemDatabase()).Root())
+	assert.NotEqual(t, root, defaultGenesis.ToBlock(ethdb.NewMemDatabase()).Root())
+
+	assert.NoError(t, saveGenesis(db, g))
+	loaded, err := loadGenesis(db)
+	assert.NoError(t, err)
+	assert.Equal(t, g.Config.ChainID, loaded.Config.ChainID)
+}
+
+// failingDB fails every read
+type failingDB struct {
+	*ethdb.MemDatabase
+}
+
+func (db failingDB) Has([]byte) (bool, error)   { return false, errors.New("io error") }
+func (db failingDB) Get([]byte) ([]byte, error) { return nil, errors.New("io error") }
+
+func TestLoadGenesis(t *testing.T) {
+	// only the chains without a saved genesis use the default one
+	g, err := loadGenesis(ethdb.NewMemDatabase())
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultGenesis(), g)
+	_, err = loadGenesis(failingDB{ethdb.NewMemDatabase()})
+	assert.Error(t, err)
+}
+
+func TestScheduleKVState(t *testing.T) {
+	block := func(n uint64) *Genesis {
+		b := math.HexOrDecimal64(n)
+		return &Genesis{KVStateBlock: &b}
+	}
+	g := DefaultGenesis()
+	scheduled, err := g.scheduleKVState(&Genesis{}, 10)
+	assert.NoError(t, err)
+	assert.False(t, scheduled)
+	_, err = g.scheduleKVState(block(10), 10)
+	assert.Error(t, err, "at the current height")
+	assert.Equal(t, int64(-1), g.KVStateHeight())
+
+	scheduled, err = g.scheduleKVState(block(11), 10)
+	assert.NoError(t, err)
+	assert.True(t, scheduled)
+	assert.Equal(t, int64(11), g.KVStateHeight())
+	scheduled, err = g.scheduleKVState(block(11), 20)
+	assert.NoError(t, err)
+	assert.False(t, scheduled)
+	_, err = g.scheduleKVState(block(30), 20)
+	assert.Error(t, err, "moved once scheduled")
+	assert.Equal(t, int64(11), g.KVStateHeight())
+}
+
+func TestGenesisValidate(t *testing.T) {
+	for name, appState := range map[string]string{
+		"no config":       `{"alloc": {}}`,
+		"no chain id":     `{"config": {"homesteadBlock": 0}}`,
+		"unknown field":   `{"config": {"chainId": 1}, "allocs": {}}`,
+		"fork order":      `{"config": {"chainId": 1, "homesteadBlock": 10, "eip150Block": 5}}`,
+		"fork after nil":  `{"config": {"chainId": 1, "homesteadBlock": 0, "eip155Block": 5}}`,
+		"admin contract":  `{"config": {"chainId": 1}, "alloc": {"0x0000000000000000000000000000000002000000": {"balance": "1"}}}`,
+		"negative amount": `{"config": {"chainId": 1}, "alloc": {"0x0000000000000000000000000000000000000001": {"balance": "-1"}}}`,
+		"tx gas limit":    `{"config": {"chainId": 1}, "txGasLimit": "1000000000"}`,
+		"tx over block":   `{"config": {"chainId": 1}, "gasLimit": "100000", "txGasLimit": "200000"}`,
+		"fee mode":        `{"config": {"chainId": 1}, "fee": {"mode": "burn"}}`,
+		"punishment":      `{"config": {"chainId": 1}, "doubleSignPunishment": "removes"}`,
+	} {
+		_, err := GenesisFromDoc(&gtypes.GenesisDoc{AppState: []byte(appState)})
+		assert.Error(t, err, name)
+	}
+}
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/dappledger/AnnChain/eth/ethdb"
 	"github.com/dappledger/AnnChain/eth/rlp"
 	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
 	"github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/syndtr/goleveldb/leveldb"
 	"go.uber.org/zap"
 )
 
@@ -119,6 +121,38 @@ func (m *KeyValueHistoryManager) get(key []byte, index uint32) (*types.ValueUpda
 	return history, nil
 }
 
+// GetAsOf returns the last update of key committed at or below height, nil if there is none
+func (m *KeyValueHistoryManager) GetAsOf(key []byte, height uint64) (*types.ValueUpdateHistory, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	size, err := m.getKeyHistorySize(key)
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// histories are appended in commit order, find the first one above height
+	var searchErr error
+	idx := sort.Search(int(size), func(i int) bool {
+		if searchErr != nil {
+			return true
+		}
+		history, err := m.get(key, uint32(i))
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return history.BlockHeight > height
+	})
+	if searchErr != nil {
+		return nil, searchErr
+	}
+	if idx == 0 {
+		return nil, nil
+	}
+	return m.get(key, uint32(idx-1))
+}
 
 func (m *KeyValueHistoryManager) Query(key []byte, pageNo uint32, pageSize uint32) (histories []*types.ValueUpdateHistory, total uint32, err error) {
 	if pageNo < 1 {
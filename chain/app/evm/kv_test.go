@@ -2,6 +2,7 @@ package evm
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
@@ -60,3 +61,33 @@ func TestLevelDb(t *testing.T) {
 		assert.Equal(t, v.Value, genValue("value", fmt.Sprintf("%d_%d", from-i, 4)))
 	}
 }
+
+func TestGetAsOf(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kvhistory")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	m := NewKeyValueHistoryManager(db)
+	defer m.Close()
+
+	key := []byte("key")
+	update := func(height uint64, value string) *types.KeyValueHistory {
+		return &types.KeyValueHistory{Key: key, ValueUpdateHistory: &types.ValueUpdateHistory{BlockHeight: height, Value: []byte(value)}}
+	}
+	assert.NoError(t, m.SaveKeyHistory(types.KeyValueHistories{update(3, "a"), update(5, "b"), update(5, "c")}))
+	// deleted at 8
+	assert.NoError(t, m.SaveKeyHistory(types.KeyValueHistories{update(8, "")}))
+
+	history, err := m.GetAsOf(key, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, history)
+	for height, value := range map[uint64]string{3: "a", 4: "a", 5: "c", 7: "c", 8: "", 100: ""} {
+		history, err = m.GetAsOf(key, height)
+		assert.NoError(t, err)
+		assert.Equal(t, value, string(history.Value), "height %d", height)
+	}
+	history, err = m.GetAsOf([]byte("missing"), 10)
+	assert.NoError(t, err)
+	assert.Nil(t, history)
+}
@@ -0,0 +1,169 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// kvStateAt tells if the state committed at height holds the kv store
+func (app *EVMApp) kvStateAt(height int64) bool {
+	return app.kvStateBlock >= 0 && height >= app.kvStateBlock
+}
+
+// checkKVState fails if the state committed at height misses the kvs of the kv store
+func (app *EVMApp) checkKVState(height int64) error {
+	if app.kvStateAt(height) {
+		return nil
+	}
+	ops, err := kvStoreOps(app.stateDb)
+	if err != nil {
+		return err
+	}
+	if len(ops) > 0 {
+		return fmt.Errorf("the kv store isn't in the state of height %d", height)
+	}
+	return nil
+}
+
+// kvStoreOps returns the kvs of the kv store as puts
+func kvStoreOps(db ethdb.Database) ([]*rtypes.KVOp, error) {
+	iterable, ok := db.(prefixIterable)
+	if !ok {
+		return nil, fmt.Errorf("kv store can't be iterated")
+	}
+	var ops []*rtypes.KVOp
+	it := iterable.NewIteratorWithPrefix(KvPrefix)
+	defer it.Release()
+	for it.Next() {
+		ops = append(ops, &rtypes.KVOp{
+			Op:    rtypes.KVOpPut,
+			Key:   common.CopyBytes(it.Key()[len(KvPrefix):]),
+			Value: common.CopyBytes(it.Value()),
+		})
+	}
+	return ops, it.Error()
+}
+
+func kvTrieAt(state *estate.StateDB) (*trie.SecureTrie, common.Hash, error) {
+	root := state.GetState(rtypes.KVStateAddress, rtypes.KVRootSlot)
+	t, err := trie.NewSecure(root, state.Database().TrieDB(), 0)
+	return t, root, err
+}
+
+// applyKVOps writes ops into the kv trie of state and stores the new trie root in the storage
+// of rtypes.KVStateAddress, so the kv store is part of the state root. The trie nodes stay in the
+// trie database of state until commitKVTrie.
+func applyKVOps(state *estate.StateDB, ops []*rtypes.KVOp) (common.Hash, error) {
+	t, _, err := kvTrieAt(state)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for _, op := range ops {
+		if op.Op == rtypes.KVOpDelete {
+			err = t.TryDelete(op.Key)
+		} else {
+			err = t.TryUpdate(op.Key, op.Value)
+		}
+		if err != nil {
+			return common.Hash{}, err
+		}
+	}
+	root, err := t.Commit(nil)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	// a nonce keeps the account from being removed as empty
+	if state.GetNonce(rtypes.KVStateAddress) == 0 {
+		state.SetNonce(rtypes.KVStateAddress, 1)
+	}
+	state.SetState(rtypes.KVStateAddress, rtypes.KVRootSlot, root)
+	return root, nil
+}
+
+// commitKVTrie persists the kv trie nodes, they aren't referenced by the state trie
+func commitKVTrie(state *estate.StateDB, root common.Hash) error {
+	if root == (common.Hash{}) {
+		return nil
+	}
+	return state.Database().TrieDB().Commit(root, false)
+}
+
+func proveKV(state *estate.StateDB, key []byte) (*rtypes.KVProof, error) {
+	proof := &rtypes.KVProof{
+		Key:       key,
+		StateRoot: state.IntermediateRoot(false),
+	}
+	t, root, err := kvTrieAt(state)
+	if err != nil {
+		return nil, err
+	}
+	proof.KVRoot = root
+	if proof.AccountProof, err = state.GetProof(rtypes.KVStateAddress); err != nil {
+		return nil, err
+	}
+	if root == (common.Hash{}) {
+		return proof, nil
+	}
+	if proof.StorageProof, err = state.GetStorageProof(rtypes.KVStateAddress, rtypes.KVRootSlot); err != nil {
+		return nil, err
+	}
+	if proof.Value, err = t.TryGet(key); err != nil {
+		return nil, err
+	}
+	var nodes proofList
+	if err = t.Prove(crypto.Keccak256(key), 0, &nodes); err != nil {
+		return nil, err
+	}
+	proof.Proof = nodes
+	return proof, nil
+}
+
+// queryKeyProof proves the value of load[8:] in the state committed at height load[:8], 0 for latest
+func (app *EVMApp) queryKeyProof(load []byte) gtypes.Result {
+	if len(load) <= rtypes.QueryHeightLen {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong height or key")
+	}
+	height := binary.BigEndian.Uint64(load[:rtypes.QueryHeightLen])
+	key := load[rtypes.QueryHeightLen:]
+	state, proofHeight, err := app.stateWithHeight(height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	if !app.kvStateAt(int64(proofHeight)) {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("the kv store isn't in the state of height %d", proofHeight))
+	}
+
+	proof, err := proveKV(state, key)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	proof.Height = proofHeight
+
+	data, err := rlp.EncodeToBytes(proof)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, fmt.Sprintf("rlp encode error:%v", err))
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,187 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/chain/verifier"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKVState(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	commit := func(state *estate.StateDB, kvRoot common.Hash) *estate.StateDB {
+		root, err := state.Commit(true)
+		assert.NoError(t, err)
+		assert.NoError(t, state.Database().TrieDB().Commit(root, false))
+		assert.NoError(t, commitKVTrie(state, kvRoot))
+		state, err = estate.New(root, estate.NewDatabase(db))
+		assert.NoError(t, err)
+		return state
+	}
+
+	state, err := estate.New(common.Hash{}, estate.NewDatabase(db))
+	assert.NoError(t, err)
+	state.AddBalance(common.HexToAddress("0x01"), big.NewInt(1))
+	state = commit(state, common.Hash{})
+	noKVRoot := state.IntermediateRoot(false)
+
+	// nothing written yet
+	proof, err := proveKV(state, []byte("a"))
+	assert.NoError(t, err)
+	assert.NoError(t, verifier.VerifyKVProof(noKVRoot, proof))
+
+	kvRoot, err := applyKVOps(state, []*rtypes.KVOp{
+		{Op: rtypes.KVOpPut, Key: []byte("a"), Value: []byte("1")},
+		{Op: rtypes.KVOpPut, Key: []byte("b"), Value: []byte("2")},
+	})
+	assert.NoError(t, err)
+	state = commit(state, kvRoot)
+	root := state.IntermediateRoot(false)
+	assert.NotEqual(t, noKVRoot, root, "kv writes change the state root")
+
+	proof, err = proveKV(state, []byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(proof.Value))
+	assert.NoError(t, verifier.VerifyKVProof(root, proof))
+	assert.Error(t, verifier.VerifyKVProof(noKVRoot, proof))
+	proof.Value = []byte("2")
+	assert.Error(t, verifier.VerifyKVProof(root, proof), "forged value")
+
+	kvRoot, err = applyKVOps(state, []*rtypes.KVOp{{Op: rtypes.KVOpDelete, Key: []byte("a")}})
+	assert.NoError(t, err)
+	state = commit(state, kvRoot)
+	proof, err = proveKV(state, []byte("a"))
+	assert.NoError(t, err)
+	assert.Empty(t, proof.Value)
+	assert.NoError(t, verifier.VerifyKVProof(state.IntermediateRoot(false), proof))
+	proof, err = proveKV(state, []byte("b"))
+	assert.NoError(t, err)
+	assert.Equal(t, "2", string(proof.Value))
+}
+
+func TestKVStateFork(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	kvTx := func(nonce uint64, k, v string) gtypes.Tx {
+		kv, err := rlp.EncodeToBytes(&rtypes.KV{Key: []byte(k), Value: []byte(v)})
+		assert.NoError(t, err)
+		tx := etypes.NewTransaction(nonce, common.Address{}, big.NewInt(0), 1000000, big.NewInt(0), append(append([]byte{}, rtypes.KVTxType...), kv...))
+		signed, err := etypes.SignTx(tx, etypes.NewEIP155Signer(big.NewInt(1001)), key)
+		assert.NoError(t, err)
+		raw, err := rlp.EncodeToBytes(signed)
+		assert.NoError(t, err)
+		return raw
+	}
+	// runs a kv tx in each of two blocks, returns the app hashes and if the kv proof of height 2 is served
+	commitBlock := func(app *EVMApp, height int64, tx gtypes.Tx) []byte {
+		block, _ := gtypes.MakeBlock(height, "test", []gtypes.Tx{tx}, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, nil, nil, 65536)
+		block.Time = time.Unix(1500000000, 0)
+		_, err := app.OnExecute(height, 0, block)
+		assert.NoError(t, err)
+		committed, err := app.OnCommit(height, 0, block)
+		assert.NoError(t, err)
+		return committed.(gtypes.CommitResult).AppHash
+	}
+	proved := func(app *EVMApp, height uint64) bool {
+		load := make([]byte, rtypes.QueryHeightLen)
+		binary.BigEndian.PutUint64(load, height)
+		return app.queryKeyProof(append(load, 'a')).Code == gtypes.CodeType_OK
+	}
+	run := func(extra map[string]interface{}) ([][]byte, bool) {
+		app, closeApp := newTestAppWithGenesis(t, []*ecdsa.PrivateKey{key}, false, extra)
+		defer closeApp()
+		var hashes [][]byte
+		for i, tx := range []gtypes.Tx{kvTx(0, "a", "1"), kvTx(1, "b", "2")} {
+			hashes = append(hashes, commitBlock(app, int64(i+1), tx))
+		}
+		return hashes, proved(app, 2)
+	}
+
+	never, neverProof := run(nil)
+	atGenesis, genesisProof := run(map[string]interface{}{"kvStateBlock": "0"})
+	atTwo, twoProof := run(map[string]interface{}{"kvStateBlock": "2"})
+	assert.False(t, neverProof)
+	assert.True(t, genesisProof)
+	assert.True(t, twoProof)
+
+	// the chain is the same until the fork
+	assert.Equal(t, never[0], atTwo[0])
+	assert.NotEqual(t, atGenesis[0], atTwo[0])
+	// the kvs stored before the fork are folded in at the fork
+	assert.NotEqual(t, never[1], atTwo[1])
+	assert.Equal(t, atGenesis[1], atTwo[1])
+}
+
+func TestKVStateScheduled(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	app, _ := newTestAppWithGenesis(t, []*ecdsa.PrivateKey{key}, false, nil)
+	conf := app.Config
+	defer os.RemoveAll(conf.GetString("db_dir"))
+	kv, err := rlp.EncodeToBytes(&rtypes.KV{Key: []byte("a"), Value: []byte("1")})
+	assert.NoError(t, err)
+	tx := etypes.NewTransaction(0, common.Address{}, big.NewInt(0), 1000000, big.NewInt(0), append(append([]byte{}, rtypes.KVTxType...), kv...))
+	signed, err := etypes.SignTx(tx, etypes.NewEIP155Signer(big.NewInt(1001)), key)
+	assert.NoError(t, err)
+	raw, err := rlp.EncodeToBytes(signed)
+	assert.NoError(t, err)
+	block, _ := gtypes.MakeBlock(1, "test", []gtypes.Tx{raw}, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, nil, nil, 65536)
+	block.Time = time.Unix(1500000000, 0)
+	_, err = app.OnExecute(1, 0, block)
+	assert.NoError(t, err)
+	_, err = app.OnCommit(1, 0, block)
+	assert.NoError(t, err)
+	app.Stop()
+
+	// the running chain schedules the fork through the app_state of its genesis doc
+	restart := func(kvStateBlock string) (*EVMApp, error) {
+		app, err := NewEVMApp(conf)
+		assert.NoError(t, err)
+		appState, err := json.Marshal(map[string]interface{}{
+			"config":       map[string]interface{}{"chainId": 1001},
+			"kvStateBlock": kvStateBlock,
+		})
+		assert.NoError(t, err)
+		app.SetCore(&testCore{genDoc: &gtypes.GenesisDoc{ChainID: "test", AppState: appState}, blocks: make(map[int64]*gtypes.Block)})
+		return app, app.Start()
+	}
+	_, err = restart("1")
+	assert.Error(t, err, "fork below the next block")
+	app, err = restart("2")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), app.kvStateBlock)
+	assert.Error(t, app.checkKVState(1), "the kv store isn't folded yet")
+	block, _ = gtypes.MakeBlock(2, "test", nil, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, nil, nil, 65536)
+	block.Time = time.Unix(1500000000, 0)
+	_, err = app.OnExecute(2, 0, block)
+	assert.NoError(t, err)
+	_, err = app.OnCommit(2, 0, block)
+	assert.NoError(t, err)
+	assert.NoError(t, app.checkKVState(2))
+	load := make([]byte, rtypes.QueryHeightLen)
+	binary.BigEndian.PutUint64(load, 2)
+	res := app.queryKeyProof(append(load, 'a'))
+	assert.Equal(t, gtypes.CodeType_OK, res.Code, res.Log)
+	app.Stop()
+
+	// the fork is saved with the genesis of the chain
+	app, err = restart("2")
+	assert.NoError(t, err)
+	app.Stop()
+	_, err = restart("3")
+	assert.Error(t, err)
+}
@@ -0,0 +1,297 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	logBloomPrefix   = []byte("lbloom_")
+	logBlockPrefix   = []byte("lblock_")
+	logAddressPrefix = []byte("laddr_")
+	logTopicPrefix   = []byte("ltopic_")
+)
+
+// MaxLogsPerQuery bounds the number of logs a single range query may return
+const MaxLogsPerQuery = 10000
+
+// LogIndexManager indexes the logs of committed blocks by height, contract address and topics.
+// Blocks without logs leave no entry, so range queries only visit blocks that emitted logs.
+type LogIndexManager struct {
+	db *ethdb.LDBDatabase
+}
+
+func NewLogIndexManager(db *ethdb.LDBDatabase) *LogIndexManager {
+	return &LogIndexManager{db: db}
+}
+
+func (m *LogIndexManager) Close() {
+	m.db.Close()
+}
+
+func putUint64(i uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, i)
+	return b
+}
+
+func makeLogKey(prefix []byte, height uint64, parts ...[]byte) []byte {
+	key := append([]byte{}, prefix...)
+	for _, p := range parts {
+		key = append(key, p...)
+	}
+	return append(key, putUint64(height)...)
+}
+
+// SaveBlockLogs indexes the logs of all receipts of the block at height
+func (m *LogIndexManager) SaveBlockLogs(height uint64, receipts etypes.Receipts) error {
+	var logs []*etypes.LogForStorage
+	for _, receipt := range receipts {
+		for _, l := range receipt.Logs {
+			l.BlockNumber = height
+			logs = append(logs, (*etypes.LogForStorage)(l))
+		}
+	}
+	if len(logs) == 0 {
+		return nil
+	}
+
+	logsBytes, err := rlp.EncodeToBytes(logs)
+	if err != nil {
+		return err
+	}
+	bloom := etypes.CreateBloom(receipts)
+
+	batch := m.db.NewBatch()
+	if err := batch.Put(makeLogKey(logBloomPrefix, height), bloom.Bytes()); err != nil {
+		return err
+	}
+	if err := batch.Put(makeLogKey(logBlockPrefix, height), logsBytes); err != nil {
+		return err
+	}
+	indexed := make(map[string]struct{})
+	for _, l := range logs {
+		keys := [][]byte{makeLogKey(logAddressPrefix, height, l.Address.Bytes())}
+		for i, topic := range l.Topics {
+			keys = append(keys, makeLogKey(logTopicPrefix, height, []byte{byte(i)}, topic.Bytes()))
+		}
+		for _, key := range keys {
+			if _, ok := indexed[string(key)]; ok {
+				continue
+			}
+			indexed[string(key)] = struct{}{}
+			if err := batch.Put(key, nil); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Write()
+}
+
+// GetBloom returns the bloom of all logs of the block at height, empty if it has no logs
+func (m *LogIndexManager) GetBloom(height uint64) (etypes.Bloom, error) {
+	data, err := m.db.Get(makeLogKey(logBloomPrefix, height))
+	if err == leveldb.ErrNotFound {
+		return etypes.Bloom{}, nil
+	}
+	if err != nil {
+		return etypes.Bloom{}, err
+	}
+	return etypes.BytesToBloom(data), nil
+}
+
+// GetBlockLogs returns all logs of the block at height in emission order
+func (m *LogIndexManager) GetBlockLogs(height uint64) ([]*etypes.Log, error) {
+	data, err := m.db.Get(makeLogKey(logBlockPrefix, height))
+	if err == leveldb.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var stored []*etypes.LogForStorage
+	if err := rlp.DecodeBytes(data, &stored); err != nil {
+		return nil, err
+	}
+	logs := make([]*etypes.Log, len(stored))
+	for i, l := range stored {
+		logs[i] = (*etypes.Log)(l)
+	}
+	return logs, nil
+}
+
+// heights collects the heights in [from, to] of the index entries under prefix
+func (m *LogIndexManager) heights(prefix []byte, from, to uint64, set map[uint64]struct{}) error {
+	r := util.BytesPrefix(prefix)
+	r.Start = append(append([]byte{}, prefix...), putUint64(from)...)
+	if to < math.MaxUint64 {
+		r.Limit = append(append([]byte{}, prefix...), putUint64(to+1)...)
+	}
+	iter := m.db.LDB().NewIterator(r, nil)
+	defer iter.Release()
+	for iter.Next() {
+		key := iter.Key()
+		set[binary.BigEndian.Uint64(key[len(key)-8:])] = struct{}{}
+	}
+	return iter.Error()
+}
+
+// candidates returns the ascending heights that may contain logs matching the filter,
+// using the most specific index the filter allows
+func (m *LogIndexManager) candidates(filter *rtypes.LogFilter) ([]uint64, error) {
+	set := make(map[uint64]struct{})
+	var err error
+	switch {
+	case len(filter.Addresses) > 0:
+		for _, addr := range filter.Addresses {
+			if err = m.heights(append(append([]byte{}, logAddressPrefix...), addr.Bytes()...), filter.FromBlock, filter.ToBlock, set); err != nil {
+				return nil, err
+			}
+		}
+	default:
+		indexed := false
+		for i, topics := range filter.Topics {
+			if len(topics) == 0 {
+				continue
+			}
+			for _, topic := range topics {
+				prefix := append(append([]byte{}, logTopicPrefix...), byte(i))
+				if err = m.heights(append(prefix, topic.Bytes()...), filter.FromBlock, filter.ToBlock, set); err != nil {
+					return nil, err
+				}
+			}
+			indexed = true
+			break
+		}
+		if !indexed {
+			if err = m.heights(logBloomPrefix, filter.FromBlock, filter.ToBlock, set); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	heights := make([]uint64, 0, len(set))
+	for h := range set {
+		heights = append(heights, h)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights, nil
+}
+
+// FilterLogs returns the logs in [filter.FromBlock, filter.ToBlock] matching the filter
+func (m *LogIndexManager) FilterLogs(filter *rtypes.LogFilter) ([]*etypes.Log, error) {
+	if filter.FromBlock > filter.ToBlock {
+		return nil, nil
+	}
+	heights, err := m.candidates(filter)
+	if err != nil {
+		return nil, err
+	}
+	var result []*etypes.Log
+	for _, height := range heights {
+		bloom, err := m.GetBloom(height)
+		if err != nil {
+			return nil, err
+		}
+		if !BloomMatchesFilter(bloom, filter) {
+			continue
+		}
+		logs, err := m.GetBlockLogs(height)
+		if err != nil {
+			return nil, err
+		}
+		for _, l := range logs {
+			if LogMatchesFilter(l, filter) {
+				result = append(result, l)
+			}
+		}
+		if len(result) > MaxLogsPerQuery {
+			return nil, fmt.Errorf("query returned more than %d results", MaxLogsPerQuery)
+		}
+	}
+	return result, nil
+}
+
+// BloomMatchesFilter reports whether a block with the bloom may contain logs matching the filter
+func BloomMatchesFilter(bloom etypes.Bloom, filter *rtypes.LogFilter) bool {
+	if len(filter.Addresses) > 0 {
+		included := false
+		for _, addr := range filter.Addresses {
+			if etypes.BloomLookup(bloom, addr) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	for _, sub := range filter.Topics {
+		included := len(sub) == 0
+		for _, topic := range sub {
+			if etypes.BloomLookup(bloom, topic) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			return false
+		}
+	}
+	return true
+}
+
+// LogMatchesFilter reports whether the log matches the addresses and topics of the filter
+func LogMatchesFilter(l *etypes.Log, filter *rtypes.LogFilter) bool {
+	if len(filter.Addresses) > 0 && !containsAddress(filter.Addresses, l.Address) {
+		return false
+	}
+	if len(filter.Topics) > len(l.Topics) {
+		return false
+	}
+	for i, sub := range filter.Topics {
+		match := len(sub) == 0
+		for _, topic := range sub {
+			if l.Topics[i] == topic {
+				match = true
+				break
+			}
+		}
+		if !match {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAddress(addrs []common.Address, addr common.Address) bool {
+	for _, a := range addrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package evm
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "logindex")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	m := NewLogIndexManager(db)
+	defer m.Close()
+
+	var (
+		token    = common.HexToAddress("0x01")
+		other    = common.HexToAddress("0x02")
+		transfer = common.HexToHash("0xaa")
+		approval = common.HexToHash("0xbb")
+		alice    = common.HexToHash("0x0a")
+		bob      = common.HexToHash("0x0b")
+	)
+	makeReceipt := func(logs ...*etypes.Log) *etypes.Receipt {
+		r := &etypes.Receipt{Logs: logs}
+		r.Bloom = etypes.CreateBloom(etypes.Receipts{r})
+		return r
+	}
+	// height 2 has no logs
+	assert.NoError(t, m.SaveBlockLogs(1, etypes.Receipts{
+		makeReceipt(&etypes.Log{Address: token, Topics: []common.Hash{transfer, alice, bob}}),
+		makeReceipt(&etypes.Log{Address: other, Topics: []common.Hash{transfer, bob}}),
+	}))
+	assert.NoError(t, m.SaveBlockLogs(2, etypes.Receipts{makeReceipt()}))
+	assert.NoError(t, m.SaveBlockLogs(3, etypes.Receipts{
+		makeReceipt(&etypes.Log{Address: token, Topics: []common.Hash{approval, alice}}),
+		makeReceipt(&etypes.Log{Address: token, Topics: []common.Hash{transfer, bob, alice}}),
+	}))
+
+	query := func(filter *rtypes.LogFilter) []*etypes.Log {
+		logs, err := m.FilterLogs(filter)
+		assert.NoError(t, err)
+		return logs
+	}
+
+	logs := query(&rtypes.LogFilter{FromBlock: 1, ToBlock: 3})
+	assert.Len(t, logs, 4)
+	assert.Equal(t, uint64(1), logs[0].BlockNumber)
+	assert.Equal(t, uint64(3), logs[3].BlockNumber)
+
+	logs = query(&rtypes.LogFilter{FromBlock: 1, ToBlock: 3, Addresses: []common.Address{token}})
+	assert.Len(t, logs, 3)
+
+	logs = query(&rtypes.LogFilter{FromBlock: 2, ToBlock: 3, Addresses: []common.Address{token}, Topics: [][]common.Hash{{transfer}}})
+	assert.Len(t, logs, 1)
+	assert.Equal(t, bob, logs[0].Topics[1])
+
+	// wildcard first position, from alice or bob to alice
+	logs = query(&rtypes.LogFilter{FromBlock: 1, ToBlock: 3, Topics: [][]common.Hash{nil, {alice, bob}, {alice}}})
+	assert.Len(t, logs, 1)
+	assert.Equal(t, uint64(3), logs[0].BlockNumber)
+
+	logs = query(&rtypes.LogFilter{FromBlock: 1, ToBlock: 3, Topics: [][]common.Hash{{transfer}, {bob}}})
+	assert.Len(t, logs, 2)
+
+	assert.Empty(t, query(&rtypes.LogFilter{FromBlock: 4, ToBlock: 10}))
+	assert.Empty(t, query(&rtypes.LogFilter{FromBlock: 1, ToBlock: 3, Addresses: []common.Address{common.HexToAddress("0x03")}}))
+
+	bloom, err := m.GetBloom(2)
+	assert.NoError(t, err)
+	assert.Equal(t, etypes.Bloom{}, bloom)
+	bloom, err = m.GetBloom(1)
+	assert.NoError(t, err)
+	assert.True(t, etypes.BloomLookup(bloom, other))
+	assert.False(t, etypes.BloomLookup(bloom, approval))
+}
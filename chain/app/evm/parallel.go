@@ -0,0 +1,126 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// speculation executes the evm txs of a block ahead of their turn, each on its own
+// view of the state the block starts from. The executions are merged in block order
+// while the values they read are unchanged, the others are run again serially.
+type speculation struct {
+	blockHash common.Hash
+	results   []*speculated
+	workers   sync.WaitGroup
+}
+
+type speculated struct {
+	done    chan struct{}
+	receipt *etypes.Receipt
+	err     error
+	access  *estate.Access // nil when the tx isn't executed ahead
+}
+
+// speculate starts executing txs on the state of root, the views share a database of their own
+// so the state being executed in turn isn't touched
+func (app *EVMApp) speculate(blockHash, root common.Hash, txs gtypes.Txs) *speculation {
+	sp := &speculation{
+		blockHash: blockHash,
+		results:   make([]*speculated, len(txs)),
+	}
+	for i := range sp.results {
+		sp.results[i] = &speculated{done: make(chan struct{})}
+	}
+	db := estate.NewDatabase(app.stateDb)
+	next := int64(-1)
+	sp.workers.Add(app.parallelWorkers)
+	for w := 0; w < app.parallelWorkers; w++ {
+		go func() {
+			defer sp.workers.Done()
+			for {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(txs) {
+					return
+				}
+				app.speculateTx(sp, root, db, i, txs[i])
+			}
+		}()
+	}
+	return sp
+}
+
+func (app *EVMApp) speculateTx(sp *speculation, root common.Hash, db estate.Database, txIndex int, raw []byte) {
+	res := sp.results[txIndex]
+	defer close(res.done)
+
+	// kv txs are cheap, they run in turn
+	tx := new(etypes.Transaction)
+	if err := rlp.DecodeBytes(raw, tx); err != nil || isKVTx(tx.Data()) {
+		return
+	}
+	if _, err := etypes.Sender(app.Signer, tx); err != nil {
+		return
+	}
+	state, err := estate.New(root, db)
+	if err != nil {
+		return
+	}
+	access := state.RecordAccess()
+	res.receipt, res.err = app.executeOriginTx(sp.blockHash, state, txIndex, raw, tx)
+	res.access = access
+}
+
+// wait returns when no tx is executing ahead anymore
+func (sp *speculation) wait() {
+	sp.workers.Wait()
+}
+
+// merge applies the speculative execution of the tx at txIndex to state, as executeOriginTx would.
+// It reports false when the tx must be executed again.
+func (sp *speculation) merge(state *estate.StateDB, txIndex int) (*etypes.Receipt, error, bool) {
+	res := sp.results[txIndex]
+	<-res.done
+	if res.access == nil || !state.Readable(res.access) {
+		return nil, nil, false
+	}
+	if res.err != nil {
+		return nil, res.err, true
+	}
+	if !state.Merge(res.access) {
+		return nil, nil, false
+	}
+	state.Finalise(true)
+
+	// logs are numbered in the block
+	receipt := res.receipt
+	state.Prepare(receipt.TxHash, sp.blockHash, txIndex)
+	for _, l := range receipt.Logs {
+		state.AddLog(&etypes.Log{
+			Address:     l.Address,
+			Topics:      l.Topics,
+			Data:        l.Data,
+			BlockNumber: l.BlockNumber,
+		})
+	}
+	receipt.Logs = state.GetLogs(receipt.TxHash)
+	return receipt, nil, true
+}
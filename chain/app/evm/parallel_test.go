@@ -0,0 +1,173 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var (
+	// increments slot 0 and logs the new value
+	counterCode = common.FromHex("0x6000546001018060005560005260aa60206000a100")
+	counterAddr = common.HexToAddress("0x0c")
+	// self destructs to the caller
+	suicideCode = common.FromHex("0x33ff")
+	suicideAddr = common.HexToAddress("0x0d")
+	// calls the counter
+	callerCode = common.FromHex("0x60006000600060006000600c5af100")
+	callerAddr = common.HexToAddress("0x0b")
+)
+
+type testCore struct {
+	genDoc *gtypes.GenesisDoc
+	blocks map[int64]*gtypes.Block
+}
+
+func (c *testCore) Query(byte, []byte) (interface{}, error)       { return nil, nil }
+func (c *testCore) GetBlockMeta(int64) (*gtypes.BlockMeta, error) { return nil, fmt.Errorf("no block") }
+func (c *testCore) GetBlock(height int64) (*gtypes.Block, *gtypes.BlockMeta, error) {
+	if block, ok := c.blocks[height]; ok {
+		return block, nil, nil
+	}
+	return nil, nil, fmt.Errorf("no block")
+}
+func (c *testCore) Genesis() *gtypes.GenesisDoc { return c.genDoc }
+
+func newTestApp(t *testing.T, keys []*ecdsa.PrivateKey, parallel bool) (*EVMApp, func()) {
+	return newTestAppWithGenesis(t, keys, parallel, nil)
+}
+
+// newTestAppWithGenesis starts an app whose genesis app_state has the fields of extra too
+func newTestAppWithGenesis(t *testing.T, keys []*ecdsa.PrivateKey, parallel bool, extra map[string]interface{}) (*EVMApp, func()) {
+	dir, err := ioutil.TempDir("", "evmapp")
+	assert.NoError(t, err)
+	conf := viper.New()
+	conf.Set("db_dir", dir)
+	conf.Set("block_size", 100)
+	conf.Set("evm_parallel_exec", parallel)
+	conf.Set("evm_parallel_workers", 4)
+	app, err := NewEVMApp(conf)
+	assert.NoError(t, err)
+
+	alloc := map[string]interface{}{
+		counterAddr.Hex(): map[string]string{"code": common.ToHex(counterCode)},
+		suicideAddr.Hex(): map[string]string{"code": common.ToHex(suicideCode), "balance": "10"},
+		callerAddr.Hex():  map[string]string{"code": common.ToHex(callerCode)},
+	}
+	for _, key := range keys {
+		alloc[crypto.PubkeyToAddress(key.PublicKey).Hex()] = map[string]string{"balance": "1000000000000000000"}
+	}
+	genesis := map[string]interface{}{
+		"config": map[string]interface{}{"chainId": 1001, "homesteadBlock": 0, "eip150Block": 0, "eip155Block": 0, "eip158Block": 0, "byzantiumBlock": 0},
+		"alloc":  alloc,
+	}
+	for k, v := range extra {
+		genesis[k] = v
+	}
+	appState, err := json.Marshal(genesis)
+	assert.NoError(t, err)
+	app.SetCore(&testCore{genDoc: &gtypes.GenesisDoc{ChainID: "test", AppState: appState}, blocks: make(map[int64]*gtypes.Block)})
+	assert.NoError(t, app.Start())
+	return app, func() {
+		app.Stop()
+		os.RemoveAll(dir)
+	}
+}
+
+// testBlockTxs makes txs conflicting on nonces, balances and storage, mixed with independent,
+// failing, invalid and kv txs
+func testBlockTxs(t *testing.T, keys []*ecdsa.PrivateKey) gtypes.Txs {
+	signer := etypes.NewEIP155Signer(big.NewInt(1001))
+	nonces := make(map[int]uint64)
+	var txs gtypes.Txs
+	add := func(k int, to *common.Address, value int64, data []byte) {
+		tx := etypes.NewContractCreation(nonces[k], big.NewInt(value), 1000000, big.NewInt(0), data)
+		if to != nil {
+			tx = etypes.NewTransaction(nonces[k], *to, big.NewInt(value), 1000000, big.NewInt(0), data)
+		}
+		nonces[k]++
+		signed, err := etypes.SignTx(tx, signer, keys[k])
+		assert.NoError(t, err)
+		raw, err := rlp.EncodeToBytes(signed)
+		assert.NoError(t, err)
+		txs = append(txs, raw)
+	}
+	addr := func(i int) *common.Address {
+		a := common.BigToAddress(big.NewInt(int64(0x1000 + i)))
+		return &a
+	}
+	for round := 0; round < 3; round++ {
+		for k := range keys {
+			add(k, addr(k*10+round), 1, nil) // independent
+			if k%2 == 0 {
+				add(k, &counterAddr, 0, nil) // same slot
+			}
+			if k%3 == 0 {
+				other := crypto.PubkeyToAddress(keys[(k+1)%len(keys)].PublicKey)
+				add(k, &other, 7, nil) // balance of another sender
+			}
+		}
+	}
+	add(1, nil, 0, common.FromHex("0x600160005500"))
+	add(2, &suicideAddr, 0, nil)
+	admin := common.BytesToAddress([]byte{254})
+	adminOp := make([]byte, 72)
+	adminOp[31] = 40 // from and op
+	add(3, &admin, 0, adminOp)
+	add(4, addr(99), 1<<62, nil) // more than the balance
+	kv, err := rlp.EncodeToBytes(&rtypes.KV{Key: []byte("k"), Value: []byte("v")})
+	assert.NoError(t, err)
+	add(5, &common.Address{}, 0, append(append([]byte{}, rtypes.KVTxType...), kv...))
+	add(5, addr(100), 1, nil)
+	nonces[6] += 100
+	add(6, addr(101), 1, nil) // nonce gap
+	return txs
+}
+
+func TestParallelDeterminism(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 8; i++ {
+		key, err := crypto.GenerateKey()
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	txs := testBlockTxs(t, keys)
+
+	execute := func(parallel bool) (gtypes.ExecuteResult, []byte, gtypes.CommitResult) {
+		app, closeApp := newTestApp(t, keys, parallel)
+		defer closeApp()
+		block, _ := gtypes.MakeBlock(1, "test", txs, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, nil, nil, 65536)
+		block.Time = time.Unix(1500000000, 0)
+		res, err := app.OnExecute(1, 0, block)
+		assert.NoError(t, err)
+		receipts, err := json.Marshal(app.receipts)
+		assert.NoError(t, err)
+		committed, err := app.OnCommit(1, 0, block)
+		assert.NoError(t, err)
+		return res.(gtypes.ExecuteResult), receipts, committed.(gtypes.CommitResult)
+	}
+	serialRes, serialReceipts, serialCommit := execute(false)
+	assert.NotEmpty(t, serialRes.InvalidTxs)
+	assert.True(t, len(serialRes.ValidTxs) > len(txs)/2)
+	for i := 0; i < 3; i++ {
+		res, receipts, commit := execute(true)
+		assert.Equal(t, serialRes, res)
+		assert.Equal(t, string(serialReceipts), string(receipts))
+		assert.Equal(t, serialCommit, commit)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// MaxProofKeys bounds the number of storage slots proved by a single QueryType_Proof
+const MaxProofKeys = 64
+
+var emptyCodeHash = crypto.Keccak256Hash(nil)
+
+type proofList [][]byte
+
+func (n *proofList) Put(key []byte, value []byte) error {
+	*n = append(*n, value)
+	return nil
+}
+
+func proveAccount(state *estate.StateDB, addr common.Address, keys []common.Hash) (*rtypes.AccountProof, error) {
+	proof := &rtypes.AccountProof{
+		Address:       addr,
+		StateRoot:     state.IntermediateRoot(false),
+		Balance:       state.GetBalance(addr),
+		CodeHash:      emptyCodeHash,
+		StorageHash:   EmptyTrieRoot,
+		StorageProofs: make([]*rtypes.StorageProof, len(keys)),
+	}
+	var err error
+	if proof.AccountProof, err = state.GetProof(addr); err != nil {
+		return nil, err
+	}
+	exist := state.Exist(addr)
+	if exist {
+		proof.Nonce = state.GetNonce(addr)
+		proof.CodeHash = state.GetCodeHash(addr)
+		proof.StorageHash = state.StorageTrie(addr).Hash()
+	}
+	for i, key := range keys {
+		sp := &rtypes.StorageProof{Key: key}
+		if exist {
+			sp.Value = state.GetState(addr, key)
+			if sp.Proof, err = state.GetStorageProof(addr, key); err != nil {
+				return nil, err
+			}
+		}
+		proof.StorageProofs[i] = sp
+	}
+	return proof, nil
+}
+
+// queryProof proves an account and some of its storage slots, the payload is a rlp encoded rtypes.ProofRequest
+func (app *EVMApp) queryProof(load []byte) gtypes.Result {
+	req := &rtypes.ProofRequest{}
+	if err := rlp.DecodeBytes(load, req); err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, fmt.Sprintf("rlp decode error:%v", err))
+	}
+	if len(req.Keys) > MaxProofKeys {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, fmt.Sprintf("at most %d storage keys per proof", MaxProofKeys))
+	}
+	state, proofHeight, err := app.stateWithHeight(req.Height)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+
+	proof, err := proveAccount(state, req.Address, req.Keys)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	proof.Height = proofHeight
+
+	data, err := rlp.EncodeToBytes(proof)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, fmt.Sprintf("rlp encode error:%v", err))
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,60 @@
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/chain/verifier"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountProof(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	state, err := estate.New(common.Hash{}, estate.NewDatabase(db))
+	assert.NoError(t, err)
+	contract, missing := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+	slot, value := common.HexToHash("0x05"), common.HexToHash("0x0102")
+	state.SetNonce(contract, 3)
+	state.AddBalance(contract, big.NewInt(100))
+	state.SetCode(contract, []byte{0x60, 0x00})
+	state.SetState(contract, slot, value)
+	root, err := state.Commit(true)
+	assert.NoError(t, err)
+	assert.NoError(t, state.Database().TrieDB().Commit(root, false))
+	state, err = estate.New(root, estate.NewDatabase(db))
+	assert.NoError(t, err)
+
+	prove := func(addr common.Address, keys ...common.Hash) *rtypes.AccountProof {
+		proof, err := proveAccount(state, addr, keys)
+		assert.NoError(t, err)
+		return proof
+	}
+
+	proof := prove(contract, slot, common.HexToHash("0x06"))
+	assert.Equal(t, crypto.Keccak256Hash([]byte{0x60, 0x00}), proof.CodeHash)
+	assert.NoError(t, verifier.VerifyAccountProof(root, proof))
+	assert.NoError(t, verifier.VerifyAccountWithHeader(&gtypes.Header{Height: 8, AppHash: root.Bytes()}, withHeight(proof, 7)))
+	assert.Error(t, verifier.VerifyAccountWithHeader(&gtypes.Header{Height: 7, AppHash: root.Bytes()}, proof))
+
+	proof.StorageProofs[0].Value = common.HexToHash("0x0103")
+	assert.Error(t, verifier.VerifyAccountProof(root, proof), "forged storage")
+	proof = prove(contract)
+	proof.Balance = big.NewInt(1000)
+	assert.Error(t, verifier.VerifyAccountProof(root, proof), "forged balance")
+
+	proof = prove(missing, slot)
+	assert.NoError(t, verifier.VerifyAccountProof(root, proof), "absent account")
+	proof.Nonce = 1
+	assert.Error(t, verifier.VerifyAccountProof(root, proof))
+}
+
+func withHeight(proof *rtypes.AccountProof, height uint64) *rtypes.AccountProof {
+	proof.Height = height
+	return proof
+}
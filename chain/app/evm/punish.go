@@ -0,0 +1,66 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	PunishRemove = "remove"
+	PunishHalve  = "halve"
+	PunishNone   = "none"
+)
+
+// Punish removes, or halves the voting power of, the validators which signed conflicting votes
+// according to the doubleSignPunishment of the genesis. The last validator is never removed.
+func (app *EVMApp) Punish(height int64, evidence []*gtypes.DuplicateVoteEvidence, validators *gtypes.ValidatorSet) []*gtypes.ValidatorAttr {
+	policy := app.punishment
+	if policy == PunishNone {
+		return nil
+	}
+	var (
+		changes  []*gtypes.ValidatorAttr
+		punished = make(map[string]struct{})
+		left     = validators.Size()
+	)
+	for _, ev := range evidence {
+		if _, ok := punished[string(ev.Address())]; ok {
+			continue
+		}
+		_, val := validators.GetByAddress(ev.Address())
+		if val == nil {
+			continue
+		}
+		punished[string(ev.Address())] = struct{}{}
+		attr := &gtypes.ValidatorAttr{PubKey: crypto.GetNodePubkeyBytes(val.PubKey), Addr: val.Address}
+		if policy == PunishHalve && val.VotingPower > 1 {
+			attr.Cmd, attr.Power = gtypes.ValidatorCmdUpdateNode, val.VotingPower/2
+		} else {
+			if left <= 1 {
+				log.Warn("keep the last validator despite its double signing", zap.Int64("height", height), zap.String("validator", ev.String()))
+				continue
+			}
+			attr.Cmd = gtypes.ValidatorCmdRemoveNode
+			left--
+		}
+		log.Warn("punish double signing validator", zap.Int64("height", height), zap.String("cmd", string(attr.Cmd)), zap.Int64("power", attr.Power), zap.String("validator", ev.String()))
+		changes = append(changes, attr)
+	}
+	return changes
+}
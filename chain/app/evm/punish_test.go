@@ -0,0 +1,61 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestPunish(t *testing.T) {
+	valSet, privVals := gtypes.RandValidatorSet(2, 10)
+	evidence := func(i int) *gtypes.DuplicateVoteEvidence {
+		return gtypes.NewDuplicateVoteEvidence(&gtypes.Vote{ValidatorAddress: privVals[i].Address}, &gtypes.Vote{ValidatorAddress: privVals[i].Address})
+	}
+	app := &EVMApp{}
+
+	app.punishment = PunishRemove
+	changes := app.Punish(1, []*gtypes.DuplicateVoteEvidence{evidence(0), evidence(0), evidence(1)}, valSet)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, gtypes.ValidatorCmdRemoveNode, changes[0].Cmd)
+		assert.Equal(t, privVals[0].Address, changes[0].Addr)
+	}
+
+	app.punishment = PunishHalve
+	changes = app.Punish(1, []*gtypes.DuplicateVoteEvidence{evidence(1)}, valSet)
+	if assert.Len(t, changes, 1) {
+		assert.Equal(t, gtypes.ValidatorCmdUpdateNode, changes[0].Cmd)
+		assert.Equal(t, int64(5), changes[0].Power)
+	}
+
+	app.punishment = PunishNone
+	assert.Empty(t, app.Punish(1, []*gtypes.DuplicateVoteEvidence{evidence(1)}, valSet))
+}
+
+func TestPunishByGenesis(t *testing.T) {
+	valSet, privVals := gtypes.RandValidatorSet(2, 10)
+	evidence := []*gtypes.DuplicateVoteEvidence{
+		gtypes.NewDuplicateVoteEvidence(&gtypes.Vote{ValidatorAddress: privVals[0].Address}, &gtypes.Vote{ValidatorAddress: privVals[0].Address}),
+	}
+
+	// the nodes of a chain punish alike, whatever their own config
+	var changes [][]*gtypes.ValidatorAttr
+	for _, local := range []string{PunishRemove, PunishNone} {
+		app, closeApp := newTestAppWithGenesis(t, nil, false, map[string]interface{}{"doubleSignPunishment": PunishHalve})
+		app.Config.Set("evm_double_sign_punishment", local)
+		changes = append(changes, app.Punish(1, evidence, valSet))
+		closeApp()
+	}
+	if assert.Len(t, changes[0], 1) {
+		assert.Equal(t, gtypes.ValidatorCmdUpdateNode, changes[0][0].Cmd)
+	}
+	assert.Equal(t, changes[0], changes[1])
+
+	// the chains whose genesis sets none remove the double signers
+	app, closeApp := newTestAppWithGenesis(t, nil, false, nil)
+	defer closeApp()
+	if changes := app.Punish(1, evidence, valSet); assert.Len(t, changes, 1) {
+		assert.Equal(t, gtypes.ValidatorCmdRemoveNode, changes[0].Cmd)
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/trie"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// RevertTo rolls the app back to the state committed at height, the trie nodes of the later states stay
+// in the database. The receipts, failures, logs and kv histories of the blocks above height are dropped.
+func (app *EVMApp) RevertTo(height int64, appHash []byte, txs []gtypes.Tx) error {
+	if last := app.lastHeight(); height > last {
+		return fmt.Errorf("can't revert the state of height %d to height %d", last, height)
+	}
+	if err := app.checkKVState(height); err != nil {
+		return err
+	}
+	state, err := estate.New(common.BytesToHash(appHash), estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return err
+	}
+	if err := revertKVStore(app.stateDb, state); err != nil {
+		return err
+	}
+	if err := app.keyValueHistoryManager.truncate(uint64(height)); err != nil {
+		return err
+	}
+	if err := app.logIndexManager.truncate(uint64(height)); err != nil {
+		return err
+	}
+	batch := app.stateDb.NewBatch()
+	for _, tx := range txs {
+		hash := tx.Hash()
+		if err := batch.Delete(append(ReceiptsPrefix, hash...)); err != nil {
+			return err
+		}
+		if err := batch.Delete(append(FailurePrefix, hash...)); err != nil {
+			return err
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		app.txStatus.revert(common.BytesToHash(tx.Hash()))
+	}
+
+	app.stateMtx.Lock()
+	app.SaveLastBlock(LastBlockInfo{Height: height, AppHash: appHash})
+	app.state = state
+	app.stateMtx.Unlock()
+	app.pool.setHeight(height)
+	app.pool.updateToState()
+	log.Info("app reverted", zap.Int64("height", height), zap.String("appHash", fmt.Sprintf("%X", appHash)), zap.Int("dropped txs", len(txs)))
+	return nil
+}
+
+// revertKVStore makes the kv store hold the kvs of the kv trie of state
+func revertKVStore(db ethdb.Database, state *estate.StateDB) error {
+	batch := db.NewBatch()
+	if err := deleteKVStore(db, batch); err != nil {
+		return err
+	}
+	kvTrie, _, err := kvTrieAt(state)
+	if err != nil {
+		return err
+	}
+	leafIt := trie.NewIterator(kvTrie.NodeIterator(nil))
+	for leafIt.Next() {
+		key := kvTrie.GetKey(leafIt.Key)
+		if key == nil {
+			return fmt.Errorf("no preimage of kv key %x", leafIt.Key)
+		}
+		// the deletes above come first in the batch
+		if err := batch.Put(append(KvPrefix, key...), common.CopyBytes(leafIt.Value)); err != nil {
+			return err
+		}
+	}
+	if leafIt.Err != nil {
+		return leafIt.Err
+	}
+	return batch.Write()
+}
+
+// truncate deletes the updates committed above height
+func (m *KeyValueHistoryManager) truncate(height uint64) error {
+	db, ok := m.db.(prefixIterable)
+	if !ok {
+		return fmt.Errorf("kv history database can't be iterated")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	batch := m.db.NewBatch()
+	it := db.NewIteratorWithPrefix(KvHistoryPrefix)
+	defer it.Release()
+	for it.Next() {
+		if !bytes.HasSuffix(it.Key(), kvHistorySizeSuffix) {
+			continue
+		}
+		key := common.CopyBytes(it.Key()[len(KvHistoryPrefix) : len(it.Key())-len(kvHistorySizeSuffix)])
+		size := binary.BigEndian.Uint32(it.Value())
+		// histories are appended in commit order, the ones above height are the last
+		kept := size
+		for kept > 0 {
+			history, err := m.get(key, kept-1)
+			if err != nil {
+				return err
+			}
+			if history.BlockHeight <= height {
+				break
+			}
+			kept--
+			if err := batch.Delete(makeKey(key, putUint32(kept))); err != nil {
+				return err
+			}
+		}
+		if kept == size {
+			continue
+		}
+		var err error
+		if kept == 0 {
+			err = batch.Delete(makeKeySizeKey(key))
+		} else {
+			err = batch.Put(makeKeySizeKey(key), putUint32(kept))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return err
+	}
+	return batch.Write()
+}
+
+// truncate deletes the logs indexed above height
+func (m *LogIndexManager) truncate(height uint64) error {
+	batch := m.db.NewBatch()
+	for _, prefix := range [][]byte{logBloomPrefix, logBlockPrefix, logAddressPrefix, logTopicPrefix} {
+		it := m.db.NewIteratorWithPrefix(prefix)
+		for it.Next() {
+			key := it.Key()
+			if binary.BigEndian.Uint64(key[len(key)-8:]) <= height {
+				continue
+			}
+			if err := batch.Delete(common.CopyBytes(key)); err != nil {
+				it.Release()
+				return err
+			}
+		}
+		err := it.Error()
+		it.Release()
+		if err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// revert records a tx of a reverted block as dropped, whatever its former status
+func (t *txStatusTracker) revert(hash common.Hash) {
+	t.mtx.Lock()
+	if e, ok := t.records[hash]; ok {
+		t.order.Remove(e)
+		delete(t.records, hash)
+	}
+	t.mtx.Unlock()
+	t.set(hash, rtypes.TxStatusDropped, "block reverted", 0, 0)
+}
@@ -0,0 +1,94 @@
+package evm
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRevert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "revert")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	db, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	defer db.Close()
+
+	// commits the kv ops on the state of root like OnCommit, the kv store included
+	commit := func(root common.Hash, ops ...*rtypes.KVOp) common.Hash {
+		state, err := estate.New(root, estate.NewDatabase(db))
+		assert.NoError(t, err)
+		kvRoot, err := applyKVOps(state, ops)
+		assert.NoError(t, err)
+		root, err = state.Commit(true)
+		assert.NoError(t, err)
+		assert.NoError(t, state.Database().TrieDB().Commit(root, false))
+		assert.NoError(t, commitKVTrie(state, kvRoot))
+		for _, op := range ops {
+			if op.Op == rtypes.KVOpDelete {
+				assert.NoError(t, db.Delete(append(KvPrefix, op.Key...)))
+			} else {
+				assert.NoError(t, db.Put(append(KvPrefix, op.Key...), op.Value))
+			}
+		}
+		return root
+	}
+	root1 := commit(common.Hash{}, &rtypes.KVOp{Op: rtypes.KVOpPut, Key: []byte("a"), Value: []byte("1")})
+	commit(root1, &rtypes.KVOp{Op: rtypes.KVOpDelete, Key: []byte("a")}, &rtypes.KVOp{Op: rtypes.KVOpPut, Key: []byte("b"), Value: []byte("2")})
+
+	state, err := estate.New(root1, estate.NewDatabase(db))
+	assert.NoError(t, err)
+	assert.NoError(t, revertKVStore(db, state))
+	value, err := db.Get(append(KvPrefix, 'a'))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+	has, err := db.Has(append(KvPrefix, 'b'))
+	assert.NoError(t, err)
+	assert.False(t, has)
+
+	histories, closeHistories := newTestHistories(t)
+	defer closeHistories()
+	update := func(key string, height uint64, value string) *types.KeyValueHistory {
+		return &types.KeyValueHistory{Key: []byte(key), ValueUpdateHistory: &types.ValueUpdateHistory{BlockHeight: height, Value: []byte(value)}}
+	}
+	assert.NoError(t, histories.SaveKeyHistory(types.KeyValueHistories{update("a", 1, "1")}))
+	assert.NoError(t, histories.SaveKeyHistory(types.KeyValueHistories{update("a", 2, ""), update("b", 2, "2")}))
+	assert.NoError(t, histories.truncate(1))
+	size, err := histories.GetKeyHistorySize([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(1), size)
+	history, err := histories.GetAsOf([]byte("a"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(history.Value))
+	_, err = histories.GetKeyHistorySize([]byte("b"))
+	assert.Error(t, err)
+
+	logDir, err := ioutil.TempDir("", "logindex")
+	assert.NoError(t, err)
+	defer os.RemoveAll(logDir)
+	logDb, err := ethdb.NewLDBDatabase(logDir, 0, 0)
+	assert.NoError(t, err)
+	logs := NewLogIndexManager(logDb)
+	defer logs.Close()
+	token, transfer := common.HexToAddress("0x01"), common.HexToHash("0xaa")
+	for height := uint64(1); height <= 2; height++ {
+		assert.NoError(t, logs.SaveBlockLogs(height, etypes.Receipts{{Logs: []*etypes.Log{{Address: token, Topics: []common.Hash{transfer}}}}}))
+	}
+	assert.NoError(t, logs.truncate(1))
+	found, err := logs.FilterLogs(&rtypes.LogFilter{FromBlock: 1, ToBlock: 2, Addresses: []common.Address{token}, Topics: [][]common.Hash{{transfer}}})
+	assert.NoError(t, err)
+	if assert.Len(t, found, 1) {
+		assert.Equal(t, uint64(1), found[0].BlockNumber)
+	}
+	bloom, err := logs.GetBloom(2)
+	assert.NoError(t, err)
+	assert.Equal(t, etypes.Bloom{}, bloom)
+}
@@ -0,0 +1,50 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+)
+
+var ErrUnprotectedTx = errors.New("tx isn't replay protected, only EIP-155 txs are accepted")
+
+// ReplaySigner is the EIP-155 signer of a chain id, which recovers the
+// senders of legacy (homestead) txs only when they're allowed
+type ReplaySigner struct {
+	etypes.EIP155Signer
+	allowLegacy bool
+}
+
+func NewReplaySigner(chainID *big.Int, allowLegacy bool) ReplaySigner {
+	return ReplaySigner{
+		EIP155Signer: etypes.NewEIP155Signer(chainID),
+		allowLegacy:  allowLegacy,
+	}
+}
+
+func (s ReplaySigner) Sender(tx *etypes.Transaction) (common.Address, error) {
+	if !tx.Protected() && !s.allowLegacy {
+		return common.Address{}, ErrUnprotectedTx
+	}
+	return s.EIP155Signer.Sender(tx)
+}
+
+func (s ReplaySigner) Equal(s2 etypes.Signer) bool {
+	other, ok := s2.(ReplaySigner)
+	return ok && other.allowLegacy == s.allowLegacy && s.EIP155Signer.Equal(other.EIP155Signer)
+}
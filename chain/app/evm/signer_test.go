@@ -0,0 +1,65 @@
+package evm
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaySigner(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	addr := crypto.PubkeyToAddress(key.PublicKey)
+	sign := func(signer etypes.Signer) *etypes.Transaction {
+		tx, err := etypes.SignTx(etypes.NewTransaction(0, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(0), nil), signer, key)
+		assert.NoError(t, err)
+		return tx
+	}
+	protected := sign(etypes.NewEIP155Signer(big.NewInt(1001)))
+	otherChain := sign(etypes.NewEIP155Signer(big.NewInt(1002)))
+	legacy := sign(etypes.HomesteadSigner{})
+
+	strict := NewReplaySigner(big.NewInt(1001), false)
+	from, err := etypes.Sender(strict, protected)
+	assert.NoError(t, err)
+	assert.Equal(t, addr, from)
+	_, err = etypes.Sender(strict, otherChain)
+	assert.Equal(t, etypes.ErrInvalidChainId, err)
+	_, err = etypes.Sender(strict, legacy)
+	assert.Equal(t, ErrUnprotectedTx, err)
+
+	lenient := NewReplaySigner(big.NewInt(1001), true)
+	assert.False(t, strict.Equal(lenient))
+	from, err = etypes.Sender(lenient, legacy)
+	assert.NoError(t, err)
+	assert.Equal(t, addr, from)
+	// the sender cached by the lenient signer isn't reused by the strict one
+	_, err = etypes.Sender(strict, legacy)
+	assert.Equal(t, ErrUnprotectedTx, err)
+}
+
+func TestApplyProtectedTx(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	db := ethdb.NewMemDatabase()
+	state, err := estate.New(common.Hash{}, estate.NewDatabase(db))
+	assert.NoError(t, err)
+	state.AddBalance(crypto.PubkeyToAddress(key.PublicKey), big.NewInt(1e18))
+
+	// the EIP-155 fork height of the config isn't reached, protected txs apply anyway
+	config := &params.ChainConfig{ChainID: big.NewInt(1001), HomesteadBlock: big.NewInt(0), EIP155Block: big.NewInt(100)}
+	tx, err := etypes.SignTx(etypes.NewTransaction(0, common.HexToAddress("0x01"), big.NewInt(1), 21000, big.NewInt(0), nil), etypes.NewEIP155Signer(config.ChainID), key)
+	assert.NoError(t, err)
+	header := &etypes.Header{Number: big.NewInt(1), Difficulty: big.NewInt(0), GasLimit: 1 << 30, Time: big.NewInt(0)}
+	_, _, err = core.ApplyTransaction(config, NewBlockChain(db), nil, new(core.GasPool).AddGas(1<<30), state, header, tx, new(uint64), evmConfig)
+	assert.NoError(t, err)
+	assert.Equal(t, big.NewInt(1), state.GetBalance(common.HexToAddress("0x01")))
+}
@@ -0,0 +1,191 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// revertSelector is the selector of Error(string), the solidity revert reasons
+var revertSelector = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// unpackRevertReason decodes the reason of the data returned by a reverted execution, it's empty if not given
+func unpackRevertReason(ret []byte) string {
+	if len(ret) < 4+64 || !bytes.Equal(ret[:4], revertSelector) {
+		return ""
+	}
+	data := ret[4:]
+	offset := new(big.Int).SetBytes(data[:32])
+	if !offset.IsUint64() || offset.Uint64()+32 > uint64(len(data)) {
+		return ""
+	}
+	start := offset.Uint64() + 32
+	size := new(big.Int).SetBytes(data[offset.Uint64():start])
+	if !size.IsUint64() || start+size.Uint64() > uint64(len(data)) {
+		return ""
+	}
+	return string(data[start : start+size.Uint64()])
+}
+
+// newCallMessage is the message of a call, its gas is capped to what the evm allows
+func newCallMessage(call *rtypes.CallMsg) core.Message {
+	gas := call.Gas
+	if gas == 0 || gas > EVMGasLimit {
+		gas = EVMGasLimit
+	}
+	var to *common.Address
+	if len(call.To) > 0 {
+		addr := common.BytesToAddress(call.To)
+		to = &addr
+	}
+	value, gasPrice := new(big.Int), new(big.Int)
+	if call.Value != nil {
+		value.Set(call.Value)
+	}
+	if call.GasPrice != nil {
+		gasPrice.Set(call.GasPrice)
+	}
+	return etypes.NewMessage(call.From, to, 0, value, gas, gasPrice, call.Data, false)
+}
+
+// simulationState is the state after the block at height, 0 for the latest, with no account loaded yet
+// so that the accesses of an execution can be recorded
+func (app *EVMApp) simulationState(height uint64) (*estate.StateDB, *etypes.Header, error) {
+	if height != 0 && height < uint64(app.lastHeight()) {
+		return app.stateAt(height)
+	}
+	app.stateMtx.Lock()
+	header := app.latestHeader()
+	app.stateMtx.Unlock()
+	state, err := estate.New(app.getLastAppHash(), estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, header, nil
+}
+
+// simulate runs a call or a signed tx and reports all it would do
+func (app *EVMApp) simulate(req *rtypes.SimulateRequest) (*rtypes.SimulateResult, error) {
+	var (
+		msg    core.Message
+		txHash common.Hash
+	)
+	if len(req.Tx) > 0 {
+		tx := new(etypes.Transaction)
+		if err := rlp.DecodeBytes(req.Tx, tx); err != nil {
+			return nil, err
+		}
+		if isKVTx(tx.Data()) {
+			return nil, fmt.Errorf("kv txs can't be simulated")
+		}
+		if err := app.checkTxGas(tx); err != nil {
+			return nil, err
+		}
+		m, err := tx.AsMessage(app.Signer)
+		if err != nil {
+			return nil, err
+		}
+		msg, txHash = m, common.BytesToHash(gtypes.Tx(req.Tx).Hash())
+	} else {
+		msg = newCallMessage(&req.Call)
+	}
+
+	state, header, err := app.simulationState(req.Call.Height)
+	if err != nil {
+		return nil, err
+	}
+	before := state.Copy()
+	access := state.RecordAccess()
+	state.Prepare(txHash, common.Hash{}, 0)
+
+	envCxt := core.NewEVMContext(msg, header, NewBlockChain(app.stateDb), nil)
+	vmEnv := vm.NewEVM(envCxt, state, app.chainConfig, evmConfig)
+	ret, gasUsed, failed, err := core.ApplyMessage(vmEnv, msg, new(core.GasPool).AddGas(app.gasLimit))
+	if err != nil {
+		return nil, err
+	}
+	state.Finalise(true)
+
+	res := &rtypes.SimulateResult{
+		GasUsed:    gasUsed,
+		Failed:     failed,
+		ReturnData: ret,
+		Logs:       state.GetLogs(txHash),
+	}
+	if failed {
+		res.RevertReason = unpackRevertReason(ret)
+	}
+	access.ForEachWrite(func(addr common.Address, deleted bool, nonce uint64, balance *big.Int, code []byte, storage estate.Storage) {
+		if deleted && !before.Exist(addr) {
+			// touched empty accounts are removed
+			return
+		}
+		diff := &rtypes.AccountDiff{
+			Address:       addr,
+			Deleted:       deleted,
+			BalanceBefore: before.GetBalance(addr),
+			BalanceAfter:  new(big.Int),
+			NonceBefore:   before.GetNonce(addr),
+			NonceAfter:    nonce,
+			Code:          code,
+		}
+		if balance != nil {
+			diff.BalanceAfter.Set(balance)
+		}
+		for key, value := range storage {
+			if prev := before.GetState(addr, key); prev != value {
+				diff.Storage = append(diff.Storage, &rtypes.StorageDiff{Key: key, Before: prev, After: value})
+			}
+		}
+		if !deleted && diff.BalanceBefore.Cmp(diff.BalanceAfter) == 0 && diff.NonceBefore == nonce && code == nil && len(diff.Storage) == 0 {
+			return
+		}
+		sort.Slice(diff.Storage, func(i, j int) bool {
+			return bytes.Compare(diff.Storage[i].Key[:], diff.Storage[j].Key[:]) < 0
+		})
+		res.StateDiff = append(res.StateDiff, diff)
+	})
+	sort.Slice(res.StateDiff, func(i, j int) bool {
+		return bytes.Compare(res.StateDiff[i].Address[:], res.StateDiff[j].Address[:]) < 0
+	})
+	return res, nil
+}
+
+func (app *EVMApp) querySimulate(load []byte) gtypes.Result {
+	req := &rtypes.SimulateRequest{}
+	if err := rlp.DecodeBytes(load, req); err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp decode error:"+err.Error())
+	}
+	res, err := app.simulate(req)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(res)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp encode error:"+err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
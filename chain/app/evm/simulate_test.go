@@ -0,0 +1,81 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+func TestSimulate(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	app, closeApp := newTestApp(t, []*ecdsa.PrivateKey{key}, false)
+	defer closeApp()
+
+	simulate := func(req *rtypes.SimulateRequest) *rtypes.SimulateResult {
+		load, err := rlp.EncodeToBytes(req)
+		assert.NoError(t, err)
+		res := app.Query(append([]byte{rtypes.QueryType_Simulate}, load...))
+		assert.Equal(t, "", res.Log)
+		sim := &rtypes.SimulateResult{}
+		assert.NoError(t, rlp.DecodeBytes(res.Data, sim))
+		return sim
+	}
+
+	// a call of the counter stores and logs 1, and bumps the nonce of the caller
+	sim := simulate(&rtypes.SimulateRequest{Call: rtypes.CallMsg{From: from, To: counterAddr.Bytes()}})
+	assert.False(t, sim.Failed)
+	assert.Equal(t, uint64(21000), sim.GasUsed)
+	assert.Len(t, sim.Logs, 1)
+	assert.Equal(t, common.BigToHash(big.NewInt(1)).Bytes(), sim.Logs[0].Data)
+	assert.Len(t, sim.StateDiff, 2)
+	assert.Equal(t, counterAddr, sim.StateDiff[0].Address)
+	assert.Equal(t, []*rtypes.StorageDiff{{Key: common.Hash{}, Before: common.Hash{}, After: common.BigToHash(big.NewInt(1))}}, sim.StateDiff[0].Storage)
+	assert.Equal(t, from, sim.StateDiff[1].Address)
+	assert.Equal(t, uint64(1), sim.StateDiff[1].NonceAfter)
+	assert.Equal(t, common.Hash{}, app.state.GetState(counterAddr, common.Hash{}))
+
+	// a signed transfer moves the value and bumps the nonce
+	to := common.HexToAddress("0x01")
+	tx, err := etypes.SignTx(etypes.NewTransaction(0, to, big.NewInt(5), 21000, big.NewInt(0), nil), etypes.NewEIP155Signer(big.NewInt(1001)), key)
+	assert.NoError(t, err)
+	raw, err := rlp.EncodeToBytes(tx)
+	assert.NoError(t, err)
+	sim = simulate(&rtypes.SimulateRequest{Tx: raw})
+	assert.Equal(t, uint64(21000), sim.GasUsed)
+	assert.Len(t, sim.StateDiff, 2)
+	assert.Equal(t, to, sim.StateDiff[0].Address)
+	assert.Equal(t, big.NewInt(5), sim.StateDiff[0].BalanceAfter)
+	assert.Equal(t, from, sim.StateDiff[1].Address)
+	assert.Equal(t, uint64(1), sim.StateDiff[1].NonceAfter)
+	assert.Equal(t, new(big.Int).Sub(sim.StateDiff[1].BalanceBefore, big.NewInt(5)), sim.StateDiff[1].BalanceAfter)
+
+	// txs of a wrong nonce aren't simulated
+	tx, err = etypes.SignTx(etypes.NewTransaction(3, to, big.NewInt(5), 21000, big.NewInt(0), nil), etypes.NewEIP155Signer(big.NewInt(1001)), key)
+	assert.NoError(t, err)
+	raw, err = rlp.EncodeToBytes(tx)
+	assert.NoError(t, err)
+	load, err := rlp.EncodeToBytes(&rtypes.SimulateRequest{Tx: raw})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, app.Query(append([]byte{rtypes.QueryType_Simulate}, load...)).Log)
+}
+
+func TestUnpackRevertReason(t *testing.T) {
+	// Error("too low")
+	ret := append([]byte{}, revertSelector...)
+	ret = append(ret, common.BigToHash(big.NewInt(32)).Bytes()...)
+	ret = append(ret, common.BigToHash(big.NewInt(7)).Bytes()...)
+	ret = append(ret, common.RightPadBytes([]byte("too low"), 32)...)
+	assert.Equal(t, "too low", unpackRevertReason(ret))
+	assert.Equal(t, "", unpackRevertReason(ret[:40]))
+	assert.Equal(t, "", unpackRevertReason(common.BigToHash(big.NewInt(1)).Bytes()))
+}
@@ -0,0 +1,348 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/syndtr/goleveldb/leveldb/iterator"
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core/rawdb"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// items of an app snapshot, written in this order
+const (
+	snapshotNode    uint8 = iota + 1 // hash -> trie node or contract code
+	snapshotKV                       // kv store key -> value
+	snapshotHistory                  // key -> rlp encoded ValueUpdateHistory
+)
+
+var kvHistorySizeSuffix = append([]byte("_fd_"), KvSizePrefix...)
+
+type snapshotItem struct {
+	Kind  uint8
+	Key   []byte
+	Value []byte
+}
+
+type prefixIterable interface {
+	NewIteratorWithPrefix(prefix []byte) iterator.Iterator
+}
+
+// ExportSnapshot writes the state trie, the kv store and the kv history committed at height into w
+func (app *EVMApp) ExportSnapshot(height int64, appHash []byte, w io.Writer) error {
+	if err := app.checkKVState(height); err != nil {
+		return err
+	}
+	state, err := estate.New(common.BytesToHash(appHash), estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return err
+	}
+	if err := exportState(state, w); err != nil {
+		return err
+	}
+	return app.keyValueHistoryManager.export(uint64(height), w)
+}
+
+// RestoreSnapshot replaces the state of an app behind height by the snapshot read from r
+func (app *EVMApp) RestoreSnapshot(height int64, appHash []byte, r io.Reader) error {
+	if app.lastHeight() >= height {
+		return fmt.Errorf("can't restore a snapshot over the state of height %d", app.lastHeight())
+	}
+	root := common.BytesToHash(appHash)
+	if err := app.keyValueHistoryManager.reset(); err != nil {
+		return err
+	}
+	// the kvs deleted on the chain since the local state are dropped too
+	batch := app.stateDb.NewBatch()
+	if err := deleteKVStore(app.stateDb, batch); err != nil {
+		return err
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+	if err := importSnapshot(app.stateDb, app.keyValueHistoryManager, root, r); err != nil {
+		return err
+	}
+
+	app.stateMtx.Lock()
+	state, err := estate.New(root, estate.NewDatabase(app.stateDb))
+	if err == nil {
+		app.SaveLastBlock(LastBlockInfo{Height: height, AppHash: appHash})
+		app.state = state
+	}
+	app.stateMtx.Unlock()
+	if err != nil {
+		return err
+	}
+	app.pool.setHeight(height)
+	app.pool.updateToState()
+	log.Info("app restored from snapshot", zap.Int64("height", height), zap.String("appHash", fmt.Sprintf("%X", appHash)))
+	return nil
+}
+
+func writeSnapshotItem(w io.Writer, kind uint8, key, value []byte) error {
+	return rlp.Encode(w, &snapshotItem{Kind: kind, Key: key, Value: value})
+}
+
+// exportState writes the nodes of the state trie, the storage tries and the kv trie, the contract codes and the kv store
+func exportState(state *estate.StateDB, w io.Writer) error {
+	triedb := state.Database().TrieDB()
+	it := estate.NewNodeIterator(state)
+	for it.Next() {
+		// nodes embedded in their parent have no hash
+		if it.Hash == (common.Hash{}) {
+			continue
+		}
+		blob, err := triedb.Node(it.Hash)
+		if err != nil {
+			return err
+		}
+		if err := writeSnapshotItem(w, snapshotNode, it.Hash.Bytes(), blob); err != nil {
+			return err
+		}
+	}
+	if it.Error != nil {
+		return it.Error
+	}
+
+	kvTrie, kvRoot, err := kvTrieAt(state)
+	if err != nil || kvRoot == (common.Hash{}) {
+		return err
+	}
+	nodeIt := kvTrie.NodeIterator(nil)
+	for nodeIt.Next(true) {
+		if nodeIt.Hash() == (common.Hash{}) {
+			continue
+		}
+		blob, err := triedb.Node(nodeIt.Hash())
+		if err != nil {
+			return err
+		}
+		if err := writeSnapshotItem(w, snapshotNode, nodeIt.Hash().Bytes(), blob); err != nil {
+			return err
+		}
+	}
+	if nodeIt.Error() != nil {
+		return nodeIt.Error()
+	}
+	leafIt := trie.NewIterator(kvTrie.NodeIterator(nil))
+	for leafIt.Next() {
+		key := kvTrie.GetKey(leafIt.Key)
+		if key == nil {
+			return fmt.Errorf("no preimage of kv key %x", leafIt.Key)
+		}
+		if err := writeSnapshotItem(w, snapshotKV, key, leafIt.Value); err != nil {
+			return err
+		}
+	}
+	return leafIt.Err
+}
+
+// export writes the updates of every key committed at or below height
+func (m *KeyValueHistoryManager) export(height uint64, w io.Writer) error {
+	db, ok := m.db.(prefixIterable)
+	if !ok {
+		return fmt.Errorf("kv history database can't be iterated")
+	}
+	it := db.NewIteratorWithPrefix(KvHistoryPrefix)
+	defer it.Release()
+	for it.Next() {
+		if !bytes.HasSuffix(it.Key(), kvHistorySizeSuffix) {
+			continue
+		}
+		key := common.CopyBytes(it.Key()[len(KvHistoryPrefix) : len(it.Key())-len(kvHistorySizeSuffix)])
+		size := binary.BigEndian.Uint32(it.Value())
+		for i := uint32(0); i < size; i++ {
+			history, err := m.Get(key, i)
+			if err != nil {
+				return err
+			}
+			// histories are appended in commit order
+			if history.BlockHeight > height {
+				break
+			}
+			data, err := rlp.EncodeToBytes(history)
+			if err != nil {
+				return err
+			}
+			if err := writeSnapshotItem(w, snapshotHistory, key, data); err != nil {
+				return err
+			}
+		}
+	}
+	return it.Error()
+}
+
+// reset deletes all the kv history
+func (m *KeyValueHistoryManager) reset() error {
+	db, ok := m.db.(prefixIterable)
+	if !ok {
+		return fmt.Errorf("kv history database can't be iterated")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	it := db.NewIteratorWithPrefix(KvHistoryPrefix)
+	defer it.Release()
+	batch := m.db.NewBatch()
+	for it.Next() {
+		if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+			return err
+		}
+	}
+	if it.Error() != nil {
+		return it.Error()
+	}
+	return batch.Write()
+}
+
+// deleteKVStore adds the deletes of every kv of the kv store to batch
+func deleteKVStore(db ethdb.Database, batch ethdb.Batch) error {
+	iterable, ok := db.(prefixIterable)
+	if !ok {
+		return fmt.Errorf("kv store can't be iterated")
+	}
+	it := iterable.NewIteratorWithPrefix(KvPrefix)
+	defer it.Release()
+	for it.Next() {
+		if err := batch.Delete(common.CopyBytes(it.Key())); err != nil {
+			return err
+		}
+	}
+	return it.Error()
+}
+
+// importSnapshot writes the snapshot read from r into stateDb and histories, and checks it
+// holds the whole state of root
+func importSnapshot(stateDb ethdb.Database, histories *KeyValueHistoryManager, root common.Hash, r io.Reader) error {
+	var (
+		stream     = rlp.NewStream(r, 0)
+		batch      = stateDb.NewBatch()
+		historyB   = histories.NewBatch()
+		kind       = snapshotNode
+		kvTrie     *trie.SecureTrie
+		kvs, leafs int
+	)
+	for {
+		item := &snapshotItem{}
+		err := stream.Decode(item)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if item.Kind < kind {
+			return fmt.Errorf("snapshot item of kind %d out of order", item.Kind)
+		}
+		if item.Kind != kind && kind == snapshotNode {
+			// all nodes are in, the kv store is checked against them
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+			state, err := estate.New(root, estate.NewDatabase(stateDb))
+			if err != nil {
+				return err
+			}
+			if kvTrie, _, err = kvTrieAt(state); err != nil {
+				return err
+			}
+		}
+		kind = item.Kind
+
+		switch item.Kind {
+		case snapshotNode:
+			if !bytes.Equal(crypto.Keccak256(item.Value), item.Key) {
+				return fmt.Errorf("snapshot node %x doesn't match its hash", item.Key)
+			}
+			err = batch.Put(item.Key, item.Value)
+		case snapshotKV:
+			var value []byte
+			if value, err = kvTrie.TryGet(item.Key); err != nil {
+				return err
+			}
+			if !bytes.Equal(value, item.Value) {
+				return fmt.Errorf("snapshot kv %x doesn't match the state", item.Key)
+			}
+			rawdb.WritePreimages(batch, map[common.Hash][]byte{crypto.Keccak256Hash(item.Key): item.Key})
+			err = batch.Put(append(KvPrefix, item.Key...), item.Value)
+			kvs++
+		case snapshotHistory:
+			history := &types.ValueUpdateHistory{}
+			if err = rlp.DecodeBytes(item.Value, history); err == nil {
+				err = historyB.put(item.Key, history)
+			}
+		default:
+			return fmt.Errorf("unknown snapshot item kind %d", item.Kind)
+		}
+		if err != nil {
+			return err
+		}
+		if batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := batch.Write(); err != nil {
+				return err
+			}
+			batch.Reset()
+		}
+		// history sizes are written once all is in
+		if historyB.batch.ValueSize() > ethdb.IdealBatchSize {
+			if err := historyB.batch.Write(); err != nil {
+				return err
+			}
+			historyB.batch.Reset()
+		}
+	}
+	if err := batch.Write(); err != nil {
+		return err
+	}
+
+	// every node of the state must be there, and every kv
+	state, err := estate.New(root, estate.NewDatabase(stateDb))
+	if err != nil {
+		return err
+	}
+	it := estate.NewNodeIterator(state)
+	for it.Next() {
+	}
+	if it.Error != nil {
+		return fmt.Errorf("incomplete snapshot: %v", it.Error)
+	}
+	if kvTrie, _, err = kvTrieAt(state); err != nil {
+		return err
+	}
+	leafIt := trie.NewIterator(kvTrie.NodeIterator(nil))
+	for leafIt.Next() {
+		leafs++
+	}
+	if leafIt.Err != nil {
+		return fmt.Errorf("incomplete snapshot: %v", leafIt.Err)
+	}
+	if leafs != kvs {
+		return fmt.Errorf("snapshot holds %d kvs, the state %d", kvs, leafs)
+	}
+	return historyB.SaveKeyHistory(nil)
+}
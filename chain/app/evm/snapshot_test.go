@@ -0,0 +1,160 @@
+package evm
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"testing"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHistories(t *testing.T) (*KeyValueHistoryManager, func()) {
+	dir, err := ioutil.TempDir("", "kvhistory")
+	assert.NoError(t, err)
+	db, err := ethdb.NewLDBDatabase(dir, 0, 0)
+	assert.NoError(t, err)
+	m := NewKeyValueHistoryManager(db)
+	return m, func() {
+		m.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func snapshotItems(t *testing.T, data []byte) []*snapshotItem {
+	var items []*snapshotItem
+	stream := rlp.NewStream(bytes.NewReader(data), 0)
+	for {
+		item := &snapshotItem{}
+		err := stream.Decode(item)
+		if err == io.EOF {
+			return items
+		}
+		assert.NoError(t, err)
+		items = append(items, item)
+	}
+}
+
+func encodeSnapshotItems(t *testing.T, items []*snapshotItem) io.Reader {
+	buf := new(bytes.Buffer)
+	for _, item := range items {
+		assert.NoError(t, rlp.Encode(buf, item))
+	}
+	return buf
+}
+
+func TestSnapshot(t *testing.T) {
+	db := ethdb.NewMemDatabase()
+	state, err := estate.New(common.Hash{}, estate.NewDatabase(db))
+	assert.NoError(t, err)
+	contract := common.HexToAddress("0x02")
+	state.AddBalance(common.HexToAddress("0x01"), big.NewInt(1))
+	state.SetCode(contract, []byte{0x60, 0x00, 0x60, 0x00})
+	for i := int64(0); i < 20; i++ {
+		state.SetState(contract, common.BigToHash(big.NewInt(i)), common.BigToHash(big.NewInt(i+1)))
+	}
+	kvRoot, err := applyKVOps(state, []*rtypes.KVOp{
+		{Op: rtypes.KVOpPut, Key: []byte("a"), Value: []byte("1")},
+		{Op: rtypes.KVOpPut, Key: []byte("b"), Value: bytes.Repeat([]byte("2"), 100)},
+	})
+	assert.NoError(t, err)
+	root, err := state.Commit(true)
+	assert.NoError(t, err)
+	assert.NoError(t, state.Database().TrieDB().Commit(root, false))
+	assert.NoError(t, commitKVTrie(state, kvRoot))
+	state, err = estate.New(root, estate.NewDatabase(db))
+	assert.NoError(t, err)
+
+	histories, closeHistories := newTestHistories(t)
+	defer closeHistories()
+	update := func(key string, height uint64, value string) *types.KeyValueHistory {
+		return &types.KeyValueHistory{Key: []byte(key), ValueUpdateHistory: &types.ValueUpdateHistory{BlockHeight: height, Value: []byte(value)}}
+	}
+	assert.NoError(t, histories.SaveKeyHistory(types.KeyValueHistories{update("a", 1, "0"), update("a", 2, "1"), update("b", 2, "2")}))
+	// committed after the snapshot height
+	assert.NoError(t, histories.SaveKeyHistory(types.KeyValueHistories{update("a", 3, "3"), update("c", 3, "3")}))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(t, exportState(state, buf))
+	assert.NoError(t, histories.export(2, buf))
+	data := buf.Bytes()
+
+	restore := func(r io.Reader) (ethdb.Database, *KeyValueHistoryManager, func(), error) {
+		db := ethdb.NewMemDatabase()
+		histories, closeHistories := newTestHistories(t)
+		return db, histories, closeHistories, importSnapshot(db, histories, root, r)
+	}
+	restoredDb, restoredHistories, closeRestored, err := restore(bytes.NewReader(data))
+	defer closeRestored()
+	assert.NoError(t, err)
+
+	restored, err := estate.New(root, estate.NewDatabase(restoredDb))
+	assert.NoError(t, err)
+	assert.Equal(t, root, restored.IntermediateRoot(false))
+	assert.Equal(t, state.GetCode(contract), restored.GetCode(contract))
+	assert.Equal(t, common.BigToHash(big.NewInt(20)), restored.GetState(contract, common.BigToHash(big.NewInt(19))))
+	value, err := restoredDb.Get(append(KvPrefix, 'b'))
+	assert.NoError(t, err)
+	assert.Equal(t, bytes.Repeat([]byte("2"), 100), value)
+	history, err := restoredHistories.GetAsOf([]byte("a"), 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(history.Value))
+	size, err := restoredHistories.GetKeyHistorySize([]byte("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, uint32(2), size)
+	_, err = restoredHistories.GetKeyHistorySize([]byte("c"))
+	assert.Error(t, err)
+
+	// the restored state exports the same snapshot, kv preimages included
+	again := new(bytes.Buffer)
+	assert.NoError(t, exportState(restored, again))
+	assert.NoError(t, restoredHistories.export(2, again))
+	assert.Equal(t, data, again.Bytes())
+
+	// an app restored from the snapshot drops the kvs of its former state
+	app, closeApp := newTestApp(t, nil, false)
+	defer closeApp()
+	assert.NoError(t, app.stateDb.Put(append(KvPrefix, 'z'), []byte("deleted")))
+	assert.NoError(t, app.RestoreSnapshot(2, root.Bytes(), bytes.NewReader(data)))
+	has, err := app.stateDb.Has(append(KvPrefix, 'z'))
+	assert.NoError(t, err)
+	assert.False(t, has)
+	value, err = app.stateDb.Get(append(KvPrefix, 'a'))
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(value))
+
+	items := snapshotItems(t, data)
+	tamper := func(change func([]*snapshotItem) []*snapshotItem) error {
+		changed := change(append([]*snapshotItem{}, items...))
+		_, _, closeRestored, err := restore(encodeSnapshotItems(t, changed))
+		closeRestored()
+		return err
+	}
+	assert.Error(t, tamper(func(items []*snapshotItem) []*snapshotItem {
+		items[0] = &snapshotItem{Kind: items[0].Kind, Key: items[0].Key, Value: append([]byte{0}, items[0].Value...)}
+		return items
+	}), "forged node")
+	assert.Error(t, tamper(func(items []*snapshotItem) []*snapshotItem {
+		return items[1:]
+	}), "missing node")
+	for i, item := range items {
+		if item.Kind == snapshotKV {
+			assert.Error(t, tamper(func(items []*snapshotItem) []*snapshotItem {
+				items[i] = &snapshotItem{Kind: snapshotKV, Key: item.Key, Value: []byte("forged")}
+				return items
+			}), "forged kv")
+			assert.Error(t, tamper(func(items []*snapshotItem) []*snapshotItem {
+				return append(items[:i], items[i+1:]...)
+			}), "missing kv")
+			break
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/core"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// blockTxState replays the block at height up to its tx at index, and returns the state the tx
+// was executed on along with the tx and the header and the hash of the block
+func (app *EVMApp) blockTxState(height, index uint64) (*estate.StateDB, *etypes.Header, *etypes.Transaction, common.Hash, error) {
+	if height == 0 || height > uint64(app.lastHeight()) {
+		return nil, nil, nil, common.Hash{}, fmt.Errorf("block %d isn't committed", height)
+	}
+	block, _, err := app.core.GetBlock(int64(height))
+	if err != nil {
+		return nil, nil, nil, common.Hash{}, err
+	}
+	if index >= uint64(len(block.Data.Txs)) {
+		return nil, nil, nil, common.Hash{}, fmt.Errorf("block %d has no tx %d", height, index)
+	}
+
+	// the app hash of a block is the state before it
+	root := EmptyTrieRoot
+	if len(block.Header.AppHash) > 0 {
+		root = common.BytesToHash(block.Header.AppHash)
+	}
+	state, err := estate.New(root, estate.NewDatabase(app.stateDb))
+	if err != nil {
+		return nil, nil, nil, common.Hash{}, err
+	}
+	header := makeCurrentHeader(block, block.Header, app.gasLimit, app.fee.Beneficiary(block.ProposerAddress))
+	blockHash := common.BytesToHash(block.Hash())
+
+	blockGas := new(core.GasPool).AddGas(app.gasLimit)
+	for i, raw := range block.Data.Txs[:index+1] {
+		tx := new(etypes.Transaction)
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			if uint64(i) == index {
+				return nil, nil, nil, common.Hash{}, err
+			}
+			continue
+		}
+		if _, err := etypes.Sender(app.Signer, tx); err != nil {
+			if uint64(i) == index {
+				return nil, nil, nil, common.Hash{}, err
+			}
+			continue
+		}
+		if uint64(i) == index {
+			return state, header, tx, blockHash, nil
+		}
+
+		// the invalid txs of the block leave the state as it was
+		snapshot := state.Snapshot()
+		if err := app.replayTx(header, blockHash, blockGas, state, i, tx); err != nil {
+			state.RevertToSnapshot(snapshot)
+		}
+	}
+	return nil, nil, nil, common.Hash{}, fmt.Errorf("block %d has no tx %d", height, index)
+}
+
+// replayTx applies a tx of a committed block as genExecFun did
+func (app *EVMApp) replayTx(header *etypes.Header, blockHash common.Hash, blockGas *core.GasPool, state *estate.StateDB, txIndex int, tx *etypes.Transaction) error {
+	if isKVTx(tx.Data()) {
+		_, err := app.executeKVTx(state, tx)
+		return err
+	}
+	if err := app.checkTxGas(tx); err != nil {
+		return err
+	}
+	if tx.Gas() > blockGas.Gas() {
+		return core.ErrGasLimitReached
+	}
+	receipt, err := app.applyTx(header, blockHash, state, txIndex, tx)
+	if err != nil {
+		return err
+	}
+	return blockGas.SubGas(receipt.GasUsed)
+}
+
+// trace re-executes a committed tx or runs a call with a tracer
+func (app *EVMApp) trace(req *rtypes.TraceRequest) (*rtypes.TraceResult, error) {
+	var (
+		logger    *vm.StructLogger
+		callTrees *vm.CallTreeTracer
+		tracer    vm.Tracer
+	)
+	switch req.Tracer {
+	case rtypes.TracerStructLog:
+		logger = vm.NewStructLogger(&vm.LogConfig{
+			DisableStack:   req.DisableStack,
+			DisableMemory:  req.DisableMemory,
+			DisableStorage: req.DisableStorage,
+		})
+		tracer = logger
+	case rtypes.TracerCall:
+		callTrees = vm.NewCallTreeTracer()
+		tracer = callTrees
+	default:
+		return nil, fmt.Errorf("unknown tracer %q", req.Tracer)
+	}
+
+	var (
+		state  *estate.StateDB
+		header *etypes.Header
+		msg    core.Message
+	)
+	if req.Height > 0 {
+		st, h, tx, blockHash, err := app.blockTxState(req.Height, req.Index)
+		if err != nil {
+			return nil, err
+		}
+		if isKVTx(tx.Data()) {
+			return nil, fmt.Errorf("kv txs can't be traced")
+		}
+		if msg, err = tx.AsMessage(app.Signer); err != nil {
+			return nil, err
+		}
+		txBytes, err := rlp.EncodeToBytes(tx)
+		if err != nil {
+			return nil, err
+		}
+		st.Prepare(common.BytesToHash(gtypes.Tx(txBytes).Hash()), blockHash, int(req.Index))
+		state, header = st, h
+	} else {
+		st, h, err := app.stateAt(req.Call.Height)
+		if err != nil {
+			return nil, err
+		}
+		state, header, msg = st, h, newCallMessage(&req.Call)
+	}
+
+	config := vm.Config{EVMGasLimit: EVMGasLimit, Debug: true, Tracer: tracer}
+	envCxt := core.NewEVMContext(msg, header, NewBlockChain(app.stateDb), nil)
+	vmEnv := vm.NewEVM(envCxt, state, app.chainConfig, config)
+	ret, gasUsed, failed, err := core.ApplyMessage(vmEnv, msg, new(core.GasPool).AddGas(app.gasLimit))
+	if err != nil {
+		return nil, err
+	}
+
+	res := &rtypes.TraceResult{
+		Gas:         gasUsed,
+		Failed:      failed,
+		ReturnValue: ret,
+	}
+	if logger != nil {
+		res.StructLogs = formatStructLogs(logger.StructLogs())
+	} else {
+		res.CallTree = callTrees.Result()
+	}
+	return res, nil
+}
+
+func formatStructLogs(logs []vm.StructLog) []*rtypes.StructLog {
+	formatted := make([]*rtypes.StructLog, len(logs))
+	for i, l := range logs {
+		formatted[i] = &rtypes.StructLog{
+			Pc:      l.Pc,
+			Op:      l.Op.String(),
+			Gas:     l.Gas,
+			GasCost: l.GasCost,
+			Depth:   uint64(l.Depth),
+			Stack:   l.Stack,
+			Memory:  l.Memory,
+		}
+		if l.Err != nil {
+			formatted[i].Error = l.Err.Error()
+		}
+		for key, value := range l.Storage {
+			formatted[i].Storage = append(formatted[i].Storage, &rtypes.StorageSlot{Key: key, Value: value})
+		}
+		sort.Slice(formatted[i].Storage, func(a, b int) bool {
+			return bytes.Compare(formatted[i].Storage[a].Key[:], formatted[i].Storage[b].Key[:]) < 0
+		})
+	}
+	return formatted
+}
+
+func (app *EVMApp) queryTrace(load []byte) gtypes.Result {
+	req := &rtypes.TraceRequest{}
+	if err := rlp.DecodeBytes(load, req); err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp decode error:"+err.Error())
+	}
+	res, err := app.trace(req)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_InternalError, err.Error())
+	}
+	data, err := rlp.EncodeToBytes(res)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp encode error:"+err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
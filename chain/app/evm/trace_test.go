@@ -0,0 +1,98 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestTrace(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	app, closeApp := newTestApp(t, []*ecdsa.PrivateKey{key}, false)
+	defer closeApp()
+
+	// the counter is called once before the caller calls it
+	var txs gtypes.Txs
+	for nonce, to := range []common.Address{counterAddr, callerAddr} {
+		tx, err := etypes.SignTx(etypes.NewTransaction(uint64(nonce), to, big.NewInt(0), 100000, big.NewInt(0), nil), etypes.NewEIP155Signer(big.NewInt(1001)), key)
+		assert.NoError(t, err)
+		raw, err := rlp.EncodeToBytes(tx)
+		assert.NoError(t, err)
+		txs = append(txs, raw)
+	}
+	block, _ := gtypes.MakeBlock(1, "test", txs, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, app.getLastAppHash().Bytes(), nil, 65536)
+	block.Time = time.Unix(1500000000, 0)
+	_, err = app.OnExecute(1, 0, block)
+	assert.NoError(t, err)
+	_, err = app.OnCommit(1, 0, block)
+	assert.NoError(t, err)
+	app.core.(*testCore).blocks[1] = block
+
+	trace := func(req *rtypes.TraceRequest) *rtypes.TraceResult {
+		load, err := rlp.EncodeToBytes(req)
+		assert.NoError(t, err)
+		res := app.Query(append([]byte{rtypes.QueryType_Trace}, load...))
+		assert.Equal(t, "", res.Log)
+		tr := &rtypes.TraceResult{}
+		assert.NoError(t, rlp.DecodeBytes(res.Data, tr))
+		return tr
+	}
+
+	// the second tx stores 2 on the state left by the first one
+	tr := trace(&rtypes.TraceRequest{Height: 1, Index: 1})
+	assert.False(t, tr.Failed)
+	assert.Nil(t, tr.CallTree)
+	var stores []*rtypes.StructLog
+	for _, l := range tr.StructLogs {
+		if l.Op == "SSTORE" {
+			stores = append(stores, l)
+		}
+	}
+	if assert.Len(t, stores, 1) {
+		assert.Equal(t, uint64(2), stores[0].Depth)
+		assert.Equal(t, []*rtypes.StorageSlot{{Key: common.Hash{}, Value: common.BigToHash(big.NewInt(2))}}, stores[0].Storage)
+	}
+
+	tr = trace(&rtypes.TraceRequest{Height: 1, Index: 1, Tracer: rtypes.TracerCall})
+	assert.Empty(t, tr.StructLogs)
+	if assert.NotNil(t, tr.CallTree) {
+		assert.Equal(t, "CALL", tr.CallTree.Type)
+		assert.Equal(t, from, tr.CallTree.From)
+		assert.Equal(t, callerAddr, tr.CallTree.To)
+		if assert.Len(t, tr.CallTree.Calls, 1) {
+			assert.Equal(t, "CALL", tr.CallTree.Calls[0].Type)
+			assert.Equal(t, callerAddr, tr.CallTree.Calls[0].From)
+			assert.Equal(t, counterAddr, tr.CallTree.Calls[0].To)
+			assert.Empty(t, tr.CallTree.Calls[0].Error)
+		}
+	}
+
+	// a call is traced on the latest state
+	tr = trace(&rtypes.TraceRequest{Call: rtypes.CallMsg{From: from, To: counterAddr.Bytes()}, DisableStack: true})
+	assert.NotEmpty(t, tr.StructLogs)
+	for _, l := range tr.StructLogs {
+		assert.Empty(t, l.Stack)
+		if l.Op == "SSTORE" {
+			assert.Equal(t, common.BigToHash(big.NewInt(3)), l.Storage[0].Value)
+		}
+	}
+
+	load, err := rlp.EncodeToBytes(&rtypes.TraceRequest{Height: 2})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, app.Query(append([]byte{rtypes.QueryType_Trace}, load...)).Log)
+	load, err = rlp.EncodeToBytes(&rtypes.TraceRequest{Height: 1, Tracer: "jsTracer"})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, app.Query(append([]byte{rtypes.QueryType_Trace}, load...)).Log)
+}
@@ -0,0 +1,103 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"fmt"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// revertFailure is the failure of a tx executed with a failed receipt
+func revertFailure(receipt *etypes.Receipt) *rtypes.TxFailure {
+	failure := &rtypes.TxFailure{
+		GasUsed:      receipt.GasUsed,
+		RevertReason: unpackRevertReason(receipt.ReturnData),
+	}
+	if receipt.VMErr != nil {
+		failure.Error = receipt.VMErr.Error()
+	}
+	return failure
+}
+
+func (app *EVMApp) addTxFailure(hash common.Hash, failure *rtypes.TxFailure) {
+	if app.txFailures == nil {
+		app.txFailures = make(map[common.Hash]*rtypes.TxFailure)
+	}
+	app.txFailures[hash] = failure
+}
+
+// saveTxFailures persists the failed txs of a committed block, along with a failure receipt for
+// the invalid ones. Neither is part of the receipts hash, and a committed tx sent again keeps its receipt.
+func (app *EVMApp) saveTxFailures(block *gtypes.Block) error {
+	if len(app.txFailures) == 0 {
+		return nil
+	}
+	batch := app.stateDb.NewBatch()
+	for i, raw := range block.Data.Txs {
+		hash := common.BytesToHash(raw.Hash())
+		failure, ok := app.txFailures[hash]
+		if !ok {
+			continue
+		}
+		failure.Height, failure.Index = uint64(block.Height), uint64(i)
+		if failure.Invalid {
+			if has, _ := app.stateDb.Has(append(ReceiptsPrefix, hash.Bytes()...)); has {
+				continue
+			}
+			receipt, err := rlp.EncodeToBytes(&etypes.ReceiptForStorage{
+				Status: etypes.ReceiptStatusFailed,
+				TxHash: hash,
+				Logs:   []*etypes.Log{},
+			})
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(append(ReceiptsPrefix, hash.Bytes()...), receipt); err != nil {
+				return err
+			}
+		}
+		data, err := rlp.EncodeToBytes(failure)
+		if err != nil {
+			return err
+		}
+		if err := batch.Put(append(FailurePrefix, hash.Bytes()...), data); err != nil {
+			return err
+		}
+	}
+	return batch.Write()
+}
+
+// receiptResult is the stored receipt of a tx along with its failure
+func (app *EVMApp) receiptResult(hash, receiptBytes []byte) ([]byte, error) {
+	receipt := &etypes.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(receiptBytes, receipt); err != nil {
+		return nil, fmt.Errorf("rlp decode error:%v", err)
+	}
+	res := &rtypes.ReceiptResult{Receipt: receiptBytes}
+	// the failure of a tx which was invalid before being executed successfully is stale
+	if receipt.Status != etypes.ReceiptStatusSuccessful {
+		if data, err := app.stateDb.Get(append(FailurePrefix, hash...)); err == nil {
+			res.Failure = &rtypes.TxFailure{}
+			if err := rlp.DecodeBytes(data, res.Failure); err != nil {
+				return nil, fmt.Errorf("rlp decode error:%v", err)
+			}
+		}
+	}
+	return rlp.EncodeToBytes(res)
+}
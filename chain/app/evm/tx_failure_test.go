@@ -0,0 +1,88 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestTxFailureReceipts(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	app, closeApp := newTestApp(t, []*ecdsa.PrivateKey{key}, false)
+	defer closeApp()
+
+	// the init code reverts with Error("too low") copied from the end of the code
+	ret := append([]byte{}, revertSelector...)
+	ret = append(ret, common.BigToHash(big.NewInt(32)).Bytes()...)
+	ret = append(ret, common.BigToHash(big.NewInt(7)).Bytes()...)
+	ret = append(ret, common.RightPadBytes([]byte("too low"), 32)...)
+	code := append(common.FromHex("0x6064600c600039606460"+"00fd"), ret...)
+	reverted, err := etypes.SignTx(etypes.NewContractCreation(0, big.NewInt(0), 100000, big.NewInt(0), code), etypes.NewEIP155Signer(big.NewInt(1001)), key)
+	assert.NoError(t, err)
+	raw, err := rlp.EncodeToBytes(reverted)
+	assert.NoError(t, err)
+	invalid, rawInvalid := signPoolTx(t, key, 5, 1, 0)
+	ok, rawOK := signPoolTx(t, key, 1, 1, 0)
+
+	block, _ := gtypes.MakeBlock(1, "test", gtypes.Txs{raw, rawInvalid, rawOK}, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, app.getLastAppHash().Bytes(), nil, 65536)
+	block.Time = time.Unix(1500000000, 0)
+	_, err = app.OnExecute(1, 0, block)
+	assert.NoError(t, err)
+	_, err = app.OnCommit(1, 0, block)
+	assert.NoError(t, err)
+
+	receipt := func(hash common.Hash) (*etypes.ReceiptForStorage, *rtypes.TxFailure) {
+		res := app.Query(append([]byte{rtypes.QueryType_Receipt}, append(hash.Bytes(), rtypes.QueryReceiptFailure)...))
+		assert.Equal(t, "", res.Log)
+		rr := &rtypes.ReceiptResult{}
+		assert.NoError(t, rlp.DecodeBytes(res.Data, rr))
+		r := &etypes.ReceiptForStorage{}
+		assert.NoError(t, rlp.DecodeBytes(rr.Receipt, r))
+		return r, rr.Failure
+	}
+
+	r, failure := receipt(reverted.Hash())
+	assert.Equal(t, etypes.ReceiptStatusFailed, r.Status)
+	if assert.NotNil(t, failure) {
+		assert.Equal(t, &rtypes.TxFailure{Height: 1, Index: 0, GasUsed: r.GasUsed, Error: "evm: execution reverted", RevertReason: "too low"}, failure)
+		assert.NotZero(t, failure.GasUsed)
+	}
+
+	r, failure = receipt(invalid.Hash())
+	assert.Equal(t, etypes.ReceiptStatusFailed, r.Status)
+	assert.Zero(t, r.GasUsed)
+	if assert.NotNil(t, failure) {
+		assert.True(t, failure.Invalid)
+		assert.Equal(t, uint64(1), failure.Index)
+		assert.NotEmpty(t, failure.Error)
+	}
+	// the bare receipt is still served
+	assert.NotEmpty(t, app.Query(append([]byte{rtypes.QueryType_Receipt}, invalid.Hash().Bytes()...)).Data)
+
+	r, failure = receipt(ok.Hash())
+	assert.Equal(t, etypes.ReceiptStatusSuccessful, r.Status)
+	assert.Nil(t, failure)
+
+	// a committed tx sent again keeps its receipt
+	block, _ = gtypes.MakeBlock(2, "test", gtypes.Txs{rawOK}, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, app.getLastAppHash().Bytes(), nil, 65536)
+	block.Time = time.Unix(1500000001, 0)
+	res, err := app.OnExecute(2, 0, block)
+	assert.NoError(t, err)
+	assert.Len(t, res.(gtypes.ExecuteResult).InvalidTxs, 1)
+	_, err = app.OnCommit(2, 0, block)
+	assert.NoError(t, err)
+	r, failure = receipt(ok.Hash())
+	assert.Equal(t, etypes.ReceiptStatusSuccessful, r.Status)
+	assert.Nil(t, failure)
+}
@@ -0,0 +1,121 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"io"
+	"os"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const txJournalName = "txpool.journal"
+
+// txJournal is an append only file of the txs accepted by the tx pool, for them to survive restarts
+type txJournal struct {
+	path    string
+	file    *os.File // nil until the journal is rotated for the first time
+	entries int      // txs in the file, the committed and the dropped ones included
+}
+
+func newTxJournal(path string) *txJournal {
+	return &txJournal{path: path}
+}
+
+// load passes the txs of the journal to add. A tx written partially by a crash ends the journal.
+func (j *txJournal) load(add func(types.Tx) error) error {
+	file, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var loaded, dropped int
+	stream := rlp.NewStream(file, 0)
+	for {
+		var tx []byte
+		if err := stream.Decode(&tx); err != nil {
+			if err != io.EOF {
+				log.Warn("tx journal ends with a broken tx", zap.Error(err))
+			}
+			break
+		}
+		loaded++
+		if err := add(tx); err != nil {
+			dropped++
+		}
+	}
+	log.Info("loaded tx journal", zap.Int("txs", loaded), zap.Int("dropped", dropped))
+	return nil
+}
+
+// insert appends a tx to the journal
+func (j *txJournal) insert(tx types.Tx) error {
+	if j.file == nil {
+		return nil
+	}
+	if err := rlp.Encode(j.file, []byte(tx)); err != nil {
+		return err
+	}
+	j.entries++
+	return nil
+}
+
+// rotate replaces the journal with the txs still in the pool
+func (j *txJournal) rotate(txs []types.Tx) error {
+	tmp, err := os.OpenFile(j.path+".new", os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	for _, tx := range txs {
+		if err := rlp.Encode(tmp, []byte(tx)); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	tmp.Close()
+
+	if j.file != nil {
+		j.file.Close()
+		j.file = nil
+	}
+	if err := os.Rename(j.path+".new", j.path); err != nil {
+		return err
+	}
+	if j.file, err = os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return err
+	}
+	j.entries = len(txs)
+	return nil
+}
+
+func (j *txJournal) close() error {
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
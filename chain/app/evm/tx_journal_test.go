@@ -0,0 +1,72 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestTxJournalRestart(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	app, closeApp := newTestApp(t, []*ecdsa.PrivateKey{key}, false)
+	defer closeApp()
+	settings := map[string]interface{}{"evm_txpool_journal": true}
+	restart := func() *ethTxPool {
+		tp := newTestPool(t, app, settings)
+		tp.Start(0)
+		return tp
+	}
+
+	tp := restart()
+	var (
+		txs  gtypes.Txs
+		last common.Hash
+	)
+	for _, nonce := range []uint64{0, 1, 2, 4} {
+		tx, raw := signPoolTx(t, key, nonce, 1, 5)
+		assert.NoError(t, tp.ReceiveTx(raw))
+		txs, last = append(txs, raw), tx.Hash()
+	}
+
+	// the pool crashes without closing the journal, its txs are back on restart
+	tp = restart()
+	content := tp.content(nil)
+	if assert.Len(t, content, 1) {
+		assert.Len(t, content[0].Pending, 3)
+		assert.Len(t, content[0].Waiting, 1)
+	}
+	assert.Equal(t, 4, tp.journal.entries)
+
+	// a tx written partially is dropped
+	file, err := os.OpenFile(filepath.Join(app.datadir, txJournalName), os.O_WRONLY|os.O_APPEND, 0644)
+	assert.NoError(t, err)
+	_, raw := signPoolTx(t, key, 3, 1, 5)
+	entry, err := rlp.EncodeToBytes([]byte(raw))
+	assert.NoError(t, err)
+	_, err = file.Write(entry[:len(entry)/2])
+	assert.NoError(t, err)
+	file.Close()
+	tp = restart()
+	assert.Len(t, tp.all, 4)
+	assert.Equal(t, 4, tp.journal.entries)
+
+	// the journal is compacted once the committed txs are the most of it
+	tp.Update(1, txs[:1])
+	assert.Equal(t, 4, tp.journal.entries)
+	tp.Update(1, txs[:3])
+	assert.Equal(t, 1, tp.journal.entries)
+	tp.Stop()
+
+	tp = restart()
+	assert.Len(t, tp.all, 1)
+	assert.Contains(t, tp.all, last)
+}
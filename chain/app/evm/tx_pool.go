@@ -17,6 +17,9 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math/big"
+	"path/filepath"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -24,6 +27,7 @@ import (
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
+	rtypes "github.com/dappledger/AnnChain/chain/types"
 	"github.com/dappledger/AnnChain/eth/common"
 	etypes "github.com/dappledger/AnnChain/eth/core/types"
 	"github.com/dappledger/AnnChain/eth/rlp"
@@ -37,9 +41,27 @@ const (
 	waitingLifeTime = 10 * time.Minute
 )
 
+// rules to replace a pooled tx by a tx of the same sender and nonce
+const (
+	TxReplaceByPriceBump = "price_bump" // the gas price must be priced up by evm_txpool_price_bump percent
+	TxReplaceAny         = "any"
+	TxReplaceNone        = "none"
+)
+
+// policies to make room for a tx in a full pool, only the last waiting tx of another account is evicted
+const (
+	TxEvictByPrice = "price"  // the cheapest one, if the new tx pays more
+	TxEvictOldest  = "oldest" // the one of the account waiting the longest
+	TxEvictNone    = "none"
+)
+
 var (
-	errTxExist                  = errors.New("tx already exist in cache")
-	errTxPoolWaitingQueueIsFull = errors.New("evm tx pool waiting queue is full")
+	errTxExist                   = errors.New("tx already exist in cache")
+	errTxPoolWaitingQueueIsFull  = errors.New("evm tx pool waiting queue is full")
+	errTxPoolIsFull              = errors.New("evm tx pool is full")
+	errAccountWaitingQueueIsFull = errors.New("waiting txs of the account reach the limit")
+	errTxNonceExist              = errors.New("tx of the same nonce is in the pool, replacement is disabled")
+	errReplacementTxUnderpriced  = errors.New("replacement tx underpriced")
 )
 
 type ethTxPool struct {
@@ -54,30 +76,90 @@ type ethTxPool struct {
 	waitingLifeTime time.Duration // Maximum amount of time non-executable transaction are queued
 	waitingLimit    int           // waiting queue size limit
 	pendingLimit    int           // pending queue size limit
+	accountWaiting  int           // waiting txs limit of an account, no limit when 0
+	accountPending  int           // pending txs limit of an account, no limit when 0
+	maxBytes        int           // size limit of the txs in all, no limit when 0
+	bytes           int           // size of the txs in all
+	replaceRule     string
+	priceBump       int64 // percent
+	evictPolicy     string
+	journal         *txJournal // nil when the txs aren't journaled
 	height          int64
 	filter          []types.IFilter
 }
 
-func NewEthTxPool(app *EVMApp, conf *viper.Viper) *ethTxPool {
-	return &ethTxPool{
+func NewEthTxPool(app *EVMApp, conf *viper.Viper) (*ethTxPool, error) {
+	tp := &ethTxPool{
 		all:             make(map[common.Hash]types.Tx),
 		waiting:         make(map[common.Address]*txSortedMap),
 		waitingBeats:    make(map[common.Address]time.Time),
 		pending:         make(map[common.Address]*txSortedMap),
 		extTxs:          clist.New(),
 		broadcastQueue:  clist.New(),
-		waitingLimit:    conf.GetInt("block_size") * 10,
-		pendingLimit:    conf.GetInt("block_size") * 10,
+		waitingLimit:    conf.GetInt("evm_txpool_waiting_limit"),
+		pendingLimit:    conf.GetInt("evm_txpool_pending_limit"),
+		accountWaiting:  conf.GetInt("evm_txpool_account_waiting"),
+		accountPending:  conf.GetInt("evm_txpool_account_pending"),
+		maxBytes:        conf.GetInt("evm_txpool_max_bytes"),
+		replaceRule:     conf.GetString("evm_txpool_replace"),
+		priceBump:       conf.GetInt64("evm_txpool_price_bump"),
+		evictPolicy:     conf.GetString("evm_txpool_evict"),
 		waitingLifeTime: waitingLifeTime,
 		app:             app,
 	}
+	if conf.GetBool("evm_txpool_journal") {
+		tp.journal = newTxJournal(filepath.Join(app.datadir, txJournalName))
+	}
+	if tp.waitingLimit == 0 {
+		tp.waitingLimit = conf.GetInt("block_size") * 10
+	}
+	if tp.pendingLimit == 0 {
+		tp.pendingLimit = conf.GetInt("block_size") * 10
+	}
+	if tp.replaceRule == "" {
+		tp.replaceRule = TxReplaceByPriceBump
+	}
+	if tp.evictPolicy == "" {
+		tp.evictPolicy = TxEvictByPrice
+	}
+
+	switch {
+	case tp.waitingLimit < 0 || tp.pendingLimit < 0 || tp.accountWaiting < 0 || tp.accountPending < 0 || tp.maxBytes < 0:
+		return nil, fmt.Errorf("evm tx pool limits can't be negative")
+	case tp.priceBump < 0:
+		return nil, fmt.Errorf("evm_txpool_price_bump can't be negative")
+	case tp.replaceRule != TxReplaceByPriceBump && tp.replaceRule != TxReplaceAny && tp.replaceRule != TxReplaceNone:
+		return nil, fmt.Errorf("unknown evm_txpool_replace %q", tp.replaceRule)
+	case tp.evictPolicy != TxEvictByPrice && tp.evictPolicy != TxEvictOldest && tp.evictPolicy != TxEvictNone:
+		return nil, fmt.Errorf("unknown evm_txpool_evict %q", tp.evictPolicy)
+	}
+	return tp, nil
 }
 
 func (tp *ethTxPool) Start(height int64) {
 	tp.setHeight(height)
+	if tp.journal != nil {
+		// the journal is written once rotated, the txs loaded aren't journaled twice
+		if err := tp.journal.load(tp.ReceiveTx); err != nil {
+			log.Warn("load tx journal", zap.Error(err))
+		}
+		tp.Lock()
+		if err := tp.journal.rotate(tp.journalTxs(nil)); err != nil {
+			log.Warn("rotate tx journal", zap.Error(err))
+		}
+		tp.Unlock()
+	}
 	go tp.loop()
 }
 
+func (tp *ethTxPool) Stop() {
+	tp.Lock()
+	defer tp.Unlock()
+	if tp.journal != nil {
+		tp.journal.close()
+	}
+}
+
 func (tp *ethTxPool) loop() {
 	evict := time.NewTicker(txEvictInterval)
 	defer evict.Stop()
@@ -94,7 +176,8 @@ func (tp *ethTxPool) loop() {
 
 					// waiting queue of account does not have the pending nonce, delete all its waiting tx
 					for _, tx := range tp.waiting[addr].Flatten() {
-						delete(tp.all, tx.Hash())
+						tp.removeFromAll(tx.Hash())
+						tp.setStatus(tx, rtypes.TxStatusDropped, fmt.Sprintf("waited longer than %s for a missing nonce", tp.waitingLifeTime))
 					}
 					delete(tp.waitingBeats, addr)
 					delete(tp.waiting, addr)
@@ -140,19 +223,33 @@ func (tp *ethTxPool) Reap(maxTxs int) []types.Tx {
 		allTxs = append(allTxs, extTxs...)
 	}
 
-OUTLOOP: // reap normal txs
-	for _, accountTxs := range tp.pending {
-		for _, tx := range accountTxs.Flatten() {
-			txBytes, exist := tp.all[tx.Hash()]
-			if !exist {
-				// cache miss
-				txBytes, _ = rlp.EncodeToBytes(tx)
-			}
-			allTxs = append(allTxs, txBytes)
-			if len(allTxs) == maxTxs {
-				break OUTLOOP
+	// the txs of a block can't ask for more gas than its gas limit
+	gasLeft := tp.app.gasLimit
+
+	// reap normal txs, the ones paying the most first in the nonce order of each account
+	accountsTxs := make(map[common.Address]etypes.Transactions, len(tp.pending))
+	for addr, accountTxs := range tp.pending {
+		if accountTxs.Len() > 0 {
+			accountsTxs[addr] = accountTxs.Flatten()
+		}
+	}
+	txs := etypes.NewTransactionsByPriceAndNonce(tp.app.Signer, accountsTxs)
+	for tx := txs.Peek(); tx != nil && len(allTxs) < maxTxs; tx = txs.Peek() {
+		if !isKVTx(tx.Data()) {
+			if tx.Gas() > gasLeft {
+				// the next txs of the account can't be executed without this one
+				txs.Pop()
+				continue
 			}
+			gasLeft -= tx.Gas()
 		}
+		txBytes, exist := tp.all[tx.Hash()]
+		if !exist {
+			// cache miss
+			txBytes, _ = rlp.EncodeToBytes(tx)
+		}
+		allTxs = append(allTxs, txBytes)
+		txs.Shift()
 	}
 	log.Debug("reap return txs", zap.Int("count", len(allTxs)))
 	return allTxs
@@ -168,10 +265,18 @@ func (tp *ethTxPool) ReceiveTx(rawTx types.Tx) error {
 	if err := rlp.DecodeBytes(rawTx, tx); err != nil {
 		return err
 	}
+	// a pooled tx keeps its status, a dropped one is received again
+	if s, ok := tp.app.txStatus.get(tx.Hash()); !ok || s.Status == rtypes.TxStatusDropped {
+		tp.setStatus(tx, rtypes.TxStatusReceived, "")
+	}
 	if err := tp.CheckAndAdd(tx, rawTx); err != nil {
+		if err != errTxExist {
+			tp.app.txStatus.reject(tx.Hash(), err)
+		}
 		return err
 	}
 	tp.Lock()
+	tp.journalTx(rawTx)
 	tp.broadcastNewTx(rawTx)
 	tp.Unlock()
 	return nil
@@ -219,6 +324,7 @@ func (tp *ethTxPool) handleAdminOP(tx types.Tx) error {
 		front.DetachPrev()
 	}
 	tp.extTxs.PushBack(tx)
+	tp.journalTx(tx)
 	tp.broadcastNewTx(tx)
 	return nil
 }
@@ -269,22 +375,129 @@ func (tp *ethTxPool) CheckAndAdd(tx *etypes.Transaction, rawTx types.Tx) error {
 		return errTxExist
 	}
 
-	from, _ := etypes.Sender(tp.app.Signer, tx)
+	from, err := etypes.Sender(tp.app.Signer, tx)
+	if err != nil {
+		return err
+	}
+	if !isKVTx(tx.Data()) {
+		if err := tp.app.checkTxGas(tx); err != nil {
+			return err
+		}
+	}
 	currentNonce := tp.safeGetNonce(from)
 	if currentNonce > tx.Nonce() {
 		return fmt.Errorf("nonce(%d) different with getNonce(%d)", tx.Nonce(), currentNonce)
 	}
 
+	if replaced, err := tp.replace(tx, from, rawTx); replaced || err != nil {
+		return err
+	}
+	for tp.maxBytes > 0 && tp.bytes+len(rawTx) > tp.maxBytes {
+		if !tp.evictWaiting(tx, from) {
+			return errTxPoolIsFull
+		}
+	}
 	if err := tp.addWaiting(tx, from); err != nil {
 		return err
 	}
-	tp.all[tx.Hash()] = rawTx
-	if currentNonce == tx.Nonce() {
+	tp.addToAll(tx.Hash(), rawTx)
+	tp.setStatus(tx, rtypes.TxStatusWaiting, "")
+	if tp.unSafeGetPendingMaxNonce(from) == tx.Nonce() {
 		tp.promoteExecutables([]common.Address{from})
 	}
 	return nil
 }
 
+// replace puts tx in place of the pooled tx of the same sender and nonce if the replace rule allows it,
+// it returns false when there is no such tx
+func (tp *ethTxPool) replace(tx *etypes.Transaction, from common.Address, rawTx types.Tx) (bool, error) {
+	for i, accountTxs := range []*txSortedMap{tp.pending[from], tp.waiting[from]} {
+		if accountTxs == nil {
+			continue
+		}
+		old := accountTxs.Get(tx.Nonce())
+		if old == nil {
+			continue
+		}
+		switch tp.replaceRule {
+		case TxReplaceNone:
+			return false, errTxNonceExist
+		case TxReplaceByPriceBump:
+			// the gas price must be higher, and by the bump at least
+			min := new(big.Int).Mul(old.GasPrice(), big.NewInt(100+tp.priceBump))
+			min.Div(min, big.NewInt(100))
+			if tx.GasPrice().Cmp(old.GasPrice()) <= 0 || tx.GasPrice().Cmp(min) < 0 {
+				return false, errReplacementTxUnderpriced
+			}
+		}
+		accountTxs.Put(tx)
+		tp.removeFromAll(old.Hash())
+		tp.addToAll(tx.Hash(), rawTx)
+		tp.setStatus(old, rtypes.TxStatusDropped, "replaced by "+tx.Hash().Hex())
+		if i == 0 {
+			tp.setStatus(tx, rtypes.TxStatusPending, "")
+		} else {
+			tp.setStatus(tx, rtypes.TxStatusWaiting, "")
+		}
+		log.Debug("replace pooled tx", zap.String("old", old.Hash().Hex()), zap.String("new", tx.Hash().Hex()))
+		return true, nil
+	}
+	return false, nil
+}
+
+// evictWaiting drops the last waiting tx of another account than from, by the evict policy,
+// to make room for tx. It returns false when no tx can be evicted.
+func (tp *ethTxPool) evictWaiting(tx *etypes.Transaction, from common.Address) bool {
+	var (
+		victim *etypes.Transaction
+		owner  common.Address
+	)
+	for addr, accountTxs := range tp.waiting {
+		if addr == from || accountTxs.Len() == 0 {
+			continue
+		}
+		last := accountTxs.Get(accountTxs.MaxNonce())
+		switch tp.evictPolicy {
+		case TxEvictByPrice:
+			if last.GasPrice().Cmp(tx.GasPrice()) >= 0 || (victim != nil && last.GasPrice().Cmp(victim.GasPrice()) >= 0) {
+				continue
+			}
+		case TxEvictOldest:
+			if victim != nil && !tp.waitingBeats[addr].Before(tp.waitingBeats[owner]) {
+				continue
+			}
+		default:
+			return false
+		}
+		victim, owner = last, addr
+	}
+	if victim == nil {
+		return false
+	}
+
+	tp.waiting[owner].Remove(victim.Nonce())
+	tp.removeFromAll(victim.Hash())
+	tp.setStatus(victim, rtypes.TxStatusDropped, fmt.Sprintf("evicted from the full pool by the %s policy", tp.evictPolicy))
+	if tp.waiting[owner].Len() == 0 {
+		delete(tp.waiting, owner)
+		delete(tp.waitingBeats, owner)
+	}
+	log.Debug("evict waiting tx", zap.String("hash", victim.Hash().Hex()), zap.String("policy", tp.evictPolicy))
+	return true
+}
+
+func (tp *ethTxPool) addToAll(hash common.Hash, rawTx types.Tx) {
+	tp.all[hash] = rawTx
+	tp.bytes += len(rawTx)
+}
+
+func (tp *ethTxPool) removeFromAll(hash common.Hash) {
+	if rawTx, ok := tp.all[hash]; ok {
+		tp.bytes -= len(rawTx)
+		delete(tp.all, hash)
+	}
+}
+
 // Tell tx pool that these txs were committed.
 func (tp *ethTxPool) Update(height int64, txs []types.Tx) {
 	log.Debug("update tx pool txs", zap.Int64("height", height))
@@ -302,6 +515,14 @@ func (tp *ethTxPool) Update(height int64, txs []types.Tx) {
 	tp.refreshBroadcastList(txsMap)
 	tp.refreshAdminOP(txsMap)
 
+	// the committed txs are left in the journal until they're the most of it
+	if tp.journal != nil {
+		if live := tp.journalTxs(txsMap); tp.journal.entries > 2*len(live) {
+			if err := tp.journal.rotate(live); err != nil {
+				log.Warn("rotate tx journal", zap.Error(err))
+			}
+		}
+	}
 	return
 }
 
@@ -334,15 +555,69 @@ func (tp *ethTxPool) TxsFrontWait() *clist.CElement {
 // Remove all transactions from tx and cache
 func (tp *ethTxPool) Flush() {
 	tp.Lock()
+	for _, txs := range []map[common.Address]*txSortedMap{tp.pending, tp.waiting} {
+		for _, accountTxs := range txs {
+			for _, tx := range accountTxs.Flatten() {
+				tp.setStatus(tx, rtypes.TxStatusDropped, "tx pool flushed")
+			}
+		}
+	}
 	tp.waiting = make(map[common.Address]*txSortedMap)
 	tp.pending = make(map[common.Address]*txSortedMap)
 	tp.waitingBeats = make(map[common.Address]time.Time)
 	tp.all = make(map[common.Hash]types.Tx)
+	tp.bytes = 0
 	tp.broadcastQueue = clist.New()
 	tp.extTxs = clist.New()
+	if tp.journal != nil {
+		if err := tp.journal.rotate(nil); err != nil {
+			log.Warn("rotate tx journal", zap.Error(err))
+		}
+	}
 	tp.Unlock()
 }
 
+func (tp *ethTxPool) journalTx(tx types.Tx) {
+	if tp.journal == nil {
+		return
+	}
+	if err := tp.journal.insert(tx); err != nil {
+		log.Warn("journal tx", zap.Error(err))
+	}
+}
+
+// journalTxs are the txs of the pool but the excluded ones, the txs of an account in nonce order
+func (tp *ethTxPool) journalTxs(exclude map[string]struct{}) []types.Tx {
+	txs := make([]types.Tx, 0, len(tp.all)+tp.extTxs.Len())
+	add := func(tx types.Tx) {
+		if _, ok := exclude[string(tx)]; !ok && len(tx) > 0 {
+			txs = append(txs, tx)
+		}
+	}
+	for e := tp.extTxs.Front(); e != nil; e = e.Next() {
+		add(e.Value.(types.Tx))
+	}
+	for addr, pending := range tp.pending {
+		for _, tx := range pending.Flatten() {
+			add(tp.all[tx.Hash()])
+		}
+		if waiting := tp.waiting[addr]; waiting != nil {
+			for _, tx := range waiting.Flatten() {
+				add(tp.all[tx.Hash()])
+			}
+		}
+	}
+	for addr, waiting := range tp.waiting {
+		if tp.pending[addr] != nil {
+			continue
+		}
+		for _, tx := range waiting.Flatten() {
+			add(tp.all[tx.Hash()])
+		}
+	}
+	return txs
+}
+
 // promoteExecutables moves transactions that have become processable from the
 // waiting queue to the set of pending transactions. During this process, all
 // invalidated transactions (low nonce, low balance) are deleted.
@@ -373,15 +648,28 @@ func (tp *ethTxPool) promoteExecutables(addrs []common.Address) {
 		}
 		nonce := tp.safeGetNonce(addr)
 		waiting := tp.waiting[addr]
+		if waiting == nil {
+			continue
+		}
 
 		// Drop all transactions that are deemed too old (low nonce)
 		oldTxs := waiting.Forward(nonce)
 		for _, otx := range oldTxs {
-			delete(tp.all, otx.Hash())
+			tp.removeFromAll(otx.Hash())
+			tp.setStatus(otx, rtypes.TxStatusDropped, "nonce too low")
 		}
 
-		// Gather up to N executable transactions and promote them
-		txs := waiting.ReadyN(nonce, tp.pendingLimit-pendingTxCount)
+		// Gather up to N executable transactions following the pending ones and promote them
+		var txs etypes.Transactions
+		if next := tp.unSafeGetPendingMaxNonce(addr); waiting.Get(next) != nil {
+			count := tp.pendingLimit - pendingTxCount
+			if tp.accountPending > 0 {
+				if left := tp.accountPending - tp.pendingLen(addr); left < count {
+					count = left
+				}
+			}
+			txs = waiting.ReadyN(next, count)
+		}
 
 		// Delete the entire queue entry if it became empty.
 		if waiting.Len() == 0 {
@@ -400,6 +688,7 @@ func (tp *ethTxPool) promoteExecutables(addrs []common.Address) {
 			// pending is not full, add
 			if err := tp.pending[addr].Add(tx); err == nil {
 				pendingTxCount++
+				tp.setStatus(tx, rtypes.TxStatusPending, "")
 			}
 		}
 	}
@@ -412,11 +701,24 @@ func (tp *ethTxPool) addWaiting(tx *etypes.Transaction, address common.Address)
 	for _, txs := range tp.waiting {
 		waitingTxCount += txs.Len()
 	}
-	if waitingTxCount >= tp.waitingLimit {
+	accountFull := tp.accountWaiting > 0 && tp.waiting[address] != nil && tp.waiting[address].Len() >= tp.accountWaiting
+	if waitingTxCount >= tp.waitingLimit && !accountFull && tp.evictWaiting(tx, address) {
+		waitingTxCount--
+	}
+	if waitingTxCount >= tp.waitingLimit || accountFull {
 		// waiting queue is full, try replace or return err
-		if tp.waiting[address] == nil || !tp.waiting[address].TryReplace(tx) {
+		if tp.waiting[address] == nil {
+			return errTxPoolWaitingQueueIsFull
+		}
+		replaced, ok := tp.waiting[address].TryReplace(tx)
+		if !ok {
+			if accountFull {
+				return errAccountWaitingQueueIsFull
+			}
 			return errTxPoolWaitingQueueIsFull
 		}
+		tp.removeFromAll(replaced.Hash())
+		tp.setStatus(replaced, rtypes.TxStatusDropped, "replaced by "+tx.Hash().Hex())
 	} else {
 		if tp.waiting[address] == nil {
 			tp.waiting[address] = newTxSortedMap()
@@ -439,8 +741,9 @@ func (tp *ethTxPool) demoteUnexecutables() {
 
 		// Drop all transactions that are deemed too old (low nonce)
 		for _, tx := range accountTxs.Forward(nonce) {
-			hash := tx.Hash()
-			delete(tp.all, hash)
+			// the committed txs keep their status
+			tp.removeFromAll(tx.Hash())
+			tp.setStatus(tx, rtypes.TxStatusDropped, "nonce too low")
 		}
 
 		if accountTxs.Len() == 0 {
@@ -451,7 +754,10 @@ func (tp *ethTxPool) demoteUnexecutables() {
 				log.Warn("Demoting invalidated transaction", zap.String("hash", tx.Hash().Hex()))
 				if err := tp.addWaiting(tx, addr); err != nil {
 					// demote pending to waiting failed, waiting queue maybe full, delete tx
-					delete(tp.all, tx.Hash())
+					tp.removeFromAll(tx.Hash())
+					tp.setStatus(tx, rtypes.TxStatusDropped, err.Error())
+				} else {
+					tp.setStatus(tx, rtypes.TxStatusWaiting, "")
 				}
 			}
 			// Delete the entire queue entry if it became empty.
@@ -460,6 +766,92 @@ func (tp *ethTxPool) demoteUnexecutables() {
 	}
 }
 
+func (tp *ethTxPool) setStatus(tx *etypes.Transaction, status, reason string) {
+	tp.app.txStatus.set(tx.Hash(), status, reason, 0, 0)
+}
+
+func (tp *ethTxPool) pendingLen(addr common.Address) int {
+	if txs := tp.pending[addr]; txs != nil {
+		return txs.Len()
+	}
+	return 0
+}
+
+// content lists the pooled txs by account, of addr only when it isn't nil, with the reasons the
+// waiting txs aren't pending
+func (tp *ethTxPool) content(addr *common.Address) []*rtypes.TxPoolAccount {
+	tp.Lock()
+	defer tp.Unlock()
+
+	pendingTxCount := 0
+	for _, txs := range tp.pending {
+		pendingTxCount += txs.Len()
+	}
+	accounts := make(map[common.Address]*rtypes.TxPoolAccount)
+	account := func(a common.Address) *rtypes.TxPoolAccount {
+		if accounts[a] == nil {
+			accounts[a] = &rtypes.TxPoolAccount{Address: a, Nonce: tp.safeGetNonce(a)}
+		}
+		return accounts[a]
+	}
+	for a, txs := range tp.pending {
+		if (addr == nil || a == *addr) && txs.Len() > 0 {
+			account(a).Pending = txs.Flatten()
+		}
+	}
+	for a, txs := range tp.waiting {
+		if (addr != nil && a != *addr) || txs.Len() == 0 {
+			continue
+		}
+		acc := account(a)
+		next := tp.unSafeGetPendingMaxNonce(a)
+		for _, tx := range txs.Flatten() {
+			var reason string
+			switch {
+			case tx.Nonce() != next:
+				reason = fmt.Sprintf("nonce gap, nonce %d is missing", next)
+			case tp.accountPending > 0 && tp.pendingLen(a) >= tp.accountPending:
+				reason = fmt.Sprintf("pending txs of the account reach the limit %d", tp.accountPending)
+			case pendingTxCount >= tp.pendingLimit:
+				reason = "pending queue is full"
+			default:
+				reason = "waiting for promotion"
+			}
+			if tx.Nonce() == next {
+				// the following txs wait for the same reason
+				next++
+			}
+			acc.Waiting = append(acc.Waiting, &rtypes.WaitingTx{Tx: tx, Reason: reason})
+		}
+	}
+
+	res := make([]*rtypes.TxPoolAccount, 0, len(accounts))
+	for _, acc := range accounts {
+		res = append(res, acc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return bytes.Compare(res[i].Address[:], res[j].Address[:]) < 0
+	})
+	return res
+}
+
+func (app *EVMApp) queryTxPool(load []byte) types.Result {
+	var addr *common.Address
+	switch len(load) {
+	case 0:
+	case common.AddressLength:
+		a := common.BytesToAddress(load)
+		addr = &a
+	default:
+		return types.NewError(types.CodeType_BaseInvalidInput, "wrong address")
+	}
+	data, err := rlp.EncodeToBytes(app.pool.content(addr))
+	if err != nil {
+		return types.NewError(types.CodeType_WrongRLP, "rlp encode error:"+err.Error())
+	}
+	return types.NewResultOK(data, "")
+}
+
 // add one tx to broadcast list
 func (tp *ethTxPool) broadcastNewTx(tx types.Tx) {
 	if tp.broadcastQueue.Len() >= tp.waitingLimit+tp.pendingLimit {
@@ -0,0 +1,153 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func signPoolTx(t *testing.T, key *ecdsa.PrivateKey, nonce uint64, value, price int64) (*etypes.Transaction, gtypes.Tx) {
+	tx, err := etypes.SignTx(etypes.NewTransaction(nonce, common.HexToAddress("0x01"), big.NewInt(value), 21000, big.NewInt(price), nil), etypes.NewEIP155Signer(big.NewInt(1001)), key)
+	assert.NoError(t, err)
+	raw, err := rlp.EncodeToBytes(tx)
+	assert.NoError(t, err)
+	return tx, raw
+}
+
+func newTestPool(t *testing.T, app *EVMApp, settings map[string]interface{}) *ethTxPool {
+	conf := viper.New()
+	conf.Set("block_size", 100)
+	for k, v := range settings {
+		conf.Set(k, v)
+	}
+	tp, err := NewEthTxPool(app, conf)
+	assert.NoError(t, err)
+	return tp
+}
+
+func TestTxPoolReplacement(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	app, closeApp := newTestApp(t, []*ecdsa.PrivateKey{key}, false)
+	defer closeApp()
+
+	tp := newTestPool(t, app, map[string]interface{}{"evm_txpool_price_bump": 10})
+	_, raw := signPoolTx(t, key, 0, 1, 10)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	_, raw = signPoolTx(t, key, 0, 2, 10)
+	assert.Equal(t, errReplacementTxUnderpriced, tp.ReceiveTx(raw))
+	tx, raw := signPoolTx(t, key, 0, 2, 11)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	content := tp.content(nil)
+	if assert.Len(t, content, 1) && assert.Len(t, content[0].Pending, 1) {
+		assert.Equal(t, tx.Hash(), content[0].Pending[0].Hash())
+	}
+	assert.Len(t, tp.all, 1)
+	assert.Equal(t, len(raw), tp.bytes)
+
+	// a waiting tx is replaced as well
+	_, raw = signPoolTx(t, key, 2, 1, 10)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	tx, raw = signPoolTx(t, key, 2, 1, 20)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	content = tp.content(nil)
+	if assert.Len(t, content[0].Waiting, 1) {
+		assert.Equal(t, tx.Hash(), content[0].Waiting[0].Tx.Hash())
+		assert.Equal(t, "nonce gap, nonce 1 is missing", content[0].Waiting[0].Reason)
+	}
+
+	tp = newTestPool(t, app, map[string]interface{}{"evm_txpool_replace": TxReplaceNone})
+	_, raw = signPoolTx(t, key, 0, 1, 10)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	_, raw = signPoolTx(t, key, 0, 2, 100)
+	assert.Equal(t, errTxNonceExist, tp.ReceiveTx(raw))
+
+	_, err = NewEthTxPool(app, func() *viper.Viper {
+		conf := viper.New()
+		conf.Set("evm_txpool_evict", "random")
+		return conf
+	}())
+	assert.Error(t, err)
+}
+
+func TestTxPoolLimits(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 4; i++ {
+		key, err := crypto.GenerateKey()
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	app, closeApp := newTestApp(t, keys, false)
+	defer closeApp()
+
+	tp := newTestPool(t, app, map[string]interface{}{
+		"evm_txpool_account_pending": 2,
+		"evm_txpool_account_waiting": 2,
+		"evm_txpool_waiting_limit":   3,
+	})
+	for nonce := uint64(0); nonce < 4; nonce++ {
+		_, raw := signPoolTx(t, keys[0], nonce, 1, 5)
+		assert.NoError(t, tp.ReceiveTx(raw))
+	}
+	_, raw := signPoolTx(t, keys[0], 4, 1, 5)
+	assert.Equal(t, errAccountWaitingQueueIsFull, tp.ReceiveTx(raw))
+	content := tp.content(nil)
+	if assert.Len(t, content, 1) {
+		assert.Len(t, content[0].Pending, 2)
+		if assert.Len(t, content[0].Waiting, 2) {
+			assert.Equal(t, "pending txs of the account reach the limit 2", content[0].Waiting[0].Reason)
+			assert.Equal(t, "pending txs of the account reach the limit 2", content[0].Waiting[1].Reason)
+		}
+	}
+
+	// the waiting queue is full, the cheapest waiting tx makes room for one paying more
+	cheap, raw := signPoolTx(t, keys[1], 5, 1, 1)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	_, raw = signPoolTx(t, keys[2], 5, 1, 1)
+	assert.Equal(t, errTxPoolWaitingQueueIsFull, tp.ReceiveTx(raw))
+	_, raw = signPoolTx(t, keys[2], 5, 1, 2)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	addr1 := crypto.PubkeyToAddress(keys[1].PublicKey)
+	assert.Empty(t, tp.content(&addr1))
+	assert.NotContains(t, tp.all, cheap.Hash())
+
+	// the txs paying the most are reaped first
+	best, raw := signPoolTx(t, keys[3], 0, 1, 9)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	reaped := tp.Reap(-1)
+	if assert.Len(t, reaped, 3) {
+		assert.Equal(t, raw, reaped[0])
+	}
+	addr3 := crypto.PubkeyToAddress(keys[3].PublicKey)
+	assert.Equal(t, best.Hash(), tp.content(&addr3)[0].Pending[0].Hash(), "reaping doesn't remove txs")
+}
+
+func TestTxPoolMaxBytes(t *testing.T) {
+	var keys []*ecdsa.PrivateKey
+	for i := 0; i < 3; i++ {
+		key, err := crypto.GenerateKey()
+		assert.NoError(t, err)
+		keys = append(keys, key)
+	}
+	app, closeApp := newTestApp(t, keys, false)
+	defer closeApp()
+
+	_, raw := signPoolTx(t, keys[0], 1, 1, 5)
+	tp := newTestPool(t, app, map[string]interface{}{"evm_txpool_max_bytes": len(raw) + 10})
+	assert.NoError(t, tp.ReceiveTx(raw))
+	_, raw = signPoolTx(t, keys[1], 1, 1, 5)
+	assert.Equal(t, errTxPoolIsFull, tp.ReceiveTx(raw))
+	_, raw = signPoolTx(t, keys[2], 1, 1, 6)
+	assert.NoError(t, tp.ReceiveTx(raw))
+	assert.Len(t, tp.all, 1)
+	assert.Equal(t, len(raw), tp.bytes)
+}
@@ -0,0 +1,176 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evm
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const defaultTxStatusLimit = 100000
+
+// txStatusTracker keeps the latest status of the recent txs, the least recently updated ones
+// are forgotten first
+type txStatusTracker struct {
+	mtx      sync.Mutex
+	limit    int
+	records  map[common.Hash]*list.Element
+	order    *list.List // of *rtypes.TxStatus, the least recently updated first
+	listener func(*rtypes.TxStatus)
+}
+
+func newTxStatusTracker(limit int) *txStatusTracker {
+	if limit <= 0 {
+		limit = defaultTxStatusLimit
+	}
+	return &txStatusTracker{
+		limit:   limit,
+		records: make(map[common.Hash]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// setListener makes listener receive every status update, nil stops it
+func (t *txStatusTracker) setListener(listener func(*rtypes.TxStatus)) {
+	t.mtx.Lock()
+	t.listener = listener
+	t.mtx.Unlock()
+}
+
+// set records the status of a tx, the status of an included or failed tx doesn't change
+func (t *txStatusTracker) set(hash common.Hash, status, reason string, height, index uint64) {
+	t.mtx.Lock()
+	if e, ok := t.records[hash]; ok {
+		if old := e.Value.(*rtypes.TxStatus).Status; old == rtypes.TxStatusIncluded || old == rtypes.TxStatusFailed {
+			t.mtx.Unlock()
+			return
+		}
+		t.order.Remove(e)
+	}
+	record := &rtypes.TxStatus{
+		Hash:   hash,
+		Status: status,
+		Height: height,
+		Index:  index,
+		Reason: reason,
+		Time:   uint64(time.Now().UnixNano()),
+	}
+	t.records[hash] = t.order.PushBack(record)
+	for t.order.Len() > t.limit {
+		delete(t.records, t.order.Remove(t.order.Front()).(*rtypes.TxStatus).Hash)
+	}
+	listener := t.listener
+	t.mtx.Unlock()
+
+	if listener != nil {
+		copied := *record
+		listener(&copied)
+	}
+}
+
+// reject records a tx refused by the pool, a tx known to be in another state keeps it
+func (t *txStatusTracker) reject(hash common.Hash, err error) {
+	if s, ok := t.get(hash); ok && s.Status != rtypes.TxStatusReceived {
+		return
+	}
+	t.set(hash, rtypes.TxStatusDropped, err.Error(), 0, 0)
+}
+
+func (t *txStatusTracker) get(hash common.Hash) (*rtypes.TxStatus, bool) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+	e, ok := t.records[hash]
+	if !ok {
+		return nil, false
+	}
+	copied := *e.Value.(*rtypes.TxStatus)
+	return &copied, true
+}
+
+// SetTxStatusListener makes listener receive the status updates of the txs
+func (app *EVMApp) SetTxStatusListener(listener func(*rtypes.TxStatus)) {
+	app.txStatus.setListener(listener)
+}
+
+// updateTxStatuses records the result of the txs of a committed block, app.txFailures are its failed txs
+func (app *EVMApp) updateTxStatuses(block *gtypes.Block) {
+	for i, raw := range block.Data.Txs {
+		hash := common.BytesToHash(raw.Hash())
+		height, index := uint64(block.Height), uint64(i)
+		if failure, ok := app.txFailures[hash]; ok {
+			status, reason := failureStatus(failure)
+			app.txStatus.set(hash, status, reason, height, index)
+		} else {
+			app.txStatus.set(hash, rtypes.TxStatusIncluded, "", height, index)
+		}
+	}
+}
+
+func failureStatus(failure *rtypes.TxFailure) (string, string) {
+	if failure.Invalid {
+		return rtypes.TxStatusInvalid, failure.Error
+	}
+	if failure.RevertReason != "" {
+		return rtypes.TxStatusFailed, failure.Error + ": " + failure.RevertReason
+	}
+	return rtypes.TxStatusFailed, failure.Error
+}
+
+// queryTxStatus returns the status of the tx of hash load, the txs committed before the status
+// was recorded are looked up by their receipts and failures
+func (app *EVMApp) queryTxStatus(load []byte) gtypes.Result {
+	if len(load) != common.HashLength {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "wrong tx hash")
+	}
+	hash := common.BytesToHash(load)
+	status, ok := app.txStatus.get(hash)
+	if !ok {
+		status = &rtypes.TxStatus{Hash: hash, Status: rtypes.TxStatusUnknown}
+		if data, err := app.stateDb.Get(append(ReceiptsPrefix, load...)); err == nil {
+			receipt := &etypes.ReceiptForStorage{}
+			if err := rlp.DecodeBytes(data, receipt); err == nil {
+				status.Status = rtypes.TxStatusIncluded
+				if receipt.Status != etypes.ReceiptStatusSuccessful {
+					status.Status = rtypes.TxStatusFailed
+					if data, err := app.stateDb.Get(append(FailurePrefix, load...)); err == nil {
+						failure := &rtypes.TxFailure{}
+						if err := rlp.DecodeBytes(data, failure); err == nil {
+							status.Status, status.Reason = failureStatus(failure)
+							status.Height, status.Index = failure.Height, failure.Index
+						}
+					}
+				}
+				if status.Height == 0 {
+					if rt, err := app.core.Query(gtypes.QueryTx, load); err == nil {
+						if rt, ok := rt.(*gtypes.ResultTransaction); ok {
+							status.Height, status.Index = rt.BlockHeight, rt.TransactionIndex
+						}
+					}
+				}
+			}
+		}
+	}
+	data, err := rlp.EncodeToBytes(status)
+	if err != nil {
+		return gtypes.NewError(gtypes.CodeType_WrongRLP, "rlp encode error:"+err.Error())
+	}
+	return gtypes.NewResultOK(data, "")
+}
@@ -0,0 +1,91 @@
+package evm
+
+import (
+	"crypto/ecdsa"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestTxStatus(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	assert.NoError(t, err)
+	app, closeApp := newTestApp(t, []*ecdsa.PrivateKey{key}, false)
+	defer closeApp()
+
+	var (
+		mtx     sync.Mutex
+		updates = make(map[common.Hash][]string)
+	)
+	app.SetTxStatusListener(func(s *rtypes.TxStatus) {
+		mtx.Lock()
+		updates[s.Hash] = append(updates[s.Hash], s.Status)
+		mtx.Unlock()
+	})
+	status := func(hash common.Hash) *rtypes.TxStatus {
+		res := app.Query(append([]byte{rtypes.QueryType_TxStatus}, hash.Bytes()...))
+		assert.Equal(t, "", res.Log)
+		s := &rtypes.TxStatus{}
+		assert.NoError(t, rlp.DecodeBytes(res.Data, s))
+		return s
+	}
+
+	tx0, raw0 := signPoolTx(t, key, 0, 1, 10)
+	assert.NoError(t, app.pool.ReceiveTx(raw0))
+	tx2, raw2 := signPoolTx(t, key, 2, 1, 10)
+	assert.NoError(t, app.pool.ReceiveTx(raw2))
+	cheap, raw := signPoolTx(t, key, 2, 2, 10)
+	assert.Equal(t, errReplacementTxUnderpriced, app.pool.ReceiveTx(raw))
+	assert.Equal(t, rtypes.TxStatusPending, status(tx0.Hash()).Status)
+	assert.Equal(t, rtypes.TxStatusWaiting, status(tx2.Hash()).Status)
+	if s := status(cheap.Hash()); assert.Equal(t, rtypes.TxStatusDropped, s.Status) {
+		assert.Equal(t, errReplacementTxUnderpriced.Error(), s.Reason)
+	}
+	assert.Equal(t, rtypes.TxStatusUnknown, status(common.HexToHash("0x01")).Status)
+
+	// tx1 creates a contract whose init code reverts, tx5 can't be executed
+	tx1, err := etypes.SignTx(etypes.NewContractCreation(1, big.NewInt(0), 100000, big.NewInt(10), common.FromHex("0x60006000fd")), etypes.NewEIP155Signer(big.NewInt(1001)), key)
+	assert.NoError(t, err)
+	raw1, err := rlp.EncodeToBytes(tx1)
+	assert.NoError(t, err)
+	tx5, raw5 := signPoolTx(t, key, 5, 1, 10)
+	block, _ := gtypes.MakeBlock(1, "test", gtypes.Txs{raw0, raw1, raw5}, nil, &gtypes.Commit{}, nil, gtypes.BlockID{}, nil, app.getLastAppHash().Bytes(), nil, 65536)
+	block.Time = time.Unix(1500000000, 0)
+	_, err = app.OnExecute(1, 0, block)
+	assert.NoError(t, err)
+	_, err = app.OnCommit(1, 0, block)
+	assert.NoError(t, err)
+
+	s := status(tx0.Hash())
+	assert.Equal(t, &rtypes.TxStatus{Hash: tx0.Hash(), Status: rtypes.TxStatusIncluded, Height: 1, Index: 0, Time: s.Time}, s)
+	assert.Equal(t, rtypes.TxStatusFailed, status(tx1.Hash()).Status)
+	if s := status(tx5.Hash()); assert.Equal(t, rtypes.TxStatusInvalid, s.Status) {
+		assert.Equal(t, uint64(2), s.Index)
+		assert.NotEmpty(t, s.Reason)
+	}
+	// tx2 is executable now
+	assert.Equal(t, rtypes.TxStatusPending, status(tx2.Hash()).Status)
+
+	mtx.Lock()
+	assert.Equal(t, []string{rtypes.TxStatusReceived, rtypes.TxStatusWaiting, rtypes.TxStatusPending, rtypes.TxStatusIncluded}, updates[tx0.Hash()])
+	assert.Equal(t, []string{rtypes.TxStatusReceived, rtypes.TxStatusWaiting, rtypes.TxStatusPending}, updates[tx2.Hash()])
+	mtx.Unlock()
+
+	// the statuses of committed txs are read from their receipts once forgotten
+	app.txStatus = newTxStatusTracker(0)
+	assert.Equal(t, rtypes.TxStatusFailed, status(tx1.Hash()).Status)
+	if s := status(tx5.Hash()); assert.Equal(t, rtypes.TxStatusInvalid, s.Status) {
+		assert.Equal(t, uint64(2), s.Index)
+	}
+	assert.Equal(t, rtypes.TxStatusUnknown, status(tx2.Hash()).Status)
+}
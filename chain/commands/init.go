@@ -19,9 +19,12 @@ import (
 	"github.com/mitchellh/go-homedir"
 	"github.com/spf13/cobra"
 
+	"github.com/dappledger/AnnChain/chain/app"
 	"github.com/dappledger/AnnChain/chain/commands/global"
 	"github.com/dappledger/AnnChain/gemmill"
 	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	"github.com/dappledger/AnnChain/gemmill/go-utils"
+	"github.com/dappledger/AnnChain/gemmill/types"
 )
 
 var (
@@ -73,5 +76,26 @@ func newInitCommandFunc(cmd *cobra.Command, args []string) {
 	log.Println("Log dir is: ", global.GFlags().LogDir)
 	defConf.Set("log_dir", global.GFlags().LogDir)
 
+	if err := checkGenesis(defConf.GetString("genesis_json_file"), appName); err != nil {
+		log.Fatal(err)
+	}
+
 	gemmill.Initialize(&gemmill.Tunes{Runtime: global.GFlags().RuntimeDir, Conf: defConf}, chainId)
 }
+
+// checkGenesis refuses a genesis whose app_state the app can't start from
+func checkGenesis(genesisJSON, appName string) error {
+	check, ok := app.GenesisCheckers[appName]
+	if len(genesisJSON) == 0 || !ok {
+		return nil
+	}
+	data, err := utils.ReadFileDataFromCmd(genesisJSON)
+	if err != nil {
+		return err
+	}
+	genDoc, err := types.GenesisDocFromJSONRet(data)
+	if err != nil {
+		return err
+	}
+	return check(genDoc)
+}
@@ -0,0 +1,1029 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/dappledger/AnnChain/chain/app/evm"
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/params"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// EthApplication is implemented by applications that can serve the ethereum compatible rpc
+type EthApplication interface {
+	ChainConfig() *params.ChainConfig
+	GetAddressFromTx(tx *etypes.Transaction) (common.Address, error)
+}
+
+// ethBlockNumber is a block height or one of the "latest", "pending", "earliest" tags
+type ethBlockNumber int64
+
+const (
+	ethPendingBlockNumber  ethBlockNumber = -2
+	ethLatestBlockNumber   ethBlockNumber = -1
+	ethEarliestBlockNumber ethBlockNumber = 0
+)
+
+func (bn *ethBlockNumber) UnmarshalJSON(data []byte) error {
+	input := strings.TrimSpace(string(data))
+	if len(input) >= 2 && input[0] == '"' && input[len(input)-1] == '"' {
+		input = input[1 : len(input)-1]
+	}
+	switch input {
+	case "earliest":
+		*bn = ethEarliestBlockNumber
+		return nil
+	case "latest":
+		*bn = ethLatestBlockNumber
+		return nil
+	case "pending":
+		*bn = ethPendingBlockNumber
+		return nil
+	}
+	n, err := hexutil.DecodeUint64(input)
+	if err != nil {
+		return err
+	}
+	if n > uint64(1<<63-1) {
+		return fmt.Errorf("block number larger than int64")
+	}
+	*bn = ethBlockNumber(n)
+	return nil
+}
+
+// ethCallArgs are the arguments of eth_call and eth_estimateGas
+type ethCallArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      hexutil.Uint64  `json:"gas"`
+	GasPrice *hexutil.Big    `json:"gasPrice"`
+	Value    *hexutil.Big    `json:"value"`
+	Data     hexutil.Bytes   `json:"data"`
+	Input    hexutil.Bytes   `json:"input"`
+}
+
+// ethSimulateArgs are the arguments of eth_simulateTransaction, Raw is a signed tx run instead of the call
+type ethSimulateArgs struct {
+	ethCallArgs
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+type ethValueDiff struct {
+	From *hexutil.Big `json:"from"`
+	To   *hexutil.Big `json:"to"`
+}
+
+type ethNonceDiff struct {
+	From hexutil.Uint64 `json:"from"`
+	To   hexutil.Uint64 `json:"to"`
+}
+
+type ethStorageDiff struct {
+	From common.Hash `json:"from"`
+	To   common.Hash `json:"to"`
+}
+
+// ethAccountDiff is the change of an account, the unchanged values are omitted
+type ethAccountDiff struct {
+	Address common.Address                 `json:"address"`
+	Deleted bool                           `json:"deleted,omitempty"`
+	Balance *ethValueDiff                  `json:"balance,omitempty"`
+	Nonce   *ethNonceDiff                  `json:"nonce,omitempty"`
+	Code    hexutil.Bytes                  `json:"code,omitempty"`
+	Storage map[common.Hash]ethStorageDiff `json:"storage,omitempty"`
+}
+
+// ethSimulation is the result of eth_simulateTransaction
+type ethSimulation struct {
+	GasUsed      hexutil.Uint64    `json:"gasUsed"`
+	Failed       bool              `json:"failed"`
+	ReturnData   hexutil.Bytes     `json:"returnData"`
+	RevertReason string            `json:"revertReason,omitempty"`
+	Logs         []*etypes.Log     `json:"logs"`
+	StateDiff    []*ethAccountDiff `json:"stateDiff"`
+}
+
+// ethTraceConfig are the options of debug_traceTransaction and debug_traceCall,
+// Tracer is "callTracer" for the call tree and empty for the struct logs
+type ethTraceConfig struct {
+	Tracer         string `json:"tracer"`
+	DisableStack   bool   `json:"disableStack"`
+	DisableMemory  bool   `json:"disableMemory"`
+	DisableStorage bool   `json:"disableStorage"`
+}
+
+type ethStructLog struct {
+	Pc      uint64                      `json:"pc"`
+	Op      string                      `json:"op"`
+	Gas     uint64                      `json:"gas"`
+	GasCost uint64                      `json:"gasCost"`
+	Depth   uint64                      `json:"depth"`
+	Error   string                      `json:"error,omitempty"`
+	Stack   []*hexutil.Big              `json:"stack,omitempty"`
+	Memory  hexutil.Bytes               `json:"memory,omitempty"`
+	Storage map[common.Hash]common.Hash `json:"storage,omitempty"`
+}
+
+// ethStructLogTrace is the result of the struct logger
+type ethStructLogTrace struct {
+	Gas         hexutil.Uint64  `json:"gas"`
+	Failed      bool            `json:"failed"`
+	ReturnValue hexutil.Bytes   `json:"returnValue"`
+	StructLogs  []*ethStructLog `json:"structLogs"`
+}
+
+// ethCallFrame is a call of the result of the call tracer
+type ethCallFrame struct {
+	Type    string          `json:"type"`
+	From    common.Address  `json:"from"`
+	To      common.Address  `json:"to"`
+	Value   *hexutil.Big    `json:"value,omitempty"`
+	Gas     hexutil.Uint64  `json:"gas"`
+	GasUsed hexutil.Uint64  `json:"gasUsed"`
+	Input   hexutil.Bytes   `json:"input"`
+	Output  hexutil.Bytes   `json:"output,omitempty"`
+	Error   string          `json:"error,omitempty"`
+	Calls   []*ethCallFrame `json:"calls,omitempty"`
+}
+
+// ethRPCTransaction is a transaction as returned by the ethereum rpc
+type ethRPCTransaction struct {
+	BlockHash        *common.Hash    `json:"blockHash"`
+	BlockNumber      *hexutil.Big    `json:"blockNumber"`
+	From             common.Address  `json:"from"`
+	Gas              hexutil.Uint64  `json:"gas"`
+	GasPrice         *hexutil.Big    `json:"gasPrice"`
+	Hash             common.Hash     `json:"hash"`
+	Input            hexutil.Bytes   `json:"input"`
+	Nonce            hexutil.Uint64  `json:"nonce"`
+	To               *common.Address `json:"to"`
+	TransactionIndex *hexutil.Uint64 `json:"transactionIndex"`
+	Value            *hexutil.Big    `json:"value"`
+	V                *hexutil.Big    `json:"v"`
+	R                *hexutil.Big    `json:"r"`
+	S                *hexutil.Big    `json:"s"`
+}
+
+// ethPoolTx is a pooled tx, Reason tells why a queued tx isn't pending
+type ethPoolTx struct {
+	*ethRPCTransaction
+	Reason string `json:"reason,omitempty"`
+}
+
+// ethTxPoolContent is the result of txpool_contentFrom, txs are keyed by nonce
+type ethTxPoolContent struct {
+	Nonce   hexutil.Uint64        `json:"nonce"`
+	Pending map[string]*ethPoolTx `json:"pending"`
+	Queued  map[string]*ethPoolTx `json:"queued"`
+}
+
+// ethTxStatus is the result of eth_getTransactionStatus, the block is set for the txs of a committed block
+type ethTxStatus struct {
+	Hash             common.Hash     `json:"hash"`
+	Status           string          `json:"status"`
+	BlockNumber      *hexutil.Uint64 `json:"blockNumber"`
+	TransactionIndex *hexutil.Uint64 `json:"transactionIndex"`
+	Reason           string          `json:"reason,omitempty"`
+	Time             hexutil.Uint64  `json:"time"`
+}
+
+// ethAPI maps the eth_*, net_* and web3_* namespaces onto the node and the application queries
+type ethAPI struct {
+	node    *Node
+	app     EthApplication
+	filters *ethFilters
+}
+
+func newEthAPI(n *Node, app EthApplication) *ethAPI {
+	return &ethAPI{node: n, app: app, filters: newEthFilters()}
+}
+
+func (n *Node) ethRoutes() map[string]*ethRPCFunc {
+	app, ok := n.Application.(EthApplication)
+	if !ok {
+		return nil
+	}
+	api := newEthAPI(n, app)
+	return map[string]*ethRPCFunc{
+		"web3_clientVersion": newEthRPCFunc(api.ClientVersion),
+		"web3_sha3":          newEthRPCFunc(api.Sha3),
+
+		"net_version":   newEthRPCFunc(api.NetVersion),
+		"net_listening": newEthRPCFunc(api.NetListening),
+		"net_peerCount": newEthRPCFunc(api.NetPeerCount),
+
+		"eth_protocolVersion": newEthRPCFunc(api.ProtocolVersion),
+		"eth_chainId":         newEthRPCFunc(api.ChainId),
+		"eth_syncing":         newEthRPCFunc(api.Syncing),
+		"eth_mining":          newEthRPCFunc(api.Mining),
+		"eth_gasPrice":        newEthRPCFunc(api.GasPrice),
+		"eth_accounts":        newEthRPCFunc(api.Accounts),
+		"eth_blockNumber":     newEthRPCFunc(api.BlockNumber),
+
+		"eth_getBalance":          newEthRPCFunc(api.GetBalance),
+		"eth_getCode":             newEthRPCFunc(api.GetCode),
+		"eth_getStorageAt":        newEthRPCFunc(api.GetStorageAt),
+		"eth_getTransactionCount": newEthRPCFunc(api.GetTransactionCount),
+		"eth_call":                newEthRPCFunc(api.Call),
+		"eth_estimateGas":         newEthRPCFunc(api.EstimateGas),
+		"eth_simulateTransaction": newEthRPCFunc(api.SimulateTransaction),
+
+		"eth_sendRawTransaction":    newEthRPCFunc(api.SendRawTransaction),
+		"eth_getTransactionByHash":  newEthRPCFunc(api.GetTransactionByHash),
+		"eth_getTransactionReceipt": newEthRPCFunc(api.GetTransactionReceipt),
+		"eth_getTransactionStatus":  newEthRPCFunc(api.GetTransactionStatus),
+		"eth_getBlockByNumber":      newEthRPCFunc(api.GetBlockByNumber),
+		"eth_getBlockByHash":        newEthRPCFunc(api.GetBlockByHash),
+
+		"eth_getLogs":          newEthRPCFunc(api.GetLogs),
+		"eth_newFilter":        newEthRPCFunc(api.NewFilter),
+		"eth_newBlockFilter":   newEthRPCFunc(api.NewBlockFilter),
+		"eth_getFilterChanges": newEthRPCFunc(api.GetFilterChanges),
+		"eth_getFilterLogs":    newEthRPCFunc(api.GetFilterLogs),
+		"eth_uninstallFilter":  newEthRPCFunc(api.UninstallFilter),
+
+		"debug_traceTransaction": newEthRPCFunc(api.TraceTransaction),
+		"debug_traceCall":        newEthRPCFunc(api.TraceCall),
+
+		"txpool_status":      newEthRPCFunc(api.TxPoolStatus),
+		"txpool_content":     newEthRPCFunc(api.TxPoolContent),
+		"txpool_contentFrom": newEthRPCFunc(api.TxPoolContentFrom),
+	}
+}
+
+func (api *ethAPI) query(queryType types.QueryType, load []byte) ([]byte, error) {
+	res := api.node.Application.Query(append([]byte{queryType}, load...))
+	if res.Code != gtypes.CodeType_OK {
+		return res.Data, errors.New(res.Log)
+	}
+	return res.Data, nil
+}
+
+// stateHeight converts a block number into the height suffix of state queries, 0 being the latest state
+func (api *ethAPI) stateHeight(bn *ethBlockNumber) ([]byte, error) {
+	if bn == nil || *bn < 0 {
+		return nil, nil
+	}
+	if *bn == ethEarliestBlockNumber {
+		return nil, errors.New("state of genesis is not available")
+	}
+	height := int64(*bn)
+	if height > api.node.Angine.Height() {
+		return nil, fmt.Errorf("block %d not found", height)
+	}
+	return putHeight(uint64(height)), nil
+}
+
+func putHeight(height uint64) []byte {
+	b := make([]byte, types.QueryHeightLen)
+	binary.BigEndian.PutUint64(b, height)
+	return b
+}
+
+func (api *ethAPI) ClientVersion() (string, error) {
+	return "AnnChain/" + types.GetVersion(), nil
+}
+
+func (api *ethAPI) Sha3(data hexutil.Bytes) (hexutil.Bytes, error) {
+	return crypto.Keccak256(data), nil
+}
+
+func (api *ethAPI) NetVersion() (string, error) {
+	return api.app.ChainConfig().ChainID.String(), nil
+}
+
+func (api *ethAPI) NetListening() (bool, error) {
+	return true, nil
+}
+
+func (api *ethAPI) NetPeerCount() (hexutil.Uint, error) {
+	return hexutil.Uint(api.node.Angine.GetNumPeers()), nil
+}
+
+func (api *ethAPI) ProtocolVersion() (hexutil.Uint, error) {
+	return hexutil.Uint(63), nil
+}
+
+func (api *ethAPI) ChainId() (*hexutil.Big, error) {
+	return (*hexutil.Big)(api.app.ChainConfig().ChainID), nil
+}
+
+func (api *ethAPI) Syncing() (bool, error) {
+	return false, nil
+}
+
+func (api *ethAPI) Mining() (bool, error) {
+	return false, nil
+}
+
+func (api *ethAPI) GasPrice() (*hexutil.Big, error) {
+	return (*hexutil.Big)(big.NewInt(0)), nil
+}
+
+func (api *ethAPI) Accounts() ([]common.Address, error) {
+	return []common.Address{}, nil
+}
+
+func (api *ethAPI) BlockNumber() (hexutil.Uint64, error) {
+	return hexutil.Uint64(api.node.Angine.Height()), nil
+}
+
+func (api *ethAPI) GetBalance(addr common.Address, bn *ethBlockNumber) (*hexutil.Big, error) {
+	suffix, err := api.stateHeight(bn)
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.query(types.QueryType_Balance, append(addr.Bytes(), suffix...))
+	if err != nil {
+		return nil, err
+	}
+	balance := new(big.Int)
+	if err := rlp.DecodeBytes(data, balance); err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(balance), nil
+}
+
+func (api *ethAPI) GetCode(addr common.Address, bn *ethBlockNumber) (hexutil.Bytes, error) {
+	suffix, err := api.stateHeight(bn)
+	if err != nil {
+		return nil, err
+	}
+	code, err := api.query(types.QueryType_Code, append(addr.Bytes(), suffix...))
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(code), nil
+}
+
+// GetStorageAt takes the key as a string like geth, hexutil.Big rejecting the zero padded keys of clients
+func (api *ethAPI) GetStorageAt(addr common.Address, key string, bn *ethBlockNumber) (hexutil.Bytes, error) {
+	suffix, err := api.stateHeight(bn)
+	if err != nil {
+		return nil, err
+	}
+	load := append(addr.Bytes(), common.HexToHash(key).Bytes()...)
+	value, err := api.query(types.QueryType_Storage, append(load, suffix...))
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(value), nil
+}
+
+type ethStorageProof struct {
+	Key   string          `json:"key"`
+	Value *hexutil.Big    `json:"value"`
+	Proof []hexutil.Bytes `json:"proof"`
+}
+
+type ethAccountProof struct {
+	Address      common.Address    `json:"address"`
+	AccountProof []hexutil.Bytes   `json:"accountProof"`
+	Balance      *hexutil.Big      `json:"balance"`
+	CodeHash     common.Hash       `json:"codeHash"`
+	Nonce        hexutil.Uint64    `json:"nonce"`
+	StorageHash  common.Hash       `json:"storageHash"`
+	StorageProof []ethStorageProof `json:"storageProof"`
+}
+
+func toHexBytes(list [][]byte) []hexutil.Bytes {
+	res := make([]hexutil.Bytes, len(list))
+	for i, b := range list {
+		res[i] = b
+	}
+	return res
+}
+
+// GetProof returns the merkle proofs of an account and some of its storage slots,
+// they can be checked with the verifier package against the AppHash of the next block
+func (api *ethAPI) GetProof(addr common.Address, keys []string, bn *ethBlockNumber) (*ethAccountProof, error) {
+	req := &types.ProofRequest{Address: addr, Keys: make([]common.Hash, len(keys))}
+	for i := range keys {
+		req.Keys[i] = common.HexToHash(keys[i])
+	}
+	suffix, err := api.stateHeight(bn)
+	if err != nil {
+		return nil, err
+	}
+	if len(suffix) > 0 {
+		req.Height = binary.BigEndian.Uint64(suffix)
+	}
+	load, err := rlp.EncodeToBytes(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.query(types.QueryType_Proof, load)
+	if err != nil {
+		return nil, err
+	}
+	proof := new(types.AccountProof)
+	if err := rlp.DecodeBytes(data, proof); err != nil {
+		return nil, err
+	}
+
+	res := &ethAccountProof{
+		Address:      proof.Address,
+		AccountProof: toHexBytes(proof.AccountProof),
+		Balance:      (*hexutil.Big)(proof.Balance),
+		CodeHash:     proof.CodeHash,
+		Nonce:        hexutil.Uint64(proof.Nonce),
+		StorageHash:  proof.StorageHash,
+		StorageProof: make([]ethStorageProof, len(proof.StorageProofs)),
+	}
+	for i, sp := range proof.StorageProofs {
+		res.StorageProof[i] = ethStorageProof{
+			Key:   keys[i],
+			Value: (*hexutil.Big)(sp.Value.Big()),
+			Proof: toHexBytes(sp.Proof),
+		}
+	}
+	return res, nil
+}
+
+func (api *ethAPI) GetTransactionCount(addr common.Address, bn *ethBlockNumber) (hexutil.Uint64, error) {
+	var (
+		data []byte
+		err  error
+	)
+	if bn != nil && *bn == ethPendingBlockNumber {
+		data, err = api.query(types.QueryType_Pending_Nonce, addr.Bytes())
+	} else {
+		suffix, serr := api.stateHeight(bn)
+		if serr != nil {
+			return 0, serr
+		}
+		data, err = api.query(types.QueryType_Nonce, append(addr.Bytes(), suffix...))
+	}
+	if err != nil {
+		return 0, err
+	}
+	var nonce uint64
+	if err := rlp.DecodeBytes(data, &nonce); err != nil {
+		return 0, err
+	}
+	return hexutil.Uint64(nonce), nil
+}
+
+func (api *ethAPI) callMsg(args *ethCallArgs, bn *ethBlockNumber) ([]byte, error) {
+	msg, err := api.toCallMsg(args, bn)
+	if err != nil {
+		return nil, err
+	}
+	return rlp.EncodeToBytes(msg)
+}
+
+func (api *ethAPI) toCallMsg(args *ethCallArgs, bn *ethBlockNumber) (*types.CallMsg, error) {
+	msg := &types.CallMsg{
+		From:     args.From,
+		Gas:      uint64(args.Gas),
+		GasPrice: (*big.Int)(args.GasPrice),
+		Value:    (*big.Int)(args.Value),
+		Data:     args.Data,
+	}
+	if len(args.Input) > 0 {
+		msg.Data = args.Input
+	}
+	if args.To != nil {
+		msg.To = args.To.Bytes()
+	}
+	if bn != nil && *bn > 0 {
+		if int64(*bn) > api.node.Angine.Height() {
+			return nil, fmt.Errorf("block %d not found", *bn)
+		}
+		msg.Height = uint64(*bn)
+	}
+	return msg, nil
+}
+
+func (api *ethAPI) Call(args ethCallArgs, bn *ethBlockNumber) (hexutil.Bytes, error) {
+	load, err := api.callMsg(&args, bn)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := api.query(types.QueryType_Call, load)
+	if err != nil {
+		return nil, err
+	}
+	return hexutil.Bytes(ret), nil
+}
+
+func (api *ethAPI) EstimateGas(args ethCallArgs, bn *ethBlockNumber) (hexutil.Uint64, error) {
+	load, err := api.callMsg(&args, bn)
+	if err != nil {
+		return 0, err
+	}
+	data, err := api.query(types.QueryType_EstimateGas, load)
+	if err != nil {
+		return 0, err
+	}
+	var gas uint64
+	if err := rlp.DecodeBytes(data, &gas); err != nil {
+		return 0, err
+	}
+	return hexutil.Uint64(gas), nil
+}
+
+// SimulateTransaction runs a call or a signed tx on the state of a block without persisting anything,
+// and returns its gas used, return data, revert reason, logs and the accounts it changes
+func (api *ethAPI) SimulateTransaction(args ethSimulateArgs, bn *ethBlockNumber) (*ethSimulation, error) {
+	msg, err := api.toCallMsg(&args.ethCallArgs, bn)
+	if err != nil {
+		return nil, err
+	}
+	load, err := rlp.EncodeToBytes(&types.SimulateRequest{Call: *msg, Tx: args.Raw})
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.query(types.QueryType_Simulate, load)
+	if err != nil {
+		return nil, err
+	}
+	res := &types.SimulateResult{}
+	if err := rlp.DecodeBytes(data, res); err != nil {
+		return nil, err
+	}
+
+	sim := &ethSimulation{
+		GasUsed:      hexutil.Uint64(res.GasUsed),
+		Failed:       res.Failed,
+		ReturnData:   res.ReturnData,
+		RevertReason: res.RevertReason,
+		Logs:         res.Logs,
+		StateDiff:    make([]*ethAccountDiff, len(res.StateDiff)),
+	}
+	if sim.Logs == nil {
+		sim.Logs = []*etypes.Log{}
+	}
+	for i, d := range res.StateDiff {
+		diff := &ethAccountDiff{Address: d.Address, Deleted: d.Deleted, Code: d.Code}
+		if d.BalanceBefore.Cmp(d.BalanceAfter) != 0 {
+			diff.Balance = &ethValueDiff{From: (*hexutil.Big)(d.BalanceBefore), To: (*hexutil.Big)(d.BalanceAfter)}
+		}
+		if d.NonceBefore != d.NonceAfter {
+			diff.Nonce = &ethNonceDiff{From: hexutil.Uint64(d.NonceBefore), To: hexutil.Uint64(d.NonceAfter)}
+		}
+		if len(d.Storage) > 0 {
+			diff.Storage = make(map[common.Hash]ethStorageDiff, len(d.Storage))
+			for _, sd := range d.Storage {
+				diff.Storage[sd.Key] = ethStorageDiff{From: sd.Before, To: sd.After}
+			}
+		}
+		sim.StateDiff[i] = diff
+	}
+	return sim, nil
+}
+
+// TraceTransaction re-executes a committed tx on the state it was executed on
+func (api *ethAPI) TraceTransaction(hash common.Hash, config *ethTraceConfig) (interface{}, error) {
+	rt, _, err := api.getTransaction(hash)
+	if err != nil {
+		return nil, err
+	}
+	return api.trace(&types.TraceRequest{Height: rt.BlockHeight, Index: rt.TransactionIndex}, config)
+}
+
+// TraceCall runs a call on the state of a block with a tracer
+func (api *ethAPI) TraceCall(args ethCallArgs, bn *ethBlockNumber, config *ethTraceConfig) (interface{}, error) {
+	msg, err := api.toCallMsg(&args, bn)
+	if err != nil {
+		return nil, err
+	}
+	return api.trace(&types.TraceRequest{Call: *msg}, config)
+}
+
+func (api *ethAPI) trace(req *types.TraceRequest, config *ethTraceConfig) (interface{}, error) {
+	if config != nil {
+		req.Tracer = config.Tracer
+		req.DisableStack = config.DisableStack
+		req.DisableMemory = config.DisableMemory
+		req.DisableStorage = config.DisableStorage
+	}
+	load, err := rlp.EncodeToBytes(req)
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.query(types.QueryType_Trace, load)
+	if err != nil {
+		return nil, err
+	}
+	res := &types.TraceResult{}
+	if err := rlp.DecodeBytes(data, res); err != nil {
+		return nil, err
+	}
+	if req.Tracer == types.TracerCall {
+		return marshalCallFrame(res.CallTree), nil
+	}
+
+	trace := &ethStructLogTrace{
+		Gas:         hexutil.Uint64(res.Gas),
+		Failed:      res.Failed,
+		ReturnValue: res.ReturnValue,
+		StructLogs:  make([]*ethStructLog, len(res.StructLogs)),
+	}
+	for i, l := range res.StructLogs {
+		entry := &ethStructLog{
+			Pc:      l.Pc,
+			Op:      l.Op,
+			Gas:     l.Gas,
+			GasCost: l.GasCost,
+			Depth:   l.Depth,
+			Error:   l.Error,
+			Memory:  l.Memory,
+		}
+		for _, item := range l.Stack {
+			entry.Stack = append(entry.Stack, (*hexutil.Big)(item))
+		}
+		if len(l.Storage) > 0 {
+			entry.Storage = make(map[common.Hash]common.Hash, len(l.Storage))
+			for _, slot := range l.Storage {
+				entry.Storage[slot.Key] = slot.Value
+			}
+		}
+		trace.StructLogs[i] = entry
+	}
+	return trace, nil
+}
+
+func marshalCallFrame(frame *vm.CallFrame) *ethCallFrame {
+	if frame == nil {
+		return nil
+	}
+	f := &ethCallFrame{
+		Type:    frame.Type,
+		From:    frame.From,
+		To:      frame.To,
+		Gas:     hexutil.Uint64(frame.Gas),
+		GasUsed: hexutil.Uint64(frame.GasUsed),
+		Input:   frame.Input,
+		Output:  frame.Output,
+		Error:   frame.Error,
+	}
+	// delegate and static calls carry no value
+	if frame.Type != vm.DELEGATECALL.String() && frame.Type != vm.OpCode(vm.STATICCALL).String() {
+		f.Value = (*hexutil.Big)(frame.Value)
+	}
+	for _, call := range frame.Calls {
+		f.Calls = append(f.Calls, marshalCallFrame(call))
+	}
+	return f
+}
+
+func (api *ethAPI) txPoolContent(addr *common.Address) (map[common.Address]*ethTxPoolContent, error) {
+	var load []byte
+	if addr != nil {
+		load = addr.Bytes()
+	}
+	data, err := api.query(types.QueryType_TxPool, load)
+	if err != nil {
+		return nil, err
+	}
+	var accounts []*types.TxPoolAccount
+	if err := rlp.DecodeBytes(data, &accounts); err != nil {
+		return nil, err
+	}
+	content := make(map[common.Address]*ethTxPoolContent, len(accounts))
+	for _, acc := range accounts {
+		c := &ethTxPoolContent{
+			Nonce:   hexutil.Uint64(acc.Nonce),
+			Pending: make(map[string]*ethPoolTx, len(acc.Pending)),
+			Queued:  make(map[string]*ethPoolTx, len(acc.Waiting)),
+		}
+		for _, tx := range acc.Pending {
+			c.Pending[fmt.Sprint(tx.Nonce())] = &ethPoolTx{ethRPCTransaction: api.marshalTx(tx, common.Hash{}, 0, 0)}
+		}
+		for _, w := range acc.Waiting {
+			c.Queued[fmt.Sprint(w.Tx.Nonce())] = &ethPoolTx{ethRPCTransaction: api.marshalTx(w.Tx, common.Hash{}, 0, 0), Reason: w.Reason}
+		}
+		content[acc.Address] = c
+	}
+	return content, nil
+}
+
+// TxPoolStatus returns the number of pending and queued txs of the pool
+func (api *ethAPI) TxPoolStatus() (map[string]hexutil.Uint, error) {
+	content, err := api.txPoolContent(nil)
+	if err != nil {
+		return nil, err
+	}
+	var pending, queued int
+	for _, c := range content {
+		pending += len(c.Pending)
+		queued += len(c.Queued)
+	}
+	return map[string]hexutil.Uint{"pending": hexutil.Uint(pending), "queued": hexutil.Uint(queued)}, nil
+}
+
+// TxPoolContent returns the pending and queued txs of the pool by account
+func (api *ethAPI) TxPoolContent() (map[string]map[common.Address]map[string]*ethPoolTx, error) {
+	content, err := api.txPoolContent(nil)
+	if err != nil {
+		return nil, err
+	}
+	res := map[string]map[common.Address]map[string]*ethPoolTx{
+		"pending": make(map[common.Address]map[string]*ethPoolTx),
+		"queued":  make(map[common.Address]map[string]*ethPoolTx),
+	}
+	for addr, c := range content {
+		if len(c.Pending) > 0 {
+			res["pending"][addr] = c.Pending
+		}
+		if len(c.Queued) > 0 {
+			res["queued"][addr] = c.Queued
+		}
+	}
+	return res, nil
+}
+
+// TxPoolContentFrom returns the pending and queued txs of an account
+func (api *ethAPI) TxPoolContentFrom(addr common.Address) (*ethTxPoolContent, error) {
+	content, err := api.txPoolContent(&addr)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := content[addr]; ok {
+		return c, nil
+	}
+	nonce, err := api.GetTransactionCount(addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &ethTxPoolContent{Nonce: nonce, Pending: map[string]*ethPoolTx{}, Queued: map[string]*ethPoolTx{}}, nil
+}
+
+func (api *ethAPI) SendRawTransaction(data hexutil.Bytes) (common.Hash, error) {
+	// the tx pool checks the tx on receiving it
+	if err := api.node.Angine.BroadcastTx(data); err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(gtypes.Tx(data).Hash()), nil
+}
+
+// getTransaction looks up a committed transaction by hash, it relies on the querycache plugin
+func (api *ethAPI) getTransaction(hash common.Hash) (*gtypes.ResultTransaction, *etypes.Transaction, error) {
+	data, err := api.query(types.QueryType_TxRaw, append([]byte{gtypes.QueryTx}, hash.Bytes()...))
+	if err != nil {
+		return nil, nil, err
+	}
+	rt := &gtypes.ResultTransaction{}
+	if err := rlp.DecodeBytes(data, rt); err != nil {
+		return nil, nil, err
+	}
+	tx := &etypes.Transaction{}
+	if err := rlp.DecodeBytes(rt.RawTransaction, tx); err != nil {
+		return nil, nil, err
+	}
+	return rt, tx, nil
+}
+
+func (api *ethAPI) getReceipt(hash common.Hash) (*etypes.Receipt, error) {
+	data, err := api.query(types.QueryType_Receipt, hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	receipt := &etypes.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(data, receipt); err != nil {
+		return nil, err
+	}
+	return (*etypes.Receipt)(receipt), nil
+}
+
+func (api *ethAPI) marshalTx(tx *etypes.Transaction, blockHash common.Hash, height uint64, index uint64) *ethRPCTransaction {
+	from, _ := api.app.GetAddressFromTx(tx)
+	v, r, s := tx.RawSignatureValues()
+	result := &ethRPCTransaction{
+		From:     from,
+		Gas:      hexutil.Uint64(tx.Gas()),
+		GasPrice: (*hexutil.Big)(tx.GasPrice()),
+		Hash:     tx.Hash(),
+		Input:    hexutil.Bytes(tx.Data()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		To:       tx.To(),
+		Value:    (*hexutil.Big)(tx.Value()),
+		V:        (*hexutil.Big)(v),
+		R:        (*hexutil.Big)(r),
+		S:        (*hexutil.Big)(s),
+	}
+	if blockHash != (common.Hash{}) {
+		result.BlockHash = &blockHash
+		result.BlockNumber = (*hexutil.Big)(new(big.Int).SetUint64(height))
+		result.TransactionIndex = (*hexutil.Uint64)(&index)
+	}
+	return result
+}
+
+func (api *ethAPI) GetTransactionByHash(hash common.Hash) (*ethRPCTransaction, error) {
+	rt, tx, err := api.getTransaction(hash)
+	if err != nil {
+		// unknown transactions are reported as null, like ethereum does
+		return nil, nil
+	}
+	return api.marshalTx(tx, common.BytesToHash(rt.BlockHash), rt.BlockHeight, rt.TransactionIndex), nil
+}
+
+// getReceiptResult returns the receipt of a tx along with why it failed
+func (api *ethAPI) getReceiptResult(hash common.Hash) (*etypes.Receipt, *types.TxFailure, error) {
+	data, err := api.query(types.QueryType_Receipt, append(hash.Bytes(), types.QueryReceiptFailure))
+	if err != nil {
+		return nil, nil, err
+	}
+	res := &types.ReceiptResult{}
+	if err := rlp.DecodeBytes(data, res); err != nil {
+		return nil, nil, err
+	}
+	receipt := &etypes.ReceiptForStorage{}
+	if err := rlp.DecodeBytes(res.Receipt, receipt); err != nil {
+		return nil, nil, err
+	}
+	return (*etypes.Receipt)(receipt), res.Failure, nil
+}
+
+func (api *ethAPI) GetTransactionReceipt(hash common.Hash) (map[string]interface{}, error) {
+	receipt, failure, err := api.getReceiptResult(hash)
+	if err != nil {
+		// not executed yet
+		return nil, nil
+	}
+	rt, tx, err := api.getTransaction(hash)
+	if err != nil {
+		return nil, fmt.Errorf("fail to locate transaction %s, is querycache plugin enabled: %v", hash.Hex(), err)
+	}
+	from, _ := api.app.GetAddressFromTx(tx)
+	blockHash := common.BytesToHash(rt.BlockHash)
+
+	logs := make([]*etypes.Log, 0, len(receipt.Logs))
+	for _, l := range receipt.Logs {
+		l.BlockNumber = rt.BlockHeight
+		l.BlockHash = blockHash
+		logs = append(logs, l)
+	}
+
+	status := etypes.ReceiptStatusFailed
+	if receipt.Status == etypes.ReceiptStatusSuccessful {
+		status = etypes.ReceiptStatusSuccessful
+	}
+	fields := map[string]interface{}{
+		"blockHash":         blockHash,
+		"blockNumber":       hexutil.Uint64(rt.BlockHeight),
+		"transactionHash":   hash,
+		"transactionIndex":  hexutil.Uint64(rt.TransactionIndex),
+		"from":              from,
+		"to":                tx.To(),
+		"gasUsed":           hexutil.Uint64(receipt.GasUsed),
+		"cumulativeGasUsed": hexutil.Uint64(receipt.CumulativeGasUsed),
+		"contractAddress":   nil,
+		"logs":              logs,
+		"logsBloom":         receipt.Bloom,
+		"status":            hexutil.Uint64(status),
+	}
+	if receipt.ContractAddress != (common.Address{}) {
+		fields["contractAddress"] = receipt.ContractAddress
+	}
+	if failure != nil {
+		fields["error"] = failure.Error
+		if failure.RevertReason != "" {
+			fields["revertReason"] = failure.RevertReason
+		}
+	}
+	return fields, nil
+}
+
+// GetTransactionStatus returns where a tx is in its lifecycle, from its receipt for the txs committed
+// before the node started
+func (api *ethAPI) GetTransactionStatus(hash common.Hash) (*ethTxStatus, error) {
+	data, err := api.query(types.QueryType_TxStatus, hash.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	s := &types.TxStatus{}
+	if err := rlp.DecodeBytes(data, s); err != nil {
+		return nil, err
+	}
+	res := &ethTxStatus{
+		Hash:   s.Hash,
+		Status: s.Status,
+		Reason: s.Reason,
+		Time:   hexutil.Uint64(s.Time),
+	}
+	if s.Height > 0 {
+		height, index := hexutil.Uint64(s.Height), hexutil.Uint64(s.Index)
+		res.BlockNumber, res.TransactionIndex = &height, &index
+	}
+	return res, nil
+}
+
+func (api *ethAPI) GetBlockByNumber(bn ethBlockNumber, fullTx bool) (map[string]interface{}, error) {
+	height := int64(bn)
+	switch bn {
+	case ethLatestBlockNumber, ethPendingBlockNumber:
+		height = api.node.Angine.Height()
+	case ethEarliestBlockNumber:
+		height = 1
+	}
+	if height <= 0 || height > api.node.Angine.Height() {
+		return nil, nil
+	}
+	block, _, err := api.node.Angine.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	return api.marshalBlock(block, fullTx)
+}
+
+func (api *ethAPI) GetBlockByHash(hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	height, err := api.blockHeightByHash(hash)
+	if err != nil {
+		return nil, nil
+	}
+	block, _, err := api.node.Angine.GetBlock(height)
+	if err != nil {
+		return nil, err
+	}
+	return api.marshalBlock(block, fullTx)
+}
+
+func (api *ethAPI) blockHeightByHash(hash common.Hash) (int64, error) {
+	height, err := api.node.Angine.GetBlockHeight(hash.Bytes())
+	if err != nil {
+		// block hashes shorter than 32 bytes are left padded
+		return api.node.Angine.GetBlockHeight(hash[common.HashLength-common.AddressLength:])
+	}
+	return height, nil
+}
+
+func (api *ethAPI) marshalBlock(block *gtypes.Block, fullTx bool) (map[string]interface{}, error) {
+	var (
+		blockHash = common.BytesToHash(block.Hash())
+		height    = uint64(block.Height)
+		gasUsed   uint64
+		txs       = make([]interface{}, 0, len(block.Data.Txs))
+	)
+	bloomBytes, err := api.query(types.QueryType_LogsBloom, putHeight(height))
+	if err != nil {
+		return nil, err
+	}
+	for i, raw := range block.Data.Txs {
+		tx := &etypes.Transaction{}
+		if err := rlp.DecodeBytes(raw, tx); err != nil {
+			// not an ethereum transaction, eg. adminOp
+			continue
+		}
+		if receipt, err := api.getReceipt(tx.Hash()); err == nil {
+			gasUsed += receipt.GasUsed
+		}
+		if fullTx {
+			txs = append(txs, api.marshalTx(tx, blockHash, height, uint64(i)))
+		} else {
+			txs = append(txs, tx.Hash())
+		}
+	}
+
+	return map[string]interface{}{
+		"number":           hexutil.Uint64(height),
+		"hash":             blockHash,
+		"parentHash":       common.BytesToHash(block.LastBlockID.Hash),
+		"nonce":            etypes.BlockNonce{},
+		"mixHash":          common.Hash{},
+		"sha3Uncles":       etypes.EmptyUncleHash,
+		"logsBloom":        etypes.BytesToBloom(bloomBytes),
+		"stateRoot":        common.BytesToHash(block.AppHash),
+		"transactionsRoot": common.BytesToHash(block.DataHash),
+		"receiptsRoot":     common.BytesToHash(block.ReceiptsHash),
+		"miner":            common.BytesToAddress(block.ProposerAddress),
+		"difficulty":       (*hexutil.Big)(big.NewInt(0)),
+		"totalDifficulty":  (*hexutil.Big)(big.NewInt(0)),
+		"extraData":        hexutil.Bytes(block.Extra),
+		"size":             hexutil.Uint64(len(wire.BinaryBytes(block))),
+		"gasLimit":         hexutil.Uint64(evm.EVMGasLimit),
+		"gasUsed":          hexutil.Uint64(gasUsed),
+		"timestamp":        hexutil.Uint64(block.Time.Unix()),
+		"transactions":     txs,
+		"uncles":           []common.Hash{},
+	}, nil
+}
+
+var _ json.Unmarshaler = (*ethBlockNumber)(nil)
@@ -0,0 +1,300 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+)
+
+// ethFilterTimeout is how long an installed filter survives without being polled
+const ethFilterTimeout = 5 * time.Minute
+
+// ethFilterArgs are the criteria of eth_getLogs and eth_newFilter
+type ethFilterArgs struct {
+	BlockHash *common.Hash
+	FromBlock *ethBlockNumber
+	ToBlock   *ethBlockNumber
+	Addresses []common.Address
+	Topics    [][]common.Hash
+}
+
+func (args *ethFilterArgs) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		BlockHash *common.Hash      `json:"blockHash"`
+		FromBlock *ethBlockNumber   `json:"fromBlock"`
+		ToBlock   *ethBlockNumber   `json:"toBlock"`
+		Address   json.RawMessage   `json:"address"`
+		Topics    []json.RawMessage `json:"topics"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	if raw.BlockHash != nil && (raw.FromBlock != nil || raw.ToBlock != nil) {
+		return errors.New("cannot specify both blockHash and fromBlock/toBlock")
+	}
+	args.BlockHash, args.FromBlock, args.ToBlock = raw.BlockHash, raw.FromBlock, raw.ToBlock
+
+	// address and every topic position are either a single value, a list of alternatives or null
+	if len(raw.Address) > 0 && string(raw.Address) != "null" {
+		if raw.Address[0] == '[' {
+			if err := json.Unmarshal(raw.Address, &args.Addresses); err != nil {
+				return fmt.Errorf("invalid address: %v", err)
+			}
+		} else {
+			var addr common.Address
+			if err := json.Unmarshal(raw.Address, &addr); err != nil {
+				return fmt.Errorf("invalid address: %v", err)
+			}
+			args.Addresses = []common.Address{addr}
+		}
+	}
+	args.Topics = make([][]common.Hash, len(raw.Topics))
+	for i, t := range raw.Topics {
+		if len(t) == 0 || string(t) == "null" {
+			continue
+		}
+		if t[0] == '[' {
+			var sub []*common.Hash
+			if err := json.Unmarshal(t, &sub); err != nil {
+				return fmt.Errorf("invalid topic %d: %v", i, err)
+			}
+			for _, h := range sub {
+				if h == nil {
+					// null inside the alternatives matches anything
+					args.Topics[i] = nil
+					break
+				}
+				args.Topics[i] = append(args.Topics[i], *h)
+			}
+			continue
+		}
+		var h common.Hash
+		if err := json.Unmarshal(t, &h); err != nil {
+			return fmt.Errorf("invalid topic %d: %v", i, err)
+		}
+		args.Topics[i] = []common.Hash{h}
+	}
+	return nil
+}
+
+const (
+	ethLogsFilter = iota
+	ethBlocksFilter
+)
+
+type ethFilter struct {
+	typ      int
+	crit     ethFilterArgs
+	last     int64 // height up to which changes were returned
+	deadline time.Time
+}
+
+// ethFilters holds the filters installed through eth_newFilter and eth_newBlockFilter
+type ethFilters struct {
+	mtx     sync.Mutex
+	filters map[string]*ethFilter
+}
+
+func newEthFilters() *ethFilters {
+	return &ethFilters{filters: make(map[string]*ethFilter)}
+}
+
+func (fs *ethFilters) install(f *ethFilter) (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", err
+	}
+	id := hexutil.Encode(idBytes)
+
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.prune()
+	f.deadline = time.Now().Add(ethFilterTimeout)
+	fs.filters[id] = f
+	return id, nil
+}
+
+func (fs *ethFilters) uninstall(id string) bool {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	_, ok := fs.filters[id]
+	delete(fs.filters, id)
+	return ok
+}
+
+// prune drops the filters nobody polled within ethFilterTimeout, fs.mtx must be held
+func (fs *ethFilters) prune() {
+	now := time.Now()
+	for id, f := range fs.filters {
+		if now.After(f.deadline) {
+			delete(fs.filters, id)
+		}
+	}
+}
+
+func (fs *ethFilters) get(id string) (*ethFilter, error) {
+	fs.prune()
+	f, ok := fs.filters[id]
+	if !ok {
+		return nil, errors.New("filter not found")
+	}
+	f.deadline = time.Now().Add(ethFilterTimeout)
+	return f, nil
+}
+
+// resolveRange converts the block numbers of the filter into a height range, ok is false for an empty range.
+// The range ends at the last block committed by the app, the logs of the blocks above aren't indexed yet.
+func (api *ethAPI) resolveRange(from, to *ethBlockNumber) (uint64, uint64, bool) {
+	latest := api.node.CommittedHeight()
+	resolve := func(bn *ethBlockNumber) int64 {
+		if bn == nil || *bn < 0 {
+			return latest
+		}
+		if *bn == ethEarliestBlockNumber {
+			return 1
+		}
+		return int64(*bn)
+	}
+	f, t := resolve(from), resolve(to)
+	if t > latest {
+		t = latest
+	}
+	if f < 1 {
+		f = 1
+	}
+	if f > t {
+		return 0, 0, false
+	}
+	return uint64(f), uint64(t), true
+}
+
+func (api *ethAPI) filterLogs(args *ethFilterArgs, from, to uint64) ([]*etypes.Log, error) {
+	load, err := rlp.EncodeToBytes(&types.LogFilter{
+		FromBlock: from,
+		ToBlock:   to,
+		Addresses: args.Addresses,
+		Topics:    args.Topics,
+	})
+	if err != nil {
+		return nil, err
+	}
+	data, err := api.query(types.QueryType_Logs, load)
+	if err != nil {
+		return nil, err
+	}
+	var stored []*etypes.LogForStorage
+	if err := rlp.DecodeBytes(data, &stored); err != nil {
+		return nil, err
+	}
+	logs := make([]*etypes.Log, len(stored))
+	for i, l := range stored {
+		logs[i] = (*etypes.Log)(l)
+	}
+	return logs, nil
+}
+
+func (api *ethAPI) GetLogs(args ethFilterArgs) ([]*etypes.Log, error) {
+	if args.BlockHash != nil {
+		height, err := api.blockHeightByHash(*args.BlockHash)
+		if err != nil {
+			return nil, err
+		}
+		return api.filterLogs(&args, uint64(height), uint64(height))
+	}
+	from, to, ok := api.resolveRange(args.FromBlock, args.ToBlock)
+	if !ok {
+		return []*etypes.Log{}, nil
+	}
+	return api.filterLogs(&args, from, to)
+}
+
+func (api *ethAPI) NewFilter(args ethFilterArgs) (string, error) {
+	if args.BlockHash != nil {
+		return "", errors.New("blockHash is not supported by installed filters")
+	}
+	return api.filters.install(&ethFilter{typ: ethLogsFilter, crit: args, last: api.node.CommittedHeight()})
+}
+
+func (api *ethAPI) NewBlockFilter() (string, error) {
+	return api.filters.install(&ethFilter{typ: ethBlocksFilter, last: api.node.CommittedHeight()})
+}
+
+func (api *ethAPI) UninstallFilter(id string) (bool, error) {
+	return api.filters.uninstall(id), nil
+}
+
+// GetFilterChanges returns the logs or block hashes committed since the filter was last polled
+func (api *ethAPI) GetFilterChanges(id string) (interface{}, error) {
+	api.filters.mtx.Lock()
+	defer api.filters.mtx.Unlock()
+	f, err := api.filters.get(id)
+	if err != nil {
+		return nil, err
+	}
+	latest := api.node.CommittedHeight()
+
+	switch f.typ {
+	case ethBlocksFilter:
+		hashes := make([]common.Hash, 0)
+		for h := f.last + 1; h <= latest; h++ {
+			block, _, err := api.node.Angine.GetBlock(h)
+			if err != nil {
+				return nil, err
+			}
+			hashes = append(hashes, common.BytesToHash(block.Hash()))
+		}
+		f.last = latest
+		return hashes, nil
+	default:
+		from := ethBlockNumber(f.last + 1)
+		if f.crit.FromBlock != nil && *f.crit.FromBlock > from {
+			from = *f.crit.FromBlock
+		}
+		start, end, ok := api.resolveRange(&from, f.crit.ToBlock)
+		if !ok {
+			return []*etypes.Log{}, nil
+		}
+		logs, err := api.filterLogs(&f.crit, start, end)
+		if err != nil {
+			return nil, err
+		}
+		f.last = int64(end)
+		return logs, nil
+	}
+}
+
+// GetFilterLogs returns all logs matching a filter installed by eth_newFilter
+func (api *ethAPI) GetFilterLogs(id string) ([]*etypes.Log, error) {
+	api.filters.mtx.Lock()
+	f, err := api.filters.get(id)
+	api.filters.mtx.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if f.typ != ethLogsFilter {
+		return nil, errors.New("filter is not a log filter")
+	}
+	return api.GetLogs(f.crit)
+}
@@ -0,0 +1,113 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/chain/app/evm"
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// logApp serves the logs of the blocks it committed, up to height
+type logApp struct {
+	gtypes.Application
+	mtx    sync.Mutex
+	height int64
+	logs   map[uint64][]*etypes.Log
+}
+
+func newLogApp() *logApp {
+	return &logApp{logs: make(map[uint64][]*etypes.Log)}
+}
+
+func (app *logApp) commit(logs ...*etypes.Log) {
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	app.height++
+	for _, l := range logs {
+		l.BlockNumber = uint64(app.height)
+	}
+	app.logs[uint64(app.height)] = logs
+}
+
+func (app *logApp) Info() gtypes.ResultInfo {
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	return gtypes.ResultInfo{LastBlockHeight: app.height}
+}
+
+func (app *logApp) Query(query []byte) gtypes.Result {
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	filter := &types.LogFilter{}
+	if query[0] != types.QueryType_Logs || rlp.DecodeBytes(query[1:], filter) != nil {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "unexpected query")
+	}
+	if filter.ToBlock > uint64(app.height) {
+		return gtypes.NewError(gtypes.CodeType_BaseInvalidInput, "block not committed")
+	}
+	stored := make([]*etypes.LogForStorage, 0)
+	for h := filter.FromBlock; h <= filter.ToBlock; h++ {
+		for _, l := range app.logs[h] {
+			if evm.LogMatchesFilter(l, filter) {
+				stored = append(stored, (*etypes.LogForStorage)(l))
+			}
+		}
+	}
+	data, _ := rlp.EncodeToBytes(stored)
+	return gtypes.NewResultOK(data, "")
+}
+
+func TestEthFilterChanges(t *testing.T) {
+	app := newLogApp()
+	api := newEthAPI(&Node{Application: app}, nil)
+	addr := common.HexToAddress("0x01")
+	app.commit(&etypes.Log{Address: addr})
+
+	id, err := api.NewFilter(ethFilterArgs{Addresses: []common.Address{addr}})
+	assert.NoError(t, err)
+	changes := func() []*etypes.Log {
+		res, err := api.GetFilterChanges(id)
+		assert.NoError(t, err)
+		return res.([]*etypes.Log)
+	}
+	assert.Empty(t, changes())
+
+	// every block committed by the app is delivered once, a poll never passes the committed height
+	app.commit(&etypes.Log{Address: addr})
+	app.commit(&etypes.Log{Address: addr}, &etypes.Log{Address: common.HexToAddress("0x02")})
+	logs := changes()
+	if assert.Len(t, logs, 2) {
+		assert.Equal(t, uint64(2), logs[0].BlockNumber)
+		assert.Equal(t, uint64(3), logs[1].BlockNumber)
+	}
+	assert.Empty(t, changes())
+	app.commit(&etypes.Log{Address: addr})
+	logs = changes()
+	if assert.Len(t, logs, 1) {
+		assert.Equal(t, uint64(4), logs[0].BlockNumber)
+	}
+
+	logs, err = api.GetLogs(ethFilterArgs{Addresses: []common.Address{addr}})
+	assert.NoError(t, err)
+	assert.Len(t, logs, 1, "latest block only")
+}
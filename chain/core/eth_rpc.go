@@ -0,0 +1,198 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+
+	"go.uber.org/zap"
+
+	gcmn "github.com/dappledger/AnnChain/gemmill/modules/go-common"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+)
+
+// EthRPCPath is where the ethereum compatible JSON-RPC endpoint is mounted on every rpc listener
+const EthRPCPath = "/eth"
+
+const (
+	ethErrCodeParse          = -32700
+	ethErrCodeInvalidRequest = -32600
+	ethErrCodeMethodNotFound = -32601
+	ethErrCodeInvalidParams  = -32602
+	ethErrCodeInternal       = -32000
+)
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+type ethRPCRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type ethRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type ethRPCResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *ethRPCError    `json:"error,omitempty"`
+}
+
+// ethRPCFunc holds the type information of a method served on the ethereum endpoint.
+// Methods take positional arguments and return (result, error), trailing pointer
+// arguments are optional.
+type ethRPCFunc struct {
+	f    reflect.Value
+	args []reflect.Type
+}
+
+func newEthRPCFunc(f interface{}) *ethRPCFunc {
+	t := reflect.TypeOf(f)
+	if t.NumOut() != 2 || !t.Out(1).Implements(errorType) {
+		gcmn.PanicSanity(fmt.Sprintf("eth rpc function must return (result, error): %v", t))
+	}
+	args := make([]reflect.Type, t.NumIn())
+	for i := range args {
+		args[i] = t.In(i)
+	}
+	return &ethRPCFunc{f: reflect.ValueOf(f), args: args}
+}
+
+func (fn *ethRPCFunc) parseArgs(params []json.RawMessage) ([]reflect.Value, error) {
+	if len(params) > len(fn.args) {
+		return nil, fmt.Errorf("too many arguments, want at most %d", len(fn.args))
+	}
+	values := make([]reflect.Value, len(fn.args))
+	for i, ty := range fn.args {
+		if i >= len(params) {
+			if ty.Kind() != reflect.Ptr {
+				return nil, fmt.Errorf("missing value for required argument %d", i)
+			}
+			values[i] = reflect.Zero(ty)
+			continue
+		}
+		v := reflect.New(ty)
+		if err := json.Unmarshal(params[i], v.Interface()); err != nil {
+			return nil, fmt.Errorf("invalid argument %d: %v", i, err)
+		}
+		values[i] = v.Elem()
+	}
+	return values, nil
+}
+
+func (fn *ethRPCFunc) call(params []json.RawMessage) (interface{}, *ethRPCError) {
+	args, err := fn.parseArgs(params)
+	if err != nil {
+		return nil, &ethRPCError{Code: ethErrCodeInvalidParams, Message: err.Error()}
+	}
+	returns := fn.f.Call(args)
+	if errV := returns[1].Interface(); errV != nil {
+		return nil, &ethRPCError{Code: ethErrCodeInternal, Message: errV.(error).Error()}
+	}
+	return returns[0].Interface(), nil
+}
+
+// makeEthRPCHandler serves JSON-RPC 2.0 requests, single or batched, the way ethereum clients send them
+func makeEthRPCHandler(funcMap map[string]*ethRPCFunc) http.HandlerFunc {
+	handle := func(req *ethRPCRequest) *ethRPCResponse {
+		resp := &ethRPCResponse{JSONRPC: "2.0", ID: req.ID}
+		if req.ID == nil {
+			resp.ID = json.RawMessage("null")
+		}
+		fn, ok := funcMap[req.Method]
+		if !ok {
+			resp.Error = &ethRPCError{Code: ethErrCodeMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", req.Method)}
+			return resp
+		}
+		result, rpcErr := fn.call(req.Params)
+		if rpcErr != nil {
+			log.Debug("eth rpc error", zap.String("method", req.Method), zap.String("err", rpcErr.Message))
+			resp.Error = rpcErr
+			return resp
+		}
+		if result == nil {
+			resp.Result = json.RawMessage("null")
+		} else {
+			resp.Result = result
+		}
+		return resp
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			// cors preflight of browser wallets
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if r.Method != http.MethodPost {
+			writeEthRPCResponse(w, &ethRPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &ethRPCError{Code: ethErrCodeInvalidRequest, Message: "only POST is supported"}})
+			return
+		}
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			writeEthRPCResponse(w, &ethRPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &ethRPCError{Code: ethErrCodeParse, Message: err.Error()}})
+			return
+		}
+
+		body = bytes.TrimSpace(body)
+		if len(body) > 0 && body[0] == '[' {
+			var reqs []*ethRPCRequest
+			if err := json.Unmarshal(body, &reqs); err != nil {
+				writeEthRPCResponse(w, &ethRPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+					Error: &ethRPCError{Code: ethErrCodeParse, Message: err.Error()}})
+				return
+			}
+			resps := make([]*ethRPCResponse, len(reqs))
+			for i, req := range reqs {
+				resps[i] = handle(req)
+			}
+			writeEthRPCResponse(w, resps)
+			return
+		}
+
+		req := &ethRPCRequest{}
+		if err := json.Unmarshal(body, req); err != nil {
+			writeEthRPCResponse(w, &ethRPCResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &ethRPCError{Code: ethErrCodeParse, Message: err.Error()}})
+			return
+		}
+		writeEthRPCResponse(w, handle(req))
+	}
+}
+
+func writeEthRPCResponse(w http.ResponseWriter, res interface{}) {
+	jsonBytes, err := json.Marshal(res)
+	if err != nil {
+		log.Error("Failed to marshal eth rpc response", zap.Error(err))
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(jsonBytes)
+}
@@ -0,0 +1,107 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEthBlockNumber(t *testing.T) {
+	var bn ethBlockNumber
+	assert.NoError(t, json.Unmarshal([]byte(`"latest"`), &bn))
+	assert.Equal(t, ethLatestBlockNumber, bn)
+	assert.NoError(t, json.Unmarshal([]byte(`"pending"`), &bn))
+	assert.Equal(t, ethPendingBlockNumber, bn)
+	assert.NoError(t, json.Unmarshal([]byte(`"earliest"`), &bn))
+	assert.Equal(t, ethEarliestBlockNumber, bn)
+	assert.NoError(t, json.Unmarshal([]byte(`"0x1f"`), &bn))
+	assert.Equal(t, ethBlockNumber(31), bn)
+	assert.Error(t, json.Unmarshal([]byte(`"31"`), &bn))
+}
+
+func TestEthRPCHandler(t *testing.T) {
+	handler := makeEthRPCHandler(map[string]*ethRPCFunc{
+		"test_add": newEthRPCFunc(func(a hexutil.Uint64, b *hexutil.Uint64) (hexutil.Uint64, error) {
+			if b == nil {
+				return a, nil
+			}
+			return a + *b, nil
+		}),
+		"test_fail": newEthRPCFunc(func() (interface{}, error) {
+			return nil, errors.New("boom")
+		}),
+	})
+	post := func(body string) string {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, EthRPCPath, strings.NewReader(body)))
+		assert.Equal(t, http.StatusOK, w.Code)
+		return strings.TrimSpace(w.Body.String())
+	}
+
+	assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"0x3"}`,
+		post(`{"jsonrpc":"2.0","id":1,"method":"test_add","params":["0x1","0x2"]}`))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":"a","result":"0x1"}`,
+		post(`{"jsonrpc":"2.0","id":"a","method":"test_add","params":["0x1"]}`))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"error":{"code":-32602,"message":"missing value for required argument 0"}}`,
+		post(`{"jsonrpc":"2.0","id":2,"method":"test_add","params":[]}`))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":3,"error":{"code":-32000,"message":"boom"}}`,
+		post(`{"jsonrpc":"2.0","id":3,"method":"test_fail"}`))
+	assert.Equal(t, `{"jsonrpc":"2.0","id":4,"error":{"code":-32601,"message":"the method test_none does not exist/is not available"}}`,
+		post(`{"jsonrpc":"2.0","id":4,"method":"test_none"}`))
+	assert.Equal(t, `[{"jsonrpc":"2.0","id":5,"result":"0x2"},{"jsonrpc":"2.0","id":6,"error":{"code":-32000,"message":"boom"}}]`,
+		post(`[{"jsonrpc":"2.0","id":5,"method":"test_add","params":["0x2"]},{"jsonrpc":"2.0","id":6,"method":"test_fail"}]`))
+}
+
+// queryApp answers every query with its data, keeping the last one
+type queryApp struct {
+	gtypes.Application
+	query []byte
+	data  []byte
+}
+
+func (app *queryApp) Query(query []byte) gtypes.Result {
+	app.query = query
+	return gtypes.NewResultOK(app.data, "")
+}
+
+func TestEthGetStorageAt(t *testing.T) {
+	app := &queryApp{data: common.HexToHash("0x2a").Bytes()}
+	api := newEthAPI(&Node{Application: app}, nil)
+	handler := makeEthRPCHandler(map[string]*ethRPCFunc{"eth_getStorageAt": newEthRPCFunc(api.GetStorageAt)})
+	post := func(body string) string {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest(http.MethodPost, EthRPCPath, strings.NewReader(body)))
+		return strings.TrimSpace(w.Body.String())
+	}
+
+	addr := common.HexToAddress("0x0000000000000000000000000000000000000abc")
+	// clients send the slot zero padded to 32 bytes
+	for _, key := range []string{"0x0000000000000000000000000000000000000000000000000000000000000001", "0x1"} {
+		assert.Equal(t, `{"jsonrpc":"2.0","id":1,"result":"0x000000000000000000000000000000000000000000000000000000000000002a"}`,
+			post(`{"jsonrpc":"2.0","id":1,"method":"eth_getStorageAt","params":["`+addr.Hex()+`","`+key+`","latest"]}`))
+		load := append([]byte{types.QueryType_Storage}, addr.Bytes()...)
+		assert.Equal(t, append(load, common.HexToHash("0x1").Bytes()...), app.query)
+	}
+}
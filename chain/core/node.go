@@ -31,6 +31,7 @@ import (
 	"github.com/dappledger/AnnChain/eth/core/vm"
 	"github.com/dappledger/AnnChain/eth/rlp"
 	"github.com/dappledger/AnnChain/gemmill"
+	_ "github.com/dappledger/AnnChain/gemmill/consensus/dev"
 	"github.com/dappledger/AnnChain/gemmill/go-crypto"
 	"github.com/dappledger/AnnChain/gemmill/go-wire"
 	cmn "github.com/dappledger/AnnChain/gemmill/modules/go-common"
@@ -50,6 +51,8 @@ type Node struct {
 	AngineTune  *gemmill.Tunes
 	Application gtypes.Application
 	GenesisDoc  *gtypes.GenesisDoc
+
+	subscriptions *subscriptionManager
 }
 
 func queryPayLoadTxParser(txData []byte) ([]byte, error) {
@@ -97,6 +100,7 @@ func NewNode(conf *viper.Viper, runtime, appName string) (*Node, error) {
 		config:        conf,
 		privValidator: newAngine.PrivValidator(),
 	}
+	node.subscriptions = newSubscriptionManager(node, newAngine.EventSwitch())
 	vm.DefaultAdminContract.SetCallback(node.ExecAdminTx)
 	initApp.SetCore(newAngine)
 
@@ -142,7 +146,12 @@ func (n *Node) Start() error {
 		return fmt.Errorf("already started")
 	}
 
-	n.Application.Start()
+	// without genesis, the app is started by angine once the genesis comes from peers
+	if !n.Angine.NoneGenesis() {
+		if err := n.Application.Start(); err != nil {
+			return fmt.Errorf("fail to start app, error: %v", err)
+		}
+	}
 	if err := n.Angine.Start(); err != nil {
 		return fmt.Errorf("fail to start, error: %v", err)
 	}
@@ -159,6 +168,7 @@ func (n *Node) Stop() {
 	log.Info("Stopping Node")
 
 	if atomic.CompareAndSwapInt64(&n.running, 1, 0) {
+		n.subscriptions.Stop()
 		n.Application.Stop()
 		n.Angine.Stop()
 	}
@@ -201,6 +211,8 @@ func (n *Node) NodeInfo() *p2p.NodeInfo {
 func (n *Node) StartRPC() ([]net.Listener, error) {
 	listenAddrs := strings.Split(n.config.GetString("rpc_laddr"), ",")
 	listeners := make([]net.Listener, len(listenAddrs))
+	ethRoutes := n.ethRoutes()
+	n.subscriptions.Start()
 
 	for i, listenAddr := range listenAddrs {
 		mux := http.NewServeMux()
@@ -211,6 +223,11 @@ func (n *Node) StartRPC() ([]net.Listener, error) {
 			}
 		}
 		server.RegisterRPCFuncs(mux, routes)
+		wm := server.NewWebsocketManager(routes, n.Angine.EventSwitch())
+		mux.HandleFunc(WebsocketPath, wm.WebsocketHandler)
+		if ethRoutes != nil {
+			mux.HandleFunc(EthRPCPath, makeEthRPCHandler(ethRoutes))
+		}
 
 		listener, err := server.StartHTTPServer(listenAddr, mux)
 		if err != nil {
@@ -230,6 +247,12 @@ func (n *Node) HealthStatus() int {
 	return n.Angine.HealthStatus()
 }
 
+// CommittedHeight is the height of the last block committed by the app. A block is saved into the block store
+// before the app commits it, the logs and receipts of the blocks above this height may be missing yet
+func (n *Node) CommittedHeight() int64 {
+	return n.Application.Info().LastBlockHeight
+}
+
 //func (n *Node) GetAdminVote(data []byte, validator *gtypes.Validator) ([]byte, error) {
 //	clientJSON := client.NewClientJSONRPC(validator.RPCAddress) // all shard nodes share the same rpc address of the Node
 //	rpcResult := new(gtypes.RPCResult)
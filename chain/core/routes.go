@@ -14,6 +14,7 @@
 package core
 
 import (
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"strconv"
@@ -57,8 +58,9 @@ func (n *Node) rpcRoutes() map[string]*rpc.RPCFunc {
 	h := newRPCHandler(n)
 	return map[string]*rpc.RPCFunc{
 		// subscribe/unsubscribe are reserved for websocket events.
-		// "subscribe":   rpc.NewWSRPCFunc(SubscribeResult, "event"),
-		// "unsubscribe": rpc.NewWSRPCFunc(UnsubscribeResult, "event"),
+		"subscribe":      rpc.NewWSRPCFunc(n.subscriptions.Subscribe, "event"),
+		"subscribe_logs": rpc.NewWSRPCFunc(n.subscriptions.SubscribeLogs, "addresses,topics"),
+		"unsubscribe":    rpc.NewWSRPCFunc(n.subscriptions.Unsubscribe, "event"),
 
 		// info API
 		// "shards":               rpc.NewRPCFunc(h.Shards, ""),
@@ -88,6 +90,7 @@ func (n *Node) rpcRoutes() map[string]*rpc.RPCFunc {
 		"info":    rpc.NewRPCFunc(h.Info, ""),
 
 		"transaction": rpc.NewRPCFunc(h.GetTransactionByHash, "tx"),
+		"block_txs":   rpc.NewRPCFunc(h.BlockTxs, "minHeight,maxHeight"),
 
 		// control API
 		// "dial_seeds":           rpc.NewRPCFunc(h.UnsafeDialSeeds, "seeds"),
@@ -130,7 +133,8 @@ func (h *rpcHandler) Status() (*gtypes.ResultStatus, error) {
 		LatestBlockHash:   latestBlockHash,
 		LatestAppHash:     latestAppHash,
 		LatestBlockHeight: latestHeight,
-		LatestBlockTime:   latestBlockTime}, nil
+		LatestBlockTime:   latestBlockTime,
+		ConsensusEngine:   h.node.Angine.ConsensusEngine()}, nil
 }
 
 func (h *rpcHandler) Genesis() (*gtypes.ResultGenesis, error) {
@@ -266,6 +270,24 @@ func (h *rpcHandler) GetTransactionByHash(hash []byte) (*gtypes.ResultQuery, err
 	return &gtypes.ResultQuery{Result: h.node.Application.Query(query)}, nil
 }
 
+// BlockTxs lists the transactions of blocks [minHeight, maxHeight] with senders and receipts,
+// maxHeight 0 stands for minHeight
+func (h *rpcHandler) BlockTxs(minHeight, maxHeight int64) (*gtypes.ResultQuery, error) {
+	if maxHeight == 0 {
+		maxHeight = minHeight
+	}
+	if minHeight <= 0 || minHeight > maxHeight {
+		return nil, fmt.Errorf("invalid height range [%d, %d]", minHeight, maxHeight)
+	}
+	if height := h.node.Angine.Height(); maxHeight > height {
+		maxHeight = height
+	}
+	load := make([]byte, 2*types.QueryHeightLen)
+	binary.BigEndian.PutUint64(load, uint64(minHeight))
+	binary.BigEndian.PutUint64(load[types.QueryHeightLen:], uint64(maxHeight))
+	return &gtypes.ResultQuery{Result: h.node.Application.Query(append([]byte{types.QueryType_BlockTxs}, load...))}, nil
+}
+
 func (h *rpcHandler) Info() (*gtypes.ResultInfo, error) {
 	res := h.node.Application.Info()
 	return &res, nil
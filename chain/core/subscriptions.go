@@ -0,0 +1,381 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/chain/app/evm"
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/common/hexutil"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	rpctypes "github.com/dappledger/AnnChain/gemmill/rpc/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	// WebsocketPath is where subscriptions are served on every rpc listener
+	WebsocketPath = "/websocket"
+
+	// EventLogPrefix names log subscriptions, followed by a per connection sequence
+	EventLogPrefix = "Log:"
+
+	defaultWSMaxSubscriptions = 100
+	maxLogFilterCriteria      = 32
+
+	subscriptionListenerID = "rpc-subscriptions"
+)
+
+// wsSubscriber is the subscription state of one websocket connection
+type wsSubscriber struct {
+	conn rpctypes.WSRPCConnection
+
+	// response id of each subscribed event, log subscriptions included
+	respIDs map[string]string
+	logs    map[string]*types.LogFilter
+	nextLog int
+}
+
+// txStatusNotifier is implemented by the applications reporting the status updates of txs
+type txStatusNotifier interface {
+	SetTxStatusListener(func(*types.TxStatus))
+}
+
+// subscriptionManager serves subscribe/unsubscribe on websocket connections.
+// NewBlock, NewBlockHeader, Tx:<HASH>, TxStatus and TxStatus:<HASH> are forwarded from the event switch, logs are
+// read from the application after every committed block and matched per subscription.
+// A connection that does not drain its events fast enough is closed rather than
+// having events silently dropped, so clients can rely on a gapless stream.
+type subscriptionManager struct {
+	node             *Node
+	evsw             gtypes.EventSwitch
+	maxSubscriptions int
+
+	mtx         sync.Mutex
+	subscribers map[string]*wsSubscriber
+
+	logHeight int64
+	logSignal chan struct{}
+	quit      chan struct{}
+}
+
+func newSubscriptionManager(n *Node, evsw gtypes.EventSwitch) *subscriptionManager {
+	max := n.config.GetInt("rpc_ws_max_subscriptions")
+	if max <= 0 {
+		max = defaultWSMaxSubscriptions
+	}
+	return &subscriptionManager{
+		node:             n,
+		evsw:             evsw,
+		maxSubscriptions: max,
+		subscribers:      make(map[string]*wsSubscriber),
+		logSignal:        make(chan struct{}, 1),
+		quit:             make(chan struct{}),
+	}
+}
+
+func (sm *subscriptionManager) Start() {
+	sm.logHeight = sm.node.CommittedHeight()
+	gtypes.AddListenerForEvent(sm.evsw, subscriptionListenerID, gtypes.EventStringNewBlock(), func(gtypes.TMEventData) {
+		select {
+		case sm.logSignal <- struct{}{}:
+		default:
+		}
+	})
+	if app, ok := sm.node.Application.(txStatusNotifier); ok {
+		app.SetTxStatusListener(func(s *types.TxStatus) {
+			gtypes.FireEventTxStatus(sm.evsw, gtypes.EventDataTxStatus{
+				TxHash:      s.Hash.Bytes(),
+				Status:      s.Status,
+				Reason:      s.Reason,
+				BlockHeight: int64(s.Height),
+				TxIndex:     int(s.Index),
+				Time:        int64(s.Time),
+			})
+		})
+	}
+	go sm.logRoutine()
+}
+
+func (sm *subscriptionManager) Stop() {
+	if app, ok := sm.node.Application.(txStatusNotifier); ok {
+		app.SetTxStatusListener(nil)
+	}
+	sm.evsw.RemoveListener(subscriptionListenerID)
+	close(sm.quit)
+}
+
+// normalizeEvent validates the events that can be subscribed on the event switch
+func normalizeEvent(event string) (string, error) {
+	switch event {
+	case gtypes.EventStringNewBlock(), gtypes.EventStringNewBlockHeader(), gtypes.EventStringTxStatus():
+		return event, nil
+	}
+	for _, prefix := range []string{"Tx:", gtypes.EventStringTxStatus() + ":"} {
+		if !strings.HasPrefix(event, prefix) {
+			continue
+		}
+		hash := strings.TrimPrefix(strings.TrimPrefix(event[len(prefix):], "0x"), "0X")
+		if b, err := hexutil.Decode("0x" + hash); err != nil || len(b) != common.HashLength {
+			return "", fmt.Errorf("invalid tx hash %s", event[len(prefix):])
+		}
+		return prefix + strings.ToUpper(hash), nil
+	}
+	return "", fmt.Errorf("unsupported event %s", event)
+}
+
+// subscriber returns the state of the connection, sm.mtx must be held
+func (sm *subscriptionManager) subscriber(conn rpctypes.WSRPCConnection) (*wsSubscriber, error) {
+	for addr, s := range sm.subscribers {
+		if !s.conn.IsRunning() {
+			delete(sm.subscribers, addr)
+		}
+	}
+	s, ok := sm.subscribers[conn.GetRemoteAddr()]
+	if !ok {
+		s = &wsSubscriber{
+			conn:    conn,
+			respIDs: make(map[string]string),
+			logs:    make(map[string]*types.LogFilter),
+		}
+		sm.subscribers[conn.GetRemoteAddr()] = s
+	}
+	if len(s.respIDs) >= sm.maxSubscriptions {
+		return nil, fmt.Errorf("too many subscriptions, at most %d per connection", sm.maxSubscriptions)
+	}
+	return s, nil
+}
+
+func (sm *subscriptionManager) Subscribe(wsCtx rpctypes.WSRPCContext, event string) (*gtypes.ResultSubscribe, error) {
+	event, err := normalizeEvent(event)
+	if err != nil {
+		return nil, err
+	}
+
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	s, err := sm.subscriber(wsCtx.WSRPCConnection)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := s.respIDs[event]; ok {
+		return nil, fmt.Errorf("already subscribed to %s", event)
+	}
+	respID := wsCtx.Request.ID + "#event"
+	s.respIDs[event] = respID
+
+	oneShot := strings.HasPrefix(event, "Tx:")
+	// the connection's remote address is the listener id, the connection removes it when closing
+	gtypes.AddListenerForEvent(sm.evsw, wsCtx.GetRemoteAddr(), event, func(data gtypes.TMEventData) {
+		sm.deliver(s, respID, event, data)
+		if oneShot {
+			// can't remove a listener while it is being fired
+			go sm.unsubscribe(s.conn, event)
+		}
+	})
+	return &gtypes.ResultSubscribe{Event: event}, nil
+}
+
+// SubscribeLogs subscribes to the logs of committed blocks emitted by one of addresses, with
+// topics matched by position. An empty list matches anything.
+func (sm *subscriptionManager) SubscribeLogs(wsCtx rpctypes.WSRPCContext, addresses []string, topics [][]string) (*gtypes.ResultSubscribe, error) {
+	criteria := len(addresses)
+	for _, t := range topics {
+		criteria += len(t)
+	}
+	if criteria > maxLogFilterCriteria {
+		return nil, fmt.Errorf("too many addresses and topics, at most %d", maxLogFilterCriteria)
+	}
+	filter := &types.LogFilter{}
+	for _, a := range addresses {
+		if !common.IsHexAddress(a) {
+			return nil, fmt.Errorf("invalid address %s", a)
+		}
+		filter.Addresses = append(filter.Addresses, common.HexToAddress(a))
+	}
+	filter.Topics = make([][]common.Hash, len(topics))
+	for i, sub := range topics {
+		for _, t := range sub {
+			b, err := hexutil.Decode(t)
+			if err != nil || len(b) != common.HashLength {
+				return nil, fmt.Errorf("invalid topic %s", t)
+			}
+			filter.Topics[i] = append(filter.Topics[i], common.BytesToHash(b))
+		}
+	}
+
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	s, err := sm.subscriber(wsCtx.WSRPCConnection)
+	if err != nil {
+		return nil, err
+	}
+	s.nextLog++
+	event := fmt.Sprintf("%s%d", EventLogPrefix, s.nextLog)
+	s.respIDs[event] = wsCtx.Request.ID + "#event"
+	s.logs[event] = filter
+	return &gtypes.ResultSubscribe{Event: event}, nil
+}
+
+func (sm *subscriptionManager) Unsubscribe(wsCtx rpctypes.WSRPCContext, event string) (*gtypes.ResultUnsubscribe, error) {
+	if !strings.HasPrefix(event, EventLogPrefix) {
+		var err error
+		if event, err = normalizeEvent(event); err != nil {
+			return nil, err
+		}
+	}
+	if !sm.unsubscribe(wsCtx.WSRPCConnection, event) {
+		return nil, fmt.Errorf("not subscribed to %s", event)
+	}
+	return &gtypes.ResultUnsubscribe{}, nil
+}
+
+func (sm *subscriptionManager) unsubscribe(conn rpctypes.WSRPCConnection, event string) bool {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	s, ok := sm.subscribers[conn.GetRemoteAddr()]
+	if !ok {
+		return false
+	}
+	if _, ok := s.respIDs[event]; !ok {
+		return false
+	}
+	delete(s.respIDs, event)
+	if _, ok := s.logs[event]; ok {
+		delete(s.logs, event)
+	} else {
+		sm.evsw.RemoveListenerForEvent(event, conn.GetRemoteAddr())
+	}
+	return true
+}
+
+// deliver writes the event without blocking the caller, a connection whose write buffer is full is closed
+func (sm *subscriptionManager) deliver(s *wsSubscriber, respID, event string, data gtypes.TMEventData) bool {
+	resp := rpctypes.NewRPCResponse(respID, &gtypes.ResultEvent{Name: event, Data: data}, "")
+	if s.conn.TryWriteRPCResponse(resp) {
+		return true
+	}
+	if s.conn.IsRunning() {
+		log.Warn("closing slow websocket subscriber", zap.String("remote", s.conn.GetRemoteAddr()), zap.String("event", event))
+	}
+	// deliver may run inside an event switch callback, which must not wait for sm.mtx
+	go func() {
+		s.conn.Stop()
+		sm.mtx.Lock()
+		if sm.subscribers[s.conn.GetRemoteAddr()] == s {
+			delete(sm.subscribers, s.conn.GetRemoteAddr())
+		}
+		sm.mtx.Unlock()
+	}()
+	return false
+}
+
+func (sm *subscriptionManager) logRoutine() {
+	for {
+		select {
+		case <-sm.quit:
+			return
+		case <-sm.logSignal:
+			// NewBlock fires once the app committed the block, a block saved above it waits for its own signal
+			latest := sm.node.CommittedHeight()
+			for ; sm.logHeight < latest; sm.logHeight++ {
+				sm.dispatchLogs(sm.logHeight + 1)
+			}
+		}
+	}
+}
+
+type logSubscription struct {
+	s      *wsSubscriber
+	event  string
+	respID string
+	filter *types.LogFilter
+}
+
+// dispatchLogs delivers the logs of the block at height to the matching log subscriptions
+func (sm *subscriptionManager) dispatchLogs(height int64) {
+	var subs []logSubscription
+	sm.mtx.Lock()
+	for _, s := range sm.subscribers {
+		for event, filter := range s.logs {
+			subs = append(subs, logSubscription{s: s, event: event, respID: s.respIDs[event], filter: filter})
+		}
+	}
+	sm.mtx.Unlock()
+	if len(subs) == 0 {
+		return
+	}
+
+	logs, err := sm.blockLogs(height)
+	if err != nil {
+		log.Warn("fail to read logs for subscriptions", zap.Int64("height", height), zap.Error(err))
+		return
+	}
+	closed := make(map[*wsSubscriber]bool)
+	for _, l := range logs {
+		for _, sub := range subs {
+			if closed[sub.s] || !evm.LogMatchesFilter(l, sub.filter) {
+				continue
+			}
+			if !sm.deliver(sub.s, sub.respID, sub.event, makeEventDataLog(l, height)) {
+				closed[sub.s] = true
+			}
+		}
+	}
+}
+
+func (sm *subscriptionManager) blockLogs(height int64) ([]*etypes.Log, error) {
+	load, err := rlp.EncodeToBytes(&types.LogFilter{FromBlock: uint64(height), ToBlock: uint64(height)})
+	if err != nil {
+		return nil, err
+	}
+	res := sm.node.Application.Query(append([]byte{types.QueryType_Logs}, load...))
+	if res.Code != gtypes.CodeType_OK {
+		return nil, errors.New(res.Log)
+	}
+	var stored []*etypes.LogForStorage
+	if err := rlp.DecodeBytes(res.Data, &stored); err != nil {
+		return nil, err
+	}
+	logs := make([]*etypes.Log, len(stored))
+	for i, l := range stored {
+		logs[i] = (*etypes.Log)(l)
+	}
+	return logs, nil
+}
+
+func makeEventDataLog(l *etypes.Log, height int64) gtypes.EventDataLog {
+	topics := make([][]byte, len(l.Topics))
+	for i, t := range l.Topics {
+		topics[i] = t.Bytes()
+	}
+	return gtypes.EventDataLog{
+		Address:     l.Address.Bytes(),
+		Topics:      topics,
+		Data:        l.Data,
+		BlockHeight: height,
+		BlockHash:   l.BlockHash.Bytes(),
+		TxHash:      l.TxHash.Bytes(),
+		TxIndex:     int(l.TxIndex),
+		LogIndex:    int(l.Index),
+	}
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-events"
+	rpctypes "github.com/dappledger/AnnChain/gemmill/rpc/types"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+type testWSConn struct {
+	mtx     sync.Mutex
+	evsw    events.EventSwitch
+	resps   []rpctypes.RPCResponse
+	full    bool
+	running bool
+}
+
+func (c *testWSConn) GetRemoteAddr() string                      { return "127.0.0.1:1234" }
+func (c *testWSConn) GetEventSwitch() events.EventSwitch         { return c.evsw }
+func (c *testWSConn) WriteRPCResponse(resp rpctypes.RPCResponse) { c.TryWriteRPCResponse(resp) }
+
+func (c *testWSConn) TryWriteRPCResponse(resp rpctypes.RPCResponse) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if c.full || !c.running {
+		return false
+	}
+	c.resps = append(c.resps, resp)
+	return true
+}
+
+func (c *testWSConn) IsRunning() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.running
+}
+
+func (c *testWSConn) Stop() bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	c.running = false
+	return true
+}
+
+func (c *testWSConn) received() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return len(c.resps)
+}
+
+func TestSubscriptions(t *testing.T) {
+	conf := viper.New()
+	conf.Set("rpc_ws_max_subscriptions", 3)
+	evsw := gtypes.NewEventSwitch()
+	evsw.Start()
+	defer evsw.Stop()
+	sm := newSubscriptionManager(&Node{config: conf}, evsw)
+
+	conn := &testWSConn{evsw: evsw, running: true}
+	ctx := func(id string) rpctypes.WSRPCContext {
+		return rpctypes.WSRPCContext{Request: rpctypes.RPCRequest{ID: id}, WSRPCConnection: conn}
+	}
+
+	res, err := sm.Subscribe(ctx("1"), gtypes.EventStringNewBlock())
+	assert.NoError(t, err)
+	assert.Equal(t, "NewBlock", res.Event)
+	_, err = sm.Subscribe(ctx("2"), gtypes.EventStringNewBlock())
+	assert.Error(t, err, "duplicated subscription")
+	_, err = sm.Subscribe(ctx("2"), "Vote")
+	assert.Error(t, err, "unsupported event")
+
+	tx := gtypes.Tx("tx")
+	res, err = sm.Subscribe(ctx("3"), "Tx:0x"+strings.ToLower(gtypes.EventStringTx(tx)[3:]))
+	assert.NoError(t, err)
+	assert.Equal(t, gtypes.EventStringTx(tx), res.Event)
+
+	res, err = sm.SubscribeLogs(ctx("4"), []string{"0x0000000000000000000000000000000000000001"}, [][]string{{}, {"0x" + strings.Repeat("0a", 32)}})
+	assert.NoError(t, err)
+	assert.Equal(t, EventLogPrefix+"1", res.Event)
+	_, err = sm.SubscribeLogs(ctx("5"), nil, nil)
+	assert.Error(t, err, "limit of subscriptions")
+
+	gtypes.FireEventNewBlock(evsw, gtypes.EventDataNewBlock{Block: &gtypes.Block{}})
+	gtypes.FireEventTx(evsw, gtypes.EventDataTx{Tx: tx})
+	assert.Equal(t, 2, conn.received())
+	assert.Equal(t, "1#event", conn.resps[0].ID)
+	assert.Equal(t, "3#event", conn.resps[1].ID)
+
+	// tx subscriptions end with the first event
+	time.Sleep(50 * time.Millisecond)
+	gtypes.FireEventTx(evsw, gtypes.EventDataTx{Tx: tx})
+	assert.Equal(t, 2, conn.received())
+	_, err = sm.Unsubscribe(ctx("6"), gtypes.EventStringTx(tx))
+	assert.Error(t, err)
+
+	_, err = sm.Unsubscribe(ctx("7"), EventLogPrefix+"1")
+	assert.NoError(t, err)
+	_, err = sm.Unsubscribe(ctx("8"), gtypes.EventStringNewBlock())
+	assert.NoError(t, err)
+	gtypes.FireEventNewBlock(evsw, gtypes.EventDataNewBlock{Block: &gtypes.Block{}})
+	assert.Equal(t, 2, conn.received())
+
+	// a subscriber that can't keep up gets disconnected
+	_, err = sm.Subscribe(ctx("9"), gtypes.EventStringNewBlockHeader())
+	assert.NoError(t, err)
+	conn.mtx.Lock()
+	conn.full = true
+	conn.mtx.Unlock()
+	gtypes.FireEventNewBlockHeader(evsw, gtypes.EventDataNewBlockHeader{Header: &gtypes.Header{}})
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, conn.IsRunning())
+	sm.mtx.Lock()
+	assert.Empty(t, sm.subscribers)
+	sm.mtx.Unlock()
+}
+
+func TestLogSubscriptions(t *testing.T) {
+	conf := viper.New()
+	evsw := gtypes.NewEventSwitch()
+	evsw.Start()
+	defer evsw.Stop()
+	app := newLogApp()
+	app.commit()
+	sm := newSubscriptionManager(&Node{config: conf, Application: app}, evsw)
+	sm.Start()
+	defer sm.Stop()
+
+	conn := &testWSConn{evsw: evsw, running: true}
+	addr := common.HexToAddress("0x01")
+	_, err := sm.SubscribeLogs(rpctypes.WSRPCContext{Request: rpctypes.RPCRequest{ID: "1"}, WSRPCConnection: conn}, []string{addr.Hex()}, nil)
+	assert.NoError(t, err)
+	waitReceived := func(n int) bool {
+		for end := time.Now().Add(time.Second); time.Now().Before(end); time.Sleep(10 * time.Millisecond) {
+			if conn.received() == n {
+				return true
+			}
+		}
+		return false
+	}
+
+	app.commit(&etypes.Log{Address: addr})
+	gtypes.FireEventNewBlock(evsw, gtypes.EventDataNewBlock{Block: &gtypes.Block{}})
+	assert.True(t, waitReceived(1))
+
+	// a signal coming before the app committed the next block doesn't skip it
+	gtypes.FireEventNewBlock(evsw, gtypes.EventDataNewBlock{Block: &gtypes.Block{}})
+	time.Sleep(50 * time.Millisecond)
+	app.commit(&etypes.Log{Address: addr})
+	app.commit(&etypes.Log{Address: addr}, &etypes.Log{Address: common.HexToAddress("0x02")})
+	gtypes.FireEventNewBlock(evsw, gtypes.EventDataNewBlock{Block: &gtypes.Block{}})
+	assert.True(t, waitReceived(3))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 3, conn.received())
+}
@@ -0,0 +1,210 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package lightclient follows a chain from a trusted validator set without running a node.
+// Every header is checked against the commit carried by the next block, validator changes are
+// replayed from the admin ops of verified blocks, and state is read through merkle proofs
+// checked against the AppHash of verified headers.
+package lightclient
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/chain/verifier"
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+type Client struct {
+	mtx      sync.Mutex
+	provider Provider
+	chainID  string
+	height   int64                // last verified height
+	lastID   gtypes.BlockID       // id of the block at height
+	vals     *gtypes.ValidatorSet // validators of the block at height+1
+	headers  map[int64]*gtypes.Header
+}
+
+// NewClient returns a client trusting the validators of genDoc
+func NewClient(genDoc *gtypes.GenesisDoc, provider Provider) (*Client, error) {
+	vals, err := GenesisValidators(genDoc)
+	if err != nil {
+		return nil, err
+	}
+	return NewClientFromTrusted(genDoc.ChainID, 0, gtypes.BlockID{}, vals, provider), nil
+}
+
+// NewClientFromTrusted returns a client trusting the block id at height and vals, the validators of height+1
+func NewClientFromTrusted(chainID string, height int64, blockID gtypes.BlockID, vals *gtypes.ValidatorSet, provider Provider) *Client {
+	return &Client{
+		provider: provider,
+		chainID:  chainID,
+		height:   height,
+		lastID:   blockID,
+		vals:     vals.Copy(),
+		headers:  make(map[int64]*gtypes.Header),
+	}
+}
+
+// Height returns the last verified height
+func (c *Client) Height() int64 {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.height
+}
+
+// Validators returns the validators of the block following the last verified one
+func (c *Client) Validators() *gtypes.ValidatorSet {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	return c.vals.Copy()
+}
+
+// Header returns a verified header
+func (c *Client) Header(height int64) (*gtypes.Header, error) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	header, ok := c.headers[height]
+	if !ok {
+		return nil, fmt.Errorf("header %d is not verified", height)
+	}
+	return header, nil
+}
+
+// Update verifies the chain up to the last block with a commit, ie. the one before the latest
+func (c *Client) Update() (int64, error) {
+	last, err := c.provider.LastHeight()
+	if err != nil {
+		return 0, err
+	}
+	if err := c.VerifyTo(last - 1); err != nil {
+		return 0, err
+	}
+	return c.Height(), nil
+}
+
+// VerifyTo verifies every header up to height, the node must have committed height+1
+func (c *Client) VerifyTo(height int64) error {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
+	if height <= c.height {
+		return nil
+	}
+
+	block, err := c.provider.Block(c.height + 1)
+	if err != nil {
+		return err
+	}
+	for c.height < height {
+		next, err := c.provider.Block(c.height + 2)
+		if err != nil {
+			return err
+		}
+		if err := c.verifyBlock(block, next.LastCommit); err != nil {
+			return err
+		}
+		if c.vals, err = nextValidators(c.vals, block); err != nil {
+			return err
+		}
+		c.height = block.Height
+		c.lastID = next.LastCommit.BlockID
+		c.headers[c.height] = block.Header
+		block = next
+	}
+	return nil
+}
+
+func (c *Client) verifyBlock(block *gtypes.Block, commit *gtypes.Commit) error {
+	if block.Header == nil || block.Data == nil || commit == nil {
+		return fmt.Errorf("incomplete block %d", c.height+1)
+	}
+	if block.Height != c.height+1 {
+		return fmt.Errorf("got block %d, expected %d", block.Height, c.height+1)
+	}
+	if c.height > 0 && !block.LastBlockID.Equals(c.lastID) {
+		return fmt.Errorf("block %d doesn't follow the verified block %d", block.Height, c.height)
+	}
+	if !bytes.Equal(block.ValidatorsHash, c.vals.Hash()) {
+		return fmt.Errorf("validators of block %d don't match the tracked validator set", block.Height)
+	}
+	if !bytes.Equal(block.DataHash, block.Data.Hash()) {
+		return fmt.Errorf("txs of block %d don't match its data hash", block.Height)
+	}
+	return verifier.VerifyHeader(c.chainID, c.vals, block.Header, commit)
+}
+
+// stateHeader returns the verified header holding the state root of height
+func (c *Client) stateHeader(height int64) (*gtypes.Header, error) {
+	if height < 1 {
+		return nil, fmt.Errorf("invalid height %d", height)
+	}
+	if err := c.VerifyTo(height + 1); err != nil {
+		return nil, err
+	}
+	return c.Header(height + 1)
+}
+
+// GetAccount returns the account and storage slots at height, verified against the AppHash of block height+1
+func (c *Client) GetAccount(addr common.Address, keys []common.Hash, height int64) (*types.AccountProof, error) {
+	header, err := c.stateHeader(height)
+	if err != nil {
+		return nil, err
+	}
+	load, err := rlp.EncodeToBytes(&types.ProofRequest{Address: addr, Keys: keys, Height: uint64(height)})
+	if err != nil {
+		return nil, err
+	}
+	data, err := c.provider.Query(append([]byte{types.QueryType_Proof}, load...))
+	if err != nil {
+		return nil, err
+	}
+	proof := new(types.AccountProof)
+	if err := rlp.DecodeBytes(data, proof); err != nil {
+		return nil, err
+	}
+	if err := verifier.VerifyAccountWithHeader(header, proof); err != nil {
+		return nil, err
+	}
+	return proof, nil
+}
+
+// GetKey returns the value of a kv store key at height (empty if absent), verified against the AppHash of block height+1
+func (c *Client) GetKey(key []byte, height int64) ([]byte, error) {
+	header, err := c.stateHeader(height)
+	if err != nil {
+		return nil, err
+	}
+	query := make([]byte, 1+types.QueryHeightLen, 1+types.QueryHeightLen+len(key))
+	query[0] = types.QueryType_Key_Proof
+	binary.BigEndian.PutUint64(query[1:], uint64(height))
+	data, err := c.provider.Query(append(query, key...))
+	if err != nil {
+		return nil, err
+	}
+	proof := new(types.KVProof)
+	if err := rlp.DecodeBytes(data, proof); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(proof.Key, key) {
+		return nil, fmt.Errorf("got the proof of another key")
+	}
+	if err := verifier.VerifyKVWithHeader(header, proof); err != nil {
+		return nil, err
+	}
+	return proof.Value, nil
+}
@@ -0,0 +1,147 @@
+package lightclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"testing"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	ecore "github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+	"github.com/stretchr/testify/assert"
+)
+
+const testChainID = "light_chain"
+
+type testProvider struct {
+	blocks []*gtypes.Block
+}
+
+func (p *testProvider) LastHeight() (int64, error) { return int64(len(p.blocks)), nil }
+
+func (p *testProvider) Block(height int64) (*gtypes.Block, error) {
+	if height < 1 || height > int64(len(p.blocks)) {
+		return nil, fmt.Errorf("block %d not found", height)
+	}
+	return p.blocks[height-1], nil
+}
+
+func (p *testProvider) Query(query []byte) ([]byte, error) {
+	return nil, fmt.Errorf("not supported")
+}
+
+// adminTx makes an admin op tx changing the power of pub, signed by privs
+func adminTx(t *testing.T, pub crypto.PubKey, power int64, privs []*gtypes.PrivValidator) gtypes.Tx {
+	msg, err := json.Marshal(&gtypes.ValidatorAttr{PubKey: crypto.GetNodePubkeyBytes(pub), Power: power, Cmd: gtypes.ValidatorCmdUpdateNode})
+	assert.NoError(t, err)
+	cmd := &gtypes.AdminOPCmd{CmdType: gtypes.AdminOpChangeValidator, Msg: msg}
+	for _, pv := range privs {
+		cmd.SInfos = append(cmd.SInfos, gtypes.SigInfo{
+			PubKey:    crypto.GetNodePubkeyBytes(pv.PubKey),
+			Signature: crypto.GetNodeSigBytes(pv.PrivKey.Sign(msg)),
+		})
+	}
+	payload, err := json.Marshal(cmd)
+	assert.NoError(t, err)
+	args, err := adminMethod.Inputs.Pack(gtypes.TagAdminOPTx(payload))
+	assert.NoError(t, err)
+	tx := etypes.NewTransaction(0, ecore.AdminTo, big.NewInt(0), 0, big.NewInt(0), append(adminMethod.Id(), args...))
+	raw, err := rlp.EncodeToBytes(tx)
+	assert.NoError(t, err)
+	return raw
+}
+
+// makeChain builds n signed blocks, txs[h] are the txs of block h and vals[h] its validators
+func makeChain(t *testing.T, n int, vals map[int64]*gtypes.ValidatorSet, privs []*gtypes.PrivValidator, txs map[int64][]gtypes.Tx) []*gtypes.Block {
+	blocks := make([]*gtypes.Block, 0, n)
+	lastCommit := &gtypes.Commit{}
+	lastID := gtypes.BlockID{}
+	for h := int64(1); h <= int64(n); h++ {
+		block, parts := gtypes.MakeBlock(h, testChainID, txs[h], nil, lastCommit, nil, lastID, vals[h].Hash(), nil, nil, 65536)
+		lastID = gtypes.BlockID{Hash: block.Hash(), PartsHeader: parts.Header()}
+		lastCommit = &gtypes.Commit{BlockID: lastID}
+		for _, pv := range privs {
+			idx, val := vals[h].GetByAddress(pv.Address)
+			if val == nil {
+				continue
+			}
+			vote := &gtypes.Vote{ValidatorAddress: pv.Address, ValidatorIndex: idx, Height: h, Type: gtypes.VoteTypePrecommit, BlockID: lastID}
+			vote.Signature = pv.Sign(gtypes.SignBytes(testChainID, vote))
+			for len(lastCommit.Precommits) <= idx {
+				lastCommit.Precommits = append(lastCommit.Precommits, nil)
+			}
+			lastCommit.Precommits[idx] = vote
+		}
+		blocks = append(blocks, block)
+	}
+	return blocks
+}
+
+func TestLightClient(t *testing.T) {
+	genVals, privs := gtypes.RandValidatorSet(4, 10)
+	genDoc := &gtypes.GenesisDoc{ChainID: testChainID}
+	for _, val := range genVals.Validators {
+		genDoc.Validators = append(genDoc.Validators, gtypes.GenesisValidator{PubKey: val.PubKey, Amount: val.VotingPower, IsCA: val.IsCA})
+	}
+
+	// block 2 doubles the power of the first validator, and carries an admin op without enough signatures
+	vals := map[int64]*gtypes.ValidatorSet{1: genVals}
+	for h := int64(2); h <= 5; h++ {
+		vals[h] = vals[h-1].Copy()
+		if h == 3 {
+			_, val := vals[h].GetByAddress(privs[0].Address)
+			val.VotingPower = 20
+			vals[h].Update(val)
+		}
+		vals[h].IncrementAccum(1)
+	}
+	txs := map[int64][]gtypes.Tx{2: {
+		adminTx(t, privs[0].PubKey, 20, privs[:3]),
+		adminTx(t, privs[1].PubKey, 30, privs[:2]),
+	}}
+	provider := &testProvider{blocks: makeChain(t, 5, vals, privs, txs)}
+
+	c, err := NewClient(genDoc, provider)
+	assert.NoError(t, err)
+	height, err := c.Update()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), height)
+	assert.Equal(t, vals[5].Hash(), c.Validators().Hash())
+	header, err := c.Header(3)
+	assert.NoError(t, err)
+	assert.Equal(t, provider.blocks[2].Hash(), header.Hash())
+	_, err = c.Header(5)
+	assert.Error(t, err)
+
+	// a forged block is refused
+	forged := makeChain(t, 5, vals, privs, txs)
+	forged[2] = makeChain(t, 3, vals, privs[:2], txs)[2]
+	c, err = NewClient(genDoc, &testProvider{blocks: forged})
+	assert.NoError(t, err)
+	assert.Error(t, c.VerifyTo(4))
+	assert.Equal(t, int64(1), c.Height())
+
+	// so is a block whose txs were swapped
+	forged = makeChain(t, 5, vals, privs, txs)
+	forged[1].Data.Txs = nil
+	c, err = NewClient(genDoc, &testProvider{blocks: forged})
+	assert.NoError(t, err)
+	assert.Error(t, c.VerifyTo(3))
+
+	// and a validator set diverging from the admin ops
+	vals[3] = vals[2].Copy()
+	vals[3].IncrementAccum(1)
+	c, err = NewClient(genDoc, &testProvider{blocks: makeChain(t, 5, vals, privs, txs)})
+	assert.NoError(t, err)
+	assert.Error(t, c.VerifyTo(3))
+	assert.Equal(t, int64(2), c.Height())
+
+	_, err = c.GetKey([]byte("key"), 1)
+	assert.Error(t, err)
+	_, err = c.GetAccount(common.Address{}, nil, 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,68 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightclient
+
+import (
+	"errors"
+	"fmt"
+
+	cl "github.com/dappledger/AnnChain/gemmill/rpc/client"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// Provider is the untrusted source of blocks and proofs, usually a full node
+type Provider interface {
+	LastHeight() (int64, error)
+	Block(height int64) (*gtypes.Block, error)
+	Query(query []byte) ([]byte, error)
+}
+
+type rpcProvider struct {
+	client *cl.ClientJSONRPC
+}
+
+// NewRPCProvider returns a Provider talking to the rpc server of a node, eg. tcp://127.0.0.1:46657
+func NewRPCProvider(remote string) Provider {
+	return &rpcProvider{client: cl.NewClientJSONRPC(remote)}
+}
+
+func (p *rpcProvider) LastHeight() (int64, error) {
+	res := new(gtypes.ResultLastHeight)
+	if _, err := p.client.Call("last_height", []interface{}{}, res); err != nil {
+		return 0, err
+	}
+	return res.LastHeight, nil
+}
+
+func (p *rpcProvider) Block(height int64) (*gtypes.Block, error) {
+	res := new(gtypes.ResultBlock)
+	if _, err := p.client.Call("block", []interface{}{height}, res); err != nil {
+		return nil, err
+	}
+	if res.Block == nil {
+		return nil, fmt.Errorf("block %d not found", height)
+	}
+	return res.Block, nil
+}
+
+func (p *rpcProvider) Query(query []byte) ([]byte, error) {
+	res := new(gtypes.ResultQuery)
+	if _, err := p.client.Call("query", []interface{}{query}, res); err != nil {
+		return nil, err
+	}
+	if !res.Result.IsOK() {
+		return nil, errors.New(res.Result.Log)
+	}
+	return res.Result.Data, nil
+}
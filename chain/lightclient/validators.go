@@ -0,0 +1,160 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package lightclient
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/dappledger/AnnChain/eth/accounts/abi"
+	ecore "github.com/dappledger/AnnChain/eth/core"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var adminMethod abi.Method
+
+func init() {
+	adminABI, err := abi.JSON(strings.NewReader(ecore.AdminABI))
+	if err != nil {
+		panic(err)
+	}
+	adminMethod = adminABI.Methods[ecore.AdminMethod]
+}
+
+// GenesisValidators returns the validator set of the first block, the same way the node builds its genesis state
+func GenesisValidators(genDoc *gtypes.GenesisDoc) (*gtypes.ValidatorSet, error) {
+	if len(genDoc.Validators) == 0 {
+		return nil, fmt.Errorf("the genesis has no validators")
+	}
+	validators := make([]*gtypes.Validator, len(genDoc.Validators))
+	for i, val := range genDoc.Validators {
+		validators[i] = &gtypes.Validator{
+			Address:     val.PubKey.Address(),
+			PubKey:      val.PubKey,
+			VotingPower: val.Amount,
+			IsCA:        val.IsCA,
+		}
+	}
+	return gtypes.NewValidatorSet(validators), nil
+}
+
+// nextValidators replays the validator changes made by the admin ops of block on vals,
+// the validators of block, and returns the validators of the next block
+func nextValidators(vals *gtypes.ValidatorSet, block *gtypes.Block) (*gtypes.ValidatorSet, error) {
+	next := vals.Copy()
+	for _, raw := range block.Data.Txs {
+		attr := validatorChange(vals, raw)
+		if attr == nil {
+			continue
+		}
+		if err := applyValidatorChange(next, attr); err != nil {
+			return nil, err
+		}
+	}
+	next.IncrementAccum(1)
+	return next, nil
+}
+
+// validatorChange extracts the validator change of an admin op tx, nil for other txs and admin ops the node would refuse
+func validatorChange(vals *gtypes.ValidatorSet, raw []byte) *gtypes.ValidatorAttr {
+	tx := new(etypes.Transaction)
+	if err := rlp.DecodeBytes(raw, tx); err != nil || tx.To() == nil || *tx.To() != ecore.AdminTo {
+		return nil
+	}
+	data := tx.Data()
+	if len(data) < 4 || !bytes.Equal(data[:4], adminMethod.Id()) {
+		return nil
+	}
+	args, err := adminMethod.Inputs.UnpackValues(data[4:])
+	if err != nil || len(args) != 1 {
+		return nil
+	}
+	payload, ok := args[0].([]byte)
+	if !ok || !gtypes.IsAdminOP(payload) {
+		return nil
+	}
+	cmd := &gtypes.AdminOPCmd{}
+	if err := json.Unmarshal(gtypes.UnwrapTx(payload), cmd); err != nil || cmd.CmdType != gtypes.AdminOpChangeValidator {
+		return nil
+	}
+	if !signedByMajor23(vals, cmd) {
+		return nil
+	}
+	attr := &gtypes.ValidatorAttr{}
+	if err := json.Unmarshal(cmd.Msg, attr); err != nil {
+		return nil
+	}
+
+	pubKey := crypto.SetNodePubkey(attr.PubKey)
+	_, val := vals.GetByAddress(pubKey.Address())
+	switch attr.Cmd {
+	case gtypes.ValidatorCmdAddPeer:
+		if val != nil || !pubKey.VerifyBytes(cmd.Msg, crypto.SetNodeSignature(cmd.SelfSign)) {
+			return nil
+		}
+	case gtypes.ValidatorCmdUpdateNode:
+		if val == nil || val.VotingPower == attr.Power {
+			return nil
+		}
+	case gtypes.ValidatorCmdRemoveNode:
+		if val == nil {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return attr
+}
+
+func signedByMajor23(vals *gtypes.ValidatorSet, cmd *gtypes.AdminOPCmd) bool {
+	var power int64
+	for _, sig := range cmd.SInfos {
+		pubKey := crypto.SetNodePubkey(sig.PubKey)
+		_, val := vals.GetByAddress(pubKey.Address())
+		if val != nil && val.VotingPower > 0 && pubKey.VerifyBytes(cmd.Msg, crypto.SetNodeSignature(sig.Signature)) {
+			power += val.VotingPower
+		}
+	}
+	return power > vals.TotalVotingPower()*2/3
+}
+
+func applyValidatorChange(vals *gtypes.ValidatorSet, attr *gtypes.ValidatorAttr) error {
+	pubKey := crypto.SetNodePubkey(attr.PubKey)
+	address := pubKey.Address()
+	switch attr.Cmd {
+	case gtypes.ValidatorCmdAddPeer, gtypes.ValidatorCmdUpdateNode:
+		_, val := vals.GetByAddress(address)
+		if val == nil {
+			if !vals.Add(gtypes.NewValidator(pubKey, attr.GetPower(), attr.GetIsCA())) {
+				return fmt.Errorf("failed to add validator %X", address)
+			}
+		} else if val.VotingPower != attr.GetPower() {
+			val.VotingPower = attr.GetPower()
+			val.IsCA = attr.GetIsCA()
+			if !vals.Update(val) {
+				return fmt.Errorf("failed to update validator %X", address)
+			}
+		}
+	case gtypes.ValidatorCmdRemoveNode:
+		if _, removed := vals.Remove(address); !removed {
+			return fmt.Errorf("failed to remove validator %X", address)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+var (
+	// KVStateAddress is the system account whose storage slot KVRootSlot holds the root of the kv trie,
+	// which folds the kv store into the state root used as AppHash
+	KVStateAddress = common.BytesToAddress([]byte("kvstore"))
+	KVRootSlot     = common.Hash{}
+)
+
+// KVProof is the result of QueryType_Key_Proof, it proves Value (empty if absent) of Key
+// in the state committed at Height, whose root is the AppHash of block Height+1
+type KVProof struct {
+	Key          []byte
+	Value        []byte
+	Height       uint64
+	StateRoot    common.Hash
+	KVRoot       common.Hash
+	AccountProof [][]byte // KVStateAddress in the state trie
+	StorageProof [][]byte // KVRootSlot in the storage trie of KVStateAddress
+	Proof        [][]byte // Key in the kv trie
+}
+
+// ProofRequest is the payload of QueryType_Proof, Height 0 stands for the latest state
+type ProofRequest struct {
+	Address common.Address
+	Keys    []common.Hash
+	Height  uint64
+}
+
+// StorageProof proves Value of the storage slot Key of an account
+type StorageProof struct {
+	Key   common.Hash
+	Value common.Hash
+	Proof [][]byte
+}
+
+// AccountProof is the result of QueryType_Proof, it proves an account and some of its storage
+// in the state committed at Height, whose root is the AppHash of block Height+1.
+// Missing accounts are proved absent and carry an empty code hash and storage root.
+type AccountProof struct {
+	Address       common.Address
+	Height        uint64
+	StateRoot     common.Hash
+	Nonce         uint64
+	Balance       *big.Int
+	CodeHash      common.Hash
+	StorageHash   common.Hash
+	AccountProof  [][]byte
+	StorageProofs []*StorageProof
+}
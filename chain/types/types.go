@@ -13,7 +13,13 @@
 
 package types
 
-import "github.com/dappledger/AnnChain/eth/common"
+import (
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	etypes "github.com/dappledger/AnnChain/eth/core/types"
+	"github.com/dappledger/AnnChain/eth/core/vm"
+)
 
 type (
 	// LastBlockInfo used for crash recover
@@ -37,6 +43,175 @@ type (
 
 	KVs []*KV
 
+	// KVOp is a single put or delete of a kv batch tx
+	KVOp struct {
+		Op    uint8
+		Key   []byte
+		Value []byte // empty for deletes
+	}
+
+	// KVBatch is the payload of KVBatchTxType txs, its ops are applied atomically and in order
+	KVBatch struct {
+		Ops []*KVOp
+	}
+
+	// CallMsg is the payload of QueryType_Call and QueryType_EstimateGas,
+	// an unsigned message executed against the state at Height (0 for latest)
+	CallMsg struct {
+		From     common.Address
+		To       []byte // empty for contract creation
+		Gas      uint64
+		GasPrice *big.Int
+		Value    *big.Int
+		Data     []byte
+		Height   uint64
+	}
+
+	// SimulateRequest is the payload of QueryType_Simulate. Tx, a signed rlp encoded tx, is run
+	// instead of Call when set, both on the state at Call.Height (0 for latest)
+	SimulateRequest struct {
+		Call CallMsg
+		Tx   []byte
+	}
+
+	// SimulateResult is the result of QueryType_Simulate, nothing of it is persisted
+	SimulateResult struct {
+		GasUsed      uint64
+		Failed       bool
+		ReturnData   []byte
+		RevertReason string // reason of a reverted execution, when given
+		Logs         []*etypes.Log
+		StateDiff    []*AccountDiff // sorted by address
+	}
+
+	// AccountDiff is the change of an account made by a simulated execution
+	AccountDiff struct {
+		Address       common.Address
+		Deleted       bool
+		BalanceBefore *big.Int
+		BalanceAfter  *big.Int
+		NonceBefore   uint64
+		NonceAfter    uint64
+		Code          []byte // new code, empty when unchanged
+		Storage       []*StorageDiff
+	}
+
+	// StorageDiff is the change of a storage slot
+	StorageDiff struct {
+		Key    common.Hash
+		Before common.Hash
+		After  common.Hash
+	}
+
+	// TraceRequest is the payload of QueryType_Trace. The tx at Index of the block at Height is
+	// re-executed on the state it was executed on, or Call when Height is 0
+	TraceRequest struct {
+		Height         uint64
+		Index          uint64
+		Call           CallMsg
+		Tracer         string // TracerStructLog or TracerCall
+		DisableStack   bool
+		DisableMemory  bool
+		DisableStorage bool
+	}
+
+	// TraceResult is the result of QueryType_Trace, StructLogs or CallTree is set by the tracer
+	TraceResult struct {
+		Gas         uint64
+		Failed      bool
+		ReturnValue []byte
+		StructLogs  []*StructLog
+		CallTree    *vm.CallFrame `rlp:"nil"`
+	}
+
+	// StructLog is the state of the evm before an opcode
+	StructLog struct {
+		Pc      uint64
+		Op      string
+		Gas     uint64
+		GasCost uint64
+		Depth   uint64
+		Error   string
+		Stack   []*big.Int
+		Memory  []byte
+		Storage []*StorageSlot // slots of the contract written so far, sorted by key
+	}
+
+	StorageSlot struct {
+		Key   common.Hash
+		Value common.Hash
+	}
+
+	// TxPoolAccount is the content of the tx pool for an account, QueryType_TxPool returns them
+	// sorted by address, of the account of a 20 bytes payload only
+	TxPoolAccount struct {
+		Address common.Address
+		Nonce   uint64 // nonce of the account in the state
+		Pending []*etypes.Transaction
+		Waiting []*WaitingTx
+	}
+
+	// WaitingTx is a pooled tx which can't be executed yet, and why
+	WaitingTx struct {
+		Tx     *etypes.Transaction
+		Reason string
+	}
+
+	// TxStatus is where a tx is in its lifecycle, QueryType_TxStatus returns it by tx hash
+	TxStatus struct {
+		Hash   common.Hash
+		Status string
+		Height uint64 // block of an included, failed or invalid tx
+		Index  uint64 // index of the tx in the block
+		Reason string // why the tx is invalid, waiting or dropped
+		Time   uint64 // unix nano of the last update
+	}
+
+	// TxFailure is why a tx of a committed block failed. A reverted tx changed the state it paid gas for,
+	// an invalid tx changed nothing and has a failure receipt using no gas.
+	TxFailure struct {
+		Height       uint64
+		Index        uint64
+		GasUsed      uint64
+		Error        string
+		RevertReason string // decoded from the Error(string) data of a revert
+		Invalid      bool
+	}
+
+	// ReceiptResult is the result of QueryType_Receipt with the QueryReceiptFailure suffix,
+	// Failure is nil for the successful txs
+	ReceiptResult struct {
+		Receipt []byte     // rlp encoded ReceiptForStorage
+		Failure *TxFailure `rlp:"nil"`
+	}
+
+	// LogFilter is the payload of QueryType_Logs, ToBlock 0 stands for the latest block.
+	// Addresses and the hashes at each position of Topics are alternatives, an empty list matches anything
+	LogFilter struct {
+		FromBlock uint64
+		ToBlock   uint64
+		Addresses []common.Address
+		Topics    [][]common.Hash
+	}
+
+	// BlockTx is a transaction of a block along with its sender and execution result
+	BlockTx struct {
+		Hash    common.Hash
+		Index   uint64
+		From    common.Address
+		Tx      *etypes.Transaction
+		Receipt []byte  // rlp encoded ReceiptForStorage, empty for kv txs
+		KVOps   []*KVOp // payload of kv and kv batch txs
+	}
+
+	// BlockTxs is the result of QueryType_BlockTxs for one block
+	BlockTxs struct {
+		Height uint64
+		Hash   []byte
+		Time   uint64
+		Txs    []*BlockTx
+	}
+
 	QueryType = byte
 )
 
@@ -55,6 +230,56 @@ const (
 	QueryType_Key_Prefix         QueryType = 12
 	QueryType_Pending_Nonce      QueryType = 13
 	QueryType_Key_Update_History QueryType = 14
+	QueryType_Code               QueryType = 15
+	QueryType_Storage            QueryType = 16
+	QueryType_Call               QueryType = 17
+	QueryType_EstimateGas        QueryType = 18
+	QueryType_Logs               QueryType = 19
+	QueryType_LogsBloom          QueryType = 20
+	QueryType_BlockTxs           QueryType = 21
+	QueryType_Key_At_Height      QueryType = 22
+	QueryType_Key_Proof          QueryType = 23
+	QueryType_Proof              QueryType = 24
+	QueryType_Simulate           QueryType = 25
+	QueryType_Trace              QueryType = 26
+	QueryType_TxPool             QueryType = 27
+	QueryType_TxStatus           QueryType = 28
 )
 
+const (
+	TracerStructLog = ""
+	TracerCall      = "callTracer"
+)
+
+// statuses of a tx, an included or failed tx keeps its status
+const (
+	TxStatusUnknown  = "unknown"
+	TxStatusReceived = "received"
+	TxStatusPending  = "pending"  // executable, it can be reaped into a block
+	TxStatusWaiting  = "waiting"  // queued until it becomes executable
+	TxStatusIncluded = "included" // committed and executed successfully
+	TxStatusFailed   = "failed"   // committed but its execution failed
+	TxStatusInvalid  = "invalid"  // in a committed block but rejected by the execution
+	TxStatusDropped  = "dropped"  // rejected, replaced or evicted by the pool
+)
+
+const (
+	KVOpPut    uint8 = 0
+	KVOpDelete uint8 = 1
+)
+
+// QueryHeightLen is the length of the optional big-endian height suffix
+// accepted by state queries
+const QueryHeightLen = 8
+
+// QueryReceiptFailure suffixes the tx hash of QueryType_Receipt to get a ReceiptResult
+// rather than the bare receipt
+const QueryReceiptFailure byte = 1
+
+// MaxBlockTxsRange bounds the number of blocks of a single QueryType_BlockTxs
+const MaxBlockTxsRange = 100
+
 var KVTxType = []byte("kvTx-")
+
+// KVBatchTxType prefixes the rlp encoded KVBatch of a kv batch tx
+var KVBatchTxType = []byte("kvBatchTx-")
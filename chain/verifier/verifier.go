@@ -0,0 +1,195 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package verifier checks block headers against validator signatures and
+// account, storage and kv proofs against the AppHash of verified headers.
+package verifier
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/eth/common"
+	estate "github.com/dappledger/AnnChain/eth/core/state"
+	"github.com/dappledger/AnnChain/eth/crypto"
+	"github.com/dappledger/AnnChain/eth/ethdb"
+	"github.com/dappledger/AnnChain/eth/rlp"
+	"github.com/dappledger/AnnChain/eth/trie"
+	gtypes "github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var (
+	// EmptyRoot is the root of an empty trie
+	EmptyRoot = common.HexToHash("56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421")
+	// EmptyCodeHash is the code hash of accounts without code
+	EmptyCodeHash = crypto.Keccak256Hash(nil)
+)
+
+// VerifyHeader checks that commit holds the signatures of more than 2/3 of vals for header
+func VerifyHeader(chainID string, vals *gtypes.ValidatorSet, header *gtypes.Header, commit *gtypes.Commit) error {
+	if header.ChainID != chainID {
+		return fmt.Errorf("wrong chain id %s, expected %s", header.ChainID, chainID)
+	}
+	if !bytes.Equal(commit.BlockID.Hash, header.Hash()) {
+		return fmt.Errorf("commit is for block %X, not %X", commit.BlockID.Hash, header.Hash())
+	}
+	return vals.VerifyCommit(chainID, commit.BlockID, header.Height, commit)
+}
+
+// StateRoot returns the root of the state committed at height header.Height-1
+func StateRoot(header *gtypes.Header) common.Hash {
+	if len(header.AppHash) == 0 {
+		return EmptyRoot
+	}
+	return common.BytesToHash(header.AppHash)
+}
+
+func checkHeight(proofHeight uint64, header *gtypes.Header) error {
+	if int64(proofHeight)+1 != header.Height {
+		return fmt.Errorf("proof of height %d can't be checked against header %d", proofHeight, header.Height)
+	}
+	return nil
+}
+
+// VerifyAccountWithHeader checks an account proof against the header of the block following it,
+// the header itself should have been checked with VerifyHeader
+func VerifyAccountWithHeader(header *gtypes.Header, proof *types.AccountProof) error {
+	if err := checkHeight(proof.Height, header); err != nil {
+		return err
+	}
+	return VerifyAccountProof(StateRoot(header), proof)
+}
+
+// VerifyKVWithHeader checks a kv proof against the header of the block following it,
+// the header itself should have been checked with VerifyHeader
+func VerifyKVWithHeader(header *gtypes.Header, proof *types.KVProof) error {
+	if err := checkHeight(proof.Height, header); err != nil {
+		return err
+	}
+	return VerifyKVProof(StateRoot(header), proof)
+}
+
+// VerifyAccountProof checks an account and its storage slots against a trusted state root
+func VerifyAccountProof(stateRoot common.Hash, proof *types.AccountProof) error {
+	if proof.StateRoot != stateRoot {
+		return fmt.Errorf("state root mismatch, proof %x, trusted %x", proof.StateRoot, stateRoot)
+	}
+	enc, err := verifyProof(stateRoot, crypto.Keccak256(proof.Address.Bytes()), proof.AccountProof)
+	if err != nil {
+		return fmt.Errorf("bad account proof: %v", err)
+	}
+	account := estate.Account{Root: EmptyRoot, CodeHash: EmptyCodeHash.Bytes()}
+	if len(enc) > 0 {
+		if err := rlp.DecodeBytes(enc, &account); err != nil {
+			return err
+		}
+	}
+	if account.Nonce != proof.Nonce ||
+		!equalBig(account.Balance, proof.Balance) ||
+		!bytes.Equal(account.CodeHash, proof.CodeHash.Bytes()) ||
+		account.Root != proof.StorageHash {
+		return fmt.Errorf("account %x doesn't match its proof", proof.Address)
+	}
+
+	for _, sp := range proof.StorageProofs {
+		var value common.Hash
+		if account.Root != EmptyRoot {
+			enc, err := verifyProof(account.Root, crypto.Keccak256(sp.Key.Bytes()), sp.Proof)
+			if err != nil {
+				return fmt.Errorf("bad storage proof of %x: %v", sp.Key, err)
+			}
+			if value, err = decodeSlot(enc); err != nil {
+				return err
+			}
+		}
+		if value != sp.Value {
+			return fmt.Errorf("storage %x doesn't match its proof", sp.Key)
+		}
+	}
+	return nil
+}
+
+// VerifyKVProof checks a key and its value (empty if absent) against a trusted state root
+func VerifyKVProof(stateRoot common.Hash, proof *types.KVProof) error {
+	if proof.StateRoot != stateRoot {
+		return fmt.Errorf("state root mismatch, proof %x, trusted %x", proof.StateRoot, stateRoot)
+	}
+	enc, err := verifyProof(stateRoot, crypto.Keccak256(types.KVStateAddress.Bytes()), proof.AccountProof)
+	if err != nil {
+		return fmt.Errorf("bad account proof: %v", err)
+	}
+	var kvRoot common.Hash
+	if len(enc) > 0 {
+		var account estate.Account
+		if err := rlp.DecodeBytes(enc, &account); err != nil {
+			return err
+		}
+		if enc, err = verifyProof(account.Root, crypto.Keccak256(types.KVRootSlot.Bytes()), proof.StorageProof); err != nil {
+			return fmt.Errorf("bad storage proof: %v", err)
+		}
+		if kvRoot, err = decodeSlot(enc); err != nil {
+			return err
+		}
+	}
+	if kvRoot != proof.KVRoot {
+		return fmt.Errorf("kv root mismatch, proof %x, proved %x", proof.KVRoot, kvRoot)
+	}
+	if kvRoot == (common.Hash{}) {
+		// no kv has ever been written
+		if len(proof.Value) != 0 {
+			return fmt.Errorf("value of %s not in an empty kv store", proof.Key)
+		}
+		return nil
+	}
+	value, err := verifyProof(kvRoot, crypto.Keccak256(proof.Key), proof.Proof)
+	if err != nil {
+		return fmt.Errorf("bad kv proof: %v", err)
+	}
+	if !bytes.Equal(value, proof.Value) {
+		return fmt.Errorf("value mismatch for key %s", proof.Key)
+	}
+	return nil
+}
+
+func verifyProof(root common.Hash, key []byte, proof [][]byte) ([]byte, error) {
+	db := ethdb.NewMemDatabase()
+	for _, node := range proof {
+		db.Put(crypto.Keccak256(node), node)
+	}
+	value, _, err := trie.VerifyProof(root, key, db)
+	return value, err
+}
+
+// decodeSlot decodes a storage trie value, slots are stored as rlp strings without leading zeros
+func decodeSlot(enc []byte) (common.Hash, error) {
+	if len(enc) == 0 {
+		return common.Hash{}, nil
+	}
+	_, content, _, err := rlp.Split(enc)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(content), nil
+}
+
+func equalBig(a, b *big.Int) bool {
+	if a == nil {
+		a = new(big.Int)
+	}
+	if b == nil {
+		b = new(big.Int)
+	}
+	return a.Cmp(b) == 0
+}
@@ -195,7 +195,7 @@ func adminContractCall(au *AdminOPUser) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	waptx := etypes.NewTransaction(au.Nonce, core.AdminTo, big.NewInt(0), gasLimit, big.NewInt(0), calldata)
+	waptx := etypes.NewTransaction(au.Nonce, core.AdminTo, big.NewInt(0), commons.GasLimit, txGasPrice(), calldata)
 	if !bytes.Equal(calldata, waptx.Data()) {
 		return "", nil
 	}
@@ -37,9 +37,6 @@ import (
 )
 
 var (
-	// gasLimit is the amount we will borrow from gas pool
-	gasLimit = uint64(90000000000)
-
 	//ContractCommands defines a more git-like subcommand system
 	EVMCommands = cli.Command{
 		Name:     "evm",
@@ -121,7 +118,7 @@ func createContract(ctx *cli.Context) error {
 		bytecode = append(bytecode, data...)
 	}
 
-	tx := etypes.NewContractCreation(nonce, big.NewInt(0), gasLimit, big.NewInt(0), bytecode)
+	tx := etypes.NewContractCreation(nonce, big.NewInt(0), commons.GasLimit, txGasPrice(), bytecode)
 
 	key, err := requireAccPrivky(ctx)
 	if err != nil {
@@ -190,7 +187,7 @@ func callContract(ctx *cli.Context) error {
 	nonce := ctx.Uint64("nonce")
 	to := common.HexToAddress(contractAddress)
 
-	tx := etypes.NewTransaction(nonce, to, big.NewInt(0), gasLimit, big.NewInt(0), data)
+	tx := etypes.NewTransaction(nonce, to, big.NewInt(0), commons.GasLimit, txGasPrice(), data)
 
 	key, err := requireAccPrivky(ctx)
 	if err != nil {
@@ -252,7 +249,7 @@ func readContract(ctx *cli.Context) error {
 	nonce := ctx.Uint64("nonce")
 	to := common.HexToAddress(contractAddress)
 
-	tx := etypes.NewTransaction(nonce, to, big.NewInt(0), gasLimit, big.NewInt(0), data)
+	tx := etypes.NewTransaction(nonce, to, big.NewInt(0), commons.GasLimit, txGasPrice(), data)
 
 	key, err := requireAccPrivky(ctx)
 	if err != nil {
@@ -376,7 +373,7 @@ func existContract(ctx *cli.Context) error {
 	data := common.Hex2Bytes(bytecode)
 	contractAddr := common.HexToAddress(gcmn.SanitizeHex(contractAddress))
 
-	tx := etypes.NewTransaction(0, contractAddr, big.NewInt(0), gasLimit, big.NewInt(0), crypto.Keccak256(data))
+	tx := etypes.NewTransaction(0, contractAddr, big.NewInt(0), commons.GasLimit, big.NewInt(0), crypto.Keccak256(data))
 
 	key, err := requireAccPrivky(ctx)
 	if err != nil {
@@ -492,8 +489,21 @@ func bytesN2Slice(value interface{}, m int) ([]byte, error) {
 	return nil, fmt.Errorf("type(bytes%d) not support", m)
 }
 
+// TxSigner is the signer of the txs sent to the network of --chainid
+func TxSigner() etypes.Signer {
+	if commons.LegacyTx {
+		return etypes.HomesteadSigner{}
+	}
+	return etypes.NewEIP155Signer(new(big.Int).SetUint64(commons.ChainID))
+}
+
+// txGasPrice is the gas price of the txs, set by --gasprice
+func txGasPrice() *big.Int {
+	return new(big.Int).SetUint64(commons.GasPrice)
+}
+
 func SignTx(privBytes []byte, tx *etypes.Transaction) (signer etypes.Signer, sig []byte, err error) {
-	signer = new(etypes.HomesteadSigner)
+	signer = TxSigner()
 
 	privkey, err := crypto.ToECDSA(privBytes)
 	if err != nil {
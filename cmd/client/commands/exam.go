@@ -109,7 +109,7 @@ func transferToAddress(addr common.Address, nonce uint64) {
 
 	clientJSON := cl.NewClientJSONRPC(commons.QueryServer)
 
-	tx := types.NewTransaction(nonce, addr, big.NewInt(10000), gasLimit, big.NewInt(0), []byte{})
+	tx := types.NewTransaction(nonce, addr, big.NewInt(10000), commons.GasLimit, txGasPrice(), []byte{})
 
 	key := "a8971729fbc199fb3459529cebcd8704791fc699d88ac89284f23ff8e7fca7d6"
 
@@ -41,6 +41,10 @@ type AnntoolFlags struct {
 	nPrivs,
 	pageNum,
 	pageSize,
+	minHeight,
+	maxHeight,
+	height,
+	proof,
 	codeHash cli.Flag
 }
 
@@ -129,4 +133,19 @@ var anntoolFlags = AnntoolFlags{
 	privateKey: cli.StringFlag{
 		Name: "priv_key",
 	},
+	minHeight: cli.Int64Flag{
+		Name: "min_height",
+	},
+	maxHeight: cli.Int64Flag{
+		Name:  "max_height",
+		Usage: "defaults to min_height",
+	},
+	height: cli.Uint64Flag{
+		Name:  "height",
+		Usage: "read the value as of this block height",
+	},
+	proof: cli.BoolFlag{
+		Name:  "proof",
+		Usage: "return the value with its merkle proof against the app hash",
+	},
 }
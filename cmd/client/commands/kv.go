@@ -21,6 +21,7 @@ import (
 	"strings"
 
 	rtypes "github.com/dappledger/AnnChain/chain/types"
+	"github.com/dappledger/AnnChain/chain/verifier"
 	"github.com/dappledger/AnnChain/eth/common"
 	"github.com/dappledger/AnnChain/eth/core/types"
 	"gopkg.in/urfave/cli.v1"
@@ -49,6 +50,27 @@ var (
 		Flags: []cli.Flag{
 			anntoolFlags.pageNum,
 			anntoolFlags.pageSize,
+			anntoolFlags.height,
+			anntoolFlags.proof,
+		},
+	}
+	KvDeleteCommands = cli.Command{
+		Name:     "delete",
+		Usage:    "operations for delete keys",
+		Category: "delete",
+		Action:   deleteKeys,
+		Flags: []cli.Flag{
+			anntoolFlags.privateKey,
+		},
+	}
+	KvBatchCommands = cli.Command{
+		Name:      "batch",
+		Usage:     "apply puts and deletes atomically",
+		ArgsUsage: "put <key> <value> | delete <key> ...",
+		Category:  "batch",
+		Action:    batchKeyValue,
+		Flags: []cli.Flag{
+			anntoolFlags.privateKey,
 		},
 	}
 )
@@ -61,8 +83,16 @@ func getKeyValue(ctx *cli.Context) error {
 	if pageNum != 0 {
 		return queryKeyUpdateHistory(ctx)
 	}
+	if ctx.Bool("proof") {
+		return queryKeyProof(ctx)
+	}
 
 	query := append([]byte{rtypes.QueryType_Key}, []byte(keyStr)...)
+	if ctx.IsSet("height") {
+		heightBytes := make([]byte, rtypes.QueryHeightLen)
+		binary.BigEndian.PutUint64(heightBytes, ctx.Uint64("height"))
+		query = append(append([]byte{rtypes.QueryType_Key_At_Height}, heightBytes...), []byte(keyStr)...)
+	}
 
 	_, err := clientJSON.Call("query", []interface{}{query}, rpcResult)
 	if err != nil {
@@ -73,13 +103,66 @@ func getKeyValue(ctx *cli.Context) error {
 }
 
 func putKeyValue(ctx *cli.Context) error {
-	clientJSON := cl.NewClientJSONRPC(commons.QueryServer)
-	rpcResult := new(gtypes.ResultBroadcastTxCommit)
 	if ctx.NArg() < 2 {
 		return cli.NewExitError(fmt.Errorf("need key and value"), 127)
 	}
-	keyStr := ctx.Args().First()
-	valueStr := ctx.Args().Get(1)
+	kvBytes, err := rlp.EncodeToBytes(&rtypes.KV{Key: []byte(ctx.Args().First()), Value: []byte(ctx.Args().Get(1))})
+	if err != nil {
+		return err
+	}
+	return sendKVTx(ctx, append(rtypes.KVTxType, kvBytes...))
+}
+
+func deleteKeys(ctx *cli.Context) error {
+	if ctx.NArg() < 1 {
+		return cli.NewExitError(fmt.Errorf("need keys"), 127)
+	}
+	batch := &rtypes.KVBatch{}
+	for _, key := range ctx.Args() {
+		batch.Ops = append(batch.Ops, &rtypes.KVOp{Op: rtypes.KVOpDelete, Key: []byte(key)})
+	}
+	return sendKVBatch(ctx, batch)
+}
+
+func batchKeyValue(ctx *cli.Context) error {
+	args := ctx.Args()
+	batch := &rtypes.KVBatch{}
+	for i := 0; i < len(args); {
+		switch args[i] {
+		case "put":
+			if i+2 >= len(args) {
+				return cli.NewExitError(fmt.Errorf("put needs key and value"), 127)
+			}
+			batch.Ops = append(batch.Ops, &rtypes.KVOp{Op: rtypes.KVOpPut, Key: []byte(args[i+1]), Value: []byte(args[i+2])})
+			i += 3
+		case "delete":
+			if i+1 >= len(args) {
+				return cli.NewExitError(fmt.Errorf("delete needs key"), 127)
+			}
+			batch.Ops = append(batch.Ops, &rtypes.KVOp{Op: rtypes.KVOpDelete, Key: []byte(args[i+1])})
+			i += 2
+		default:
+			return cli.NewExitError(fmt.Errorf("unknown operation %s, should be put or delete", args[i]), 127)
+		}
+	}
+	if len(batch.Ops) == 0 {
+		return cli.NewExitError(fmt.Errorf("need operations"), 127)
+	}
+	return sendKVBatch(ctx, batch)
+}
+
+func sendKVBatch(ctx *cli.Context, batch *rtypes.KVBatch) error {
+	batchBytes, err := rlp.EncodeToBytes(batch)
+	if err != nil {
+		return err
+	}
+	return sendKVTx(ctx, append(rtypes.KVBatchTxType, batchBytes...))
+}
+
+// sendKVTx signs txdata with the priv_key flag and broadcasts it
+func sendKVTx(ctx *cli.Context, txdata []byte) error {
+	clientJSON := cl.NewClientJSONRPC(commons.QueryServer)
+	rpcResult := new(gtypes.ResultBroadcastTxCommit)
 	privkey := ctx.String("priv_key")
 	if privkey == "" {
 		return cli.NewExitError("privkey is required", 127)
@@ -97,13 +180,7 @@ func putKeyValue(ctx *cli.Context) error {
 	}
 
 	nonce, _ := getNonce(addr)
-	kvBytes, err := rlp.EncodeToBytes(&rtypes.KV{Key: []byte(keyStr), Value: []byte(valueStr)})
-	if err != nil {
-		return err
-	}
-
-	txdata := append(rtypes.KVTxType, kvBytes...)
-	tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(0), gasLimit, big.NewInt(0), txdata)
+	tx := types.NewTransaction(nonce, common.Address{}, big.NewInt(0), commons.GasLimit, big.NewInt(0), txdata)
 	signer, sig, err := SignTx(privBytes, tx)
 	if err != nil {
 		return err
@@ -168,6 +245,38 @@ func queryKeyUpdateHistory(ctx *cli.Context) error {
 	return nil
 }
 
+func queryKeyProof(ctx *cli.Context) error {
+	clientJSON := cl.NewClientJSONRPC(commons.QueryServer)
+	rpcResult := new(gtypes.ResultQuery)
+	heightBytes := make([]byte, rtypes.QueryHeightLen)
+	binary.BigEndian.PutUint64(heightBytes, ctx.Uint64("height"))
+	query := append(append([]byte{rtypes.QueryType_Key_Proof}, heightBytes...), []byte(ctx.Args().First())...)
+
+	_, err := clientJSON.Call("query", []interface{}{query}, rpcResult)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 127)
+	}
+
+	proof := &rtypes.KVProof{}
+	if err = rlp.DecodeBytes(rpcResult.Result.Data, proof); err != nil {
+		fmt.Println(rpcResult.Result)
+		return cli.NewExitError(err.Error(), 127)
+	}
+	// the proof is only checked for consistency here, light clients verify it against the app hash of block Height+1
+	if err = verifier.VerifyKVProof(proof.StateRoot, proof); err != nil {
+		return cli.NewExitError(err.Error(), 127)
+	}
+
+	responseJSON, err := json.Marshal(proof)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 127)
+	}
+
+	fmt.Println("query result:", string(responseJSON))
+
+	return nil
+}
+
 func putUint32(i uint32) []byte {
 	index := make([]byte, 4)
 	binary.BigEndian.PutUint32(index, i)
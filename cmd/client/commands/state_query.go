@@ -20,6 +20,7 @@ import (
 
 	"gopkg.in/urfave/cli.v1"
 
+	ctypes "github.com/dappledger/AnnChain/chain/types"
 	"github.com/dappledger/AnnChain/cmd/client/commons"
 	"github.com/dappledger/AnnChain/eth/common"
 	"github.com/dappledger/AnnChain/eth/core/types"
@@ -59,6 +60,15 @@ var (
 					anntoolFlags.hash,
 				},
 			},
+			{
+				Name:   "block_txs",
+				Usage:  "list the transactions and receipts of blocks",
+				Action: queryBlockTxs,
+				Flags: []cli.Flag{
+					anntoolFlags.minHeight,
+					anntoolFlags.maxHeight,
+				},
+			},
 		},
 	}
 )
@@ -135,8 +145,8 @@ func queryReceipt(ctx *cli.Context) error {
 		return cli.NewExitError(err.Error(), 127)
 	}
 
-	ethSigner := &types.HomesteadSigner{}
-	from, err := types.Sender(ethSigner, etx)
+	// recovers legacy senders too
+	from, err := types.Sender(types.NewEIP155Signer(etx.ChainId()), etx)
 	if err != nil {
 		return cli.NewExitError(err.Error(), 127)
 	}
@@ -168,6 +178,79 @@ func queryReceipt(ctx *cli.Context) error {
 	return nil
 }
 
+func queryBlockTxs(ctx *cli.Context) error {
+	clientJSON := cl.NewClientJSONRPC(commons.QueryServer)
+	rpcResult := new(gtypes.ResultQuery)
+
+	_, err := clientJSON.Call("block_txs", []interface{}{ctx.Int64("min_height"), ctx.Int64("max_height")}, rpcResult)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 127)
+	}
+	if rpcResult.Result.Code != gtypes.CodeType_OK {
+		return cli.NewExitError(rpcResult.Result.Log, 127)
+	}
+
+	var blocks []*ctypes.BlockTxs
+	if err = rlp.DecodeBytes(rpcResult.Result.Data, &blocks); err != nil {
+		return cli.NewExitError(err.Error(), 127)
+	}
+
+	response := make([]map[string]interface{}, 0, len(blocks))
+	for _, b := range blocks {
+		txs := make([]map[string]interface{}, 0, len(b.Txs))
+		for _, btx := range b.Txs {
+			tx := map[string]interface{}{
+				"hash":             btx.Hash.Hex(),
+				"transactionIndex": btx.Index,
+				"from":             btx.From.Hex(),
+				"to":               btx.Tx.To(),
+				"nonce":            btx.Tx.Nonce(),
+				"value":            btx.Tx.Value(),
+				"input":            fmt.Sprintf("0x%x", btx.Tx.Data()),
+			}
+			if len(btx.Receipt) > 0 {
+				receipt := new(types.ReceiptForStorage)
+				if err = rlp.DecodeBytes(btx.Receipt, receipt); err != nil {
+					return cli.NewExitError(err.Error(), 127)
+				}
+				tx["receipt"] = map[string]interface{}{
+					"status":          fmt.Sprintf("0x%x", receipt.Status),
+					"gasUsed":         receipt.GasUsed,
+					"contractAddress": receipt.ContractAddress,
+					"logs":            receipt.Logs,
+				}
+			}
+			if len(btx.KVOps) > 0 {
+				kvs := make([]map[string]string, len(btx.KVOps))
+				for i, op := range btx.KVOps {
+					kvs[i] = map[string]string{"key": string(op.Key), "value": string(op.Value)}
+					if op.Op == ctypes.KVOpDelete {
+						kvs[i]["op"] = "delete"
+					} else {
+						kvs[i]["op"] = "put"
+					}
+				}
+				tx["kvs"] = kvs
+			}
+			txs = append(txs, tx)
+		}
+		response = append(response, map[string]interface{}{
+			"blockNumber":  b.Height,
+			"blockHash":    fmt.Sprintf("0x%x", b.Hash),
+			"timestamp":    b.Time,
+			"transactions": txs,
+		})
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 127)
+	}
+
+	fmt.Println("query result:", string(responseJSON))
+	return nil
+}
+
 func getTxByHash(hash []byte) (rt *gtypes.ResultTransaction, ethtx *types.Transaction, err error) {
 	res := new(gtypes.ResultQuery)
 	clientJSON := cl.NewClientJSONRPC(commons.QueryServer)
@@ -51,8 +51,7 @@ var (
 		Flags:  []cli.Flag{},
 	}
 
-	nonceMap  = make(map[common.Address]uint64)
-	ethSigner = types.HomesteadSigner{}
+	nonceMap = make(map[common.Address]uint64)
 )
 
 func newPrivkey(n int64) *ecdsa.PrivateKey {
@@ -104,7 +103,8 @@ func transComplement(start int64, serial int64, n int64, times int64) {
 			nonceMap[meAddress] = nonce
 		}
 
-		tx := types.NewTransaction(nonce, to, big.NewInt(1), gasLimit, big.NewInt(0), []byte{})
+		tx := types.NewTransaction(nonce, to, big.NewInt(1), commons.GasLimit, txGasPrice(), []byte{})
+		ethSigner := TxSigner()
 		sig, err := crypto.Sign(ethSigner.Hash(tx).Bytes(), privkey)
 		if err != nil {
 			panic(err)
@@ -147,7 +147,8 @@ func benchAnnCoin(ctx *cli.Context) error {
 }
 
 func transferAnnCoin(fromAddr common.Address, toAddr common.Address, privkey *ecdsa.PrivateKey, clientJSON *cl.ClientJSONRPC, nonce uint64) {
-	tx := types.NewTransaction(nonce, toAddr, big.NewInt(1), gasLimit, big.NewInt(0), []byte{})
+	tx := types.NewTransaction(nonce, toAddr, big.NewInt(1), commons.GasLimit, txGasPrice(), []byte{})
+	ethSigner := TxSigner()
 	sig, err := crypto.Sign(ethSigner.Hash(tx).Bytes(), privkey)
 	if err != nil {
 		panic(err)
@@ -68,7 +68,7 @@ func sendTx(ctx *cli.Context) error {
 
 	data := []byte(payload)
 
-	tx := types.NewTransaction(nonce, to, big.NewInt(value), gasLimit, big.NewInt(0), data)
+	tx := types.NewTransaction(nonce, to, big.NewInt(value), commons.GasLimit, txGasPrice(), data)
 
 	key, err := requireAccPrivky(ctx)
 	if err != nil {
@@ -16,4 +16,8 @@ package commons
 var (
 	QueryServer = "tcp://localhost:46657"
 	CallMode    = "sync"
+	ChainID     = uint64(1) // evm chain id of the EIP-155 signatures, the one of chains without app_state by default
+	LegacyTx    = false     // sign txs without replay protection
+	GasLimit    = uint64(90000000000)
+	GasPrice    = uint64(0)
 )
@@ -43,6 +43,8 @@ func main() {
 		commands.VersionCommands,
 		commands.KvGetCommands,
 		commands.KvPutCommands,
+		commands.KvDeleteCommands,
+		commands.KvBatchCommands,
 	}
 
 	app.Flags = []cli.Flag{
@@ -58,6 +60,28 @@ func main() {
 			Destination: &commons.QueryServer,
 			Usage:       "rpc address of the node",
 		},
+		cli.Uint64Flag{
+			Name:        "chainid",
+			Value:       1,
+			Destination: &commons.ChainID,
+			Usage:       "evm chain id of the network, txs are signed for it only",
+		},
+		cli.BoolFlag{
+			Name:        "legacy",
+			Destination: &commons.LegacyTx,
+			Usage:       "sign txs without EIP-155 replay protection",
+		},
+		cli.Uint64Flag{
+			Name:        "gas",
+			Value:       commons.GasLimit,
+			Destination: &commons.GasLimit,
+			Usage:       "gas limit of the txs",
+		},
+		cli.Uint64Flag{
+			Name:        "gasprice",
+			Destination: &commons.GasPrice,
+			Usage:       "gas price of the txs, 0 unless the chain charges fees",
+		},
 	}
 
 	app.Before = func(ctx *cli.Context) error {
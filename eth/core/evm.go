@@ -33,7 +33,13 @@ type ChainContext interface {
 
 // NewEVMContext creates a new context for use in the EVM.
 func NewEVMContext(msg Message, header *types.Header, chain ChainContext, author *common.Address) vm.Context {
+	// Edit by zhongan, the fees go to the coinbase of the header when there's no author
 	var beneficiary common.Address
+	if author == nil {
+		beneficiary = header.Coinbase
+	} else {
+		beneficiary = *author
+	}
 
 	return vm.Context{
 		CanTransfer: CanTransfer,
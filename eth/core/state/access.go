@@ -0,0 +1,185 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"bytes"
+	"math/big"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+// Access is what a speculative execution read from the state it started on and what it wrote,
+// it lets the execution be merged into another state holding the same values.
+type Access struct {
+	accounts map[common.Address]*Account // nil for the accounts which didn't exist
+	storage  map[common.Address]map[common.Hash]common.Hash
+
+	writes   map[common.Address]*accountWrite
+	aborted  bool // the execution can't be merged, it must be run again
+	recreate bool // an existing account was created again, its storage is reset
+}
+
+type accountWrite struct {
+	deleted bool
+	nonce   uint64
+	balance *big.Int
+	code    []byte
+	dirty   bool // code is set
+	storage Storage
+}
+
+func newAccess() *Access {
+	return &Access{
+		accounts: make(map[common.Address]*Account),
+		storage:  make(map[common.Address]map[common.Hash]common.Hash),
+		writes:   make(map[common.Address]*accountWrite),
+	}
+}
+
+// RecordAccess makes self record the accesses of the following executions, self
+// must be fresh, the state of the last commit
+func (self *StateDB) RecordAccess() *Access {
+	self.access = newAccess()
+	return self.access
+}
+
+// AbortSpeculation reports whether self records its access, the recording is then
+// marked unmergeable. Side effects out of the state can't run speculatively, they call it.
+func (self *StateDB) AbortSpeculation() bool {
+	if self.access == nil {
+		return false
+	}
+	self.access.aborted = true
+	return true
+}
+
+func (a *Access) readAccount(addr common.Address, data *Account) {
+	if _, ok := a.accounts[addr]; ok {
+		return
+	}
+	if data != nil {
+		copied := *data
+		copied.Balance = new(big.Int).Set(data.Balance)
+		data = &copied
+	}
+	a.accounts[addr] = data
+}
+
+func (a *Access) readStorage(addr common.Address, key, value common.Hash) {
+	slots := a.storage[addr]
+	if slots == nil {
+		slots = make(map[common.Hash]common.Hash)
+		a.storage[addr] = slots
+	}
+	if _, ok := slots[key]; !ok {
+		slots[key] = value
+	}
+}
+
+// write records obj as finalised, before its dirty storage is flushed
+func (a *Access) write(obj *stateObject, deleted bool) {
+	w := a.writes[obj.address]
+	if w == nil || deleted {
+		w = &accountWrite{storage: make(Storage)}
+		a.writes[obj.address] = w
+	}
+	w.deleted = deleted
+	if deleted {
+		return
+	}
+	w.nonce = obj.data.Nonce
+	w.balance = new(big.Int).Set(obj.data.Balance)
+	if obj.dirtyCode {
+		w.code, w.dirty = obj.code, true
+	}
+	for key, value := range obj.dirtyStorage {
+		w.storage[key] = value
+	}
+}
+
+// Readable reports whether the values read by the execution of a are still the values of self,
+// an aborted execution read nothing usable
+func (self *StateDB) Readable(a *Access) bool {
+	if a.aborted {
+		return false
+	}
+	for addr, data := range a.accounts {
+		obj := self.getStateObject(addr)
+		if data == nil || obj == nil {
+			if data != nil || obj != nil {
+				return false
+			}
+			continue
+		}
+		if obj.data.Nonce != data.Nonce || obj.data.Balance.Cmp(data.Balance) != 0 || !bytes.Equal(obj.data.CodeHash, data.CodeHash) {
+			return false
+		}
+	}
+	for addr, slots := range a.storage {
+		obj := self.getStateObject(addr)
+		for key, value := range slots {
+			current := common.Hash{}
+			if obj != nil {
+				current = obj.GetState(self.db, key)
+			}
+			if current != value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Merge writes the changes of the execution of a into self, which must be Readable. It reports
+// false, changing nothing, when the changes can't be merged.
+func (self *StateDB) Merge(a *Access) bool {
+	if a.aborted || a.recreate {
+		return false
+	}
+	for addr, w := range a.writes {
+		// removing an existing account isn't merged, one made and removed leaves nothing
+		if w.deleted && a.accounts[addr] != nil {
+			return false
+		}
+	}
+	for addr, w := range a.writes {
+		if w.deleted {
+			continue
+		}
+		obj := self.GetOrNewStateObject(addr)
+		obj.SetNonce(w.nonce)
+		obj.SetBalance(new(big.Int).Set(w.balance))
+		if w.dirty {
+			self.SetCode(addr, w.code)
+		}
+		for key, value := range w.storage {
+			obj.SetState(self.db, key, value)
+		}
+	}
+	return true
+}
+
+// ForEachWrite calls fn with the final values of each account written by the recorded execution,
+// code is nil when unchanged and storage holds the written slots only
+func (a *Access) ForEachWrite(fn func(addr common.Address, deleted bool, nonce uint64, balance *big.Int, code []byte, storage Storage)) {
+	for addr, w := range a.writes {
+		var code []byte
+		if w.dirty {
+			code = w.code
+		}
+		fn(addr, w.deleted, w.nonce, w.balance, code, w.storage)
+	}
+}
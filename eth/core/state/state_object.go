@@ -191,6 +191,9 @@ func (self *stateObject) GetCommittedState(db Database, key common.Hash) common.
 		value.SetBytes(content)
 	}
 	self.originStorage[key] = value
+	if self.db.access != nil {
+		self.db.access.readStorage(self.address, key, value)
+	}
 	return value
 }
 
@@ -86,6 +86,9 @@ type StateDB struct {
 	journal        *journal
 	validRevisions []revision
 	nextRevisionId int
+
+	// Edit by zhongan
+	access *Access // accesses of a speculative execution, nil when not recorded
 }
 
 // Create a new state from a given trie.
@@ -416,6 +419,9 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 	enc, err := self.trie.TryGet(addr[:])
 	if len(enc) == 0 {
 		self.setError(err)
+		if self.access != nil {
+			self.access.readAccount(addr, nil)
+		}
 		return nil
 	}
 	var data Account
@@ -423,6 +429,9 @@ func (self *StateDB) getStateObject(addr common.Address) (stateObject *stateObje
 		log.Error("Failed to decode state object", "addr", addr, "err", err)
 		return nil
 	}
+	if self.access != nil {
+		self.access.readAccount(addr, &data)
+	}
 	// Insert into the live set.
 	obj := newObject(self, addr, data)
 	self.setStateObject(obj)
@@ -448,6 +457,9 @@ func (self *StateDB) createObject(addr common.Address) (newobj, prev *stateObjec
 	prev = self.getStateObject(addr)
 	newobj = newObject(self, addr, Account{})
 	newobj.setNonce(0) // sets the object to dirty
+	if prev != nil && self.access != nil {
+		self.access.recreate = true
+	}
 	if prev == nil {
 		self.journal.append(createObjectChange{account: &addr})
 	} else {
@@ -583,7 +595,11 @@ func (s *StateDB) Finalise(deleteEmptyObjects bool) {
 			continue
 		}
 
-		if stateObject.suicided || (deleteEmptyObjects && stateObject.empty()) {
+		deleted := stateObject.suicided || (deleteEmptyObjects && stateObject.empty())
+		if s.access != nil {
+			s.access.write(stateObject, deleted)
+		}
+		if deleted {
 			s.deleteStateObject(stateObject)
 		} else {
 			stateObject.updateRoot(s.db)
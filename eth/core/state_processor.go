@@ -36,7 +36,8 @@ var Big1 = big.NewInt(1)
 // for the transaction, gas used and an error if the transaction failed,
 // indicating the block was invalid.
 func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *common.Address, gp *GasPool, statedb *state.StateDB, header *types.Header, tx *types.Transaction, usedGas *uint64, cfg vm.Config) (*types.Receipt, uint64, error) {
-	msg, err := tx.AsMessage(types.MakeSigner(config, header.Number))
+	// Edit by zhongan, txs may be EIP-155 signed whatever the fork heights
+	msg, err := tx.AsMessage(types.NewEIP155Signer(config.ChainID))
 	if err != nil {
 		return nil, 0, err
 	}
@@ -47,7 +48,9 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	vmenv := vm.NewEVM(context, statedb, config, cfg)
 
 	// Apply the transaction to the current state (included in the env)
-	_, gas, failed, err := ApplyMessage(vmenv, msg, gp)
+	// Edit by zhongan, the output and the error of a failed tx are kept in its receipt
+	st := NewStateTransition(vmenv, msg, gp)
+	ret, gas, failed, err := st.TransitionDb()
 	if err != nil {
 		return nil, 0, err
 	}
@@ -71,6 +74,10 @@ func ApplyTransaction(config *params.ChainConfig, bc ChainContext, author *commo
 	receipt.TxHash = common.BytesToHash(gtypes.Tx(txBytes).Hash())
 
 	receipt.GasUsed = gas
+	// Edit by zhongan
+	if failed {
+		receipt.ReturnData, receipt.VMErr = ret, st.vmerr
+	}
 	// if the transaction created a contract, store the creation address in the receipt.
 	if msg.To() == nil {
 		receipt.ContractAddress = crypto.CreateAddress(vmenv.Context.Origin, tx.Nonce())
@@ -58,6 +58,9 @@ type StateTransition struct {
 	data       []byte
 	state      vm.StateDB
 	evm        *vm.EVM
+
+	// Edit by zhongan, the error of a failed execution, which TransitionDb only reports as failed
+	vmerr error
 }
 
 // Message represents a message sent to a contract.
@@ -221,6 +224,8 @@ func (st *StateTransition) TransitionDb() (ret []byte, usedGas uint64, failed bo
 			return nil, 0, false, vmerr
 		}
 	}
+	// Edit by zhongan
+	st.vmerr = vmerr
 	st.refundGas()
 	st.state.AddBalance(st.evm.Coinbase, new(big.Int).Mul(new(big.Int).SetUint64(st.gasUsed()), st.gasPrice))
 
@@ -59,6 +59,10 @@ type Receipt struct {
 	TxHash          common.Hash    `json:"transactionHash" gencodec:"required"`
 	ContractAddress common.Address `json:"contractAddress"`
 	GasUsed         uint64         `json:"gasUsed" gencodec:"required"`
+
+	// Edit by zhongan, the output and the vm error of a failed tx, neither is encoded
+	ReturnData []byte `json:"-"`
+	VMErr      error  `json:"-"`
 }
 
 type receiptMarshaling struct {
@@ -0,0 +1,132 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package vm
+
+import (
+	"math/big"
+	"time"
+
+	"github.com/dappledger/AnnChain/eth/common"
+)
+
+// CallTracer is a Tracer told of the calls and creates made by contracts too,
+// the outermost one is still reported by CaptureStart and CaptureEnd.
+type CallTracer interface {
+	Tracer
+	CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int)
+	CaptureExit(output []byte, gasUsed uint64, err error)
+}
+
+// callTracer is the tracer to tell of a nested call, nil if there is none
+func (evm *EVM) callTracer() CallTracer {
+	if !evm.vmConfig.Debug || evm.depth == 0 {
+		return nil
+	}
+	tracer, _ := evm.vmConfig.Tracer.(CallTracer)
+	return tracer
+}
+
+// CallFrame is a call or a create and the ones it made
+type CallFrame struct {
+	Type    string
+	From    common.Address
+	To      common.Address
+	Value   *big.Int
+	Gas     uint64
+	GasUsed uint64
+	Input   []byte
+	Output  []byte
+	Error   string
+	Calls   []*CallFrame
+}
+
+// CallTreeTracer records the tree of the calls of an execution
+type CallTreeTracer struct {
+	root  *CallFrame
+	stack []*CallFrame
+}
+
+func NewCallTreeTracer() *CallTreeTracer {
+	return &CallTreeTracer{}
+}
+
+func (t *CallTreeTracer) CaptureStart(from common.Address, to common.Address, create bool, input []byte, gas uint64, value *big.Int) error {
+	typ := CALL
+	if create {
+		typ = CREATE
+	}
+	t.root = newCallFrame(typ, from, to, input, gas, value)
+	t.stack = []*CallFrame{t.root}
+	return nil
+}
+
+func (t *CallTreeTracer) CaptureState(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *CallTreeTracer) CaptureFault(env *EVM, pc uint64, op OpCode, gas, cost uint64, memory *Memory, stack *Stack, contract *Contract, depth int, err error) error {
+	return nil
+}
+
+func (t *CallTreeTracer) CaptureEnd(output []byte, gasUsed uint64, d time.Duration, err error) error {
+	if t.root != nil {
+		t.root.end(output, gasUsed, err)
+	}
+	t.stack = nil
+	return nil
+}
+
+func (t *CallTreeTracer) CaptureEnter(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) {
+	if len(t.stack) == 0 {
+		return
+	}
+	frame := newCallFrame(typ, from, to, input, gas, value)
+	parent := t.stack[len(t.stack)-1]
+	parent.Calls = append(parent.Calls, frame)
+	t.stack = append(t.stack, frame)
+}
+
+func (t *CallTreeTracer) CaptureExit(output []byte, gasUsed uint64, err error) {
+	if len(t.stack) < 2 {
+		return
+	}
+	t.stack[len(t.stack)-1].end(output, gasUsed, err)
+	t.stack = t.stack[:len(t.stack)-1]
+}
+
+// Result is the outermost call, nil if nothing was traced
+func (t *CallTreeTracer) Result() *CallFrame { return t.root }
+
+func newCallFrame(typ OpCode, from common.Address, to common.Address, input []byte, gas uint64, value *big.Int) *CallFrame {
+	frame := &CallFrame{
+		Type:  typ.String(),
+		From:  from,
+		To:    to,
+		Gas:   gas,
+		Input: common.CopyBytes(input),
+	}
+	if value != nil {
+		frame.Value = new(big.Int).Set(value)
+	}
+	return frame
+}
+
+func (f *CallFrame) end(output []byte, gasUsed uint64, err error) {
+	f.Output = common.CopyBytes(output)
+	f.GasUsed = gasUsed
+	if err != nil {
+		f.Error = err.Error()
+	}
+}
@@ -107,7 +107,20 @@ func (c *AdminOP)SetState(s StateDB){
 	c.state = s
 }
 
+// speculativeState is the state of an execution ahead of its turn, which can't run side effects
+type speculativeState interface {
+	AbortSpeculation() bool
+}
+
 func (c *AdminOP) Run(input []byte) ([]byte, error) {
+	return c.RunWithState(c.state, input)
+}
+
+// RunWithState runs the admin op on state, unlike Run it is safe for concurrent executions
+func (c *AdminOP) RunWithState(state StateDB, input []byte) ([]byte, error) {
+	if s, ok := state.(speculativeState); ok && s.AbortSpeculation() {
+		return nil, ErrSpeculativeAdminOp
+	}
 	//[$len + $arg]
 	dlen := new(big.Int).SetBytes(input[:32]).Uint64()
 	offset := dlen + 32
@@ -117,7 +130,7 @@ func (c *AdminOP) Run(input []byte) ([]byte, error) {
 	from := input[32:32+20]
 	data := input[32+20:offset]
 	app := &AdminDBApp{
-		c.state,
+		state,
 		from,
 	}
 	if c.callback != nil {
@@ -18,6 +18,7 @@ import (
 	"bytes"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
@@ -25,12 +26,13 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dappledger/AnnChain/gemmill/rpc/server"
 
 	"github.com/dappledger/AnnChain/gemmill/consensus/pbft"
-	"github.com/dappledger/AnnChain/gemmill/consensus/raft"
+	_ "github.com/dappledger/AnnChain/gemmill/consensus/raft"
 
 	"go.uber.org/zap"
 
@@ -52,9 +54,9 @@ import (
 	"github.com/dappledger/AnnChain/gemmill/plugin"
 	"github.com/dappledger/AnnChain/gemmill/refuse_list"
 	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/statesync"
 	"github.com/dappledger/AnnChain/gemmill/trace"
 	"github.com/dappledger/AnnChain/gemmill/types"
-	"github.com/dappledger/AnnChain/gemmill/utils/zip"
 )
 
 const version = "0.9.0"
@@ -73,10 +75,14 @@ type Angine struct {
 	dbs           map[string]dbm.DB
 	privValidator *types.PrivValidator
 	blockstore    *blockchain.BlockStore
+	bcReactor     *blockchain.BlockchainReactor
+	snapshots     *statesync.Store
+	snapshotting  int32
 	dataArchive   *archive.Archive
 	conf          *viper.Viper
 	txPool        types.TxPool
 	consensus     consensus.Engine
+	consensusName string
 	traceRouter   *trace.Router
 	stateMachine  *state.State
 	p2pSwitch     *p2p.Switch
@@ -174,7 +180,12 @@ func NewAngine(app types.Application, tune *Tunes) (angine *Angine, err error) {
 	}
 
 	p2pListener := p2psw.Listeners()[0]
-	dataArchive := archive.NewArchive(dbBackend, dbDir, conf.GetInt64("threshold_blocks"))
+	archiveClient, err := archive.NewClient(conf)
+	if err != nil {
+		err = fmt.Errorf("archive client error: %v", err)
+		return nil, err
+	}
+	dataArchive := archive.NewArchive(dbBackend, dbDir, conf.GetInt64("threshold_blocks"), archiveClient)
 	eventSwitch := types.NewEventSwitch()
 	angine = &Angine{
 		Tune: tune,
@@ -205,6 +216,11 @@ func NewAngine(app types.Application, tune *Tunes) (angine *Angine, err error) {
 	return
 }
 
+// EventSwitch returns the switch consensus and execution events are fired on
+func (a *Angine) EventSwitch() types.EventSwitch {
+	return *a.eventSwitch
+}
+
 func (a *Angine) APIs() []map[string]*server.RPCFunc {
 	return a.apis
 }
@@ -229,15 +245,28 @@ func (a *Angine) OnRecvExchangeData(data *p2p.ExchangeData) error {
 			return err
 		}
 		a.p2pSwitch.GetExchangeData().GenesisJSON = data.GenesisJSON
+		// the app reads its genesis state from app_state, which a state loaded from the db misses
+		a.genesis = otherGenesis
 		if err = a.buildState(otherGenesis); err != nil {
 			// TODO log err
 			log.Warn("build state err:",  zap.Error(err))
+			a.genesis = nil
 			return err
 		}
 		if a.stateMachine == nil {
+			a.genesis = nil
 			return errors.New("state generaterr")
 		}
+		// the app makes its genesis state from the genesis it couldn't have before
+		if err = a.app.Start(); err != nil {
+			log.Warn("start app err:", zap.Error(err))
+			return err
+		}
 		a.p2pSwitch.Start()
+		if err = a.startConsensus(); err != nil {
+			log.Warn("start consensus err:", zap.Error(err))
+			return err
+		}
 	}
 	return nil
 }
@@ -285,7 +314,9 @@ func (ang *Angine) assembleStateMachine(stateM *state.State) {
 
 	blockStore := blockchain.NewBlockStore(ang.dbs["blockstore"], ang.dbs["archive"])
 	_, stateLastHeight, _ := stateM.GetLastBlockInfo()
-	bcReactor := blockchain.NewBlockchainReactor(conf, stateLastHeight, blockStore, fastSync, ang.dataArchive)
+	// a state synced node fast-syncs once the snapshot is restored
+	stateSync := fastSync && stateSyncable(conf, ang.app, stateLastHeight, blockStore)
+	bcReactor := blockchain.NewBlockchainReactor(conf, stateLastHeight, blockStore, fastSync && !stateSync, ang.dataArchive)
 	var txPool types.TxPool
 	if txPoolApp, isType := ang.app.(types.TxPoolApplication); isType {
 		log.Info("app implemented tx pool")
@@ -296,36 +327,35 @@ func (ang *Angine) assembleStateMachine(stateM *state.State) {
 	}
 	memReactor := mempool.NewTxReactor(conf, txPool)
 
-	var consensusEngine consensus.Engine
-
-	if conf.Get("consensus") == "raft" {
-
-		consensusState, err := raft.NewConsensusState(conf, *ang.eventSwitch, blockStore, stateM, txPool, ang.privValidator)
-		if err != nil {
-			log.Fatal("assembleStateMachine with raft err", zap.Error(err))
-		}
-		consensusEngine = consensusState
-
-		bcReactor.SetBlockVerifier(func(bID types.BlockID, h int64, lc *types.Commit) error { return nil })
-
-		ang.apis = append(ang.apis, consensusState.NewPublicAPI().API())
-
+	engineName := conf.GetString("consensus")
+	if engineName == "" {
+		engineName = consensus.DefaultEngine
+	}
+	consensusEngine, err := consensus.New(engineName, &consensus.Context{
+		Conf:           conf,
+		EventSwitch:    *ang.eventSwitch,
+		State:          stateM,
+		BlockStore:     blockStore,
+		TxPool:         txPool,
+		PrivValidator:  ang.privValidator,
+		App:            ang.app,
+		Switch:         ang.p2pSwitch,
+		FastSync:       fastSync || stateSync,
+		CommittedState: func() *state.State { return ang.stateMachine },
+	})
+	if err != nil {
+		log.Fatal("assembleStateMachine with consensus engine err", zap.String("engine", engineName), zap.Error(err))
+	}
+	if verifier, ok := consensusEngine.(consensus.CommitVerifier); ok {
+		bcReactor.SetBlockVerifier(verifier.VerifyCommit)
 	} else {
-
-		consensusState := pbft.NewConsensusState(conf, stateM, blockStore, txPool)
-		consensusState.SetPrivValidator(ang.privValidator)
-
-		consensusReactor := pbft.NewConsensusReactor(consensusState, fastSync)
-		consensusState.BindReactor(consensusReactor)
-		ang.p2pSwitch.AddReactor("CONSENSUS", consensusReactor)
-		setEventSwitch(*ang.eventSwitch, bcReactor, memReactor, consensusReactor)
-
-		consensusEngine = consensusState
-
 		bcReactor.SetBlockVerifier(func(bID types.BlockID, h int64, lc *types.Commit) error {
 			return stateM.Validators.VerifyCommit(stateM.ChainID, bID, h, lc)
 		})
 	}
+	if provider, ok := consensusEngine.(consensus.APIProvider); ok {
+		ang.apis = append(ang.apis, provider.API())
+	}
 
 	bcReactor.SetBlockExecuter(func(blk *types.Block, pst *types.PartSet, c *types.Commit) error {
 		blockStore.SaveBlock(blk, pst, c)
@@ -334,12 +364,16 @@ func (ang *Angine) assembleStateMachine(stateM *state.State) {
 			return err
 		}
 		stateM.Save()
+		ang.takeSnapshot(stateM)
 		log.Debug("save to db", zap.Int64("height", blk.Height), zap.String("state receiptHash", fmt.Sprintf("%X", stateM.ReceiptsHash)), zap.String("block receiptHash", fmt.Sprintf("%X", blk.ReceiptsHash)))
 		return nil
 	})
 
 	ang.p2pSwitch.AddReactor("MEMPOOL", memReactor)
 	ang.p2pSwitch.AddReactor("BLOCKCHAIN", bcReactor)
+	if snapApp, ok := ang.app.(types.SnapshotApplication); ok {
+		ang.setupStateSync(conf, snapApp, stateM, blockStore, bcReactor, stateSync)
+	}
 
 	var addrBook *p2p.AddrBook
 	if conf.GetBool("pex_reactor") {
@@ -355,11 +389,15 @@ func (ang *Angine) assembleStateMachine(stateM *state.State) {
 	setEventSwitch(*ang.eventSwitch, bcReactor, memReactor, consensusEngine)
 
 	ang.blockstore = blockStore
+	ang.bcReactor = bcReactor
 	ang.consensus = consensusEngine
+	ang.consensusName = engineName
 	ang.txPool = txPool
 
 	ang.stateMachine = stateM
-	ang.genesis = stateM.GenesisDoc
+	if ang.genesis == nil {
+		ang.genesis = stateM.GenesisDoc
+	}
 	ang.addrBook = addrBook
 	ang.stateMachine.SetBlockExecutable(ang)
 	ang.stateMachine.SetBlockVerifier(consensusEngine)
@@ -505,6 +543,15 @@ func (e *Angine) GetBlock(height int64) (block *types.Block, meta *types.BlockMe
 	return
 }
 
+// GetBlockHeight returns the height of the block with the given hash
+func (e *Angine) GetBlockHeight(hash []byte) (int64, error) {
+	height := e.blockstore.LoadBlockHeight(hash)
+	if height == 0 {
+		return 0, fmt.Errorf("block %X not found", hash)
+	}
+	return height, nil
+}
+
 func (e *Angine) GetBlockMeta(height int64) (meta *types.BlockMeta, err error) {
 
 	if height == 0 {
@@ -531,24 +578,7 @@ func (e *Angine) GetBlockMeta(height int64) (meta *types.BlockMeta, err error) {
 }
 
 func (e *Angine) newArchiveDB(height int64) (archiveDB dbm.DB, err error) {
-
-	fileHash := string(e.dataArchive.QueryFileHash(height))
-	archiveDir := e.conf.GetString("db_archive_dir")
-	_, err = os.Stat(filepath.Join(archiveDir, fileHash+".zip"))
-	if err != nil {
-		// download archive data zip
-		err = e.dataArchive.Client.DownloadFile(fileHash, filepath.Join(archiveDir, fileHash+".zip"))
-		if err != nil {
-			return
-		}
-		err = zip.Decompress(filepath.Join(archiveDir, fileHash+".zip"), filepath.Join(archiveDir, fileHash+".db"))
-		if err != nil {
-			return
-		}
-	}
-
-	archiveDB = dbm.NewDB(fileHash, e.conf.GetString("db_backend"), archiveDir)
-	return
+	return e.dataArchive.OpenDB(height, e.conf.GetString("db_archive_dir"), e.conf.GetString("db_backend"))
 }
 
 func (e *Angine) NoneGenesis() bool {
@@ -575,6 +605,11 @@ func (e *Angine) Start() error {
 		log.Warn("fail to start event switch, error: ", zap.Error(err))
 		return err
 	}
+	if e.stateMachine != nil {
+		if err := e.startConsensus(); err != nil {
+			return err
+		}
+	}
 
 	e.started = true
 	seeds := e.tune.Conf.GetString("seeds")
@@ -585,8 +620,24 @@ func (e *Angine) Start() error {
 	return nil
 }
 
+// startConsensus starts the engines that aren't driven by the reactors of the switch
+func (e *Angine) startConsensus() error {
+	if lc, ok := e.consensus.(consensus.Lifecycle); ok {
+		return lc.StartEngine()
+	}
+	return nil
+}
+
+// ConsensusEngine returns the name of the consensus engine in use
+func (e *Angine) ConsensusEngine() string {
+	return e.consensusName
+}
+
 // Stop just wrap around swtich.Stop, which will stop reactors, listeners,etc
 func (ang *Angine) Stop() bool {
+	if lc, ok := ang.consensus.(consensus.Lifecycle); ok {
+		lc.StopEngine()
+	}
 	ret := ang.p2pSwitch.Stop()
 	ang.Destroy()
 	return ret
@@ -740,6 +791,10 @@ func (ang *Angine) QueryTransaction(load []byte) (interface{}, error) {
 			if err != nil {
 				return nil, fmt.Errorf("[Angine Query] fail to get block:%v", err)
 			}
+			// the dev consensus may have reverted the block and sealed another at its height
+			if !bytes.Equal(block.Hash(), info.BlockHash) {
+				return nil, errors.New("not found")
+			}
 
 			if int(info.Index) >= len(block.Txs) {
 				return nil, fmt.Errorf("[Angine Query] fail to get block, invalid tx index")
@@ -791,12 +846,15 @@ func (e *Angine) RecoverFromCrash(appHash []byte, appBlockHeight int64) error {
 		// NOTE: If ABCI allowed rollbacks, we could just replay the
 		// block even though it's been committed
 		stateAppHash := e.stateMachine.AppHash
-		lastBlockAppHash := e.blockstore.LoadBlock(storeBlockHeight).AppHash
-
 		if bytes.Equal(stateAppHash, appHash) {
 			// we're all synced up
 			log.Debug("RelpayBlocks: Already synced")
-		} else if bytes.Equal(stateAppHash, lastBlockAppHash) {
+			return nil
+		}
+		// a node restored from a snapshot has no block at its first height, it is synced up though
+		lastBlockAppHash := e.blockstore.LoadBlock(storeBlockHeight).AppHash
+
+		if bytes.Equal(stateAppHash, lastBlockAppHash) {
 			// we crashed after commit and before saving state,
 			// so load the intermediate state and update the hash
 			e.stateMachine.LoadIntermediate()
@@ -915,6 +973,7 @@ func (ang *Angine) ExecBlock(block *types.Block, eventFireable events.Fireable,
 
 // plugins modify changedValidators inplace
 func (ang *Angine) EndBlock(block *types.Block, eventFireable events.Fireable, blockPartsHeader *types.PartSetHeader, changedValAttrs []*types.ValidatorAttr, nextVS *types.ValidatorSet) error {
+	punished := ang.punish(block, nextVS)
 	params := &plugin.EndBlockParams{
 		Block:             block,
 		ChangedValidators: changedValAttrs,
@@ -927,9 +986,35 @@ func (ang *Angine) EndBlock(block *types.Block, eventFireable events.Fireable, b
 		}
 	}
 
+	// only the blocks committing evidence change validators here, after their admin ops,
+	// the blocks made before evidence replay to the same validators
+	var changes []*types.ValidatorAttr
+	for _, v := range punished {
+		// a validator removed by an admin op of the block stays removed
+		if _, val := nextVS.GetByAddress(crypto.SetNodePubkey(v.PubKey).Address()); val != nil {
+			changes = append(changes, v)
+		}
+	}
+	if len(changes) > 0 {
+		return plugin.UpdateValidators(nextVS, changes)
+	}
 	return nil
 }
 
+// punish returns the changes of validators the app makes for the evidence committed by the block
+func (ang *Angine) punish(block *types.Block, validators *types.ValidatorSet) []*types.ValidatorAttr {
+	evidence, err := block.Evidence()
+	if err != nil || len(evidence) == 0 {
+		return nil
+	}
+	app, ok := ang.app.(types.PunishApplication)
+	if !ok {
+		log.Warn("evidence committed, but the app doesn't punish validators", zap.Int64("height", block.Height), zap.Int("evidence", len(evidence)))
+		return nil
+	}
+	return app.Punish(block.Height, evidence, validators.Copy())
+}
+
 func setEventSwitch(evsw types.EventSwitch, eventables ...types.Eventable) {
 	for _, e := range eventables {
 		e.SetEventSwitch(evsw)
@@ -1023,6 +1108,87 @@ func (ang *Angine) InitPlugins() {
 //UpdateStateMachine
 func (ang *Angine) UpdateStateMachine(s *state.State) {
 	ang.stateMachine = s
+	ang.takeSnapshot(s)
+}
+
+// stateSyncable tells if the node should restore its state from a snapshot rather than replay the chain
+func stateSyncable(conf *viper.Viper, app types.Application, stateHeight int64, blockStore *blockchain.BlockStore) bool {
+	if !conf.GetBool("state_sync") || stateHeight != 0 || blockStore.Height() != 0 {
+		return false
+	}
+	if _, ok := app.(types.SnapshotApplication); !ok {
+		log.Warn("state_sync is ignored, the app can't restore snapshots")
+		return false
+	}
+	return true
+}
+
+// setupStateSync serves the snapshots taken every snapshot_interval blocks to peers and, when restore is set,
+// restores the node from a peer snapshot verified against the trusted block state_sync_trust_height
+func (ang *Angine) setupStateSync(conf *viper.Viper, app types.SnapshotApplication, stateM *state.State, blockStore *blockchain.BlockStore, bcReactor *blockchain.BlockchainReactor, restore bool) {
+	var err error
+	if conf.GetInt64("snapshot_interval") > 0 {
+		if conf.GetInt("snapshot_chunk_size") <= 0 || conf.GetInt("snapshot_chunk_size") > statesync.MaxChunkSize {
+			log.Fatal("invalid snapshot_chunk_size", zap.Int("snapshot_chunk_size", conf.GetInt("snapshot_chunk_size")))
+		}
+		if ang.snapshots, err = statesync.NewStore(conf.GetString("snapshot_dir")); err != nil {
+			log.Fatal("open snapshot store", zap.Error(err))
+		}
+	}
+	reactor := statesync.NewReactor(ang.snapshots, blockStore)
+	if restore {
+		trustHeight := conf.GetInt64("state_sync_trust_height")
+		trustHash, err := hex.DecodeString(conf.GetString("state_sync_trust_hash"))
+		if err != nil || len(trustHash) == 0 || trustHeight < 2 {
+			log.Fatal("state_sync needs state_sync_trust_height, a snapshot height plus one, and state_sync_trust_hash, the hash of that block")
+		}
+		reactor.SetRestorer(&statesync.Restorer{
+			ChainID:       stateM.ChainID,
+			TrustHeight:   trustHeight,
+			TrustHash:     trustHash,
+			DiscoveryTime: time.Duration(conf.GetInt64("state_sync_discovery_time")) * time.Second,
+			Dir:           filepath.Join(conf.GetString("snapshot_dir"), "restore"),
+			Restore: func(st *state.State, commit *types.Commit, r io.Reader) error {
+				if err := app.RestoreSnapshot(st.LastBlockHeight, st.AppHash, r); err != nil {
+					return err
+				}
+				blockStore.RestoreBase(st.LastBlockHeight, commit)
+				stateM.Restore(st)
+				log.Info("restored state from snapshot", zap.Int64("height", st.LastBlockHeight))
+				return bcReactor.StartFastSync(st.LastBlockHeight)
+			},
+		})
+	}
+	ang.p2pSwitch.AddReactor("STATESYNC", reactor)
+}
+
+// takeSnapshot snapshots the app in the background when s, a committed state, is at a multiple of snapshot_interval
+func (ang *Angine) takeSnapshot(s *state.State) {
+	interval := ang.conf.GetInt64("snapshot_interval")
+	if ang.snapshots == nil || interval <= 0 || s.LastBlockHeight%interval != 0 {
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&ang.snapshotting, 0, 1) {
+		log.Warn("skip snapshot, the previous one is still running", zap.Int64("height", s.LastBlockHeight))
+		return
+	}
+	app := ang.app.(types.SnapshotApplication)
+	height, appHash, stateBytes := s.LastBlockHeight, s.AppHash, s.Bytes()
+	go func() {
+		defer atomic.StoreInt32(&ang.snapshotting, 0)
+		start := time.Now()
+		snapshot, err := ang.snapshots.Create(height, stateBytes, ang.conf.GetInt("snapshot_chunk_size"), func(w io.Writer) error {
+			return app.ExportSnapshot(height, appHash, w)
+		})
+		if err != nil {
+			log.Error("take snapshot", zap.Int64("height", height), zap.Error(err))
+			return
+		}
+		log.Info("took snapshot", zap.Int64("height", height), zap.Int("chunks", len(snapshot.ChunkHashes)), zap.Duration("taken", time.Since(start)))
+		if err := ang.snapshots.Prune(ang.conf.GetInt("snapshot_keep_recent")); err != nil {
+			log.Warn("prune snapshots", zap.Error(err))
+		}
+	}()
 }
 
 func ensureQueryDB(dbDir string) (*dbm.GoLevelDB, error) {
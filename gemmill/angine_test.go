@@ -0,0 +1,77 @@
+package gemmill
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/eth/common"
+	"github.com/dappledger/AnnChain/gemmill/config"
+	"github.com/dappledger/AnnChain/gemmill/p2p"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// genesisApp records the genesis it's started with
+type genesisApp struct {
+	core    types.Core
+	genesis *types.GenesisDoc
+}
+
+func (app *genesisApp) GetAngineHooks() types.Hooks { return types.Hooks{} }
+func (app *genesisApp) CompatibleWithAngine()       {}
+func (app *genesisApp) CheckTx([]byte) (common.Address, uint64, error) {
+	return common.Address{}, 0, nil
+}
+func (app *genesisApp) Query([]byte) types.Result { return types.Result{} }
+func (app *genesisApp) Info() types.ResultInfo    { return types.ResultInfo{} }
+func (app *genesisApp) SetCore(core types.Core)   { app.core = core }
+func (app *genesisApp) Stop()                     {}
+func (app *genesisApp) Start() error {
+	app.genesis = app.core.Genesis()
+	return nil
+}
+
+func TestJoinByExchangeData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "angine")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	conf := config.DefaultConfig()
+	conf.Set("p2p_laddr", "tcp://127.0.0.1:0")
+	conf.Set("auth_by_ca", false)
+	conf.Set("log_path", filepath.Join(dir, "log"))
+	conf.Set("audit_log_path", filepath.Join(dir, "audit.log"))
+	assert.NoError(t, config.InitRuntime(dir, "test", conf))
+
+	// the joining node has no genesis of its own
+	genesis, err := ioutil.ReadFile(conf.GetString("genesis_file"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(conf.GetString("genesis_file")))
+
+	app := &genesisApp{}
+	ang, err := NewAngine(app, &Tunes{Runtime: dir, Conf: conf})
+	assert.NoError(t, err)
+	app.SetCore(ang)
+	assert.True(t, ang.NoneGenesis())
+
+	genDoc, err := types.GenesisDocFromJSONRet(genesis)
+	assert.NoError(t, err)
+	genDoc.GenesisTime = time.Now()
+	genDoc.AppState, err = json.Marshal(map[string]interface{}{
+		"alloc": map[string]interface{}{"0x0000000000000000000000000000000000000001": map[string]string{"balance": "1000"}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, ang.OnRecvExchangeData(&p2p.ExchangeData{GenesisJSON: genDoc.JSONBytes()}))
+	defer ang.Stop()
+
+	// the app is started with the genesis of the network, app_state included
+	if assert.NotNil(t, app.genesis) {
+		assert.Equal(t, genDoc.ChainID, app.genesis.ChainID)
+		assert.JSONEq(t, string(genDoc.AppState), string(app.genesis.AppState))
+	}
+	assert.Equal(t, app.genesis, ang.Genesis())
+}
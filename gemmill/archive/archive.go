@@ -15,8 +15,11 @@ package archive
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/utils/zip"
 )
 
 type Archive struct {
@@ -25,6 +28,7 @@ type Archive struct {
 	Client    ArchiveClient
 }
 
+// ArchiveClient stores archive files, a file is named by the hex sha256 of its content
 type ArchiveClient interface {
 	UploadFile(filepath string) (fileHash string, err error)
 	DownloadFile(fileHash, filepath string) (err error)
@@ -32,28 +36,76 @@ type ArchiveClient interface {
 
 var dbName = "archive"
 
-func NewArchive(dbBackend, dbDir string, threshold int64) *Archive {
+// NewArchive returns an archive of threshold blocks long ranges stored by client, which may be nil when blocks aren't archived
+func NewArchive(dbBackend, dbDir string, threshold int64, client ArchiveClient) *Archive {
 	archiveDB := dbm.NewDB(dbName, dbBackend, dbDir)
 	return &Archive{
 		db:        archiveDB,
 		Threshold: threshold,
-		// client implement ArchiveClient
-		//	Client:    client,
+		Client:    client,
 	}
 }
 
+// RangeOf returns the range of archived blocks holding height
+func (ar *Archive) RangeOf(height int64) (from, to int64) {
+	from = (height-1)/ar.Threshold*ar.Threshold + 1
+	return from, from - 1 + ar.Threshold
+}
+
 func (ar *Archive) QueryFileHash(height int64) (ret []byte) {
-	origin := (height-1)/ar.Threshold*ar.Threshold + 1
-	key := fmt.Sprintf("%d_%d", origin, origin-1+ar.Threshold)
+	from, to := ar.RangeOf(height)
+	key := fmt.Sprintf("%d_%d", from, to)
 	ret = ar.db.Get([]byte(key))
 	return
 }
 
-func (ar *Archive) AddItem(originHeight int64, value string) {
-	key := fmt.Sprintf("%d_%d", originHeight+1, originHeight+ar.Threshold)
+// AddItem records value, the file hash, for the range of blocks ending at toHeight
+func (ar *Archive) AddItem(toHeight int64, value string) {
+	from, to := ar.RangeOf(toHeight)
+	key := fmt.Sprintf("%d_%d", from, to)
 	ar.db.SetSync([]byte(key), []byte(value))
 }
 
+// OpenDB opens the archived block store holding height, it is downloaded into dir and checked against its hash when missing
+func (ar *Archive) OpenDB(height int64, dir, dbBackend string) (dbm.DB, error) {
+	if ar.Client == nil || ar.Threshold <= 0 {
+		return nil, fmt.Errorf("block %d is not kept by this node", height)
+	}
+	fileHash := string(ar.QueryFileHash(height))
+	if fileHash == "" {
+		return nil, fmt.Errorf("block %d is not archived", height)
+	}
+	if _, err := os.Stat(filepath.Join(dir, fileHash+".db")); err != nil {
+		zipFile := filepath.Join(dir, fileHash+".zip")
+		if err := ar.Download(fileHash, zipFile); err != nil {
+			return nil, err
+		}
+		defer os.Remove(zipFile)
+		if err := zip.Decompress(zipFile, filepath.Join(dir, fileHash+".db")); err != nil {
+			os.RemoveAll(filepath.Join(dir, fileHash+".db"))
+			return nil, err
+		}
+	}
+	return dbm.NewDB(fileHash, dbBackend, dir), nil
+}
+
+// Download fetches the file of fileHash into path, failing if its content doesn't match the hash
+func (ar *Archive) Download(fileHash, path string) error {
+	tmp := path + ".download"
+	defer os.Remove(tmp)
+	if err := ar.Client.DownloadFile(fileHash, tmp); err != nil {
+		return err
+	}
+	hash, err := FileHash(tmp)
+	if err != nil {
+		return err
+	}
+	if hash != fileHash {
+		return fmt.Errorf("archive file %s is corrupted, its hash is %s", fileHash, hash)
+	}
+	return os.Rename(tmp, path)
+}
+
 func (ar *Archive) Close() {
 	ar.db.Close()
 }
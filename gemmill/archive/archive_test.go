@@ -0,0 +1,125 @@
+package archive
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/utils/zip"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeS3 stands in for an S3 compatible store, keeping objects in memory
+type fakeS3 struct {
+	mtx     sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("Authorization"), s3Algorithm+" Credential=key/") || r.Header.Get("x-amz-date") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		data, _ := ioutil.ReadAll(r.Body)
+		s.objects[r.URL.Path] = data
+	case http.MethodGet:
+		data, ok := s.objects[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func TestS3SigningKey(t *testing.T) {
+	// example of the AWS signature v4 documentation
+	key := s3SigningKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	assert.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
+
+func TestClients(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	assert.NoError(t, ioutil.WriteFile(src, []byte("blocks"), 0600))
+
+	local, err := NewLocalClient(filepath.Join(dir, "local"))
+	assert.NoError(t, err)
+	s3 := &fakeS3{objects: make(map[string][]byte)}
+	server := httptest.NewServer(s3)
+	defer server.Close()
+	remote, err := NewS3Client(server.URL, "", "bucket", "key", "secret")
+	assert.NoError(t, err)
+
+	for _, client := range []ArchiveClient{local, remote} {
+		fileHash, err := client.UploadFile(src)
+		assert.NoError(t, err)
+		assert.Len(t, fileHash, 64)
+
+		dst := filepath.Join(dir, "dst")
+		assert.NoError(t, client.DownloadFile(fileHash, dst))
+		data, err := ioutil.ReadFile(dst)
+		assert.NoError(t, err)
+		assert.Equal(t, "blocks", string(data))
+		assert.Error(t, client.DownloadFile("missing", dst))
+	}
+	hash, err := FileHash(src)
+	assert.NoError(t, err)
+	assert.Contains(t, s3.objects, "/bucket/"+hash)
+
+	remote.secretKey, remote.accessKey = "", "other"
+	_, err = remote.UploadFile(src)
+	assert.Error(t, err)
+}
+
+func TestOpenDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	client, err := NewLocalClient(filepath.Join(dir, "files"))
+	assert.NoError(t, err)
+	ar := NewArchive("goleveldb", dir, 10, client)
+	defer ar.Close()
+
+	// an archived range, as made by the blockchain reactor
+	storeDir := filepath.Join(dir, "store")
+	db := dbm.NewDB("blockstore", "goleveldb", storeDir)
+	db.SetSync([]byte("block"), []byte("15"))
+	db.Close()
+	assert.NoError(t, zip.CompressDir(filepath.Join(storeDir, "blockstore.db")))
+	fileHash, err := client.UploadFile(filepath.Join(storeDir, "blockstore.db.zip"))
+	assert.NoError(t, err)
+	ar.AddItem(20, fileHash)
+
+	from, to := ar.RangeOf(15)
+	assert.Equal(t, int64(11), from)
+	assert.Equal(t, int64(20), to)
+	_, err = ar.OpenDB(25, filepath.Join(dir, "cache"), "goleveldb")
+	assert.Error(t, err, "not archived")
+
+	cacheDir := filepath.Join(dir, "cache")
+	assert.NoError(t, os.MkdirAll(cacheDir, 0700))
+	archived, err := ar.OpenDB(15, cacheDir, "goleveldb")
+	assert.NoError(t, err)
+	assert.Equal(t, "15", string(archived.Get([]byte("block"))))
+	archived.Close()
+
+	// a corrupted file is refused
+	assert.NoError(t, os.RemoveAll(filepath.Join(cacheDir, fileHash+".db")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "files", fileHash), []byte("corrupted"), 0600))
+	_, err = ar.OpenDB(15, cacheDir, "goleveldb")
+	assert.Error(t, err)
+}
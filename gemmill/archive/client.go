@@ -0,0 +1,112 @@
+// Copyright © 2017 ZhongAn Technology
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package archive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/viper"
+)
+
+// NewClient makes the client of the archive_backend configured, nil if there is none
+func NewClient(conf *viper.Viper) (ArchiveClient, error) {
+	switch backend := conf.GetString("archive_backend"); backend {
+	case "":
+		return nil, nil
+	case "local":
+		return NewLocalClient(conf.GetString("archive_local_dir"))
+	case "s3":
+		return NewS3Client(
+			conf.GetString("archive_s3_endpoint"),
+			conf.GetString("archive_s3_region"),
+			conf.GetString("archive_s3_bucket"),
+			conf.GetString("archive_s3_access_key"),
+			conf.GetString("archive_s3_secret_key"),
+		)
+	default:
+		return nil, fmt.Errorf("unknown archive_backend %s", backend)
+	}
+}
+
+// FileHash returns the hex sha256 of the content of path
+func FileHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// LocalClient keeps archive files in a directory, a local disk or a mounted NFS path
+type LocalClient struct {
+	dir string
+}
+
+func NewLocalClient(dir string) (*LocalClient, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("archive_local_dir is not set")
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &LocalClient{dir: dir}, nil
+}
+
+func (c *LocalClient) UploadFile(path string) (string, error) {
+	fileHash, err := FileHash(path)
+	if err != nil {
+		return "", err
+	}
+	// written aside first so a file under its hash name is always complete
+	tmp := filepath.Join(c.dir, fileHash+".tmp")
+	if err := copyFile(path, tmp); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Rename(tmp, filepath.Join(c.dir, fileHash)); err != nil {
+		return "", err
+	}
+	return fileHash, nil
+}
+
+func (c *LocalClient) DownloadFile(fileHash, path string) error {
+	return copyFile(filepath.Join(c.dir, fileHash), path)
+}
@@ -78,6 +78,13 @@ func NewBlockPool(start int64, requestsCh chan<- BlockRequest, timeoutsCh chan<-
 	return bp
 }
 
+// SetHeight moves the height blocks are requested from, it must be called before the pool starts
+func (pool *BlockPool) SetHeight(height int64) {
+	pool.mtx.Lock()
+	defer pool.mtx.Unlock()
+	pool.height = height
+}
+
 func (pool *BlockPool) OnStart() error {
 	pool.BaseService.OnStart()
 	go pool.makeRequestersRoutine()
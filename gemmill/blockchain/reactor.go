@@ -28,7 +28,6 @@ import (
 	"github.com/dappledger/AnnChain/gemmill/archive"
 	"github.com/dappledger/AnnChain/gemmill/go-wire"
 	gcmn "github.com/dappledger/AnnChain/gemmill/modules/go-common"
-	"github.com/dappledger/AnnChain/gemmill/modules/go-db"
 	log "github.com/dappledger/AnnChain/gemmill/modules/go-log"
 	"github.com/dappledger/AnnChain/gemmill/p2p"
 	"github.com/dappledger/AnnChain/gemmill/types"
@@ -110,10 +109,13 @@ func (bcR *BlockchainReactor) SetBlockExecuter(x func(*types.Block, *types.PartS
 
 func (bcR *BlockchainReactor) OnStart() error {
 	bcR.BaseReactor.OnStart()
-	if bcR.archive.Threshold > 0 {
-		go bcR.BlockArchive()
-	} else {
+	switch {
+	case bcR.archive.Threshold <= 0:
 		log.Warn("invalid archive.Threshold", zap.Int64("archive_threshold", bcR.archive.Threshold))
+	case bcR.archive.Client == nil:
+		log.Warn("blocks aren't archived, archive_backend is not set", zap.Int64("archive_threshold", bcR.archive.Threshold))
+	default:
+		go bcR.BlockArchive()
 	}
 	if bcR.fastSync {
 		_, err := bcR.pool.Start()
@@ -125,6 +127,20 @@ func (bcR *BlockchainReactor) OnStart() error {
 	return nil
 }
 
+// StartFastSync fast-syncs the blocks following height, once state sync restored the state of height
+func (bcR *BlockchainReactor) StartFastSync(height int64) error {
+	if bcR.fastSync {
+		return errors.New("already fast syncing")
+	}
+	bcR.fastSync = true
+	bcR.pool.SetHeight(height + 1)
+	if _, err := bcR.pool.Start(); err != nil {
+		return err
+	}
+	go bcR.poolRoutine()
+	return bcR.BroadcastStatusRequest()
+}
+
 func (bcR *BlockchainReactor) OnStop() {
 	bcR.BaseReactor.OnStop()
 	bcR.pool.Stop()
@@ -207,23 +223,10 @@ func (bcR *BlockchainReactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte)
 }
 
 func (bcR *BlockchainReactor) loadArchiveBlock(height int64) (block *types.Block, err error) {
-
-	fileHash := string(bcR.archive.QueryFileHash(height))
-	archiveDir := bcR.config.GetString("db_archive_dir")
-	_, err = os.Stat(filepath.Join(archiveDir, fileHash+".zip"))
+	archiveDB, err := bcR.archive.OpenDB(height, bcR.config.GetString("db_archive_dir"), bcR.config.GetString("db_backend"))
 	if err != nil {
-		//download archive file zip
-		err = bcR.archive.Client.DownloadFile(fileHash, filepath.Join(archiveDir, fileHash+".zip"))
-		if err != nil {
-			return
-		}
-		err = zip.Decompress(filepath.Join(archiveDir, fileHash+".zip"), filepath.Join(archiveDir, fileHash+".db"))
-		if err != nil {
-			return
-		}
+		return
 	}
-
-	archiveDB := db.NewDB(fileHash, bcR.config.GetString("db_backend"), archiveDir)
 	defer archiveDB.Close()
 	newStore := NewBlockStore(archiveDB, nil)
 	block = newStore.LoadBlock(height)
@@ -253,8 +256,10 @@ func (bcR *BlockchainReactor) BlockArchive() {
 			}
 		}
 		originHeight := bcR.store.OriginHeight()
-		if bcR.store.Height()-originHeight > bcR.archive.Threshold {
-			for i := originHeight + 1; i <= originHeight+bcR.archive.Threshold; i++ {
+		// the store of a node restored from a snapshot may start inside a range
+		_, toHeight := bcR.archive.RangeOf(originHeight + 1)
+		if bcR.store.Height() > toHeight {
+			for i := originHeight + 1; i <= toHeight; i++ {
 				block := bcR.store.LoadBlock(i)
 				partSet := block.MakePartSet(bcR.config.GetInt("block_part_size"))
 				seenCommit := bcR.store.LoadSeenCommit(i)
@@ -277,14 +282,16 @@ func (bcR *BlockchainReactor) BlockArchive() {
 			} else {
 				log.Info("archiveClient.UploadFile success")
 			}
-			bcR.archive.AddItem(originHeight, fileHash)
-			bcR.store.SetOriginHeight(originHeight + bcR.archive.Threshold)
-			for i := originHeight + 1; i <= bcR.store.OriginHeight(); i++ {
+			bcR.archive.AddItem(toHeight, fileHash)
+			bcR.store.SetOriginHeight(toHeight)
+			for i := originHeight + 1; i <= toHeight; i++ {
 				err = bcR.store.DeleteBlock(i)
 				if err != nil {
 					log.Error("bcR.store.DeleteBlock("+strconv.FormatInt(i, 10)+")", zap.String("error", err.Error()))
 				}
 			}
+			os.Remove(storeDir + ".zip")
+			log.Info("archived blocks", zap.Int64("from", originHeight+1), zap.Int64("to", toHeight), zap.String("fileHash", fileHash))
 		}
 
 	}
@@ -20,6 +20,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"sync"
 
 	"github.com/dappledger/AnnChain/gemmill/go-wire"
@@ -69,10 +70,16 @@ func (bs *BlockStore) Height() int64 {
 }
 
 func (bs *BlockStore) OriginHeight() int64 {
+	bs.mtx.RLock()
+	defer bs.mtx.RUnlock()
 	return bs.originHeight
 }
 
+// SetOriginHeight records the blocks up to height were archived
 func (bs *BlockStore) SetOriginHeight(height int64) {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	BlockStoreStateJSON{Height: bs.height, OriginHeight: height}.Save(bs.db)
 	bs.originHeight = height
 }
 
@@ -115,6 +122,19 @@ func (bs *BlockStore) LoadBlock(height int64) *types.Block {
 	return block
 }
 
+// LoadBlockHeight returns the height of the block with the given hash, 0 if unknown.
+func (bs *BlockStore) LoadBlockHeight(hash []byte) int64 {
+	bytez := bs.db.Get(calcBlockHashKey(hash))
+	if bytez == nil {
+		return 0
+	}
+	height, err := strconv.ParseInt(string(bytez), 10, 64)
+	if err != nil {
+		gcmn.PanicCrisis(gcmn.Fmt("Error reading block height: %v", err))
+	}
+	return height
+}
+
 func (bs *BlockStore) LoadBlockPart(height int64, index int) *types.Part {
 	var n int
 	var err error
@@ -198,6 +218,9 @@ func (bs *BlockStore) SaveBlock(block *types.Block, blockParts *types.PartSet, s
 		bs.saveBlockPart(height, i, blockParts.GetPart(i))
 	}
 
+	// Save block hash index, it is kept when the block gets archived
+	bs.db.Set(calcBlockHashKey(block.Hash()), []byte(strconv.FormatInt(height, 10)))
+
 	// Save block commit (duplicate and separate from the Block)
 	blockCommitBytes := wire.BinaryBytes(block.LastCommit)
 	bs.db.Set(calcBlockCommitKey(height-1), blockCommitBytes)
@@ -208,10 +231,10 @@ func (bs *BlockStore) SaveBlock(block *types.Block, blockParts *types.PartSet, s
 	bs.db.Set(calcSeenCommitKey(height), seenCommitBytes)
 
 	// Save new BlockStoreStateJSON descriptor
+	bs.mtx.Lock()
 	BlockStoreStateJSON{Height: height, OriginHeight: bs.originHeight}.Save(bs.db)
 
 	// Done!
-	bs.mtx.Lock()
 	bs.height = height
 	bs.mtx.Unlock()
 
@@ -298,8 +321,42 @@ func (bs *BlockStore) savePartToArchive(height int64, index int, part *types.Par
 	bs.archiveDB.Set(calcBlockPartKey(height, index), partBytes)
 }
 
-func (bs *BlockStore) RevertToHeight(height int64) {
+// RestoreBase moves a store behind height to height, whose state was restored from a snapshot,
+// keeping seenCommit, the commit of the block at height, for the consensus to resume from it
+func (bs *BlockStore) RestoreBase(height int64, seenCommit *types.Commit) {
+	if bs.Height() >= height {
+		gcmn.PanicSanity(gcmn.Fmt("BlockStore can only restore a snapshot above its height %v, got %v", bs.Height(), height))
+	}
+	bs.db.Set(calcSeenCommitKey(height), wire.BinaryBytes(seenCommit))
+	BlockStoreStateJSON{Height: height, OriginHeight: height}.Save(bs.db)
+
+	bs.mtx.Lock()
+	bs.height = height
+	bs.originHeight = height
+	bs.mtx.Unlock()
+}
+
+// RevertToHeight drops the blocks above height, along with the commit of the block at height
+func (bs *BlockStore) RevertToHeight(height int64) error {
+	bs.mtx.Lock()
+	defer bs.mtx.Unlock()
+	if height < bs.originHeight || height > bs.height {
+		return fmt.Errorf("can't revert to height %v, the store holds blocks %v to %v", height, bs.originHeight+1, bs.height)
+	}
+	for h := bs.height; h > height; h-- {
+		if meta := bs.LoadBlockMeta(h); meta != nil {
+			for i := 0; i < meta.PartsHeader.Total; i++ {
+				bs.db.Delete(calcBlockPartKey(h, i))
+			}
+			bs.db.Delete(calcBlockHashKey(meta.Hash))
+		}
+		bs.db.Delete(calcBlockMetaKey(h))
+		bs.db.Delete(calcSeenCommitKey(h))
+		bs.db.Delete(calcBlockCommitKey(h - 1))
+	}
 	BlockStoreStateJSON{Height: height, OriginHeight: bs.originHeight}.Save(bs.db)
+	bs.height = height
+	return nil
 }
 
 //-----------------------------------------------------------------------------
@@ -320,6 +377,10 @@ func calcSeenCommitKey(height int64) []byte {
 	return []byte(fmt.Sprintf("SC:%v", height))
 }
 
+func calcBlockHashKey(hash []byte) []byte {
+	return []byte(fmt.Sprintf("BH:%X", hash))
+}
+
 //-----------------------------------------------------------------------------
 
 var blockStoreKey = []byte("blockStore")
@@ -34,6 +34,7 @@ const (
 	DEFAULT_RUNTIME = ".genesis"
 	DATADIR         = "data"
 	ARCHIVEDIR      = "data/archive"
+	SNAPSHOTDIR     = "data/snapshots"
 	CONFIGFILE      = "config.toml"
 )
 
@@ -197,6 +198,9 @@ func SetDefaults(runtime string, conf *viper.Viper) *viper.Viper {
 
 	setMempoolDefaults(conf)
 	setConsensusDefaults(conf)
+	setStateSyncDefaults(conf)
+	setArchiveDefaults(conf)
+	setEVMDefaults(conf)
 
 	return conf
 }
@@ -227,6 +231,42 @@ func setConsensusDefaults(conf *viper.Viper) {
 	conf.SetDefault("tracerouter_msg_ttl", 5) // seconds
 }
 
+func setStateSyncDefaults(conf *viper.Viper) {
+	conf.SetDefault("snapshot_dir", path.Join(conf.GetString("runtime"), SNAPSHOTDIR))
+	conf.SetDefault("snapshot_interval", 0) // blocks between snapshots, 0 takes none
+	conf.SetDefault("snapshot_keep_recent", 2)
+	conf.SetDefault("snapshot_chunk_size", 4<<20) // 4M
+	conf.SetDefault("state_sync", false)
+	conf.SetDefault("state_sync_trust_height", 0)    // snapshot height + 1
+	conf.SetDefault("state_sync_trust_hash", "")     // hash of the block at state_sync_trust_height
+	conf.SetDefault("state_sync_discovery_time", 10) // seconds
+}
+
+func setArchiveDefaults(conf *viper.Viper) {
+	conf.SetDefault("archive_backend", "") // local or s3, blocks aren't archived when empty
+	conf.SetDefault("archive_local_dir", path.Join(conf.GetString("runtime"), DATADIR, "archive_files"))
+	conf.SetDefault("archive_s3_endpoint", "")
+	conf.SetDefault("archive_s3_region", "us-east-1")
+	conf.SetDefault("archive_s3_bucket", "")
+	conf.SetDefault("archive_s3_access_key", "")
+	conf.SetDefault("archive_s3_secret_key", "")
+}
+
+func setEVMDefaults(conf *viper.Viper) {
+	conf.SetDefault("evm_parallel_exec", false)         // execute txs optimistically in parallel
+	conf.SetDefault("evm_parallel_workers", 0)          // runtime.NumCPU() when 0
+	conf.SetDefault("evm_txpool_pending_limit", 0)      // block_size * 10 when 0
+	conf.SetDefault("evm_txpool_waiting_limit", 0)      // block_size * 10 when 0
+	conf.SetDefault("evm_txpool_account_pending", 64)   // no limit when 0
+	conf.SetDefault("evm_txpool_account_waiting", 128)  // no limit when 0
+	conf.SetDefault("evm_txpool_max_bytes", 64<<20)     // 64M, no limit when 0
+	conf.SetDefault("evm_txpool_replace", "price_bump") // price_bump, any or none
+	conf.SetDefault("evm_txpool_price_bump", 10)        // percent
+	conf.SetDefault("evm_txpool_evict", "price")        // price, oldest or none
+	conf.SetDefault("evm_txpool_journal", true)         // keep the pooled txs over restarts
+	conf.SetDefault("evm_txstatus_limit", 100000)       // statuses of the recent txs kept in memory
+}
+
 func getPrivkeyFromConf(conf *viper.Viper) (privkey crypto.PrivKey) {
 	if conf == nil {
 		return nil
@@ -251,6 +291,8 @@ func DefaultConfig() (conf *viper.Viper) {
 	conf.Set("auth_by_ca", true)
 	conf.Set("signbyCA", "")
 	conf.Set("fast_sync", true)
+	conf.Set("snapshot_interval", 0)
+	conf.Set("state_sync", false)
 	conf.Set("skip_upnp", true)
 	conf.Set("log_path", "")
 	conf.Set("audit_log_path", "audit.log")
@@ -258,6 +300,7 @@ func DefaultConfig() (conf *viper.Viper) {
 	conf.Set("non_validator_node_auth", true)
 	conf.SetDefault("tracerouter_msg_ttl", 5)
 	conf.Set("threshold_blocks", 0)
+	conf.Set("archive_backend", "")
 	conf.SetDefault("block_size", 5000)
 
 	return conf
@@ -0,0 +1,88 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dev
+
+import (
+	"time"
+
+	"github.com/dappledger/AnnChain/gemmill/rpc/server"
+)
+
+type PublicAPI struct {
+	*ConsensusState
+}
+
+func (cs *ConsensusState) NewPublicAPI() *PublicAPI {
+	return &PublicAPI{cs}
+}
+
+func (p *PublicAPI) API() map[string]*server.RPCFunc {
+	return map[string]*server.RPCFunc{
+		"dev/mine":          server.NewRPCFunc(p.MineBlocks, "blocks"),
+		"dev/snapshot":      server.NewRPCFunc(p.TakeSnapshot, ""),
+		"dev/revert":        server.NewRPCFunc(p.RevertSnapshot, "id"),
+		"dev/increase_time": server.NewRPCFunc(p.IncreaseBlockTime, "seconds"),
+	}
+}
+
+type MineResult struct {
+	Height int64 `json:"height"`
+}
+
+// MineBlocks seals blocks empty blocks at once
+func (p *PublicAPI) MineBlocks(blocks int) (*MineResult, error) {
+	height, err := p.Mine(blocks)
+	if err != nil {
+		return nil, err
+	}
+	return &MineResult{Height: height}, nil
+}
+
+type SnapshotResult struct {
+	ID uint64 `json:"id"`
+}
+
+func (p *PublicAPI) TakeSnapshot() (*SnapshotResult, error) {
+	id, err := p.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotResult{ID: id}, nil
+}
+
+type RevertResult struct {
+	Height int64 `json:"height"`
+}
+
+// RevertSnapshot reverts the chain to the snapshot of id, the snapshot can't be reverted to twice
+func (p *PublicAPI) RevertSnapshot(id uint64) (*RevertResult, error) {
+	if err := p.Revert(id); err != nil {
+		return nil, err
+	}
+	return &RevertResult{Height: p.blockStore.Height()}, nil
+}
+
+type IncreaseTimeResult struct {
+	Offset int64 `json:"offset"` // seconds the block time is ahead of the clock
+}
+
+// IncreaseBlockTime moves the time of the next blocks forward by seconds
+func (p *PublicAPI) IncreaseBlockTime(seconds int64) (*IncreaseTimeResult, error) {
+	offset, err := p.IncreaseTime(time.Duration(seconds) * time.Second)
+	if err != nil {
+		return nil, err
+	}
+	return &IncreaseTimeResult{Offset: int64(offset / time.Second)}, nil
+}
@@ -0,0 +1,39 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dev
+
+import (
+	"github.com/dappledger/AnnChain/gemmill/consensus"
+	"github.com/dappledger/AnnChain/gemmill/rpc/server"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const EngineName = "dev"
+
+func init() {
+	consensus.Register(EngineName, newEngine)
+}
+
+func newEngine(ctx *consensus.Context) (consensus.Engine, error) {
+	cs := NewConsensusState(ctx.Conf, ctx.EventSwitch, ctx.BlockStore, ctx.State, ctx.TxPool, ctx.PrivValidator)
+	if app, ok := ctx.App.(types.RevertibleApplication); ok {
+		cs.SetRevertibleApplication(app)
+	}
+	return cs, nil
+}
+
+func (cs *ConsensusState) API() map[string]*server.RPCFunc {
+	return cs.NewPublicAPI().API()
+}
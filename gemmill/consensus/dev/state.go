@@ -0,0 +1,326 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dev
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/gemmill/blockchain"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// txPollInterval is how often the pool is checked for txs to seal when there is no block interval
+const txPollInterval = time.Millisecond * 10
+
+var ErrNotRevertible = errors.New("the app can't revert its state")
+
+type config struct {
+	blockSize     int
+	blockPartSize int
+	blockInterval time.Duration // seal a block every interval, txs are sealed as they arrive when 0
+}
+
+func initConfig(conf *viper.Viper) *config {
+	return &config{
+		blockSize:     conf.GetInt("block_size"),
+		blockPartSize: conf.GetInt("block_part_size"),
+		blockInterval: conf.GetDuration("dev.block_interval"),
+	}
+}
+
+// snapshot is a state the chain can be reverted to
+type snapshot struct {
+	id         uint64
+	state      *state.State
+	timeOffset time.Duration
+}
+
+// ConsensusState seals the blocks of a single validator chain on its own, for developing against the real app.
+// The chain can be snapshotted and reverted, and its block time moved forward.
+type ConsensusState struct {
+	conf          *config
+	evsw          types.EventSwitch
+	blockStore    *blockchain.BlockStore
+	mempool       types.TxPool
+	privValidator *types.PrivValidator
+	app           types.RevertibleApplication // nil if the app can't revert
+	onUpdateState func(s *state.State)
+
+	mtx        sync.Mutex // serializes sealing, snapshots and reverts
+	timeOffset time.Duration
+	snapshots  []*snapshot // ascending ids
+	lastID     uint64
+
+	stateMtx sync.RWMutex
+	state    *state.State
+
+	quit     chan struct{}
+	done     chan struct{}
+	started  bool
+	stopOnce sync.Once
+}
+
+func NewConsensusState(vconf *viper.Viper, evsw types.EventSwitch, blockStore *blockchain.BlockStore, state *state.State, mempool types.TxPool, privValidator *types.PrivValidator) *ConsensusState {
+	return newConsensusState(initConfig(vconf), evsw, blockStore, state, mempool, privValidator)
+}
+
+func newConsensusState(conf *config, evsw types.EventSwitch, blockStore *blockchain.BlockStore, state *state.State, mempool types.TxPool, privValidator *types.PrivValidator) *ConsensusState {
+	return &ConsensusState{
+		conf:          conf,
+		evsw:          evsw,
+		blockStore:    blockStore,
+		mempool:       mempool,
+		privValidator: privValidator,
+		state:         state,
+		quit:          make(chan struct{}),
+		done:          make(chan struct{}),
+	}
+}
+
+// SetRevertibleApplication lets the chain be reverted to its snapshots
+func (cs *ConsensusState) SetRevertibleApplication(app types.RevertibleApplication) {
+	cs.app = app
+}
+
+func (cs *ConsensusState) GetValidators() (int64, []*types.Validator) {
+	cs.stateMtx.RLock()
+	defer cs.stateMtx.RUnlock()
+	return cs.state.LastBlockHeight, cs.state.Validators.Copy().Validators
+}
+
+func (cs *ConsensusState) SetEventSwitch(evsw types.EventSwitch) {
+	cs.evsw = evsw
+}
+
+func (cs *ConsensusState) SetOnUpdateStatus(onUpdateState func(s *state.State)) {
+	cs.onUpdateState = onUpdateState
+}
+
+// ValidateBlock is called while sealing the block, on the state it's sealed on
+func (cs *ConsensusState) ValidateBlock(b *types.Block) error {
+	s := cs.state
+	if err := b.ValidateBasic(s.ChainID, s.LastBlockHeight, s.LastBlockID, s.LastBlockTime, s.AppHash, s.ReceiptsHash); err != nil {
+		return err
+	}
+	if !bytes.Equal(b.ProposerAddress, cs.privValidator.GetAddress()) {
+		return fmt.Errorf("block %d proposed by %X, not by the local validator", b.Height, b.ProposerAddress)
+	}
+	return nil
+}
+
+// VerifyCommit accepts any commit, dev blocks carry no votes
+func (cs *ConsensusState) VerifyCommit(types.BlockID, int64, *types.Commit) error {
+	return nil
+}
+
+// StartEngine starts sealing blocks, the local validator must be the only one
+func (cs *ConsensusState) StartEngine() error {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	if cs.started {
+		return nil
+	}
+	vals := cs.state.Validators
+	if vals.Size() != 1 || !vals.HasAddress(cs.privValidator.GetAddress()) {
+		return errors.New("dev consensus needs the node to be the only validator")
+	}
+	cs.started = true
+	go cs.run()
+	log.Info("dev consensus started", zap.Int64("height", cs.state.LastBlockHeight), zap.Duration("block interval", cs.conf.blockInterval))
+	return nil
+}
+
+func (cs *ConsensusState) StopEngine() {
+	cs.mtx.Lock()
+	started := cs.started
+	cs.mtx.Unlock()
+	if !started {
+		return
+	}
+	cs.stopOnce.Do(func() {
+		close(cs.quit)
+		<-cs.done
+	})
+}
+
+func (cs *ConsensusState) run() {
+	defer close(cs.done)
+
+	interval := cs.conf.blockInterval
+	if interval <= 0 {
+		interval = txPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cs.quit:
+			return
+		case <-ticker.C:
+			// without a block interval, only blocks with txs are sealed
+			if cs.conf.blockInterval <= 0 && cs.mempool.Size() == 0 {
+				continue
+			}
+			if _, err := cs.seal(cs.conf.blockInterval > 0); err != nil {
+				log.Error("dev consensus seal block", zap.Error(err))
+			}
+		}
+	}
+}
+
+// seal makes a block of the txs in the pool, it makes an empty block only if empty is set
+func (cs *ConsensusState) seal(empty bool) (*types.Block, error) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	txs := cs.mempool.Reap(cs.conf.blockSize)
+	if len(txs) == 0 && !empty {
+		return nil, nil
+	}
+	return cs.sealBlock(txs)
+}
+
+func (cs *ConsensusState) blockTime(last time.Time) time.Time {
+	t := time.Now().Add(cs.timeOffset)
+	if !t.After(last) {
+		// a reverted time offset never moves the time of blocks backward
+		t = last.Add(time.Millisecond)
+	}
+	return t
+}
+
+// sealBlock executes and commits the block of txs, cs.mtx must be held
+func (cs *ConsensusState) sealBlock(txs []types.Tx) (*types.Block, error) {
+	start := time.Now()
+	s := cs.state
+	block, _ := types.MakeBlock(s.LastBlockHeight+1, s.ChainID, txs, nil, &types.Commit{}, cs.privValidator.GetAddress(),
+		s.LastBlockID, s.Validators.Hash(), s.AppHash, s.ReceiptsHash, cs.conf.blockPartSize)
+	block.Time = cs.blockTime(s.LastBlockTime)
+	partSet := block.MakePartSet(cs.conf.blockPartSize)
+
+	cs.blockStore.SaveBlock(block, partSet, &types.Commit{})
+	stateCopy := s.Copy()
+	if err := stateCopy.ApplyBlock(cs.evsw, block, partSet.Header(), cs.mempool, 0); err != nil {
+		// the block is sealed again with the next txs
+		if rerr := cs.blockStore.RevertToHeight(s.LastBlockHeight); rerr != nil {
+			log.Error("dev consensus drop block", zap.Int64("height", block.Height), zap.Error(rerr))
+		}
+		return nil, err
+	}
+	stateCopy.Save()
+
+	types.FireEventNewBlock(cs.evsw, types.EventDataNewBlock{Block: block})
+	types.FireEventNewBlockHeader(cs.evsw, types.EventDataNewBlockHeader{Header: block.Header})
+
+	cs.setState(stateCopy)
+	log.Info("dev consensus sealed block", zap.Int64("height", block.Height), zap.Int("txs num", len(txs)), zap.Duration("taken", time.Since(start)))
+	return block, nil
+}
+
+func (cs *ConsensusState) setState(s *state.State) {
+	cs.stateMtx.Lock()
+	cs.state = s
+	cs.stateMtx.Unlock()
+	if cs.onUpdateState != nil {
+		cs.onUpdateState(s)
+	}
+}
+
+// Mine seals n empty blocks and returns the height of the last one
+func (cs *ConsensusState) Mine(n int) (int64, error) {
+	if n <= 0 {
+		return 0, errors.New("blocks to mine must be positive")
+	}
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	for i := 0; i < n; i++ {
+		if _, err := cs.sealBlock(nil); err != nil {
+			return 0, err
+		}
+	}
+	return cs.state.LastBlockHeight, nil
+}
+
+// IncreaseTime moves the time of the next blocks forward by d and returns the total offset
+func (cs *ConsensusState) IncreaseTime(d time.Duration) (time.Duration, error) {
+	if d < 0 {
+		return 0, errors.New("block time can't move backward")
+	}
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	cs.timeOffset += d
+	return cs.timeOffset, nil
+}
+
+// Snapshot records the current chain and returns the id to revert to it
+func (cs *ConsensusState) Snapshot() (uint64, error) {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	if cs.app == nil {
+		return 0, ErrNotRevertible
+	}
+	cs.lastID++
+	cs.snapshots = append(cs.snapshots, &snapshot{id: cs.lastID, state: cs.state.Copy(), timeOffset: cs.timeOffset})
+	return cs.lastID, nil
+}
+
+// Revert moves the chain back to the snapshot of id, which is dropped along with the later ones
+func (cs *ConsensusState) Revert(id uint64) error {
+	cs.mtx.Lock()
+	defer cs.mtx.Unlock()
+	if cs.app == nil {
+		return ErrNotRevertible
+	}
+	idx := -1
+	for i, snap := range cs.snapshots {
+		if snap.id == id {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return fmt.Errorf("no snapshot %d", id)
+	}
+	snap := cs.snapshots[idx]
+	height := snap.state.LastBlockHeight
+
+	var txs []types.Tx
+	for h := height + 1; h <= cs.blockStore.Height(); h++ {
+		if block := cs.blockStore.LoadBlock(h); block != nil {
+			txs = append(txs, block.Data.Txs...)
+		}
+	}
+	if err := cs.app.RevertTo(height, snap.state.AppHash, txs); err != nil {
+		return err
+	}
+	if err := cs.blockStore.RevertToHeight(height); err != nil {
+		return err
+	}
+	stateCopy := cs.state.Copy()
+	stateCopy.Restore(snap.state)
+	cs.setState(stateCopy)
+	cs.timeOffset = snap.timeOffset
+	cs.snapshots = cs.snapshots[:idx]
+	log.Info("dev consensus reverted", zap.Uint64("snapshot", id), zap.Int64("height", height))
+	return nil
+}
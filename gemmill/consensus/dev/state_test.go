@@ -0,0 +1,142 @@
+package dev
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/gemmill/blockchain"
+	"github.com/dappledger/AnnChain/gemmill/mempool"
+	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-events"
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// revertApp records the reverts of the chain
+type revertApp struct {
+	types.Application
+
+	mtx      sync.Mutex
+	height   int64
+	reverted []types.Tx
+}
+
+func (app *revertApp) RevertTo(height int64, appHash []byte, txs []types.Tx) error {
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	app.height = height
+	app.reverted = append(app.reverted, txs...)
+	return nil
+}
+
+type noopExecutable struct{}
+
+func (noopExecutable) BeginBlock(*types.Block, events.Fireable, *types.PartSetHeader) error {
+	return nil
+}
+
+func (noopExecutable) ExecBlock(*types.Block, events.Fireable, *types.ExecuteResult) error {
+	return nil
+}
+
+func (noopExecutable) EndBlock(*types.Block, events.Fireable, *types.PartSetHeader, []*types.ValidatorAttr, *types.ValidatorSet) error {
+	return nil
+}
+
+func newTestConsensus(t *testing.T, validators int) (*ConsensusState, *blockchain.BlockStore, types.TxPool, *revertApp) {
+	valSet, privVals := types.RandValidatorSet(validators, 10)
+	genDoc := &types.GenesisDoc{ChainID: "dev-test", GenesisTime: time.Now()}
+	for _, val := range valSet.Validators {
+		genDoc.Validators = append(genDoc.Validators, types.GenesisValidator{PubKey: val.PubKey, Amount: val.VotingPower})
+	}
+	vconf := viper.New()
+	vconf.Set("block_size", 10)
+
+	st := state.MakeGenesisState(dbm.NewMemDB(), genDoc)
+	st.SetBlockExecutable(noopExecutable{})
+	blockStore := blockchain.NewBlockStore(dbm.NewMemDB(), dbm.NewMemDB())
+	pool := mempool.NewMempool(vconf)
+	evsw := types.NewEventSwitch()
+	evsw.Start()
+	types.AddListenerForEvent(evsw, "app", types.EventStringHookExecute(), func(ed types.TMEventData) {
+		ed.(types.EventDataHookExecute).ResCh <- types.ExecuteResult{}
+	})
+	types.AddListenerForEvent(evsw, "app", types.EventStringHookCommit(), func(ed types.TMEventData) {
+		data := ed.(types.EventDataHookCommit)
+		data.ResCh <- types.CommitResult{AppHash: data.Block.Hash()}
+	})
+
+	app := &revertApp{}
+	cs := newConsensusState(&config{blockSize: 10, blockPartSize: 65536}, evsw, blockStore, st, pool, privVals[0])
+	cs.SetRevertibleApplication(app)
+	st.SetBlockVerifier(cs)
+	return cs, blockStore, pool, app
+}
+
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	for end := time.Now().Add(timeout); time.Now().Before(end); time.Sleep(time.Millisecond * 10) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestDevConsensus(t *testing.T) {
+	cs, blockStore, pool, app := newTestConsensus(t, 1)
+	assert.NoError(t, cs.StartEngine())
+	defer cs.StopEngine()
+
+	// a tx is sealed as it arrives, no empty blocks are sealed meanwhile
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, int64(0), blockStore.Height())
+	assert.NoError(t, pool.ReceiveTx(types.Tx("tx1")))
+	assert.True(t, waitFor(time.Second*5, func() bool { return blockStore.Height() == 1 }))
+	assert.Equal(t, types.Txs{types.Tx("tx1")}, types.Txs(blockStore.LoadBlock(1).Data.Txs))
+
+	height, err := cs.Mine(3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), height)
+	_, err = cs.Mine(0)
+	assert.Error(t, err)
+
+	id, err := cs.Snapshot()
+	assert.NoError(t, err)
+	offset, err := cs.IncreaseTime(time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, offset)
+	assert.NoError(t, pool.ReceiveTx(types.Tx("tx2")))
+	assert.True(t, waitFor(time.Second*5, func() bool { return blockStore.Height() == 5 }))
+	assert.True(t, blockStore.LoadBlock(5).Time.After(time.Now().Add(time.Minute*59)))
+	hash5 := blockStore.LoadBlockMeta(5).Hash
+
+	// the blocks above the snapshot and their txs are dropped, the time offset is reverted
+	assert.NoError(t, cs.Revert(id))
+	assert.Equal(t, int64(4), blockStore.Height())
+	assert.Nil(t, blockStore.LoadBlock(5))
+	assert.Equal(t, int64(0), blockStore.LoadBlockHeight(hash5))
+	lastHeight, _ := cs.GetValidators()
+	assert.Equal(t, int64(4), lastHeight)
+	app.mtx.Lock()
+	assert.Equal(t, int64(4), app.height)
+	assert.Equal(t, []types.Tx{types.Tx("tx2")}, app.reverted)
+	app.mtx.Unlock()
+	assert.Error(t, cs.Revert(id))
+
+	height, err = cs.Mine(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), height)
+	block := blockStore.LoadBlock(5)
+	assert.Equal(t, blockStore.LoadBlockMeta(4).Hash, block.LastBlockID.Hash)
+	assert.True(t, block.Time.Before(time.Now().Add(time.Minute)))
+}
+
+func TestDevConsensusNeedsSingleValidator(t *testing.T) {
+	cs, _, _, _ := newTestConsensus(t, 2)
+	assert.Error(t, cs.StartEngine())
+	cs.StopEngine()
+}
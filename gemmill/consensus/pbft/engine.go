@@ -0,0 +1,48 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pbft
+
+import (
+	"github.com/dappledger/AnnChain/gemmill/consensus"
+	"github.com/dappledger/AnnChain/gemmill/evidence"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const EngineName = "pbft"
+
+func init() {
+	consensus.Register(EngineName, newEngine)
+}
+
+// newEngine assembles the consensus state with its reactor and the evidence pool gossiping double signs
+func newEngine(ctx *consensus.Context) (consensus.Engine, error) {
+	cs := NewConsensusState(ctx.Conf, ctx.State, ctx.BlockStore, ctx.TxPool)
+	cs.SetPrivValidator(ctx.PrivValidator)
+
+	conR := NewConsensusReactor(cs, ctx.FastSync)
+	cs.BindReactor(conR)
+	ctx.Switch.AddReactor("CONSENSUS", conR)
+
+	evpool := evidence.NewPool(func(ev *types.DuplicateVoteEvidence) error {
+		return ctx.CommittedState().VerifyEvidence(ev)
+	})
+	cs.SetEvidencePool(evpool)
+	evR := evidence.NewReactor(evpool)
+	ctx.Switch.AddReactor("EVIDENCE", evR)
+
+	conR.SetEventSwitch(ctx.EventSwitch)
+	evR.SetEventSwitch(ctx.EventSwitch)
+	return cs, nil
+}
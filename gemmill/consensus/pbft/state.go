@@ -238,6 +238,13 @@ type PrivValidator interface {
 	SignProposal(chainID string, proposal *types.Proposal) error
 }
 
+// EvidencePool collects the evidence of conflicting votes until it's committed
+type EvidencePool interface {
+	AddEvidence(*types.DuplicateVoteEvidence) error
+	PendingEvidence(max int) []*types.DuplicateVoteEvidence
+	Update(*types.Block)
+}
+
 // Tracks consensus state across block heights and rounds.
 type ConsensusState struct {
 	gcmn.BaseService
@@ -245,6 +252,7 @@ type ConsensusState struct {
 	config     *viper.Viper
 	blockStore *bc.BlockStore
 	mempool    types.TxPool
+	evpool     EvidencePool
 
 	conR *ConsensusReactor
 
@@ -328,6 +336,11 @@ func (cs *ConsensusState) BindReactor(r *ConsensusReactor) {
 	cs.conR = r
 }
 
+// SetEvidencePool makes the node publish the conflicting votes it finds and propose the evidence of the pool
+func (cs *ConsensusState) SetEvidencePool(evpool EvidencePool) {
+	cs.evpool = evpool
+}
+
 func (cs *ConsensusState) String() string {
 	// better not to access shared variables
 	return gcmn.Fmt("ConsensusState") //(H:%v R:%v S:%v", cs.Height, cs.Round, cs.Step)
@@ -960,6 +973,11 @@ func (cs *ConsensusState) createProposalBlock() (block *types.Block, blockParts
 	alltxs := cs.mempool.Reap(cs.config.GetInt("block_size"))
 	extxs := []types.Tx{}
 	txs := []types.Tx{}
+	if cs.evpool != nil {
+		for _, ev := range cs.evpool.PendingEvidence(types.MaxBlockEvidence) {
+			extxs = append(extxs, types.TagEvidenceTx(ev))
+		}
+	}
 	for _, tx := range alltxs {
 		if types.IsAdminOP(tx) {
 			extxs = append(extxs, tx)
@@ -1323,6 +1341,9 @@ func (cs *ConsensusState) finalizeCommit(height int64) {
 		// TODO!
 		log.Error("apply block", zap.Error(err))
 	}
+	if cs.evpool != nil {
+		cs.evpool.Update(block)
+	}
 
 	// Fire off event for new block.
 	// TODO: Handle app failure.  See #177
@@ -1424,12 +1445,19 @@ func (cs *ConsensusState) tryAddVote(vote *types.Vote, peerKey string) (bool, er
 		// If it's otherwise invalid, punish peer.
 		if err == ErrVoteHeightMismatch {
 			return added, err
-		} else if _, ok := err.(*types.ErrVoteConflictingVotes); ok {
+		} else if conflicting, ok := err.(*types.ErrVoteConflictingVotes); ok {
 			if peerKey == "" {
 				log.Warn("Found conflicting vote from ourselves. Did you unsafe_reset a validator?", zap.Int64("height", vote.Height), zap.Int64("round", vote.Round), zap.Binary("type", []byte{vote.Type}))
 				return added, err
 			}
-			log.Warn("Found conflicting vote. Publish evidence (TODO)")
+			ev := types.NewDuplicateVoteEvidence(conflicting.VoteA, conflicting.VoteB)
+			log.Warn("Found conflicting vote. Publish evidence", zap.String("evidence", ev.String()))
+			types.FireEventDupeout(cs.evsw, types.EventDataDupeout{Evidence: ev})
+			if cs.evpool != nil {
+				if err := cs.evpool.AddEvidence(ev); err != nil {
+					log.Warn("Failed to add evidence", zap.Error(err))
+				}
+			}
 			return added, err
 		} else {
 			// Probably an invalid signature. Bad peer.
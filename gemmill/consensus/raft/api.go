@@ -1,15 +1,12 @@
 package raft
 
 import (
-	"encoding/hex"
 	"encoding/json"
 	"fmt"
 
-	"github.com/dappledger/AnnChain/gemmill/go-crypto"
 	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
 	"github.com/dappledger/AnnChain/gemmill/rpc/client"
 	"github.com/dappledger/AnnChain/gemmill/rpc/server"
-	"github.com/dappledger/AnnChain/gemmill/types"
 	"github.com/hashicorp/raft"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
@@ -24,8 +21,7 @@ func (p *PublicAPI) API() map[string]*server.RPCFunc {
 	return map[string]*server.RPCFunc{
 		"raft/role":        server.NewRPCFunc(p.Role, ""),
 		"raft/leader":      server.NewRPCFunc(p.Leader, ""),
-		"raft/add_peer":    server.NewRPCFunc(p.AddPeer, "addr,rpc,pubKey"),
-		"raft/remove_peer": server.NewRPCFunc(p.RemovePeer, "pubKey"),
+		"raft/change_peer": server.NewRPCFunc(p.ChangePeer, "cmd"),
 		"raft/stats":       server.NewRPCFunc(p.Stats, ""),
 	}
 }
@@ -64,100 +60,33 @@ func (p *PublicAPI) Leader() (*LeaderResult, error) {
 	return &LeaderResult{ID: fmt.Sprintf("%x", peer.PubKey.Bytes()), Address: string(bind), RPC: rpc}, nil
 }
 
-type AddPeerResult struct{}
+type ChangePeerResult struct{}
 
-func (p *PublicAPI) AddPeer(addr, rpc string, pubKey string) (*AddPeerResult, error) {
-
-	pubKeyBytes, err := hex.DecodeString(pubKey)
-	if err != nil {
-		return nil, fmt.Errorf("invalid pubKey, must be hex encode")
-	}
+// ChangePeer hands the AdminOPCmd changing a peer, signed by more than 2/3 of the voting power, to the leader
+// which commits it by its next block
+func (p *PublicAPI) ChangePeer(cmd []byte) (*ChangePeerResult, error) {
 
 	if p.rawRaft.State() == raft.Leader {
-
-		for _, peer := range p.conf.clusterConfig.Peers {
-
-			if p.conf.clusterConfig.Local.PubKey.Equals(peer.PubKey) {
-				continue
-			}
-
-			cli := client.NewClientJSONRPC(peer.RPC)
-			r := AddPeerResult{}
-			if _, err := cli.Call("raft/add_peer", []interface{}{addr, rpc, pubKey}, &r); err != nil {
-				log.Error("call raft add peer", zap.String("rpc", peer.RPC), zap.Error(err))
-			}
-		}
-		i := p.rawRaft.AddVoter(raft.ServerID(pubKey), raft.ServerAddress(addr), 0, 0)
-		if err := i.Error(); err != nil {
+		if err := p.ProposePeerChange(cmd); err != nil {
 			return nil, err
 		}
+		return &ChangePeerResult{}, nil
 	}
 
-	publicKey, err := crypto.PubKeyFromBytes(pubKeyBytes)
-	if err != nil {
-		return nil, err
+	leader := p.conf.clusterConfig.FindByBindAddress(string(p.rawRaft.Leader()))
+	if leader == nil {
+		return nil, errors.New("not found leader")
 	}
-
-	if p.conf.clusterConfig.AddPeer(Peer{PubKey: publicKey, RPC: rpc, Bind: addr}) {
-		if err := p.conf.clusterConfig.Save(); err != nil {
-			return nil, err
-		}
+	cli := client.NewClientJSONRPC(leader.RPC)
+	r := ChangePeerResult{}
+	if _, err := cli.Call("raft/change_peer", []interface{}{cmd}, &r); err != nil {
+		log.Error("call leader change peer", zap.String("rpc", leader.RPC), zap.Error(err))
+		return nil, err
 	}
-
-	p.ConsensusState.fsm.state.Validators.Add(&types.Validator{
-		Address: publicKey.Address(),
-		PubKey:  publicKey,
-	})
-	return &AddPeerResult{}, nil
+	return &r, nil
 }
 
 func (p *PublicAPI) Stats() (string, error) {
 	bs, err := json.MarshalIndent(p.rawRaft.Stats(), "", "\t")
 	return string(bs), err
 }
-
-type RemovePeerResult struct{}
-
-func (p *PublicAPI) RemovePeer(pubKey string) (*RemovePeerResult, error) {
-
-	pubKeyBytes, err := hex.DecodeString(pubKey)
-	if err != nil {
-		return nil, fmt.Errorf("invalid pubKey, must be hex encode")
-	}
-
-	if p.rawRaft.State() == raft.Leader {
-
-		for _, peer := range p.conf.clusterConfig.Peers {
-
-			if p.conf.clusterConfig.Local.PubKey.Equals(peer.PubKey) {
-				continue
-			}
-
-			cli := client.NewClientJSONRPC(peer.RPC)
-			r := RemovePeerResult{}
-			if _, err := cli.Call("raft/remove_peer", []interface{}{pubKey}, &r); err != nil {
-				log.Error("call peers remove peer", zap.String("rpc", peer.RPC), zap.Error(err))
-			}
-		}
-
-		i := p.rawRaft.RemoveServer(raft.ServerID(pubKey), 0, 0)
-		if err := i.Error(); err != nil {
-			return nil, err
-		}
-	}
-
-	publicKey, err := crypto.PubKeyFromBytes(pubKeyBytes)
-	if err != nil {
-		return nil, err
-	}
-
-	if p.conf.clusterConfig.Remove(publicKey) {
-		if err := p.conf.clusterConfig.Save(); err != nil {
-			return nil, err
-		}
-	}
-
-	// We can not remove it from validators, otherwise when a new node replay txs, it will be got errors
-	// p.ConsensusState.fsm.state.Validators.Remove(publicKey.Address())
-	return &RemovePeerResult{}, nil
-}
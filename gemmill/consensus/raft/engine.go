@@ -0,0 +1,47 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"github.com/dappledger/AnnChain/gemmill/consensus"
+	"github.com/dappledger/AnnChain/gemmill/rpc/server"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const EngineName = "raft"
+
+func init() {
+	consensus.Register(EngineName, newEngine)
+}
+
+func newEngine(ctx *consensus.Context) (consensus.Engine, error) {
+	cs, err := NewConsensusState(ctx.Conf, ctx.EventSwitch, ctx.BlockStore, ctx.State, ctx.TxPool, ctx.PrivValidator)
+	if err != nil {
+		return nil, err
+	}
+	if snapApp, ok := ctx.App.(types.SnapshotApplication); ok {
+		cs.SetSnapshotApplication(snapApp)
+	}
+	return cs, nil
+}
+
+// VerifyCommit accepts any commit, raft blocks are committed by the log rather than by votes
+func (cs *ConsensusState) VerifyCommit(types.BlockID, int64, *types.Commit) error {
+	return nil
+}
+
+func (cs *ConsensusState) API() map[string]*server.RPCFunc {
+	return cs.NewPublicAPI().API()
+}
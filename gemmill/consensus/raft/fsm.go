@@ -2,6 +2,8 @@ package raft
 
 import (
 	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"sync"
@@ -29,6 +31,7 @@ type BlockChainFSM struct {
 	blockStore      *blockchain.BlockStore
 	mut             sync.Mutex
 	onUpdateState   func(s *state.State)
+	app             types.SnapshotApplication
 }
 
 func newBlockChainFSM(conf *config, mempool types.TxPool, blockStore *blockchain.BlockStore, state *state.State) *BlockChainFSM {
@@ -46,6 +49,11 @@ func (b *BlockChainFSM) SetEventSwitch(evsw types.EventSwitch) {
 	b.evsw = evsw
 }
 
+// SetSnapshotApplication makes the snapshots carry the state of app, a node can't be restored from snapshots without it
+func (b *BlockChainFSM) SetSnapshotApplication(app types.SnapshotApplication) {
+	b.app = app
+}
+
 func (b *BlockChainFSM) Apply(l *raft.Log) interface{} {
 
 	start := time.Now()
@@ -60,6 +68,15 @@ func (b *BlockChainFSM) Apply(l *raft.Log) interface{} {
 		log.Warn("FSM.Apply, found dup block", zap.Int64("height", block.Height))
 		return nil
 	}
+	// every node checks the peer change of the block itself, a block carrying an invalid one is rejected
+	cmd, change, err := blockPeerChange(block)
+	if err == nil && change != nil {
+		err = verifyPeerChange(cmd, change, b.state.Validators, b.conf.clusterConfig.nonce())
+	}
+	if err != nil {
+		log.Error("FSM.Apply reject peer change", zap.Int64("height", block.Height), zap.Error(err))
+		return err
+	}
 	b.blockStore.SaveBlock(block, partSet, &types.Commit{})
 
 	stateCopy := b.state.Copy()
@@ -67,9 +84,18 @@ func (b *BlockChainFSM) Apply(l *raft.Log) interface{} {
 		log.Error("FSM.Apply state ApplyBlock", zap.Int64("height", block.Height), zap.Error(err))
 		return err
 	}
+	if change != nil {
+		if err := b.applyPeerChange(stateCopy, change); err != nil {
+			log.Error("FSM.Apply apply peer change", zap.Int64("height", block.Height), zap.Error(err))
+			return err
+		}
+	}
 	stateCopy.Save()
 
-	b.state = stateCopy
+	types.FireEventNewBlock(b.evsw, types.EventDataNewBlock{Block: block})
+	types.FireEventNewBlockHeader(b.evsw, types.EventDataNewBlockHeader{Header: block.Header})
+
+	b.setState(stateCopy)
 	if b.onUpdateState != nil {
 		b.onUpdateState(b.state)
 	}
@@ -80,11 +106,11 @@ func (b *BlockChainFSM) Apply(l *raft.Log) interface{} {
 	return nil
 }
 
-func (b *BlockChainFSM) createProposalBlock(proposerAddr []byte) *types.Block {
+func (b *BlockChainFSM) createProposalBlock(proposerAddr []byte, extxs types.Txs) *types.Block {
 
 	txs := b.mempool.Reap(b.conf.blockSize)
 
-	if len(txs) < b.conf.blockSize {
+	if len(txs) < b.conf.blockSize && len(extxs) == 0 {
 		t1 := time.NewTimer(b.conf.emptyBlockInterval)
 	L1:
 		for {
@@ -101,27 +127,89 @@ func (b *BlockChainFSM) createProposalBlock(proposerAddr []byte) *types.Block {
 		}
 	}
 
-	h := b.state.LastBlockHeight + 1
+	s := b.currentState()
+	h := s.LastBlockHeight + 1
 
-	block, _ := types.MakeBlock(h, b.state.ChainID, txs, nil, &types.Commit{}, proposerAddr,
-		b.state.LastBlockID, b.state.Validators.Hash(), b.state.AppHash, b.state.ReceiptsHash, b.conf.blockPartSize)
+	block, _ := types.MakeBlock(h, s.ChainID, txs, extxs, &types.Commit{}, proposerAddr,
+		s.LastBlockID, s.Validators.Hash(), s.AppHash, s.ReceiptsHash, b.conf.blockPartSize)
 	return block
 }
 
 func (b *BlockChainFSM) Snapshot() (raft.FSMSnapshot, error) {
 
 	return &BlockChainSnapshot{
-		Height: b.state.LastBlockHeight,
-		Hash:   b.state.LastBlockID.Hash,
+		Height:  b.state.LastBlockHeight,
+		Hash:    b.state.LastBlockID.Hash,
+		State:   b.state.Bytes(),
+		AppHash: b.state.AppHash,
+		Cluster: b.conf.clusterConfig.peersBytes(),
+		app:     b.app,
 	}, nil
 }
 
+// Restore moves a node behind the snapshot to its height, the blocks below stay missing
 func (b *BlockChainFSM) Restore(r io.ReadCloser) error {
 
-	io.Copy(ioutil.Discard, r)
+	var (
+		n   int
+		err error
+	)
+	header := wire.ReadBinary(&snapshotHeader{}, r, 0, &n, &err).(*snapshotHeader)
+	if err != nil {
+		return err
+	}
+	if header.Height <= b.blockStore.Height() {
+		log.Info("FSM.Restore skip snapshot", zap.Int64("height", header.Height), zap.Int64("blockstore height", b.blockStore.Height()))
+		io.Copy(ioutil.Discard, r)
+		return nil
+	}
+	if !header.HasApp || b.app == nil {
+		return errors.New("the app state can't be restored from the snapshot")
+	}
+	st, err := state.MakeStateFromBytes(nil, header.State)
+	if err != nil {
+		return err
+	}
+	if st.LastBlockHeight != header.Height {
+		return fmt.Errorf("snapshot of height %d carries the state of height %d", header.Height, st.LastBlockHeight)
+	}
+	if err := b.app.RestoreSnapshot(header.Height, st.AppHash, r); err != nil {
+		return err
+	}
+	b.blockStore.RestoreBase(header.Height, &types.Commit{})
+	if err := b.conf.clusterConfig.restorePeers(header.Cluster); err != nil {
+		return err
+	}
+
+	stateCopy := b.state.Copy()
+	stateCopy.Restore(st)
+	b.setState(stateCopy)
+	if b.onUpdateState != nil {
+		b.onUpdateState(b.state)
+	}
+	log.Info("BlockChainFSM Restored snapshot", zap.Int64("height", header.Height), zap.String("hash", fmt.Sprintf("%X", header.Hash)))
 	return nil
 }
 
+// setState replaces the state, which is never changed once set, the fsm goroutine is the only writer
+func (b *BlockChainFSM) setState(s *state.State) {
+	b.mut.Lock()
+	b.state = s
+	b.mut.Unlock()
+}
+
+// currentState returns the state for the goroutines other than the fsm one, it mustn't be changed
+func (b *BlockChainFSM) currentState() *state.State {
+	b.mut.Lock()
+	defer b.mut.Unlock()
+	return b.state
+}
+
+// validators returns a copy of the validators of the state
+func (b *BlockChainFSM) validators() *types.ValidatorSet {
+	return b.currentState().Validators.Copy()
+}
+
 func (b *BlockChainFSM) AppliedCh() <-chan *types.Block {
 	return b.appliedCh
 }
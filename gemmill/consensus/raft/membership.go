@@ -0,0 +1,239 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package raft
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"go.uber.org/zap"
+
+	"github.com/hashicorp/raft"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/plugin"
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var (
+	// PeerChangeTag prefixes the peer changes carried by the ExTxs of a block
+	PeerChangeTag = []byte("zarp")
+
+	ErrNotLeader = errors.New("not the raft leader")
+)
+
+// PeerChange is the msg of the AdminOPCmd adding a peer to, or removing one from, the raft cluster.
+// Once committed by a block every node applies it to the validators and to its cluster config.
+type PeerChange struct {
+	Cmd    types.ValidatorCmd `json:"cmd"` // add_peer or remove_node
+	PubKey []byte             `json:"pubKey"`
+	Power  int64              `json:"power,omitempty"`
+	Bind   string             `json:"bind,omitempty"`
+	RPC    string             `json:"rpc,omitempty"`
+	Nonce  uint64             `json:"nonce"` // the nonce of the cluster config the change applies to
+}
+
+func TagPeerChangeTx(cmd []byte) types.Tx {
+	return append(append([]byte{}, PeerChangeTag...), cmd...)
+}
+
+func IsPeerChangeTx(tx []byte) bool {
+	return bytes.HasPrefix(tx, PeerChangeTag)
+}
+
+func decodePeerChange(data []byte) (*types.AdminOPCmd, *PeerChange, error) {
+	cmd := &types.AdminOPCmd{}
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return nil, nil, err
+	}
+	if cmd.CmdType != types.AdminOpChangeRaftPeer {
+		return nil, nil, fmt.Errorf("unsupported admin operation: %s", cmd.CmdType)
+	}
+	change := &PeerChange{}
+	if err := json.Unmarshal(cmd.Msg, change); err != nil {
+		return nil, nil, err
+	}
+	return cmd, change, nil
+}
+
+// verifyPeerChange checks the change is signed by more than 2/3 of the voting power, applies to nonce and,
+// when adding a peer, is signed by the new peer too
+func verifyPeerChange(cmd *types.AdminOPCmd, change *PeerChange, validators *types.ValidatorSet, nonce uint64) error {
+	if change.Nonce != nonce {
+		return fmt.Errorf("peer change nonce error: need(%d) gived(%d)", nonce, change.Nonce)
+	}
+	if !plugin.SignedByMajor23(validators, cmd) {
+		return errors.New("need more than 2/3 total voting power")
+	}
+	pubKey := crypto.SetNodePubkey(change.PubKey)
+	switch change.Cmd {
+	case types.ValidatorCmdAddPeer:
+		if change.Bind == "" || change.Power < 0 {
+			return errors.New("peer without bind address or with negative power")
+		}
+		if !pubKey.VerifyBytes(cmd.Msg, crypto.SetNodeSignature(cmd.SelfSign)) {
+			return errors.New("self verify failed")
+		}
+		if validators.HasAddress(pubKey.Address()) {
+			return fmt.Errorf("node(%s) is a peer already", pubKey.KeyString())
+		}
+	case types.ValidatorCmdRemoveNode:
+		if !validators.HasAddress(pubKey.Address()) {
+			return fmt.Errorf("node(%s) is not a peer", pubKey.KeyString())
+		}
+		if validators.Size() <= 1 {
+			return errors.New("can't remove the last peer")
+		}
+	default:
+		return errors.New("unsupported peer change:" + string(change.Cmd))
+	}
+	return nil
+}
+
+// blockPeerChange returns the peer change committed by the block, a block carries one at most
+func blockPeerChange(block *types.Block) (*types.AdminOPCmd, *PeerChange, error) {
+	var txs []types.Tx
+	for _, tx := range block.Data.ExTxs {
+		if IsPeerChangeTx(tx) {
+			txs = append(txs, tx)
+		}
+	}
+	switch len(txs) {
+	case 0:
+		return nil, nil, nil
+	case 1:
+		return decodePeerChange(types.UnwrapTx(txs[0]))
+	default:
+		return nil, nil, fmt.Errorf("block carries %d peer changes", len(txs))
+	}
+}
+
+// applyPeerChange changes the validators of s and the cluster config, which is saved before the state
+func (b *BlockChainFSM) applyPeerChange(s *state.State, change *PeerChange) error {
+	attr := &types.ValidatorAttr{PubKey: change.PubKey, Power: change.Power, Cmd: change.Cmd}
+	if err := plugin.UpdateValidators(s.Validators, []*types.ValidatorAttr{attr}); err != nil {
+		return err
+	}
+	cluster := b.conf.clusterConfig
+	pubKey := crypto.SetNodePubkey(change.PubKey)
+	if change.Cmd == types.ValidatorCmdAddPeer {
+		cluster.AddPeer(Peer{PubKey: pubKey, RPC: change.RPC, Bind: change.Bind})
+	} else {
+		cluster.Remove(pubKey)
+	}
+	cluster.mtx.Lock()
+	cluster.Nonce++
+	cluster.mtx.Unlock()
+	log.Info("raft peer changed", zap.String("cmd", string(change.Cmd)), zap.String("peer", pubKey.KeyString()), zap.Uint64("nonce", change.Nonce+1))
+	return cluster.Save()
+}
+
+func (c *ClusterConfig) nonce() uint64 {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return c.Nonce
+}
+
+// ProposePeerChange verifies the AdminOPCmd changing a peer and queues it for the next block, on the leader only
+func (cs *ConsensusState) ProposePeerChange(data []byte) error {
+	if cs.rawRaft.State() != raft.Leader {
+		return ErrNotLeader
+	}
+	cmd, change, err := decodePeerChange(data)
+	if err != nil {
+		return err
+	}
+	if err := verifyPeerChange(cmd, change, cs.fsm.validators(), cs.conf.clusterConfig.nonce()); err != nil {
+		return err
+	}
+	cs.pendingMtx.Lock()
+	cs.pendingChanges = append(cs.pendingChanges, TagPeerChangeTx(data))
+	cs.pendingMtx.Unlock()
+	return nil
+}
+
+// reapPeerChange returns the first pending change still valid, dropping the ones before it
+func (cs *ConsensusState) reapPeerChange() types.Txs {
+	cs.pendingMtx.Lock()
+	defer cs.pendingMtx.Unlock()
+	for len(cs.pendingChanges) > 0 {
+		tx := cs.pendingChanges[0]
+		cs.pendingChanges = cs.pendingChanges[1:]
+		cmd, change, err := decodePeerChange(types.UnwrapTx(tx))
+		if err == nil {
+			err = verifyPeerChange(cmd, change, cs.fsm.validators(), cs.conf.clusterConfig.nonce())
+		}
+		if err != nil {
+			log.Warn("drop peer change", zap.Error(err))
+			continue
+		}
+		return types.Txs{tx}
+	}
+	return nil
+}
+
+// syncConfiguration makes the voters of raft match the validators, whose addresses come from the cluster config.
+// The leader runs it once peers changed through blocks, so that a cluster configured by hand isn't touched.
+// It runs aside the run loop, the configuration changes wait for the fsm which may wait for the run loop.
+func (cs *ConsensusState) syncConfiguration() {
+	if cs.conf.clusterConfig.nonce() == 0 {
+		return
+	}
+	cs.syncMtx.Lock()
+	defer cs.syncMtx.Unlock()
+	future := cs.rawRaft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		log.Error("get raft configuration", zap.Error(err))
+		return
+	}
+	servers := make(map[raft.ServerID]bool)
+	for _, server := range future.Configuration().Servers {
+		servers[server.ID] = true
+	}
+
+	members := make(map[raft.ServerID]bool)
+	for _, val := range cs.fsm.validators().Validators {
+		id := serverID(val.PubKey)
+		members[id] = true
+		if servers[id] {
+			continue
+		}
+		peer := cs.conf.clusterConfig.FindByPubKey(val.PubKey)
+		if peer == nil {
+			log.Warn("no address of raft peer", zap.String("id", string(id)))
+			continue
+		}
+		addr, err := tryResolveTCPAddr(peer.Bind)
+		if err != nil {
+			log.Warn("invalid address of raft peer", zap.String("bind", peer.Bind), zap.Error(err))
+			continue
+		}
+		if err := cs.rawRaft.AddVoter(id, raft.ServerAddress(addr), 0, 0).Error(); err != nil {
+			log.Error("add raft voter", zap.String("id", string(id)), zap.Error(err))
+		}
+	}
+	for id := range servers {
+		if members[id] {
+			continue
+		}
+		if err := cs.rawRaft.RemoveServer(id, 0, 0).Error(); err != nil {
+			log.Error("remove raft server", zap.String("id", string(id)), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package raft
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func peerChangeCmd(t *testing.T, change *PeerChange, self *types.PrivValidator, signers ...*types.PrivValidator) []byte {
+	cmd := &types.AdminOPCmd{CmdType: types.AdminOpChangeRaftPeer, Time: time.Now()}
+	assert.NoError(t, cmd.LoadMsg(change))
+	for _, signer := range signers {
+		cmd.SInfos = append(cmd.SInfos, types.SigInfo{
+			PubKey:    crypto.GetNodePubkeyBytes(signer.PubKey),
+			Signature: crypto.GetNodeSigBytes(signer.PrivKey.Sign(cmd.Msg)),
+		})
+	}
+	if self != nil {
+		cmd.SelfSign = crypto.GetNodeSigBytes(self.PrivKey.Sign(cmd.Msg))
+	}
+	data, err := json.Marshal(cmd)
+	assert.NoError(t, err)
+	return data
+}
+
+func currentLeader(nodes []*testNode) *testNode {
+	for _, node := range nodes {
+		if node.cs.rawRaft.State() == raft.Leader {
+			return node
+		}
+	}
+	return nil
+}
+
+func isVoter(node *testNode, pubKey crypto.PubKey) bool {
+	future := node.cs.rawRaft.GetConfiguration()
+	if future.Error() != nil {
+		return false
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.ID == serverID(pubKey) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPeerChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	nodes := newTestNodes(t, dir, 3)
+	for _, node := range nodes {
+		node.start(t, nodes)
+	}
+	defer func() {
+		for _, node := range nodes {
+			node.cs.rawRaft.Shutdown()
+		}
+	}()
+
+	var leader *testNode
+	assert.True(t, waitFor(time.Second*10, func() bool {
+		leader = currentLeader(nodes)
+		return leader != nil && leader.blockStore.Height() > 1
+	}), "no blocks committed")
+	if leader == nil {
+		return
+	}
+	var removed, other *testNode
+	for _, node := range nodes {
+		if node == leader {
+			continue
+		}
+		if removed == nil {
+			removed = node
+		} else {
+			other = node
+		}
+	}
+	pubKey := crypto.GetNodePubkeyBytes(removed.privVal.PubKey)
+	remove := &PeerChange{Cmd: types.ValidatorCmdRemoveNode, PubKey: pubKey}
+
+	// a change needs the signatures of more than 2/3 of the voting power, on the leader
+	assert.Error(t, leader.cs.ProposePeerChange(peerChangeCmd(t, remove, nil, leader.privVal, leader.privVal)))
+	assert.Equal(t, ErrNotLeader, other.cs.ProposePeerChange(peerChangeCmd(t, remove, nil, leader.privVal, other.privVal, removed.privVal)))
+	removeCmd := peerChangeCmd(t, remove, nil, leader.privVal, other.privVal, removed.privVal)
+	assert.NoError(t, leader.cs.ProposePeerChange(removeCmd))
+
+	// every node commits it through the block carrying it
+	assert.True(t, waitFor(time.Second*10, func() bool {
+		return leader.cluster.nonce() == 1 && other.cluster.nonce() == 1 && !isVoter(leader, removed.privVal.PubKey)
+	}), "peer not removed")
+	for _, node := range []*testNode{leader, other} {
+		assert.False(t, node.cs.fsm.validators().HasAddress(removed.privVal.Address))
+		assert.Nil(t, node.cluster.FindByPubKey(removed.privVal.PubKey))
+		saved, err := NewClusterConfig(node.cluster.filename)
+		if assert.NoError(t, err) {
+			assert.Equal(t, uint64(1), saved.Nonce)
+			assert.Len(t, saved.Peers, 2)
+		}
+	}
+
+	// the committed change can't be replayed, adding the peer back needs its signature too
+	add := &PeerChange{Cmd: types.ValidatorCmdAddPeer, PubKey: pubKey, Power: 10, Bind: removed.addr, Nonce: 1}
+	assert.True(t, waitFor(time.Second*10, func() bool {
+		leader = currentLeader(nodes)
+		return leader != nil && leader != removed
+	}))
+	assert.Error(t, leader.cs.ProposePeerChange(removeCmd))
+	assert.Error(t, leader.cs.ProposePeerChange(peerChangeCmd(t, add, nil, leader.privVal, other.privVal)))
+	assert.NoError(t, leader.cs.ProposePeerChange(peerChangeCmd(t, add, removed.privVal, leader.privVal, other.privVal)))
+	assert.True(t, waitFor(time.Second*20, func() bool {
+		leader = currentLeader(nodes)
+		return leader != nil && leader.cluster.nonce() == 2 && isVoter(leader, removed.privVal.PubKey) &&
+			removed.blockStore.Height() >= leader.blockStore.Height()-1
+	}), "peer not added back")
+	assert.NotNil(t, leader.cluster.FindByPubKey(removed.privVal.PubKey))
+}
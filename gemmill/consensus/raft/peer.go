@@ -5,6 +5,8 @@ import (
 	"io/ioutil"
 	"net"
 	"os"
+	"path"
+	"sync"
 	"time"
 
 	"github.com/dappledger/AnnChain/gemmill/go-crypto"
@@ -20,10 +22,13 @@ type Peer struct {
 
 type ClusterConfig struct {
 	filename string
+	mtx      sync.RWMutex
 
 	Local     Peer   `json:"local"`
 	Advertise string `json:"advertise"`
 	Peers     []Peer `json:"peers"`
+	// Nonce counts the committed peer changes, a signed change is valid for the nonce it carries only
+	Nonce uint64 `json:"nonce"`
 }
 
 func NewClusterConfig(filename string) (*ClusterConfig, error) {
@@ -41,26 +46,71 @@ func NewClusterConfig(filename string) (*ClusterConfig, error) {
 }
 
 func (c *ClusterConfig) String() string {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
 	data := wire.JSONBytesPretty(c)
 	return string(data)
 }
 
+// Save replaces the config file by a synced temporary file, so that a crash leaves either the old or the new config
 func (c *ClusterConfig) Save() error {
-
+	c.mtx.RLock()
 	data := wire.JSONBytesPretty(c)
+	c.mtx.RUnlock()
 
 	tmp := c.filename + ".tmp"
-	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
-		return nil
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
 	}
-
 	if err := os.Rename(tmp, c.filename); err != nil {
 		return err
 	}
-	return nil
+	dir, err := os.Open(path.Dir(c.filename))
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// clusterPeers is the replicated part of a ClusterConfig, it is carried by snapshots
+type clusterPeers struct {
+	Peers []Peer `json:"peers"`
+	Nonce uint64 `json:"nonce"`
+}
+
+func (c *ClusterConfig) peersBytes() []byte {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+	return wire.JSONBytes(&clusterPeers{Peers: c.Peers, Nonce: c.Nonce})
+}
+
+func (c *ClusterConfig) restorePeers(data []byte) error {
+	peers := clusterPeers{}
+	if err := wire.ReadJSONBytes(data, &peers); err != nil {
+		return err
+	}
+	c.mtx.Lock()
+	c.Peers, c.Nonce = peers.Peers, peers.Nonce
+	c.mtx.Unlock()
+	return c.Save()
 }
 
 func (c *ClusterConfig) AddPeer(peer Peer) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 
 	for _, p := range c.Peers {
 		if p.Bind == peer.Bind {
@@ -75,6 +125,8 @@ func (c *ClusterConfig) AddPeer(peer Peer) bool {
 }
 
 func (c *ClusterConfig) FindByBindAddress(bind string) *Peer {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
 
 	for i, p := range c.Peers {
 		if p.Bind == bind {
@@ -93,7 +145,22 @@ func (c *ClusterConfig) FindByBindAddress(bind string) *Peer {
 	return nil
 }
 
+// FindByPubKey returns a copy of the peer of pubKey
+func (c *ClusterConfig) FindByPubKey(pubKey crypto.PubKey) *Peer {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	for _, p := range c.Peers {
+		if p.PubKey.Equals(pubKey) {
+			return &p
+		}
+	}
+	return nil
+}
+
 func (c *ClusterConfig) Remove(pubKey crypto.PubKey) bool {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 
 	for i, p := range c.Peers {
 		if p.PubKey.Equals(pubKey) {
@@ -114,7 +181,7 @@ func (c *ClusterConfig) Remove(pubKey crypto.PubKey) bool {
 func (c *ClusterConfig) LocalServer() raft.Server {
 
 	return raft.Server{
-		ID:      raft.ServerID(fmt.Sprintf("%x", c.Local.PubKey.Bytes())),
+		ID:      serverID(c.Local.PubKey),
 		Address: raft.ServerAddress(c.Local.Bind),
 	}
 }
@@ -133,6 +200,8 @@ func tryResolveTCPAddr(bind string) (string, error) {
 }
 
 func (c *ClusterConfig) Server() ([]raft.Server, error) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
 
 	servers := make([]raft.Server, 0, len(c.Peers))
 	for _, c := range c.Peers {
@@ -142,9 +211,13 @@ func (c *ClusterConfig) Server() ([]raft.Server, error) {
 			return nil, fmt.Errorf("invalid address, err %v", err)
 		}
 		servers = append(servers, raft.Server{
-			ID:      raft.ServerID(fmt.Sprintf("%x", c.PubKey.Bytes())),
+			ID:      serverID(c.PubKey),
 			Address: raft.ServerAddress(n),
 		})
 	}
 	return servers, nil
 }
+
+func serverID(pubKey crypto.PubKey) raft.ServerID {
+	return raft.ServerID(fmt.Sprintf("%x", pubKey.Bytes()))
+}
@@ -1,18 +1,47 @@
 package raft
 
 import (
-	"fmt"
 	"github.com/hashicorp/raft"
+
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	"github.com/dappledger/AnnChain/gemmill/types"
 )
 
+// snapshotHeader leads a snapshot, the app state exported at Height follows it when HasApp
+type snapshotHeader struct {
+	Height  int64
+	Hash    []byte
+	State   []byte
+	Cluster []byte
+	HasApp  bool
+}
+
+// BlockChainSnapshot captures the state of the chain, the peers of the cluster and, if the app can export it, the app state at Height.
+// The blocks aren't part of it, a node restored from it keeps the blocks above Height only.
 type BlockChainSnapshot struct {
-	Height int64
-	Hash   []byte
+	Height  int64
+	Hash    []byte
+	State   []byte
+	AppHash []byte
+	Cluster []byte
+
+	app types.SnapshotApplication
 }
 
 func (s *BlockChainSnapshot) Persist(sink raft.SnapshotSink) error {
-	_, err := sink.Write([]byte(fmt.Sprintf("%d-%x", s.Height, s.Hash)))
-	return err
+	var (
+		n   int
+		err error
+	)
+	header := &snapshotHeader{Height: s.Height, Hash: s.Hash, State: s.State, Cluster: s.Cluster, HasApp: s.app != nil}
+	wire.WriteBinary(header, sink, &n, &err)
+	if err != nil {
+		return err
+	}
+	if s.app == nil {
+		return nil
+	}
+	return s.app.ExportSnapshot(s.Height, s.AppHash, sink)
 }
 
 func (s *BlockChainSnapshot) Release() {
@@ -0,0 +1,282 @@
+package raft
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/gemmill/blockchain"
+	"github.com/dappledger/AnnChain/gemmill/mempool"
+	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-events"
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// hashChainApp hashes the blocks it commits into a chain, keeping the hash of every height to export snapshots
+type hashChainApp struct {
+	types.Application
+
+	mtx      sync.Mutex
+	height   int64
+	hashes   map[int64][]byte
+	restored int64
+}
+
+func (app *hashChainApp) commit(block *types.Block) []byte {
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	h := sha256.Sum256(append(append([]byte{}, app.hashes[app.height]...), block.Hash()...))
+	app.height = block.Height
+	app.hashes[app.height] = h[:]
+	return h[:]
+}
+
+func (app *hashChainApp) restoredHeight() int64 {
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	return app.restored
+}
+
+func (app *hashChainApp) ExportSnapshot(height int64, appHash []byte, w io.Writer) error {
+	app.mtx.Lock()
+	hash := app.hashes[height]
+	app.mtx.Unlock()
+	if !bytes.Equal(hash, appHash) {
+		return fmt.Errorf("no state of app hash %X at height %d", appHash, height)
+	}
+	_, err := w.Write(hash)
+	return err
+}
+
+func (app *hashChainApp) RestoreSnapshot(height int64, appHash []byte, r io.Reader) error {
+	hash, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(hash, appHash) {
+		return fmt.Errorf("restored app hash %X, expected %X", hash, appHash)
+	}
+	app.mtx.Lock()
+	defer app.mtx.Unlock()
+	app.height = height
+	app.hashes[height] = hash
+	app.restored = height
+	return nil
+}
+
+type noopExecutable struct{}
+
+func (noopExecutable) BeginBlock(*types.Block, events.Fireable, *types.PartSetHeader) error {
+	return nil
+}
+
+func (noopExecutable) ExecBlock(*types.Block, events.Fireable, *types.ExecuteResult) error {
+	return nil
+}
+
+func (noopExecutable) EndBlock(*types.Block, events.Fireable, *types.PartSetHeader, []*types.ValidatorAttr, *types.ValidatorSet) error {
+	return nil
+}
+
+type testNode struct {
+	addr        string
+	privVal     *types.PrivValidator
+	state       *state.State
+	blockStore  *blockchain.BlockStore
+	mempool     types.TxPool
+	evsw        types.EventSwitch
+	app         *hashChainApp
+	logStore    *raft.InmemStore
+	stableStore *raft.InmemStore
+	snaps       *raft.InmemSnapshotStore
+	trans       *raft.InmemTransport
+	cluster     *ClusterConfig
+	cs          *ConsensusState
+}
+
+func newTestNodes(t *testing.T, dir string, n int) []*testNode {
+	valSet, privVals := types.RandValidatorSet(n, 10)
+	genDoc := &types.GenesisDoc{ChainID: "raft-test", GenesisTime: time.Now()}
+	for _, val := range valSet.Validators {
+		genDoc.Validators = append(genDoc.Validators, types.GenesisValidator{PubKey: val.PubKey, Amount: val.VotingPower})
+	}
+	vconf := viper.New()
+	vconf.Set("block_size", 10)
+
+	nodes := make([]*testNode, n)
+	for i := range nodes {
+		node := &testNode{
+			addr:        fmt.Sprintf("127.0.0.1:%d", 46000+i),
+			privVal:     privVals[i],
+			state:       state.MakeGenesisState(dbm.NewMemDB(), genDoc),
+			blockStore:  blockchain.NewBlockStore(dbm.NewMemDB(), dbm.NewMemDB()),
+			mempool:     mempool.NewMempool(vconf),
+			evsw:        types.NewEventSwitch(),
+			app:         &hashChainApp{hashes: make(map[int64][]byte)},
+			logStore:    raft.NewInmemStore(),
+			stableStore: raft.NewInmemStore(),
+			snaps:       raft.NewInmemSnapshotStore(),
+		}
+		node.cluster = &ClusterConfig{filename: path.Join(dir, fmt.Sprintf("raft-cluster-%d.json", i)), Local: Peer{PubKey: node.privVal.PubKey, Bind: node.addr}}
+		node.state.SetBlockExecutable(noopExecutable{})
+		node.evsw.Start()
+		types.AddListenerForEvent(node.evsw, "app", types.EventStringHookExecute(), func(ed types.TMEventData) {
+			ed.(types.EventDataHookExecute).ResCh <- types.ExecuteResult{}
+		})
+		types.AddListenerForEvent(node.evsw, "app", types.EventStringHookCommit(), func(ed types.TMEventData) {
+			data := ed.(types.EventDataHookCommit)
+			data.ResCh <- types.CommitResult{AppHash: node.app.commit(data.Block)}
+		})
+		nodes[i] = node
+	}
+	for _, node := range nodes {
+		for _, other := range nodes {
+			node.cluster.Peers = append(node.cluster.Peers, Peer{PubKey: other.privVal.PubKey, Bind: other.addr})
+		}
+	}
+	return nodes
+}
+
+func (node *testNode) start(t *testing.T, nodes []*testNode) {
+	_, node.trans = raft.NewInmemTransport(raft.ServerAddress(node.addr))
+	for _, other := range nodes {
+		if other != node && other.trans != nil {
+			node.trans.Connect(raft.ServerAddress(other.addr), other.trans)
+			other.trans.Connect(raft.ServerAddress(node.addr), node.trans)
+		}
+	}
+	conf := &config{
+		raftLog:            nopCloser{ioutil.Discard},
+		blockSize:          10,
+		blockPartSize:      65536,
+		emptyBlockInterval: time.Millisecond * 50,
+		snapshotThreshold:  5,
+		snapshotInterval:   time.Millisecond * 100,
+		trailingLogs:       2,
+		clusterConfig:      node.cluster,
+		logStore:           node.logStore,
+		stableStore:        node.stableStore,
+	}
+	cs, err := newConsensusState(conf, node.evsw, node.blockStore, node.state, node.mempool, node.privVal, node.trans, node.snaps)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cs.SetEventSwitch(node.evsw)
+	cs.SetSnapshotApplication(node.app)
+	cs.SetOnUpdateStatus(func(s *state.State) { node.state = s })
+	node.state.SetBlockVerifier(cs)
+	node.cs = cs
+	go cs.run()
+}
+
+func (node *testNode) stop(nodes []*testNode) {
+	for _, other := range nodes {
+		if other != node && other.trans != nil {
+			other.trans.Disconnect(raft.ServerAddress(node.addr))
+		}
+	}
+	node.trans.DisconnectAll()
+	// keep the fsm applying until raft is down, the run loop may not read the applied blocks anymore
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-node.cs.fsm.AppliedCh():
+			case <-done:
+				return
+			}
+		}
+	}()
+	node.cs.rawRaft.Shutdown().Error()
+	close(done)
+	node.cs.stop <- struct{}{}
+}
+
+type nopCloser struct {
+	io.Writer
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	for end := time.Now().Add(timeout); time.Now().Before(end); time.Sleep(time.Millisecond * 50) {
+		if cond() {
+			return true
+		}
+	}
+	return false
+}
+
+func TestLaggingFollowerCatchesUpFromSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "raft")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	nodes := newTestNodes(t, dir, 3)
+	for _, node := range nodes {
+		node.start(t, nodes)
+	}
+
+	var leader, follower *testNode
+	assert.True(t, waitFor(time.Second*10, func() bool {
+		for _, node := range nodes {
+			if node.cs.rawRaft.State() == raft.Leader && node.blockStore.Height() > 2 {
+				leader = node
+			}
+		}
+		return leader != nil
+	}), "no blocks committed")
+	if leader == nil {
+		return
+	}
+	for _, node := range nodes {
+		if node != leader {
+			follower = node
+			break
+		}
+	}
+	assert.True(t, waitFor(time.Second*5, func() bool { return follower.blockStore.Height() > 0 }))
+	follower.stop(nodes)
+	lagging := follower.blockStore.Height()
+
+	// the leader goes on with the other follower, then compacts the logs the lagging one misses
+	assert.True(t, waitFor(time.Second*20, func() bool {
+		first, _ := leader.logStore.FirstIndex()
+		return leader.blockStore.Height() > lagging+10 && first > uint64(lagging)+5
+	}), "leader logs not compacted")
+
+	follower.start(t, nodes)
+	assert.True(t, waitFor(time.Second*20, func() bool {
+		return follower.blockStore.Height() > leader.blockStore.Height()-2 && follower.app.restoredHeight() > lagging
+	}), "lagging follower didn't catch up")
+
+	// the follower runs the blocks above the snapshot on the restored state
+	assert.True(t, waitFor(time.Second*5, func() bool { return follower.blockStore.Height() > follower.app.restoredHeight() }))
+	height := follower.blockStore.Height()
+	assert.True(t, waitFor(time.Second*5, func() bool { return leader.blockStore.Height() > height }))
+	for _, node := range nodes {
+		node.cs.rawRaft.Shutdown()
+	}
+	follower.app.mtx.Lock()
+	leader.app.mtx.Lock()
+	assert.Equal(t, leader.app.hashes[height], follower.app.hashes[height])
+	assert.Nil(t, follower.blockStore.LoadBlock(follower.app.restored))
+	assert.NotNil(t, follower.blockStore.LoadBlock(height))
+	leader.app.mtx.Unlock()
+	follower.app.mtx.Unlock()
+}
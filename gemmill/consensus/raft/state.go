@@ -35,6 +35,10 @@ type config struct {
 	blockSize          int
 	blockPartSize      int
 	emptyBlockInterval time.Duration
+	snapshotThreshold  uint64 // logs committed since the last snapshot to take a new one
+	snapshotInterval   time.Duration
+	trailingLogs       uint64 // logs kept by the compaction following a snapshot
+	snapshotRetain     int
 	clusterConfig      *ClusterConfig
 	logStore           raft.LogStore
 	stableStore        raft.StableStore
@@ -116,6 +120,24 @@ func initConfig(conf *viper.Viper) (*config, error) {
 		c.emptyBlockInterval = time.Second * 3
 	}
 
+	// a follower lagging behind the trailing logs catches up from a snapshot
+	c.snapshotThreshold = uint64(conf.GetInt64("raft.snapshot_threshold"))
+	if c.snapshotThreshold == 0 {
+		c.snapshotThreshold = 8192
+	}
+	c.snapshotInterval = conf.GetDuration("raft.snapshot_interval")
+	if c.snapshotInterval == 0 {
+		c.snapshotInterval = time.Second * 120
+	}
+	c.trailingLogs = uint64(conf.GetInt64("raft.trailing_logs"))
+	if c.trailingLogs == 0 {
+		c.trailingLogs = 10240
+	}
+	c.snapshotRetain = conf.GetInt("raft.snapshot_retain")
+	if c.snapshotRetain == 0 {
+		c.snapshotRetain = 2
+	}
+
 	return &c, nil
 }
 
@@ -131,6 +153,10 @@ type ConsensusState struct {
 	fsm           *BlockChainFSM
 	mtx           sync.Mutex
 	isRunning     uint32
+
+	pendingMtx     sync.Mutex
+	pendingChanges []types.Tx // of tagged AdminOPCmd changing peers
+	syncMtx        sync.Mutex
 }
 
 func (cs *ConsensusState) OnStart() error {
@@ -150,7 +176,8 @@ func (cs *ConsensusState) GetValidators() (int64, []*types.Validator) {
 	cs.mtx.Lock()
 	defer cs.mtx.Unlock()
 
-	return cs.fsm.state.LastBlockHeight, cs.fsm.state.Validators.Copy().Validators
+	s := cs.fsm.currentState()
+	return s.LastBlockHeight, s.Validators.Copy().Validators
 }
 
 func (cs *ConsensusState) SetEventSwitch(evsw types.EventSwitch) {
@@ -161,17 +188,10 @@ func (cs *ConsensusState) SetEventSwitch(evsw types.EventSwitch) {
 
 func NewConsensusState(vconf *viper.Viper, evsw types.EventSwitch, blockStore *blockchain.BlockStore, state *state.State, mempool types.TxPool, privValidator *types.PrivValidator) (*ConsensusState, error) {
 
-	raftConf := raft.DefaultConfig()
-
 	config, err := initConfig(vconf)
 	if err != nil {
 		return nil, err
 	}
-	raftConf.LogOutput = config.raftLog
-
-	fsm := newBlockChainFSM(config, mempool, blockStore, state)
-
-	raftConf.LocalID = config.clusterConfig.LocalServer().ID
 
 	var a net.Addr
 	if config.clusterConfig.Advertise != "" {
@@ -186,16 +206,29 @@ func NewConsensusState(vconf *viper.Viper, evsw types.EventSwitch, blockStore *b
 		return nil, err
 	}
 
-	fileSnap, err := raft.NewFileSnapshotStore(config.snapshotDir, 10, config.snapshotLog)
+	fileSnap, err := raft.NewFileSnapshotStore(config.snapshotDir, config.snapshotRetain, config.snapshotLog)
 	if err != nil {
 		return nil, err
 	}
 
-	// memStore is good enough, because Raft Log is same with the block, and block stored in LevelDB.
-	// Besides, raft snapshot is useless,  BlockChain sync system did it for snapshot.
-	//store := raft.NewInmemStore()
+	return newConsensusState(config, evsw, blockStore, state, mempool, privValidator, trans, fileSnap)
+}
+
+func newConsensusState(config *config, evsw types.EventSwitch, blockStore *blockchain.BlockStore, state *state.State, mempool types.TxPool, privValidator *types.PrivValidator,
+	trans raft.Transport, snaps raft.SnapshotStore) (*ConsensusState, error) {
 
-	rawRaft, err := raft.NewRaft(raftConf, fsm, config.logStore, config.stableStore, fileSnap, trans)
+	raftConf := raft.DefaultConfig()
+	raftConf.LogOutput = config.raftLog
+	raftConf.LocalID = config.clusterConfig.LocalServer().ID
+	raftConf.SnapshotThreshold = config.snapshotThreshold
+	raftConf.SnapshotInterval = config.snapshotInterval
+	raftConf.TrailingLogs = config.trailingLogs
+	// the block store and the state survive restarts, the local snapshots are for the lagging followers only
+	raftConf.NoSnapshotRestoreOnStart = true
+
+	fsm := newBlockChainFSM(config, mempool, blockStore, state)
+
+	rawRaft, err := raft.NewRaft(raftConf, fsm, config.logStore, config.stableStore, snaps, trans)
 	if err != nil {
 		return nil, err
 	}
@@ -239,7 +272,7 @@ func (cs *ConsensusState) sign(b *types.Block) {
 
 func (cs *ConsensusState) ValidateBlock(b *types.Block) error {
 
-	s := cs.fsm.state
+	s := cs.fsm.currentState()
 
 	if err := b.ValidateBasic(s.ChainID, s.LastBlockHeight, s.LastBlockID, s.LastBlockTime, s.AppHash, s.ReceiptsHash); err != nil {
 		return err
@@ -250,11 +283,21 @@ func (cs *ConsensusState) ValidateBlock(b *types.Block) error {
 		return err
 	}
 
-	_, v := cs.fsm.state.Validators.GetByAddress(b.ProposerAddress)
+	_, v := s.Validators.GetByAddress(b.ProposerAddress)
+	if v == nil {
+		return errors.New(common.Fmt("Wrong Block.ProposerAddress %x, not a validator", b.ProposerAddress))
+	}
 	if !v.PubKey.VerifyBytes(b.Hash(), sig) {
 		return errors.New(common.Fmt("Wrong Block.Signature.  proposerAddress %x, hash %x, signature %v", b.ProposerAddress, b.Hash(), sig.String()))
 	}
 
+	cmd, change, err := blockPeerChange(b)
+	if err != nil {
+		return err
+	}
+	if change != nil {
+		return verifyPeerChange(cmd, change, s.Validators, cs.conf.clusterConfig.nonce())
+	}
 	return nil
 }
 
@@ -264,6 +307,7 @@ func (cs *ConsensusState) run() {
 		return
 	}
 
+	leading := false
 L1:
 	for {
 		select {
@@ -272,23 +316,48 @@ L1:
 		default:
 			switch cs.rawRaft.State() {
 			case raft.Follower:
+				leading = false
 				select {
 				case _ = <-cs.fsm.AppliedCh():
 				case _ = <-time.After(time.Second * 1):
 				}
 			case raft.Leader:
+				if !leading {
+					// a change committed under the former leader may be missing from the raft configuration
+					leading = true
+					go cs.syncConfiguration()
+				}
 
 				start := time.Now()
-				b := cs.fsm.createProposalBlock(cs.privValidator.GetAddress())
+				b := cs.fsm.createProposalBlock(cs.privValidator.GetAddress(), cs.reapPeerChange())
 				end := time.Now()
 				log.Debug("createProposalBlock", zap.Int64("height", b.Height), zap.Duration("taken", end.Sub(start)))
 				cs.sign(b)
 
 				data := wire.BinaryBytes(b)
-				cs.rawRaft.Apply(data, time.Second*3)
+				future := cs.rawRaft.Apply(data, time.Second*3)
+				failed := make(chan error, 1)
+				go func() {
+					// the fsm hands out the block before the future is done, so it's done first only for a block never applied
+					if err := future.Error(); err != nil {
+						failed <- err
+					} else if err, ok := future.Response().(error); ok {
+						failed <- err
+					} else {
+						failed <- nil
+					}
+				}()
 
-				_ = <-cs.fsm.AppliedCh()
+				select {
+				case block := <-cs.fsm.AppliedCh():
+					if _, change, _ := blockPeerChange(block); change != nil {
+						go cs.syncConfiguration()
+					}
+				case err := <-failed:
+					log.Warn("block not applied", zap.Int64("height", b.Height), zap.Error(err))
+				}
 			default:
+				leading = false
 				time.Sleep(time.Second)
 			}
 		}
@@ -303,3 +372,8 @@ func (cs *ConsensusState) NewPublicAPI() *PublicAPI {
 func (cs *ConsensusState) SetOnUpdateStatus(onUpdateState func(s *state.State)) {
 	cs.fsm.onUpdateState = onUpdateState
 }
+
+// SetSnapshotApplication lets the lagging followers catch up from snapshots carrying the state of app
+func (cs *ConsensusState) SetSnapshotApplication(app types.SnapshotApplication) {
+	cs.fsm.SetSnapshotApplication(app)
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package consensus
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+
+	"github.com/dappledger/AnnChain/gemmill/blockchain"
+	"github.com/dappledger/AnnChain/gemmill/p2p"
+	"github.com/dappledger/AnnChain/gemmill/rpc/server"
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// DefaultEngine is used when the config doesn't name the consensus engine
+const DefaultEngine = "pbft"
+
+// Context carries what an engine is assembled from
+type Context struct {
+	Conf          *viper.Viper
+	EventSwitch   types.EventSwitch
+	State         *state.State
+	BlockStore    *blockchain.BlockStore
+	TxPool        types.TxPool
+	PrivValidator *types.PrivValidator
+	App           types.Application
+	Switch        *p2p.Switch
+	// FastSync is true if the node syncs blocks from its peers before taking part in consensus
+	FastSync bool
+	// CommittedState returns the state of the last committed block
+	CommittedState func() *state.State
+}
+
+// Factory makes an engine, adding the reactors it needs to ctx.Switch
+type Factory func(ctx *Context) (Engine, error)
+
+// Lifecycle is implemented by the engines started and stopped along with the node
+type Lifecycle interface {
+	StartEngine() error
+	StopEngine()
+}
+
+// CommitVerifier is implemented by the engines verifying the commits of the fast synced blocks
+// otherwise than by the votes of the validators
+type CommitVerifier interface {
+	VerifyCommit(blockID types.BlockID, height int64, commit *types.Commit) error
+}
+
+// APIProvider is implemented by the engines serving rpc calls of their own
+type APIProvider interface {
+	API() map[string]*server.RPCFunc
+}
+
+var (
+	factoriesMtx sync.RWMutex
+	factories    = make(map[string]Factory)
+)
+
+// Register makes the engine made by factory available by name, registering a name twice panics
+func Register(name string, factory Factory) {
+	factoriesMtx.Lock()
+	defer factoriesMtx.Unlock()
+	if factory == nil {
+		panic("consensus: nil factory of engine " + name)
+	}
+	if _, dup := factories[name]; dup {
+		panic("consensus: engine registered twice: " + name)
+	}
+	factories[name] = factory
+}
+
+// Engines returns the sorted names of the registered engines
+func Engines() []string {
+	factoriesMtx.RLock()
+	defer factoriesMtx.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New makes the engine registered by name
+func New(name string, ctx *Context) (Engine, error) {
+	factoriesMtx.RLock()
+	factory, ok := factories[name]
+	factoriesMtx.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown consensus engine %q, registered: %s", name, strings.Join(Engines(), ", "))
+	}
+	return factory(ctx)
+}
@@ -0,0 +1,38 @@
+package consensus
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+type testEngine struct {
+	ctx *Context
+}
+
+func (e *testEngine) GetValidators() (int64, []*types.Validator) { return 0, nil }
+
+func (e *testEngine) SetEventSwitch(types.EventSwitch) {}
+
+func (e *testEngine) ValidateBlock(*types.Block) error { return nil }
+
+func (e *testEngine) SetOnUpdateStatus(func(s *state.State)) {}
+
+func TestRegistry(t *testing.T) {
+	_, err := New("test-b", &Context{})
+	assert.Error(t, err)
+
+	Register("test-b", func(ctx *Context) (Engine, error) { return &testEngine{ctx}, nil })
+	Register("test-a", func(ctx *Context) (Engine, error) { return &testEngine{ctx}, nil })
+	assert.Panics(t, func() { Register("test-a", func(ctx *Context) (Engine, error) { return nil, nil }) })
+	assert.Equal(t, []string{"test-a", "test-b"}, Engines())
+
+	ctx := &Context{FastSync: true}
+	engine, err := New("test-b", ctx)
+	if assert.NoError(t, err) {
+		assert.Equal(t, ctx, engine.(*testEngine).ctx)
+	}
+}
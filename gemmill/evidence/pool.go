@@ -0,0 +1,116 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package evidence collects the proofs of validators signing conflicting votes, gossips them to
+// peers and hands them to proposers so that they are committed and punished.
+package evidence
+
+import (
+	"errors"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/gemmill/modules/go-clist"
+	log "github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var ErrEvidenceExist = errors.New("evidence already in pool")
+
+// Pool keeps the verified evidence waiting to be committed, it lives in memory only
+type Pool struct {
+	mtx      sync.Mutex
+	verify   func(*types.DuplicateVoteEvidence) error
+	evidence *clist.CList // of *types.DuplicateVoteEvidence
+	elements map[string]*clist.CElement
+}
+
+// NewPool returns a pool accepting the evidence which passes verify, ie. could be committed by the next block
+func NewPool(verify func(*types.DuplicateVoteEvidence) error) *Pool {
+	return &Pool{
+		verify:   verify,
+		evidence: clist.New(),
+		elements: make(map[string]*clist.CElement),
+	}
+}
+
+func (p *Pool) Size() int {
+	return p.evidence.Len()
+}
+
+// AddEvidence verifies the evidence and queues it for gossip and the next proposals
+func (p *Pool) AddEvidence(ev *types.DuplicateVoteEvidence) error {
+	if ev == nil || ev.VoteA == nil || ev.VoteB == nil {
+		return errors.New("malformed evidence")
+	}
+	key := string(ev.Hash())
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	if _, ok := p.elements[key]; ok {
+		return ErrEvidenceExist
+	}
+	if err := p.verify(ev); err != nil {
+		return err
+	}
+	p.elements[key] = p.evidence.PushBack(ev)
+	log.Info("added evidence", zap.Int64("height", ev.Height()), zap.String("validator", ev.String()))
+	return nil
+}
+
+// PendingEvidence returns up to max evidence which can be committed by the next block, the evidence
+// which can't anymore, eg. committed by blocks the node synced, is dropped
+func (p *Pool) PendingEvidence(max int) []*types.DuplicateVoteEvidence {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	var pending []*types.DuplicateVoteEvidence
+	for e := p.evidence.Front(); e != nil && len(pending) < max; {
+		next := e.Next()
+		ev := e.Value.(*types.DuplicateVoteEvidence)
+		if err := p.verify(ev); err != nil {
+			log.Info("drop evidence", zap.Int64("height", ev.Height()), zap.Error(err))
+			p.remove(e)
+		} else {
+			pending = append(pending, ev)
+		}
+		e = next
+	}
+	return pending
+}
+
+// Update removes the evidence committed by the block
+func (p *Pool) Update(block *types.Block) {
+	evidence, err := block.Evidence()
+	if err != nil {
+		return
+	}
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	for _, ev := range evidence {
+		if e, ok := p.elements[string(ev.Hash())]; ok {
+			p.remove(e)
+		}
+	}
+}
+
+func (p *Pool) remove(e *clist.CElement) {
+	ev := p.evidence.Remove(e).(*types.DuplicateVoteEvidence)
+	e.DetachPrev()
+	delete(p.elements, string(ev.Hash()))
+}
+
+// EvidenceFrontWait blocks until the pool isn't empty and returns its first element
+func (p *Pool) EvidenceFrontWait() *clist.CElement {
+	return p.evidence.FrontWait()
+}
@@ -0,0 +1,70 @@
+package evidence
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// testEvidence makes the evidence of a validator voting for two blocks at height, its signatures aren't checked
+func testEvidence(validator string, height int64) *types.DuplicateVoteEvidence {
+	vote := func(hash string) *types.Vote {
+		return &types.Vote{
+			ValidatorAddress: []byte(validator),
+			Height:           height,
+			Type:             types.VoteTypePrevote,
+			BlockID:          types.BlockID{Hash: []byte(hash)},
+		}
+	}
+	return types.NewDuplicateVoteEvidence(vote("block a"), vote("block b"))
+}
+
+func TestPool(t *testing.T) {
+	// evidence below minHeight is too old to be committed
+	minHeight := int64(0)
+	pool := NewPool(func(ev *types.DuplicateVoteEvidence) error {
+		if ev.Height() < minHeight {
+			return errors.New("evidence too old")
+		}
+		return nil
+	})
+
+	ev1, ev2, ev3 := testEvidence("val1", 5), testEvidence("val2", 6), testEvidence("val3", 7)
+	for _, ev := range []*types.DuplicateVoteEvidence{ev1, ev2, ev3} {
+		assert.NoError(t, pool.AddEvidence(ev))
+	}
+	assert.Equal(t, 3, pool.Size())
+
+	// the same evidence is kept once, malformed or unverified evidence isn't kept
+	assert.Equal(t, ErrEvidenceExist, pool.AddEvidence(ev1))
+	assert.Error(t, pool.AddEvidence(&types.DuplicateVoteEvidence{VoteA: ev1.VoteA}))
+	assert.Error(t, pool.AddEvidence(testEvidence("val4", -1)))
+	assert.Equal(t, 3, pool.Size())
+
+	pending := pool.PendingEvidence(2)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, ev1.Hash(), pending[0].Hash())
+		assert.Equal(t, ev2.Hash(), pending[1].Hash())
+	}
+
+	// the evidence which expired is dropped
+	minHeight = 6
+	pending = pool.PendingEvidence(10)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, ev2.Hash(), pending[0].Hash())
+	}
+	assert.Equal(t, 2, pool.Size())
+
+	// the evidence committed by a block is removed
+	block := &types.Block{Header: &types.Header{Height: 8}, Data: &types.Data{ExTxs: types.Txs{types.TagEvidenceTx(ev2)}}}
+	pool.Update(block)
+	assert.Equal(t, 1, pool.Size())
+	pending = pool.PendingEvidence(10)
+	if assert.Len(t, pending, 1) {
+		assert.Equal(t, ev3.Hash(), pending[0].Hash())
+	}
+	assert.Equal(t, ev3.Hash(), pool.EvidenceFrontWait().Value.(*types.DuplicateVoteEvidence).Hash())
+}
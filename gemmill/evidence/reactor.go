@@ -0,0 +1,151 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package evidence
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-clist"
+	log "github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/p2p"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	EvidenceChannel = byte(0x38)
+
+	maxEvidenceMessageSize = 65536
+	peerRetrySleepInterval = 100 * time.Millisecond
+)
+
+// Reactor gossips the evidence of the pool to peers
+type Reactor struct {
+	p2p.BaseReactor
+	Pool *Pool
+	evsw types.EventSwitch
+}
+
+func NewReactor(pool *Pool) *Reactor {
+	r := &Reactor{Pool: pool}
+	r.BaseReactor = *p2p.NewBaseReactor("EvidenceReactor", r)
+	return r
+}
+
+// Implements Reactor
+func (r *Reactor) GetChannels() []*p2p.ChannelDescriptor {
+	return []*p2p.ChannelDescriptor{
+		&p2p.ChannelDescriptor{
+			ID:                  EvidenceChannel,
+			Priority:            5,
+			RecvMessageCapacity: maxEvidenceMessageSize,
+		},
+	}
+}
+
+// Implements Reactor
+func (r *Reactor) AddPeer(peer *p2p.Peer) {
+	go r.broadcastEvidenceRoutine(peer)
+}
+
+// Implements Reactor
+func (r *Reactor) RemovePeer(peer *p2p.Peer, reason interface{}) {
+	// broadcast routine checks if peer is gone and returns
+}
+
+// Implements Reactor
+func (r *Reactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
+	_, msg, err := DecodeMessage(msgBytes)
+	if err != nil {
+		log.Warn("Error decoding message", zap.String("error", err.Error()))
+		return
+	}
+
+	switch msg := msg.(type) {
+	case *EvidenceMessage:
+		if err := r.Pool.AddEvidence(msg.Evidence); err != nil && err != ErrEvidenceExist {
+			log.Warn("Invalid evidence from peer", zap.String("peer", src.Key), zap.Error(err))
+		}
+	default:
+		log.Info(fmt.Sprintf("Unknown message type %T", msg))
+	}
+}
+
+type Peer interface {
+	IsRunning() bool
+	Send(byte, interface{}) bool
+}
+
+// Send the evidence of the pool to peer.
+func (r *Reactor) broadcastEvidenceRoutine(peer Peer) {
+	var next *clist.CElement
+	for {
+		if !r.IsRunning() || !peer.IsRunning() {
+			return
+		}
+		if next == nil {
+			// the element we were looking at got removed, start from the beginning
+			next = r.Pool.EvidenceFrontWait()
+		}
+		ev := next.Value.(*types.DuplicateVoteEvidence)
+		if !peer.Send(EvidenceChannel, struct{ EvidenceMessageI }{&EvidenceMessage{Evidence: ev}}) {
+			time.Sleep(peerRetrySleepInterval)
+			continue
+		}
+		next = next.NextWait()
+	}
+}
+
+// implements events.Eventable
+func (r *Reactor) SetEventSwitch(evsw types.EventSwitch) {
+	r.evsw = evsw
+}
+
+//-----------------------------------------------------------------------------
+// Messages
+
+const (
+	msgTypeEvidence = byte(0x01)
+)
+
+type EvidenceMessageI interface{}
+
+var _ = wire.RegisterInterface(
+	struct{ EvidenceMessageI }{},
+	wire.ConcreteType{&EvidenceMessage{}, msgTypeEvidence},
+)
+
+func DecodeMessage(bz []byte) (msgType byte, msg EvidenceMessageI, err error) {
+	if len(bz) == 0 {
+		return 0, nil, fmt.Errorf("empty message")
+	}
+	msgType = bz[0]
+	n := new(int)
+	r := bytes.NewReader(bz)
+	msg = wire.ReadBinary(struct{ EvidenceMessageI }{}, r, maxEvidenceMessageSize, n, &err).(struct{ EvidenceMessageI }).EvidenceMessageI
+	return
+}
+
+type EvidenceMessage struct {
+	Evidence *types.DuplicateVoteEvidence
+}
+
+func (m *EvidenceMessage) String() string {
+	return fmt.Sprintf("[EvidenceMessage %v]", m.Evidence)
+}
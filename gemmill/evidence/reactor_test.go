@@ -0,0 +1,89 @@
+package evidence
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	"github.com/dappledger/AnnChain/gemmill/p2p"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+// testPeer delivers what is sent to it to the reactor of another node, its first send fails
+type testPeer struct {
+	mtx     sync.Mutex
+	to      *Reactor
+	sends   int
+	running bool
+}
+
+func (p *testPeer) IsRunning() bool {
+	p.mtx.Lock()
+	defer p.mtx.Unlock()
+	return p.running
+}
+
+func (p *testPeer) Send(chID byte, msg interface{}) bool {
+	p.mtx.Lock()
+	p.sends++
+	failed := p.sends == 1
+	p.mtx.Unlock()
+	if failed {
+		return false
+	}
+	p.to.Receive(chID, &p2p.Peer{Key: "test"}, wire.BinaryBytes(msg))
+	return true
+}
+
+func TestReactorGossip(t *testing.T) {
+	accept := func(*types.DuplicateVoteEvidence) error { return nil }
+	from := NewReactor(NewPool(accept))
+	// the receiving node refuses the evidence of val3
+	to := NewReactor(NewPool(func(ev *types.DuplicateVoteEvidence) error {
+		if string(ev.Address()) == "val3" {
+			return errors.New("unknown validator")
+		}
+		return nil
+	}))
+	_, err := from.Start()
+	assert.NoError(t, err)
+	defer from.Stop()
+
+	ev1, ev2, ev3 := testEvidence("val1", 5), testEvidence("val2", 6), testEvidence("val3", 7)
+	assert.NoError(t, from.Pool.AddEvidence(ev1))
+	peer := &testPeer{to: to, running: true}
+	go from.broadcastEvidenceRoutine(peer)
+	defer func() {
+		peer.mtx.Lock()
+		peer.running = false
+		peer.mtx.Unlock()
+	}()
+
+	waitSize := func(size int) bool {
+		for end := time.Now().Add(time.Second * 5); time.Now().Before(end); time.Sleep(time.Millisecond * 10) {
+			if to.Pool.Size() == size {
+				return true
+			}
+		}
+		return false
+	}
+	// the evidence is sent again after a failed send
+	assert.True(t, waitSize(1))
+	// evidence added later is gossiped too, the invalid one isn't kept
+	assert.NoError(t, from.Pool.AddEvidence(ev3))
+	assert.NoError(t, from.Pool.AddEvidence(ev2))
+	assert.True(t, waitSize(2))
+	pending := to.Pool.PendingEvidence(10)
+	if assert.Len(t, pending, 2) {
+		assert.Equal(t, ev1.Hash(), pending[0].Hash())
+		assert.Equal(t, ev2.Hash(), pending[1].Hash())
+	}
+
+	// evidence received twice is kept once
+	to.Receive(EvidenceChannel, &p2p.Peer{Key: "test"}, wire.BinaryBytes(struct{ EvidenceMessageI }{&EvidenceMessage{Evidence: ev1}}))
+	assert.Equal(t, 2, to.Pool.Size())
+}
@@ -253,14 +253,24 @@ func (s *AdminOp) ProcessAdminOP(cmd *agtypes.AdminOPCmd, app AdminApp) error {
 }
 
 func (s *AdminOp) CheckMajor23(cmd *agtypes.AdminOPCmd) bool {
+	return SignedByMajor23(*s.validators, cmd)
+}
+
+// SignedByMajor23 tells whether the validators signing the msg of cmd own more than 2/3 of the voting power
+func SignedByMajor23(validators *agtypes.ValidatorSet, cmd *agtypes.AdminOPCmd) bool {
 	msg := cmd.Msg
 	var major23 int64
+	signed := make(map[string]struct{})
 	for _, sig := range cmd.SInfos {
 		sigPubKey := crypto.SetNodePubkey(sig.PubKey)
-		_, validator := (*s.validators).GetByAddress(sigPubKey.Address())
+		if _, ok := signed[string(sigPubKey.Address())]; ok {
+			continue
+		}
+		_, validator := validators.GetByAddress(sigPubKey.Address())
 		if validator != nil && validator.VotingPower > 0 {
 			sig64 := crypto.SetNodeSignature(sig.Signature)
 			if sigPubKey.VerifyBytes(msg, sig64) {
+				signed[string(sigPubKey.Address())] = struct{}{}
 				major23 += validator.VotingPower
 			} else {
 				log.Info("check major 2/3", zap.String("vote nil", fmt.Sprintf("sig=%X;pubkey=%X", sig.Signature, sigPubKey.KeyString())))
@@ -269,7 +279,7 @@ func (s *AdminOp) CheckMajor23(cmd *agtypes.AdminOPCmd) bool {
 			log.Warn(fmt.Sprintf("node(%s) is not validator", sigPubKey.KeyString()))
 		}
 	}
-	return major23 > (*s.validators).TotalVotingPower()*2/3
+	return major23 > validators.TotalVotingPower()*2/3
 }
 
 func (s *AdminOp) ParseValidator(cmd *agtypes.AdminOPCmd) (*agtypes.ValidatorAttr, error) {
@@ -290,6 +300,11 @@ func (s *AdminOp) isValidatorPubKey(pubkey crypto.PubKey) bool {
 }
 
 func (s *AdminOp) updateValidators(validators *agtypes.ValidatorSet, changedValidators []*agtypes.ValidatorAttr) error {
+	return UpdateValidators(validators, changedValidators)
+}
+
+// UpdateValidators applies the changes of admin ops, or of any other source, to the validator set
+func UpdateValidators(validators *agtypes.ValidatorSet, changedValidators []*agtypes.ValidatorAttr) error {
 	// TODO: prevent change of 1/3+ at once
 	for _, vAttr := range changedValidators {
 		pubkey := crypto.SetNodePubkey(vAttr.PubKey)
@@ -473,6 +473,8 @@ type WSRPCConnection interface {
 	GetEventSwitch() events.EventSwitch
 	WriteRPCResponse(resp RPCResponse)
 	TryWriteRPCResponse(resp RPCResponse) bool
+	IsRunning() bool
+	Stop() bool
 }
 
 // websocket-only RPCFuncs take this as the first parameter.
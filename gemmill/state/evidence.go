@@ -0,0 +1,103 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package state
+
+import (
+	"fmt"
+
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+var evidencePrefix = []byte("evidence:")
+
+func evidenceKey(hash []byte) []byte {
+	return append(append([]byte{}, evidencePrefix...), hash...)
+}
+
+// VerifyEvidence checks ev can be committed by the next block
+func (s *State) VerifyEvidence(ev *types.DuplicateVoteEvidence) error {
+	return s.verifyEvidence(ev, s.LastBlockHeight+1)
+}
+
+// verifyEvidence checks ev can be committed by the block at height, the evidence committed
+// by that very block is accepted again when it's replayed
+func (s *State) verifyEvidence(ev *types.DuplicateVoteEvidence, height int64) error {
+	if ev.Height() > height || height-ev.Height() > types.MaxEvidenceAge {
+		return fmt.Errorf("evidence of height %d can't be committed at height %d", ev.Height(), height)
+	}
+	_, val := s.Validators.GetByAddress(ev.Address())
+	if val == nil {
+		_, val = s.LastValidators.GetByAddress(ev.Address())
+	}
+	if val == nil {
+		return fmt.Errorf("%X is not a validator", ev.Address())
+	}
+	if err := ev.Verify(s.ChainID, val.PubKey); err != nil {
+		return err
+	}
+	if committed := s.EvidenceHeight(ev.Hash()); committed != 0 && committed != height {
+		return fmt.Errorf("evidence already committed at height %d", committed)
+	}
+	return nil
+}
+
+// validateEvidence checks the evidence carried by the block
+func (s *State) validateEvidence(block *types.Block) error {
+	evidence, err := block.Evidence()
+	if err != nil {
+		return err
+	}
+	if len(evidence) > types.MaxBlockEvidence {
+		return fmt.Errorf("too much evidence in block, %d > %d", len(evidence), types.MaxBlockEvidence)
+	}
+	seen := make(map[string]struct{}, len(evidence))
+	for _, ev := range evidence {
+		hash := ev.Hash()
+		if _, ok := seen[string(hash)]; ok {
+			return fmt.Errorf("duplicate evidence %X", hash)
+		}
+		seen[string(hash)] = struct{}{}
+		if err := s.verifyEvidence(ev, block.Height); err != nil {
+			return fmt.Errorf("invalid evidence %X: %v", hash, err)
+		}
+	}
+	return nil
+}
+
+// saveEvidence records the evidence committed by the block so that it isn't committed again
+func (s *State) saveEvidence(block *types.Block) error {
+	evidence, err := block.Evidence()
+	if err != nil {
+		return err
+	}
+	for _, ev := range evidence {
+		s.db.Set(evidenceKey(ev.Hash()), wire.BinaryBytes(block.Height))
+	}
+	return nil
+}
+
+// EvidenceHeight returns the height of the block which committed the evidence of hash, 0 if none did
+func (s *State) EvidenceHeight(hash []byte) int64 {
+	buf := s.db.Get(evidenceKey(hash))
+	if len(buf) == 0 {
+		return 0
+	}
+	var height int64
+	if err := wire.ReadBinaryBytes(buf, &height); err != nil {
+		return 0
+	}
+	return height
+}
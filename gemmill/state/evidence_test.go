@@ -0,0 +1,72 @@
+package state
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	dbm "github.com/dappledger/AnnChain/gemmill/modules/go-db"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+func TestEvidence(t *testing.T) {
+	valSet, privVals := types.RandValidatorSet(4, 10)
+	s := &State{db: dbm.NewMemDB(), ChainID: "test", Validators: valSet, LastValidators: valSet, LastBlockHeight: 5}
+
+	vote := func(i int, hash string) *types.Vote {
+		idx, _ := valSet.GetByAddress(privVals[i].Address)
+		v := &types.Vote{
+			ValidatorAddress: privVals[i].Address,
+			ValidatorIndex:   idx,
+			Height:           5,
+			Round:            1,
+			Type:             types.VoteTypePrevote,
+			BlockID:          types.BlockID{Hash: []byte(hash)},
+		}
+		v.Signature = privVals[i].Sign(types.SignBytes("test", v))
+		return v
+	}
+
+	// the conflicting votes found by a vote set make the evidence
+	voteSet := types.NewVoteSet("test", 5, 1, types.VoteTypePrevote, valSet)
+	_, err := voteSet.AddVote(vote(0, "block b"))
+	assert.NoError(t, err)
+	_, err = voteSet.AddVote(vote(0, "block a"))
+	conflicting, ok := err.(*types.ErrVoteConflictingVotes)
+	if !assert.True(t, ok) {
+		return
+	}
+	ev := types.NewDuplicateVoteEvidence(conflicting.VoteA, conflicting.VoteB)
+	assert.Equal(t, ev.Hash(), types.NewDuplicateVoteEvidence(conflicting.VoteB, conflicting.VoteA).Hash())
+	assert.NoError(t, s.VerifyEvidence(ev))
+
+	forged := types.NewDuplicateVoteEvidence(vote(0, "block a"), vote(1, "block b"))
+	assert.Error(t, s.VerifyEvidence(forged))
+	same := &types.DuplicateVoteEvidence{VoteA: vote(0, "block a"), VoteB: vote(0, "block a")}
+	assert.Error(t, s.VerifyEvidence(same))
+	badSig := types.NewDuplicateVoteEvidence(vote(0, "block a"), vote(0, "block b"))
+	badSig.VoteB.Signature = badSig.VoteA.Signature
+	assert.Error(t, s.VerifyEvidence(badSig))
+
+	// the evidence rides in the ExTxs of a block
+	block := &types.Block{Header: &types.Header{Height: 6}, Data: &types.Data{ExTxs: types.Txs{types.TagEvidenceTx(ev)}}}
+	evidence, err := block.Evidence()
+	assert.NoError(t, err)
+	if assert.Len(t, evidence, 1) {
+		assert.Equal(t, ev.Hash(), evidence[0].Hash())
+	}
+	assert.NoError(t, s.validateEvidence(block))
+	block.Data.ExTxs = append(block.Data.ExTxs, types.TagEvidenceTx(ev))
+	assert.Error(t, s.validateEvidence(block))
+	block.Data.ExTxs = block.Data.ExTxs[:1]
+
+	// committed evidence is accepted again by the same block only
+	assert.NoError(t, s.saveEvidence(block))
+	assert.Equal(t, int64(6), s.EvidenceHeight(ev.Hash()))
+	assert.NoError(t, s.validateEvidence(block))
+	s.LastBlockHeight = 6
+	assert.Error(t, s.VerifyEvidence(ev))
+
+	old := &types.Block{Header: &types.Header{Height: 5 + types.MaxEvidenceAge + 1}, Data: block.Data}
+	assert.Error(t, s.validateEvidence(old))
+}
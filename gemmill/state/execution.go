@@ -59,6 +59,10 @@ func (s *State) ExecBlock(eventSwitch types.EventSwitch, block *types.Block, blo
 		return err
 	}
 
+	if err := s.saveEvidence(block); err != nil {
+		return err
+	}
+
 	err = s.blockExecutable.EndBlock(block, eventSwitch, &blockPartsHeader, changedValidators, nextValSet)
 	if err != nil {
 		return err
@@ -145,7 +149,10 @@ func (s *State) ValidateBlock(block *types.Block) error {
 }
 
 func (s *State) validateBlock(block *types.Block) error {
-	return s.blockVerifier.ValidateBlock(block)
+	if err := s.blockVerifier.ValidateBlock(block); err != nil {
+		return err
+	}
+	return s.validateEvidence(block)
 }
 
 // ApplyBlock executes the block, then commits and updates the mempool atomically
@@ -16,6 +16,7 @@ package state
 
 import (
 	"bytes"
+	"errors"
 	"io/ioutil"
 	"sync"
 	"time"
@@ -94,6 +95,20 @@ func loadState(db dbm.DB, key []byte) *State {
 	return s
 }
 
+// MakeStateFromBytes decodes a state encoded by Bytes, eg. the one carried by a snapshot
+func MakeStateFromBytes(db dbm.DB, buf []byte) (s *State, err error) {
+	s = &State{db: db}
+	r, n := bytes.NewReader(buf), new(int)
+	wire.ReadBinaryPtr(&s, r, 0, n, &err)
+	if err != nil {
+		return nil, err
+	}
+	if s.Validators == nil || s.LastValidators == nil {
+		return nil, errors.New("state without validators")
+	}
+	return s, nil
+}
+
 func (s *State) Copy() *State {
 	return &State{
 		db:              s.db,
@@ -166,6 +181,14 @@ func (s *State) LoadIntermediate() {
 	s.setBlockAndValidators(s2.LastBlockHeight, s2.LastNonEmptyHeight, s2.LastBlockID, s2.LastBlockTime, s2.Validators.Copy(), s2.LastValidators.Copy())
 }
 
+// Restore replaces the chain state of s by the one of s2, restored from a snapshot, and saves it
+func (s *State) Restore(s2 *State) {
+	s.AppHash = s2.AppHash
+	s.ReceiptsHash = s2.ReceiptsHash
+	s.setBlockAndValidators(s2.LastBlockHeight, s2.LastNonEmptyHeight, s2.LastBlockID, s2.LastBlockTime, s2.LastValidators.Copy(), s2.Validators.Copy())
+	s.Save()
+}
+
 func (s *State) SetBlockExecutable(ex IBlockExecutable) {
 	s.blockExecutable = ex
 }
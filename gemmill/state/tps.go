@@ -15,6 +15,7 @@
 package state
 
 import (
+	"sync"
 	"time"
 )
 
@@ -24,6 +25,7 @@ type blockExeInfo struct {
 }
 
 type TPSCalculator struct {
+	mtx    sync.Mutex
 	count  uint32
 	offset uint32
 	data   []blockExeInfo
@@ -39,6 +41,8 @@ func NewTPSCalculator(count uint32) *TPSCalculator {
 }
 
 func (c *TPSCalculator) AddRecord(txExcuted uint32) {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	now := time.Now()
 	if c.offset == c.count {
 		c.offset = 0
@@ -53,6 +57,8 @@ func (c *TPSCalculator) AddRecord(txExcuted uint32) {
 }
 
 func (c *TPSCalculator) TPS() int {
+	c.mtx.Lock()
+	defer c.mtx.Unlock()
 	var totalTime time.Duration
 	var totalExecuted uint32
 	for _, v := range c.data {
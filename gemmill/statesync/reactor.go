@@ -0,0 +1,313 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package statesync advertises and serves the app state snapshots kept by a node, and bootstraps
+// a new node from a snapshot of its peers instead of replaying the whole chain.
+package statesync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/dappledger/AnnChain/gemmill/blockchain"
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	gcmn "github.com/dappledger/AnnChain/gemmill/modules/go-common"
+	log "github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/p2p"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	StateSyncChannel = byte(0x60)
+
+	// MaxChunkSize bounds snapshot chunks so they fit in a single p2p message
+	MaxChunkSize = 16 << 20
+
+	maxMsgSize = MaxChunkSize + 1024
+
+	// most snapshots advertised to a peer
+	maxAdvertised = 10
+)
+
+// Reactor serves the snapshots of store to peers, and restores the node from a peer snapshot when a
+// Restorer is set
+type Reactor struct {
+	p2p.BaseReactor
+
+	store      *Store
+	blockStore *blockchain.BlockStore
+
+	mtx      sync.Mutex
+	offers   map[string][]*Snapshot // snapshots advertised by each peer
+	restorer *Restorer
+	blockCh  chan *types.Block
+	chunkCh  chan *chunkResponseMessage
+}
+
+// NewReactor returns a reactor serving the snapshots of store, which may be nil on nodes keeping none
+func NewReactor(store *Store, blockStore *blockchain.BlockStore) *Reactor {
+	r := &Reactor{
+		store:      store,
+		blockStore: blockStore,
+		offers:     make(map[string][]*Snapshot),
+		blockCh:    make(chan *types.Block, 16),
+		chunkCh:    make(chan *chunkResponseMessage, 64),
+	}
+	r.BaseReactor = *p2p.NewBaseReactor("StateSyncReactor", r)
+	return r
+}
+
+// SetRestorer makes the reactor restore the node from a snapshot once started
+func (r *Reactor) SetRestorer(restorer *Restorer) {
+	r.restorer = restorer
+}
+
+func (r *Reactor) OnStart() error {
+	r.BaseReactor.OnStart()
+	if r.restorer != nil {
+		go r.restoreRoutine()
+	}
+	return nil
+}
+
+// Implements Reactor
+func (r *Reactor) GetChannels() []*p2p.ChannelDescriptor {
+	return []*p2p.ChannelDescriptor{
+		&p2p.ChannelDescriptor{
+			ID:                  StateSyncChannel,
+			Priority:            1,
+			SendQueueCapacity:   10,
+			RecvMessageCapacity: maxMsgSize,
+		},
+	}
+}
+
+// Implements Reactor
+func (r *Reactor) AddPeer(peer *p2p.Peer) {
+	if r.restorer != nil {
+		peer.TrySend(StateSyncChannel, struct{ StateSyncMessage }{&snapshotsRequestMessage{}})
+	}
+}
+
+// Implements Reactor
+func (r *Reactor) RemovePeer(peer *p2p.Peer, reason interface{}) {
+	r.mtx.Lock()
+	delete(r.offers, peer.Key)
+	r.mtx.Unlock()
+}
+
+// Implements Reactor
+func (r *Reactor) Receive(chID byte, src *p2p.Peer, msgBytes []byte) {
+	start := time.Now()
+	defer func() {
+		src.AuditLog(chID, msgBytes, start, r.String())
+	}()
+	_, msg, err := DecodeMessage(msgBytes)
+	if err != nil {
+		log.Warn("Error decoding message", zap.String("error", err.Error()))
+		return
+	}
+
+	switch msg := msg.(type) {
+	case *snapshotsRequestMessage:
+		src.TrySend(StateSyncChannel, struct{ StateSyncMessage }{&snapshotsResponseMessage{Snapshots: r.advertised()}})
+	case *snapshotsResponseMessage:
+		r.mtx.Lock()
+		r.offers[src.Key] = msg.Snapshots
+		r.mtx.Unlock()
+	case *chunkRequestMessage:
+		if r.store == nil {
+			return
+		}
+		chunk, err := r.store.LoadChunk(msg.Height, msg.Index)
+		if err != nil {
+			log.Debug("peer asked for an unknown snapshot chunk", zap.Int64("height", msg.Height), zap.Int("index", msg.Index))
+			return
+		}
+		src.TrySend(StateSyncChannel, struct{ StateSyncMessage }{&chunkResponseMessage{Height: msg.Height, Index: msg.Index, Chunk: chunk}})
+	case *chunkResponseMessage:
+		msg.peer = src.Key
+		select {
+		case r.chunkCh <- msg:
+		default:
+		}
+	case *blockRequestMessage:
+		if msg.Height <= r.blockStore.OriginHeight() {
+			return
+		}
+		if block := r.blockStore.LoadBlock(msg.Height); block != nil {
+			src.TrySend(StateSyncChannel, struct{ StateSyncMessage }{&blockResponseMessage{Block: block}})
+		}
+	case *blockResponseMessage:
+		select {
+		case r.blockCh <- msg.Block:
+		default:
+		}
+	default:
+		log.Error(gcmn.Fmt("Unknown message type %v", reflect.TypeOf(msg)))
+	}
+}
+
+func (r *Reactor) advertised() []*Snapshot {
+	if r.store == nil {
+		return nil
+	}
+	snapshots, err := r.store.List()
+	if err != nil {
+		log.Warn("list snapshots", zap.Error(err))
+		return nil
+	}
+	if len(snapshots) > maxAdvertised {
+		snapshots = snapshots[:maxAdvertised]
+	}
+	return snapshots
+}
+
+// offersOf returns the peers advertising a snapshot of height, grouped by snapshot hash
+func (r *Reactor) offersOf(height int64) map[string]*offer {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	offers := make(map[string]*offer)
+	for peer, snapshots := range r.offers {
+		for _, snapshot := range snapshots {
+			if snapshot.Height != height {
+				continue
+			}
+			key := string(snapshot.Hash())
+			if offers[key] == nil {
+				offers[key] = &offer{snapshot: snapshot}
+			}
+			offers[key].peers = append(offers[key].peers, peer)
+		}
+	}
+	return offers
+}
+
+type offer struct {
+	snapshot *Snapshot
+	peers    []string
+}
+
+func (r *Reactor) send(peerKey string, msg StateSyncMessage) bool {
+	peer := r.Switch.Peers().Get(peerKey)
+	if peer == nil {
+		return false
+	}
+	return peer.TrySend(StateSyncChannel, struct{ StateSyncMessage }{msg})
+}
+
+//-----------------------------------------------------------------------------
+// Messages
+
+const (
+	msgTypeSnapshotsRequest  = byte(0x01)
+	msgTypeSnapshotsResponse = byte(0x02)
+	msgTypeChunkRequest      = byte(0x03)
+	msgTypeChunkResponse     = byte(0x04)
+	msgTypeBlockRequest      = byte(0x05)
+	msgTypeBlockResponse     = byte(0x06)
+)
+
+type StateSyncMessage interface{}
+
+var _ = wire.RegisterInterface(
+	struct{ StateSyncMessage }{},
+	wire.ConcreteType{&snapshotsRequestMessage{}, msgTypeSnapshotsRequest},
+	wire.ConcreteType{&snapshotsResponseMessage{}, msgTypeSnapshotsResponse},
+	wire.ConcreteType{&chunkRequestMessage{}, msgTypeChunkRequest},
+	wire.ConcreteType{&chunkResponseMessage{}, msgTypeChunkResponse},
+	wire.ConcreteType{&blockRequestMessage{}, msgTypeBlockRequest},
+	wire.ConcreteType{&blockResponseMessage{}, msgTypeBlockResponse},
+)
+
+func DecodeMessage(bz []byte) (msgType byte, msg StateSyncMessage, err error) {
+	if len(bz) == 0 {
+		return 0, nil, errors.New("empty message")
+	}
+	msgType = bz[0]
+	n := int(0)
+	r := bytes.NewReader(bz)
+	msg = wire.ReadBinary(struct{ StateSyncMessage }{}, r, maxMsgSize, &n, &err).(struct{ StateSyncMessage }).StateSyncMessage
+	return
+}
+
+//-------------------------------------
+
+type snapshotsRequestMessage struct {
+}
+
+func (m *snapshotsRequestMessage) String() string {
+	return "[snapshotsRequestMessage]"
+}
+
+//-------------------------------------
+
+type snapshotsResponseMessage struct {
+	Snapshots []*Snapshot
+}
+
+func (m *snapshotsResponseMessage) String() string {
+	return fmt.Sprintf("[snapshotsResponseMessage %v]", len(m.Snapshots))
+}
+
+//-------------------------------------
+
+type chunkRequestMessage struct {
+	Height int64
+	Index  int
+}
+
+func (m *chunkRequestMessage) String() string {
+	return fmt.Sprintf("[chunkRequestMessage %v %v]", m.Height, m.Index)
+}
+
+//-------------------------------------
+
+type chunkResponseMessage struct {
+	Height int64
+	Index  int
+	Chunk  []byte
+
+	peer string
+}
+
+func (m *chunkResponseMessage) String() string {
+	return fmt.Sprintf("[chunkResponseMessage %v %v]", m.Height, m.Index)
+}
+
+//-------------------------------------
+
+type blockRequestMessage struct {
+	Height int64
+}
+
+func (m *blockRequestMessage) String() string {
+	return fmt.Sprintf("[blockRequestMessage %v]", m.Height)
+}
+
+//-------------------------------------
+
+type blockResponseMessage struct {
+	Block *types.Block
+}
+
+func (m *blockResponseMessage) String() string {
+	return fmt.Sprintf("[blockResponseMessage %v]", m.Block.Height)
+}
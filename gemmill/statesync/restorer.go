@@ -0,0 +1,230 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statesync
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	log "github.com/dappledger/AnnChain/gemmill/modules/go-log"
+	"github.com/dappledger/AnnChain/gemmill/state"
+	"github.com/dappledger/AnnChain/gemmill/types"
+)
+
+const (
+	requestTimeout     = 15 * time.Second
+	retryInterval      = 5 * time.Second
+	maxPendingChunks   = 8
+	chunkCheckInterval = time.Second
+)
+
+var errStopped = errors.New("state sync stopped")
+
+// Restorer bootstraps an empty node from the snapshot of height TrustHeight-1. The block at TrustHeight,
+// whose hash is trusted, carries the AppHash, validators and commit the snapshot is checked against.
+type Restorer struct {
+	ChainID       string
+	TrustHeight   int64
+	TrustHash     []byte
+	DiscoveryTime time.Duration
+	Dir           string // where chunks are downloaded
+
+	// Restore installs a verified snapshot, st is the state once its height is committed,
+	// commit the commit of that height and app reads the snapshot of the app
+	Restore func(st *state.State, commit *types.Commit, app io.Reader) error
+}
+
+func (r *Reactor) restoreRoutine() {
+	for {
+		err := r.restore()
+		if err == nil || err == errStopped {
+			return
+		}
+		log.Warn("state sync failed, retrying", zap.Error(err))
+		select {
+		case <-time.After(retryInterval):
+		case <-r.Quit:
+			return
+		}
+	}
+}
+
+func (r *Reactor) restore() error {
+	rs := r.restorer
+	height := rs.TrustHeight - 1
+
+	r.Switch.Broadcast(StateSyncChannel, struct{ StateSyncMessage }{&snapshotsRequestMessage{}})
+	select {
+	case <-time.After(rs.DiscoveryTime):
+	case <-r.Quit:
+		return errStopped
+	}
+	var best *offer
+	for _, o := range r.offersOf(height) {
+		if best == nil || len(o.peers) > len(best.peers) {
+			best = o
+		}
+	}
+	if best == nil {
+		return fmt.Errorf("no peer offers a snapshot of height %d", height)
+	}
+
+	block, err := r.fetchBlock(best.peers, rs.TrustHeight, rs.TrustHash)
+	if err != nil {
+		return err
+	}
+	st, err := verifySnapshot(rs.ChainID, best.snapshot, block)
+	if err != nil {
+		return err
+	}
+	log.Info("downloading snapshot", zap.Int64("height", height), zap.Int("chunks", len(best.snapshot.ChunkHashes)), zap.Int("peers", len(best.peers)))
+	if err := r.fetchChunks(best, rs.Dir); err != nil {
+		return err
+	}
+	defer os.RemoveAll(rs.Dir)
+
+	log.Info("restoring snapshot", zap.Int64("height", height))
+	return rs.Restore(st, block.LastCommit, newChunkReader(rs.Dir, len(best.snapshot.ChunkHashes)))
+}
+
+// fetchBlock gets the block of height from peers, it must match hash
+func (r *Reactor) fetchBlock(peers []string, height int64, hash []byte) (*types.Block, error) {
+	for _, peer := range peers {
+		if !r.send(peer, &blockRequestMessage{Height: height}) {
+			continue
+		}
+		timeout := time.After(requestTimeout)
+	WAIT:
+		for {
+			select {
+			case block := <-r.blockCh:
+				if block != nil && block.Header != nil && block.Height == height && bytes.Equal(block.Hash(), hash) {
+					return block, nil
+				}
+			case <-timeout:
+				break WAIT
+			case <-r.Quit:
+				return nil, errStopped
+			}
+		}
+	}
+	return nil, fmt.Errorf("no peer sent the trusted block %d", height)
+}
+
+// fetchChunks downloads the chunks of o into dir, spreading requests over the peers offering it
+func (r *Reactor) fetchChunks(o *offer, dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	snapshot := o.snapshot
+	total := len(snapshot.ChunkHashes)
+	pending := make(map[int]time.Time) // deadline of the requested chunks
+	attempts := make(map[int]int)
+	next, done := 0, 0
+
+	request := func(index int) error {
+		for i := 0; i < len(o.peers); i++ {
+			peer := o.peers[(index+attempts[index])%len(o.peers)]
+			attempts[index]++
+			if r.send(peer, &chunkRequestMessage{Height: snapshot.Height, Index: index}) {
+				pending[index] = time.Now().Add(requestTimeout)
+				return nil
+			}
+		}
+		return fmt.Errorf("no peer to request chunk %d from", index)
+	}
+
+	ticker := time.NewTicker(chunkCheckInterval)
+	defer ticker.Stop()
+	for done < total {
+		for len(pending) < maxPendingChunks && next < total {
+			if err := request(next); err != nil {
+				return err
+			}
+			next++
+		}
+		select {
+		case msg := <-r.chunkCh:
+			if _, ok := pending[msg.Index]; !ok || msg.Height != snapshot.Height {
+				continue
+			}
+			if err := snapshot.VerifyChunk(msg.Index, msg.Chunk); err != nil {
+				log.Warn("got a bad snapshot chunk", zap.String("peer", msg.peer), zap.Error(err))
+				if err := request(msg.Index); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := ioutil.WriteFile(chunkFile(dir, msg.Index), msg.Chunk, 0600); err != nil {
+				return err
+			}
+			delete(pending, msg.Index)
+			done++
+		case now := <-ticker.C:
+			for index, deadline := range pending {
+				if now.After(deadline) {
+					if err := request(index); err != nil {
+						return err
+					}
+				}
+			}
+		case <-r.Quit:
+			return errStopped
+		}
+	}
+	return nil
+}
+
+// verifySnapshot checks the state carried by snapshot against block, the trusted block following it
+func verifySnapshot(chainID string, snapshot *Snapshot, block *types.Block) (*state.State, error) {
+	st, err := state.MakeStateFromBytes(nil, snapshot.State)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case st.ChainID != chainID || block.ChainID != chainID:
+		return nil, fmt.Errorf("snapshot of another chain")
+	case st.LastBlockHeight != snapshot.Height || block.Height != snapshot.Height+1:
+		return nil, fmt.Errorf("snapshot %d doesn't precede block %d", snapshot.Height, block.Height)
+	case !block.LastBlockID.Equals(st.LastBlockID):
+		return nil, fmt.Errorf("snapshot last block id doesn't match block %d", block.Height)
+	case !bytes.Equal(block.AppHash, st.AppHash):
+		return nil, fmt.Errorf("snapshot app hash doesn't match block %d", block.Height)
+	case !bytes.Equal(block.ReceiptsHash, st.ReceiptsHash):
+		return nil, fmt.Errorf("snapshot receipts hash doesn't match block %d", block.Height)
+	case !bytes.Equal(block.ValidatorsHash, st.Validators.Hash()):
+		return nil, fmt.Errorf("snapshot validators don't match block %d", block.Height)
+	}
+	if !bytes.Equal(block.LastCommitHash, block.LastCommit.Hash()) {
+		return nil, fmt.Errorf("last commit doesn't match block %d", block.Height)
+	}
+	// raft blocks carry no commit
+	if len(block.LastCommit.Precommits) > 0 {
+		if err := st.LastValidators.VerifyCommit(chainID, st.LastBlockID, st.LastBlockHeight, block.LastCommit); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
@@ -0,0 +1,271 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package statesync
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/dappledger/AnnChain/gemmill/modules/go-merkle"
+)
+
+const snapshotFile = "snapshot.json"
+
+// Snapshot describes the app state committed at Height, split in chunks
+type Snapshot struct {
+	Height      int64
+	ChunkHashes [][]byte // sha256 of every chunk
+	State       []byte   // go-wire encoded state.State once Height is committed
+}
+
+// Hash identifies the content of the snapshot
+func (s *Snapshot) Hash() []byte {
+	stateHash := sha256.Sum256(s.State)
+	return merkle.SimpleHashFromHashes(append(append([][]byte{}, s.ChunkHashes...), stateHash[:]))
+}
+
+// VerifyChunk checks chunk is the index-th chunk of the snapshot
+func (s *Snapshot) VerifyChunk(index int, chunk []byte) error {
+	if index < 0 || index >= len(s.ChunkHashes) {
+		return fmt.Errorf("snapshot %d has no chunk %d", s.Height, index)
+	}
+	hash := sha256.Sum256(chunk)
+	if !bytes.Equal(hash[:], s.ChunkHashes[index]) {
+		return fmt.Errorf("chunk %d doesn't match snapshot %d", index, s.Height)
+	}
+	return nil
+}
+
+// Store keeps snapshots on disk, one directory per height holding the description and the chunks
+type Store struct {
+	mtx sync.Mutex
+	dir string
+}
+
+func NewStore(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+func (st *Store) heightDir(height int64) string {
+	return filepath.Join(st.dir, strconv.FormatInt(height, 10))
+}
+
+func chunkFile(dir string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("chunk-%d", index))
+}
+
+// Create stores the snapshot of height written by export, cut in chunks of chunkSize bytes
+func (st *Store) Create(height int64, state []byte, chunkSize int, export func(io.Writer) error) (*Snapshot, error) {
+	if chunkSize <= 0 {
+		return nil, fmt.Errorf("invalid chunk size %d", chunkSize)
+	}
+	tmpDir, err := ioutil.TempDir(st.dir, "tmp-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	w := &chunkWriter{dir: tmpDir, size: chunkSize}
+	if err := export(w); err != nil {
+		return nil, err
+	}
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{Height: height, ChunkHashes: w.hashes, State: state}
+	if err := writeSnapshot(tmpDir, snapshot); err != nil {
+		return nil, err
+	}
+
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	if err := os.RemoveAll(st.heightDir(height)); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmpDir, st.heightDir(height)); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+func writeSnapshot(dir string, snapshot *Snapshot) error {
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, snapshotFile), data, 0600)
+}
+
+// List returns the stored snapshots, latest first
+func (st *Store) List() ([]*Snapshot, error) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	heights, err := st.heights()
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]*Snapshot, 0, len(heights))
+	for i := len(heights) - 1; i >= 0; i-- {
+		snapshot, err := st.load(heights[i])
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, snapshot)
+	}
+	return snapshots, nil
+}
+
+func (st *Store) heights() ([]int64, error) {
+	fs, err := ioutil.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	heights := make([]int64, 0, len(fs))
+	for _, f := range fs {
+		height, err := strconv.ParseInt(f.Name(), 10, 64)
+		if err != nil || !f.IsDir() {
+			continue
+		}
+		heights = append(heights, height)
+	}
+	sort.Slice(heights, func(i, j int) bool { return heights[i] < heights[j] })
+	return heights, nil
+}
+
+// Load returns the snapshot of height
+func (st *Store) Load(height int64) (*Snapshot, error) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return st.load(height)
+}
+
+func (st *Store) load(height int64) (*Snapshot, error) {
+	data, err := ioutil.ReadFile(filepath.Join(st.heightDir(height), snapshotFile))
+	if err != nil {
+		return nil, err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// LoadChunk returns a chunk of the snapshot of height
+func (st *Store) LoadChunk(height int64, index int) ([]byte, error) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	return ioutil.ReadFile(chunkFile(st.heightDir(height), index))
+}
+
+// Prune deletes all but the keep latest snapshots
+func (st *Store) Prune(keep int) error {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+	heights, err := st.heights()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(heights)-keep; i++ {
+		if err := os.RemoveAll(st.heightDir(heights[i])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chunkWriter cuts what is written into chunk files of size bytes
+type chunkWriter struct {
+	dir    string
+	size   int
+	buf    []byte
+	hashes [][]byte
+}
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		room := w.size - len(w.buf)
+		if room > len(p) {
+			room = len(p)
+		}
+		w.buf = append(w.buf, p[:room]...)
+		p = p[room:]
+		if len(w.buf) == w.size {
+			if err := w.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+func (w *chunkWriter) flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if err := ioutil.WriteFile(chunkFile(w.dir, len(w.hashes)), w.buf, 0600); err != nil {
+		return err
+	}
+	hash := sha256.Sum256(w.buf)
+	w.hashes = append(w.hashes, hash[:])
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// chunkReader reads the chunks of dir in order
+type chunkReader struct {
+	dir   string
+	total int
+	next  int
+	cur   io.Reader
+}
+
+// newChunkReader returns a reader over the total chunk files of dir, as written by a Store
+func newChunkReader(dir string, total int) io.Reader {
+	return &chunkReader{dir: dir, total: total}
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	for {
+		if r.cur != nil {
+			if n, err := r.cur.Read(p); err != io.EOF {
+				return n, err
+			}
+			r.cur = nil
+		}
+		if r.next == r.total {
+			return 0, io.EOF
+		}
+		data, err := ioutil.ReadFile(chunkFile(r.dir, r.next))
+		if err != nil {
+			return 0, err
+		}
+		r.cur = bytes.NewReader(data)
+		r.next++
+	}
+}
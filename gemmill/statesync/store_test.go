@@ -0,0 +1,62 @@
+package statesync
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := NewStore(dir)
+	assert.NoError(t, err)
+
+	data := bytes.Repeat([]byte("0123456789"), 10)
+	for _, height := range []int64{10, 20, 30} {
+		snapshot, err := store.Create(height, []byte("state"), 32, func(w io.Writer) error {
+			_, err := w.Write(data)
+			return err
+		})
+		assert.NoError(t, err)
+		assert.Len(t, snapshot.ChunkHashes, 4)
+	}
+	_, err = store.Create(40, nil, 32, func(w io.Writer) error {
+		return io.ErrUnexpectedEOF
+	})
+	assert.Error(t, err)
+
+	snapshots, err := store.List()
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 3)
+	assert.Equal(t, int64(30), snapshots[0].Height)
+
+	snapshot, err := store.Load(20)
+	assert.NoError(t, err)
+	chunk, err := store.LoadChunk(20, 3)
+	assert.NoError(t, err)
+	assert.Len(t, chunk, 4)
+	assert.NoError(t, snapshot.VerifyChunk(3, chunk))
+	assert.Error(t, snapshot.VerifyChunk(2, chunk))
+	assert.Error(t, snapshot.VerifyChunk(4, chunk))
+
+	restored, err := ioutil.ReadAll(newChunkReader(store.heightDir(20), len(snapshot.ChunkHashes)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, restored)
+
+	assert.NoError(t, store.Prune(2))
+	snapshots, err = store.List()
+	assert.NoError(t, err)
+	assert.Len(t, snapshots, 2)
+	_, err = store.Load(10)
+	assert.Error(t, err)
+
+	other := *snapshots[0]
+	other.State = []byte("other state")
+	assert.NotEqual(t, snapshots[0].Hash(), other.Hash())
+}
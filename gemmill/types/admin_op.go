@@ -53,6 +53,7 @@ type CmdType string
 
 const (
 	AdminOpChangeValidator = "changeValidator"
+	AdminOpChangeRaftPeer  = "changeRaftPeer"
 )
 
 var (
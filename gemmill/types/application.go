@@ -17,6 +17,7 @@ package types
 import (
 	"bytes"
 	"errors"
+	"io"
 
 	"github.com/dappledger/AnnChain/eth/common"
 	"github.com/dappledger/AnnChain/gemmill/go-wire"
@@ -31,6 +32,31 @@ type TxPoolApplication interface {
 	GetTxPool() TxPool
 }
 
+// SnapshotApplication is an Application whose state can be exported into snapshots and restored from them by state sync
+type SnapshotApplication interface {
+	Application
+	// ExportSnapshot writes the state committed at height, whose root is appHash, into w
+	ExportSnapshot(height int64, appHash []byte, w io.Writer) error
+	// RestoreSnapshot replaces the state of an app behind height by the one read from r, it fails unless the restored state matches appHash
+	RestoreSnapshot(height int64, appHash []byte, r io.Reader) error
+}
+
+// PunishApplication is an Application punishing the validators found signing conflicting votes
+type PunishApplication interface {
+	Application
+	// Punish returns the changes of validators for the evidence committed at height, they are applied
+	// like the ones of admin ops. It must be deterministic as every node applies the changes
+	Punish(height int64, evidence []*DuplicateVoteEvidence, validators *ValidatorSet) []*ValidatorAttr
+}
+
+// RevertibleApplication is an Application whose state can be rolled back to an earlier height by the dev consensus
+type RevertibleApplication interface {
+	Application
+	// RevertTo rolls the state back to the one committed at height, whose root is appHash, and forgets
+	// the txs of the blocks above it
+	RevertTo(height int64, appHash []byte, txs []Tx) error
+}
+
 type Application interface {
 	GetAngineHooks() Hooks
 	CompatibleWithAngine()
@@ -45,6 +71,8 @@ type Application interface {
 type Core interface {
 	Query(byte, []byte) (interface{}, error)
 	GetBlockMeta(height int64) (*BlockMeta, error)
+	GetBlock(height int64) (*Block, *BlockMeta, error)
+	Genesis() *GenesisDoc
 }
 
 // type AppMaker func(config.Config) Application
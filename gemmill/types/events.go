@@ -32,6 +32,10 @@ func EventStringDupeout() string { return "Dupeout" }
 func EventStringFork() string    { return "Fork" }
 func EventStringTx(tx Tx) string { return gcmn.Fmt("Tx:%X", tx.Hash()) }
 
+// status updates of all the txs, or of the tx of hash
+func EventStringTxStatus() string              { return "TxStatus" }
+func EventStringTxStatusOf(hash []byte) string { return gcmn.Fmt("TxStatus:%X", hash) }
+
 func EventStringNewBlock() string         { return "NewBlock" }
 func EventStringNewBlockHeader() string   { return "NewBlockHeader" }
 func EventStringNewRound() string         { return "NewRound" }
@@ -67,6 +71,9 @@ const (
 	EventDataTypeFork           = byte(0x02)
 	EventDataTypeTx             = byte(0x03)
 	EventDataTypeNewBlockHeader = byte(0x04)
+	EventDataTypeLog            = byte(0x06)
+	EventDataTypeTxStatus       = byte(0x07)
+	EventDataTypeDupeout        = byte(0x08)
 
 	EventDataTypeSwitchToConsensus = byte(0x5)
 
@@ -87,6 +94,9 @@ var _ = wire.RegisterInterface(
 	wire.ConcreteType{EventDataNewBlockHeader{}, EventDataTypeNewBlockHeader},
 	// wire.ConcreteType{EventDataFork{}, EventDataTypeFork },
 	wire.ConcreteType{EventDataTx{}, EventDataTypeTx},
+	wire.ConcreteType{EventDataLog{}, EventDataTypeLog},
+	wire.ConcreteType{EventDataTxStatus{}, EventDataTypeTxStatus},
+	wire.ConcreteType{EventDataDupeout{}, EventDataTypeDupeout},
 	wire.ConcreteType{EventDataRoundState{}, EventDataTypeRoundState},
 	wire.ConcreteType{EventDataVote{}, EventDataTypeVote},
 
@@ -121,6 +131,34 @@ type EventDataTx struct {
 	Error string   `json:"error"` // this is redundant information for now
 }
 
+// EventDataLog is a log emitted by a contract in a committed block,
+// it is delivered to log subscriptions rather than fired on the event switch
+type EventDataLog struct {
+	Address     []byte   `json:"address"`
+	Topics      [][]byte `json:"topics"`
+	Data        []byte   `json:"data"`
+	BlockHeight int64    `json:"block_height"`
+	BlockHash   []byte   `json:"block_hash"`
+	TxHash      []byte   `json:"tx_hash"`
+	TxIndex     int      `json:"tx_index"`
+	LogIndex    int      `json:"log_index"`
+}
+
+// EventDataTxStatus is a status update of a tx, the block is set once it's committed
+type EventDataTxStatus struct {
+	TxHash      []byte `json:"tx_hash"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+	BlockHeight int64  `json:"block_height"`
+	TxIndex     int    `json:"tx_index"`
+	Time        int64  `json:"time"`
+}
+
+// EventDataDupeout is fired when a validator is found signing conflicting votes
+type EventDataDupeout struct {
+	Evidence *DuplicateVoteEvidence `json:"evidence"`
+}
+
 // NOTE: This goes into the replay WAL
 type EventDataRoundState struct {
 	Height int64  `json:"height"`
@@ -201,6 +239,9 @@ func NewEventDataHookCommit(height, round int64, block *Block) EventDataHookComm
 func (_ EventDataNewBlock) AssertIsTMEventData()          {}
 func (_ EventDataNewBlockHeader) AssertIsTMEventData()    {}
 func (_ EventDataTx) AssertIsTMEventData()                {}
+func (_ EventDataLog) AssertIsTMEventData()               {}
+func (_ EventDataTxStatus) AssertIsTMEventData()          {}
+func (_ EventDataDupeout) AssertIsTMEventData()           {}
 func (_ EventDataRoundState) AssertIsTMEventData()        {}
 func (_ EventDataVote) AssertIsTMEventData()              {}
 func (_ EventDataSwitchToConsensus) AssertIsTMEventData() {}
@@ -272,6 +313,15 @@ func FireEventTx(fireable events.Fireable, tx EventDataTx) {
 	fireEvent(fireable, EventStringTx(tx.Tx), tx)
 }
 
+func FireEventTxStatus(fireable events.Fireable, status EventDataTxStatus) {
+	fireEvent(fireable, EventStringTxStatus(), status)
+	fireEvent(fireable, EventStringTxStatusOf(status.TxHash), status)
+}
+
+func FireEventDupeout(fireable events.Fireable, dupeout EventDataDupeout) {
+	fireEvent(fireable, EventStringDupeout(), dupeout)
+}
+
 //--- EventDataRoundState events
 
 func FireEventNewRoundStep(fireable events.Fireable, rs EventDataRoundState) {
@@ -0,0 +1,138 @@
+// Copyright 2017 ZhongAn Information Technology Services Co.,Ltd.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package types
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/dappledger/AnnChain/gemmill/go-crypto"
+	"github.com/dappledger/AnnChain/gemmill/go-wire"
+	gcmn "github.com/dappledger/AnnChain/gemmill/modules/go-common"
+	"github.com/dappledger/AnnChain/gemmill/modules/go-merkle"
+)
+
+const (
+	// MaxEvidenceAge is the number of blocks after which the evidence of a height can't be committed anymore
+	MaxEvidenceAge = 100000
+	// MaxBlockEvidence is the maximum number of evidence in a block
+	MaxBlockEvidence = 50
+)
+
+var (
+	// EvidenceTag prefixes the evidence carried by the ExTxs of a block
+	EvidenceTag = []byte("zaev")
+)
+
+// DuplicateVoteEvidence proves a validator signed two votes for different blocks at the same height, round and step
+type DuplicateVoteEvidence struct {
+	VoteA *Vote `json:"vote_a"`
+	VoteB *Vote `json:"vote_b"`
+}
+
+// NewDuplicateVoteEvidence orders the votes so that every node makes the same evidence of them
+func NewDuplicateVoteEvidence(voteA, voteB *Vote) *DuplicateVoteEvidence {
+	if voteA.BlockID.Key() > voteB.BlockID.Key() {
+		voteA, voteB = voteB, voteA
+	}
+	return &DuplicateVoteEvidence{VoteA: voteA, VoteB: voteB}
+}
+
+func (ev *DuplicateVoteEvidence) Height() int64 {
+	return ev.VoteA.Height
+}
+
+// Address is the address of the validator which signed the votes
+func (ev *DuplicateVoteEvidence) Address() []byte {
+	return ev.VoteA.ValidatorAddress
+}
+
+func (ev *DuplicateVoteEvidence) Bytes() []byte {
+	return wire.BinaryBytes(ev)
+}
+
+func (ev *DuplicateVoteEvidence) Hash() []byte {
+	return merkle.SimpleHashFromBinary(ev)
+}
+
+func (ev *DuplicateVoteEvidence) String() string {
+	return fmt.Sprintf("DuplicateVoteEvidence{%X %v %v}", gcmn.Fingerprint(ev.Address()), ev.VoteA, ev.VoteB)
+}
+
+// Verify checks the votes conflict and are both signed by pubKey
+func (ev *DuplicateVoteEvidence) Verify(chainID string, pubKey crypto.PubKey) error {
+	a, b := ev.VoteA, ev.VoteB
+	if a == nil || b == nil {
+		return errors.New("evidence without votes")
+	}
+	if a.Height != b.Height || a.Round != b.Round || a.Type != b.Type {
+		return fmt.Errorf("votes of different steps %d/%d/%d and %d/%d/%d", a.Height, a.Round, a.Type, b.Height, b.Round, b.Type)
+	}
+	if !IsVoteTypeValid(a.Type) {
+		return fmt.Errorf("invalid vote type %d", a.Type)
+	}
+	if !bytes.Equal(a.ValidatorAddress, b.ValidatorAddress) || a.ValidatorIndex != b.ValidatorIndex {
+		return errors.New("votes of different validators")
+	}
+	if !bytes.Equal(a.ValidatorAddress, pubKey.Address()) {
+		return fmt.Errorf("votes of %X are not signed by %X", a.ValidatorAddress, pubKey.Address())
+	}
+	if a.BlockID.Key() >= b.BlockID.Key() {
+		return errors.New("votes for the same block or out of order")
+	}
+	if !pubKey.VerifyBytes(SignBytes(chainID, a), a.Signature) || !pubKey.VerifyBytes(SignBytes(chainID, b), b.Signature) {
+		return ErrVoteInvalidSignature
+	}
+	return nil
+}
+
+func TagEvidenceTx(ev *DuplicateVoteEvidence) Tx {
+	return append(append([]byte{}, EvidenceTag...), ev.Bytes()...)
+}
+
+func IsEvidenceTx(tx []byte) bool {
+	return bytes.HasPrefix(tx, EvidenceTag)
+}
+
+func DecodeEvidenceTx(tx []byte) (ev *DuplicateVoteEvidence, err error) {
+	if !IsEvidenceTx(tx) {
+		return nil, errors.New("not an evidence tx")
+	}
+	ev = &DuplicateVoteEvidence{}
+	if err = wire.ReadBinaryBytes(UnwrapTx(tx), &ev); err != nil {
+		return nil, err
+	}
+	if ev.VoteA == nil || ev.VoteB == nil {
+		return nil, errors.New("malformed evidence")
+	}
+	return ev, nil
+}
+
+// Evidence returns the evidence committed by the block
+func (b *Block) Evidence() ([]*DuplicateVoteEvidence, error) {
+	var evidence []*DuplicateVoteEvidence
+	for _, tx := range b.Data.ExTxs {
+		if !IsEvidenceTx(tx) {
+			continue
+		}
+		ev, err := DecodeEvidenceTx(tx)
+		if err != nil {
+			return nil, err
+		}
+		evidence = append(evidence, ev)
+	}
+	return evidence, nil
+}
@@ -45,16 +45,33 @@ type GenesisDoc struct {
 	Validators  []GenesisValidator `json:"validators"`
 	AppHash     []byte             `json:"app_hash"`
 	Plugins     string             `json:"plugins"`
+
+	// AppState is the app_state section, the genesis of the app read by the app itself.
+	// It is kept out of go-wire, so only json carries it.
+	AppState json.RawMessage `json:"-"`
 }
 
 // Utility method for saving GenensisDoc as JSON file.
 func (genDoc *GenesisDoc) SaveAs(file string) error {
-	genDocBytes := wire.JSONBytesPretty(genDoc)
+	genDocBytes := genDoc.JSONBytes()
 	return gcmn.WriteFile(file, genDocBytes, 0644)
 }
 
 func (genDoc *GenesisDoc) JSONBytes() []byte {
-	return wire.JSONBytesPretty(genDoc)
+	genDocBytes := wire.JSONBytesPretty(genDoc)
+	if len(genDoc.AppState) == 0 {
+		return genDocBytes
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(genDocBytes, &fields); err != nil {
+		gcmn.PanicSanity(gcmn.Fmt("Could not unmarshal genesis doc: %v", err))
+	}
+	fields["app_state"] = genDoc.AppState
+	genDocBytes, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		gcmn.PanicSanity(gcmn.Fmt("Could not marshal genesis doc: %v", err))
+	}
+	return genDocBytes
 }
 
 //------------------------------------------------------------
@@ -70,6 +87,16 @@ func GenesisDocFromJSON(jsonBlob []byte) *GenesisDoc {
 
 func GenesisDocFromJSONRet(jsonBlob []byte) (genState *GenesisDoc, err error) {
 	wire.ReadJSONPtr(&genState, jsonBlob, &err)
+	if err != nil || genState == nil {
+		return
+	}
+	var appState struct {
+		AppState json.RawMessage `json:"app_state"`
+	}
+	if err = json.Unmarshal(jsonBlob, &appState); err != nil {
+		return
+	}
+	genState.AppState = appState.AppState
 	return
 }
 
@@ -56,6 +56,7 @@ type ResultStatus struct {
 	LatestAppHash     []byte        `json:"latest_app_hash"`
 	LatestBlockHeight int64         `json:"latest_block_height"`
 	LatestBlockTime   int64         `json:"latest_block_time"` // nano
+	ConsensusEngine   string        `json:"consensus_engine"`
 }
 
 type ResultNetInfo struct {
@@ -168,6 +169,7 @@ type ResultUnsafeSetConfig struct{}
 type ResultUnsafeProfile struct{}
 
 type ResultSubscribe struct {
+	Event string `json:"event"`
 }
 
 type ResultUnsubscribe struct {
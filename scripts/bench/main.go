@@ -33,7 +33,7 @@ import (
 var version = "0.9.0"
 
 var (
-	ethSigner = etypes.HomesteadSigner{}
+	ethSigner etypes.Signer
 	gasLimit  = uint64(90000000000)
 	txSize    = 250
 
@@ -53,8 +53,10 @@ func main() {
 	flag.StringVar(&method, "method", "set", "method")
 	// flag.StringVar(&arguments, "arguments", "", "arguments")
 	flag.Int64Var(&argument, "argument", 0, "argument")
+	chainID := flag.Uint64("chainid", 1, "evm chain id of the network")
 
 	flag.Parse()
+	ethSigner = etypes.NewEIP155Signer(new(big.Int).SetUint64(*chainID))
 
 	if flag.NArg() == 0 {
 		flag.Usage()